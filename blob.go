@@ -0,0 +1,373 @@
+package bbolt
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"unsafe"
+
+	berrors "go.etcd.io/bbolt/errors"
+	"go.etcd.io/bbolt/internal/common"
+)
+
+// blobChunkPages is the number of pages of a blob chunk. Each chunk is
+// allocated separately, so a large blob doesn't need a long run of contiguous
+// free pages.
+const blobChunkPages = 16
+
+// PutReader sets the value for a key in the bucket to the data read from r,
+// until io.EOF. The value is stored as a blob: chunk pages which are written
+// to the file as they are read, so that the value is never held in memory as
+// a whole, and an index page listing the chunks. In WAL mode the chunks are
+// only written when the transaction commits, like the other dirty pages.
+//
+// If the key exists then its previous value is overwritten, and the chunks of
+// a previous blob are freed. Blobs aren't compressed by the codec of the
+// bucket. Returns an error if the bucket was created from a read-only
+// transaction, if the key is blank, if the key is too large, if the key is a
+// nested bucket, or if reading r fails.
+func (b *Bucket) PutReader(key []byte, r io.Reader) (err error) {
+	lg := b.tx.db.Logger()
+	lg.Debugf("Putting blob %q", string(key))
+	defer func() {
+		if err != nil {
+			lg.Errorf("Putting blob %q failed: %v", string(key), err)
+		} else {
+			lg.Debugf("Putting blob %q successfully", string(key))
+		}
+	}()
+	if b.tx.db == nil {
+		return berrors.ErrTxClosed
+	} else if !b.Writable() {
+		return berrors.ErrTxNotWritable
+	} else if len(key) == 0 {
+		return berrors.ErrKeyRequired
+	} else if len(key) > MaxKeySize {
+		return berrors.ErrKeyTooLarge
+	}
+
+	newKey := cloneBytes(key)
+
+	// Return an error if there is an existing key with a bucket value, before
+	// writing any chunk.
+	if k, _, flags := b.Cursor().seek(newKey); bytes.Equal(newKey, k) && (flags&common.BucketLeafFlag) != 0 {
+		return berrors.ErrIncompatibleValue
+	}
+
+	ref, err := b.tx.writeBlob(r)
+	if err != nil {
+		return err
+	}
+
+	// Writing the chunks may have remapped the file, so seek again.
+	c := b.Cursor()
+	k, v, flags := c.seek(newKey)
+
+	// Update the indexes of the bucket, which need the whole values.
+	if defs := b.indexes(); len(defs) > 0 {
+		var old, newValue []byte
+		if bytes.Equal(newKey, k) {
+			old, err = b.indexValue(v, flags)
+		}
+		if err == nil {
+			newValue, err = b.tx.readBlob(ref.Bytes())
+		}
+		if err == nil {
+			err = b.updateIndexes(defs, newKey, old, newValue)
+		}
+		if err != nil {
+			b.tx.freeBlob(ref.Bytes())
+			return err
+		}
+	}
+
+	if bytes.Equal(newKey, k) && (flags&common.BlobLeafFlag) != 0 {
+		b.tx.freeBlob(v)
+	}
+	if !bytes.Equal(newKey, k) {
+		v = nil
+	}
+	b.recordChange(ChangePut, newKey, v, flags, nil)
+	if err := b.deleteExpiry(newKey, v, flags); err != nil {
+		b.tx.freeBlob(ref.Bytes())
+		return err
+	}
+	c.node().put(newKey, newKey, cloneBytes(ref.Bytes()), 0, common.BlobLeafFlag)
+
+	return nil
+}
+
+// GetReader returns a reader of the value for a key in the bucket, which is
+// the only way to read a blob, as Get and the cursors return an empty value
+// for it. Returns nil if the key does not exist or if the key is a nested
+// bucket.
+// The chunks of a blob are read directly from the mmap when the reader is
+// read, and copied into a writer without an intermediate buffer by WriteTo.
+// The reader is only valid for the life of the transaction.
+func (b *Bucket) GetReader(key []byte) io.ReadSeeker {
+	k, v, flags := b.Cursor().seek(key)
+
+	// Return nil if this is a bucket, or if the key doesn't exist.
+	if (flags&common.BucketLeafFlag) != 0 || !bytes.Equal(key, k) {
+		return nil
+	}
+
+	if (flags & common.BlobLeafFlag) != 0 {
+		return newBlobReader(b.tx, *common.LoadBlobRef(v))
+	}
+	return bytes.NewReader(b.value(v, flags))
+}
+
+// blobChunkCap returns the maximum number of data bytes of a chunk.
+func (db *DB) blobChunkCap() int {
+	return blobChunkPages*db.pageSize - int(common.PageHeaderSize) - common.BlobChunkHeaderSize - db.pageTrailerSize()
+}
+
+// writeBlob writes the data read from r to blob chunks, followed by their
+// index, and returns the reference to the blob. The pages are freed again if
+// it fails.
+func (tx *Tx) writeBlob(r io.Reader) (ref common.BlobRef, err error) {
+	// Make the versions of bbolt which can't read blobs refuse the file.
+	tx.meta.AddFeature(common.FeatureBlobs)
+
+	var allocated []*common.Page
+	defer func() {
+		if err != nil {
+			for _, p := range allocated {
+				tx.db.freelist.free(tx.meta.Txid(), p)
+				delete(tx.pages, p.Id())
+			}
+		}
+	}()
+
+	buf := make([]byte, tx.db.blobChunkCap())
+	var ids []common.Pgid
+	var size uint64
+	for {
+		n, rerr := io.ReadFull(r, buf)
+		if rerr == io.EOF {
+			break
+		} else if rerr != nil && rerr != io.ErrUnexpectedEOF {
+			return ref, fmt.Errorf("read blob: %w", rerr)
+		}
+
+		p, aerr := tx.allocateEagerPage(common.BlobPageFlag, int(common.PageHeaderSize)+common.BlobChunkHeaderSize+n)
+		if aerr != nil {
+			return ref, aerr
+		}
+		allocated = append(allocated, common.NewPage(p.Id(), p.Flags(), 0, p.Overflow()))
+		p.BlobChunk().SetSize(uint32(n))
+		copy(p.BlobData(), buf[:n])
+		ids = append(ids, p.Id())
+		size += uint64(n)
+		if werr := tx.writeEagerPage(p); werr != nil {
+			return ref, werr
+		}
+
+		if n < len(buf) {
+			break
+		}
+	}
+
+	// Empty blobs have no index.
+	if len(ids) == 0 {
+		return common.NewBlobRef(0, 0), nil
+	}
+	p, err := tx.writeBlobIndex(ids, uint32(len(buf)))
+	if err != nil {
+		return ref, err
+	}
+	allocated = append(allocated, p)
+	return common.NewBlobRef(size, p.Id()), nil
+}
+
+// writeBlobIndex writes a blob index page listing the given chunks, and
+// returns its header.
+func (tx *Tx) writeBlobIndex(ids []common.Pgid, chunkSize uint32) (*common.Page, error) {
+	p, err := tx.allocateEagerPage(common.BlobIndexPageFlag, common.BlobIndexPageSize(len(ids)))
+	if err != nil {
+		return nil, err
+	}
+	hdr := common.NewPage(p.Id(), p.Flags(), 0, p.Overflow())
+	p.BlobIndex().SetCount(uint32(len(ids)))
+	p.BlobIndex().SetChunkSize(chunkSize)
+	copy(p.BlobChunkIds(), ids)
+	if err := tx.writeEagerPage(p); err != nil {
+		return nil, err
+	}
+	return hdr, nil
+}
+
+// allocateEagerPage allocates a page of the given type, large enough to hold
+// sz bytes, which is written before commit by writeEagerPage.
+func (tx *Tx) allocateEagerPage(flags uint16, sz int) (*common.Page, error) {
+	count := (sz + tx.db.pageTrailerSize() + tx.db.pageSize - 1) / tx.db.pageSize
+	p, err := tx.db.allocate(tx.meta.Txid(), count)
+	if err != nil {
+		return nil, err
+	}
+	tx.eagerPages = append(tx.eagerPages, common.NewPage(p.Id(), flags, 0, p.Overflow()))
+	tx.stats.IncPageCount(int64(count))
+	tx.stats.IncPageAlloc(int64(count * tx.db.pageSize))
+
+	p.SetFlags(flags)
+	p.SetCount(0)
+	return p, nil
+}
+
+// writeEagerPage writes a page to the file, as the pages are written on
+// commit. In WAL mode, it's added to the dirty pages instead, so that it's
+// logged on commit.
+func (tx *Tx) writeEagerPage(p *common.Page) error {
+	if tx.db.wal != nil {
+		tx.pages[p.Id()] = p
+		return nil
+	}
+
+	pages := common.Pages{p}
+	tx.db.setPageChecksums(pages)
+	sealed, err := tx.db.sealPages(pages)
+	if err != nil {
+		return err
+	}
+	buf := common.UnsafeByteSlice(unsafe.Pointer(sealed[0]), 0, 0, (int(p.Overflow())+1)*tx.db.pageSize)
+	if _, err := tx.db.ops.writeAt(buf, int64(p.Id())*int64(tx.db.pageSize)); err != nil {
+		return err
+	}
+	tx.stats.IncWrite(1)
+	tx.db.recordPageWrites(pages, tx.meta.Txid())
+
+	// Put small pages back to page pool.
+	if p.Overflow() == 0 {
+		buf := common.UnsafeByteSlice(unsafe.Pointer(p), 0, 0, tx.db.pageSize)
+		for i := range buf {
+			buf[i] = 0
+		}
+		tx.db.pagePool.Put(buf) //nolint:staticcheck
+	}
+	return nil
+}
+
+// freeBlob frees the chunks and the index of the blob referenced by the leaf
+// value v.
+func (tx *Tx) freeBlob(v []byte) {
+	id := common.LoadBlobRef(v).Index()
+	if id == 0 {
+		return
+	}
+	for _, cid := range tx.page(id).BlobChunkIds() {
+		tx.db.freelist.free(tx.meta.Txid(), tx.page(cid))
+		delete(tx.pages, cid)
+	}
+	tx.db.freelist.free(tx.meta.Txid(), tx.page(id))
+	delete(tx.pages, id)
+}
+
+// readBlob returns the whole value of the blob referenced by the leaf value v.
+func (tx *Tx) readBlob(v []byte) ([]byte, error) {
+	r := newBlobReader(tx, *common.LoadBlobRef(v))
+	buf := make([]byte, r.ref.Size())
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, fmt.Errorf("read blob: %w", err)
+	}
+	return buf, nil
+}
+
+// blobReader reads a blob from its chunk pages. The pages are looked up by id
+// on every read, as a writable transaction may remap the file.
+type blobReader struct {
+	tx        *Tx
+	ref       common.BlobRef
+	off       int64
+	ids       []common.Pgid // page ids of the chunks, loaded on first read
+	chunkSize int64
+}
+
+func newBlobReader(tx *Tx, ref common.BlobRef) *blobReader {
+	return &blobReader{tx: tx, ref: ref}
+}
+
+// data returns the data of the blob from offset off, which must be lower
+// than the size of the blob, to the end of its chunk. Returns the error of
+// the transaction if a page of the blob failed verification or decryption.
+func (r *blobReader) data(off int64) ([]byte, error) {
+	if r.ids == nil {
+		x := r.tx.page(r.ref.Index())
+		if err := r.tx.Err(); err != nil {
+			return nil, err
+		}
+		r.ids = append([]common.Pgid{}, x.BlobChunkIds()...)
+		r.chunkSize = int64(x.BlobIndex().ChunkSize())
+	}
+	i := off / r.chunkSize
+	common.Assert(i < int64(len(r.ids)), "blob offset %d beyond its %d chunks", off, len(r.ids))
+	p := r.tx.page(r.ids[i])
+	if err := r.tx.Err(); err != nil {
+		return nil, err
+	}
+	return p.BlobData()[off-i*r.chunkSize:], nil
+}
+
+// Read implements io.Reader.
+func (r *blobReader) Read(p []byte) (n int, err error) {
+	if r.tx.db == nil {
+		return 0, berrors.ErrTxClosed
+	}
+	size := int64(r.ref.Size())
+	if r.off >= size {
+		return 0, io.EOF
+	}
+	for n < len(p) && r.off < size {
+		data, err := r.data(r.off)
+		if err != nil {
+			return n, err
+		}
+		c := copy(p[n:], data)
+		n += c
+		r.off += int64(c)
+	}
+	return n, nil
+}
+
+// WriteTo implements io.WriterTo. The data is written from the chunk pages.
+func (r *blobReader) WriteTo(w io.Writer) (n int64, err error) {
+	if r.tx.db == nil {
+		return 0, berrors.ErrTxClosed
+	}
+	for r.off < int64(r.ref.Size()) {
+		data, err := r.data(r.off)
+		if err != nil {
+			return n, err
+		}
+		c, err := w.Write(data)
+		n += int64(c)
+		r.off += int64(c)
+		if err != nil {
+			return n, err
+		} else if c != len(data) {
+			return n, io.ErrShortWrite
+		}
+	}
+	return n, nil
+}
+
+// Seek implements io.Seeker.
+func (r *blobReader) Seek(offset int64, whence int) (int64, error) {
+	var abs int64
+	switch whence {
+	case io.SeekStart:
+		abs = offset
+	case io.SeekCurrent:
+		abs = r.off + offset
+	case io.SeekEnd:
+		abs = int64(r.ref.Size()) + offset
+	default:
+		return 0, errors.New("blob seek: invalid whence")
+	}
+	if abs < 0 {
+		return 0, errors.New("blob seek: negative position")
+	}
+	r.off = abs
+	return abs, nil
+}
@@ -0,0 +1,416 @@
+package bbolt_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/iotest"
+
+	"github.com/stretchr/testify/require"
+
+	bolt "go.etcd.io/bbolt"
+	berrors "go.etcd.io/bbolt/errors"
+	"go.etcd.io/bbolt/internal/btesting"
+	"go.etcd.io/bbolt/internal/common"
+)
+
+// blobSizes are the sizes of the blobs stored by putBlobs: empty, smaller
+// than a page, and spanning several chunks.
+var blobSizes = []int{0, 100, 200 * 1024, 1 << 20}
+
+func blobKey(n int) []byte {
+	return []byte(fmt.Sprintf("blob-%08d", n))
+}
+
+// blobData returns n bytes of pseudo-random data.
+func blobData(n int) []byte {
+	data := make([]byte, n)
+	rand.New(rand.NewSource(int64(n))).Read(data)
+	return data
+}
+
+// putBlobs stores a blob of each of the given sizes in the bucket "blobs".
+func putBlobs(t testing.TB, db *btesting.DB, sizes ...int) {
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte("blobs"))
+		if err != nil {
+			return err
+		}
+		for _, n := range sizes {
+			if err := b.PutReader(blobKey(n), bytes.NewReader(blobData(n))); err != nil {
+				return err
+			}
+		}
+		return nil
+	}))
+}
+
+// requireBlobs verifies the blobs stored by putBlobs, through GetReader, Get
+// and a cursor.
+func requireBlobs(t testing.TB, db *bolt.DB, sizes ...int) {
+	require.NoError(t, db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("blobs"))
+		for _, n := range sizes {
+			r := b.GetReader(blobKey(n))
+			require.NotNil(t, r)
+			data, err := io.ReadAll(r)
+			require.NoError(t, err)
+			require.Equal(t, blobData(n), data)
+
+			// Get and the cursors don't read blobs.
+			require.Equal(t, []byte{}, b.Get(blobKey(n)))
+			_, v := b.Cursor().Seek(blobKey(n))
+			require.Equal(t, []byte{}, v)
+		}
+		require.Equal(t, len(sizes), b.Stats().BlobN)
+		return nil
+	}))
+}
+
+// readBlob reads the value of a key with GetReader.
+func readBlob(t testing.TB, b *bolt.Bucket, key []byte) []byte {
+	r := b.GetReader(key)
+	require.NotNil(t, r)
+	data, err := io.ReadAll(r)
+	require.NoError(t, err)
+	return data
+}
+
+// Ensure that reading a corrupted blob chunk returns an error, and that Get
+// and the cursors don't read it.
+func TestBucket_GetReader_Corrupted(t *testing.T) {
+	const size = 200 * 1024
+	db := btesting.MustCreateDBWithOption(t, &bolt.Options{PageChecksums: true})
+	putBlobs(t, db, size)
+	db.MustClose()
+
+	data, err := os.ReadFile(db.Path())
+	require.NoError(t, err)
+	off := bytes.Index(data, blobData(size)[:4096])
+	require.Positive(t, off)
+	data[off+100] ^= 0xff
+	path := filepath.Join(t.TempDir(), "corrupted")
+	require.NoError(t, os.WriteFile(path, data, 0600))
+
+	corrupted, err := bolt.Open(path, 0600, &bolt.Options{ChecksumVerification: bolt.ChecksumVerifyAlways})
+	require.NoError(t, err)
+	defer corrupted.Close()
+
+	tx, err := corrupted.Begin(false)
+	require.NoError(t, err)
+	b := tx.Bucket([]byte("blobs"))
+	require.Equal(t, []byte{}, b.Get(blobKey(size)))
+	require.NoError(t, tx.Err())
+	_, err = io.ReadAll(b.GetReader(blobKey(size)))
+	require.ErrorIs(t, err, berrors.ErrPageChecksum)
+	require.ErrorIs(t, tx.Rollback(), berrors.ErrPageChecksum)
+}
+
+// Ensure that blobs can be written, read and reopened.
+func TestBucket_PutReader(t *testing.T) {
+	db := btesting.MustCreateDB(t)
+	putBlobs(t, db, blobSizes...)
+	requireBlobs(t, db.DB, blobSizes...)
+	db.MustCheck()
+
+	db.MustClose()
+	db.MustReopen()
+	requireBlobs(t, db.DB, blobSizes...)
+}
+
+// Ensure that the meta page flags the blobs once one is committed, so that
+// the versions of bbolt which can't read them refuse the file.
+func TestBucket_PutReader_Format(t *testing.T) {
+	db := btesting.MustCreateDB(t)
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("blobs"))
+		require.NoError(t, err)
+		return b.Put([]byte("foo"), []byte("bar"))
+	}))
+	db.MustClose()
+	require.False(t, fileMeta(t, db.Path()).HasFeature(common.FeatureBlobs))
+
+	db.MustReopen()
+	putBlobs(t, db, blobSizes...)
+	db.MustClose()
+	require.Equal(t, common.FeatureVersion, fileMeta(t, db.Path()).Version())
+	require.True(t, fileMeta(t, db.Path()).HasFeature(common.FeatureBlobs))
+	db.MustReopen()
+	requireBlobs(t, db.DB, blobSizes...)
+}
+
+// Ensure that a blob can be read in parts, from any offset, and copied.
+func TestBucket_GetReader_Seek(t *testing.T) {
+	db := btesting.MustCreateDB(t)
+	const size = 300 * 1024
+	putBlobs(t, db, size)
+	expected := blobData(size)
+
+	require.NoError(t, db.View(func(tx *bolt.Tx) error {
+		r := tx.Bucket([]byte("blobs")).GetReader(blobKey(size))
+
+		for _, off := range []int64{size - 1, 0, 70000, 65530, size / 2} {
+			pos, err := r.Seek(off, io.SeekStart)
+			require.NoError(t, err)
+			require.Equal(t, off, pos)
+			buf := make([]byte, 10000)
+			n, err := io.ReadFull(r, buf)
+			if off+10000 > size {
+				require.ErrorIs(t, err, io.ErrUnexpectedEOF)
+			} else {
+				require.NoError(t, err)
+			}
+			require.Equal(t, expected[off:off+int64(n)], buf[:n])
+		}
+
+		pos, err := r.Seek(-100, io.SeekEnd)
+		require.NoError(t, err)
+		require.Equal(t, int64(size-100), pos)
+		var w bytes.Buffer
+		n, err := io.Copy(&w, r)
+		require.NoError(t, err)
+		require.Equal(t, int64(100), n)
+		require.Equal(t, expected[size-100:], w.Bytes())
+
+		_, err = r.Read(make([]byte, 1))
+		require.ErrorIs(t, err, io.EOF)
+
+		_, err = r.Seek(-1, io.SeekStart)
+		require.Error(t, err)
+		return nil
+	}))
+}
+
+// Ensure that GetReader reads plain values, and returns nil for missing keys
+// and nested buckets.
+func TestBucket_GetReader_Value(t *testing.T) {
+	db := btesting.MustCreateDB(t)
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		require.NoError(t, err)
+		require.NoError(t, b.Put([]byte("foo"), []byte("bar")))
+		_, err = b.CreateBucket([]byte("nested"))
+		require.NoError(t, err)
+
+		data, err := io.ReadAll(b.GetReader([]byte("foo")))
+		require.NoError(t, err)
+		require.Equal(t, []byte("bar"), data)
+		require.Nil(t, b.GetReader([]byte("missing")))
+		require.Nil(t, b.GetReader([]byte("nested")))
+		return nil
+	}))
+}
+
+// Ensure that the chunks of a blob are freed when it's overwritten or deleted,
+// including by deleting its bucket.
+func TestBucket_PutReader_Free(t *testing.T) {
+	const size = 1 << 20
+	for name, fn := range map[string]func(b *bolt.Bucket) error{
+		"put":          func(b *bolt.Bucket) error { return b.Put(blobKey(size), []byte("small")) },
+		"putReader":    func(b *bolt.Bucket) error { return b.PutReader(blobKey(size), bytes.NewReader(blobData(size/2))) },
+		"delete":       func(b *bolt.Bucket) error { return b.Delete(blobKey(size)) },
+		"deleteBucket": func(b *bolt.Bucket) error { return b.Tx().DeleteBucket([]byte("blobs")) },
+		"cursorDelete": func(b *bolt.Bucket) error {
+			c := b.Cursor()
+			c.Seek(blobKey(size))
+			return c.Delete()
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			db := btesting.MustCreateDB(t)
+			putBlobs(t, db, size)
+			require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+				return fn(tx.Bucket([]byte("blobs")))
+			}))
+			db.MustCheck()
+
+			// The freed pages are reused.
+			before := fileSize(db.Path())
+			putBlobs(t, db, size/4)
+			require.Equal(t, before, fileSize(db.Path()))
+			db.MustCheck()
+		})
+	}
+}
+
+// Ensure that the blobs written by a transaction are freed when they are
+// deleted in the same transaction, or when it's rolled back.
+func TestBucket_PutReader_SameTx(t *testing.T) {
+	db := btesting.MustCreateDB(t)
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("blobs"))
+		require.NoError(t, err)
+		require.NoError(t, b.PutReader([]byte("a"), bytes.NewReader(blobData(100000))))
+		require.NoError(t, b.PutReader([]byte("a"), bytes.NewReader(blobData(200000))))
+		require.Equal(t, blobData(200000), readBlob(t, b, []byte("a")))
+		require.NoError(t, b.PutReader([]byte("b"), bytes.NewReader(blobData(100000))))
+		require.NoError(t, b.Delete([]byte("b")))
+
+		// An inline bucket holding a blob.
+		inline, err := tx.CreateBucket([]byte("inline"))
+		require.NoError(t, err)
+		return inline.PutReader([]byte("c"), bytes.NewReader(blobData(100000)))
+	}))
+	db.MustCheck()
+
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		require.NoError(t, tx.Bucket([]byte("inline")).PutReader([]byte("d"), bytes.NewReader(blobData(1000))))
+		return tx.DeleteBucket([]byte("inline"))
+	}))
+	db.MustCheck()
+
+	tx, err := db.Begin(true)
+	require.NoError(t, err)
+	require.NoError(t, tx.Bucket([]byte("blobs")).PutReader([]byte("e"), bytes.NewReader(blobData(100000))))
+	require.NoError(t, tx.Rollback())
+	db.MustCheck()
+
+	db.MustClose()
+	db.MustReopen()
+	require.NoError(t, db.View(func(tx *bolt.Tx) error {
+		require.Equal(t, blobData(200000), readBlob(t, tx.Bucket([]byte("blobs")), []byte("a")))
+		require.Nil(t, tx.Bucket([]byte("blobs")).Get([]byte("e")))
+		return nil
+	}))
+}
+
+// Ensure that an inline bucket opened before a blob grows the file remains
+// readable, whatever the alignment of its value in the mmap.
+func TestBucket_PutReader_Remap(t *testing.T) {
+	for n := 1; n <= 8; n++ {
+		name := bytes.Repeat([]byte("a"), n)
+		t.Run(string(name), func(t *testing.T) {
+			db := btesting.MustCreateDB(t)
+			require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+				b, err := tx.CreateBucket(name)
+				if err != nil {
+					return err
+				}
+				return b.Put([]byte("key"), name)
+			}))
+
+			require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+				b := tx.Bucket(name)
+				require.Equal(t, name, b.Get([]byte("key")))
+				blobs, err := tx.CreateBucket([]byte("blobs"))
+				if err != nil {
+					return err
+				}
+				if err := blobs.PutReader(blobKey(0), bytes.NewReader(make([]byte, 64<<20))); err != nil {
+					return err
+				}
+				require.Equal(t, name, b.Get([]byte("key")))
+				return nil
+			}))
+		})
+	}
+}
+
+// Ensure that PutReader returns an error for invalid keys, and frees the
+// chunks already written if the reader fails.
+func TestBucket_PutReader_Errors(t *testing.T) {
+	db := btesting.MustCreateDB(t)
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		require.NoError(t, err)
+		_, err = b.CreateBucket([]byte("nested"))
+		require.NoError(t, err)
+
+		require.ErrorIs(t, b.PutReader(nil, bytes.NewReader(nil)), berrors.ErrKeyRequired)
+		require.ErrorIs(t, b.PutReader(make([]byte, bolt.MaxKeySize+1), bytes.NewReader(nil)), berrors.ErrKeyTooLarge)
+		require.ErrorIs(t, b.PutReader([]byte("nested"), bytes.NewReader(nil)), berrors.ErrIncompatibleValue)
+
+		errRead := errors.New("read failed")
+		r := io.MultiReader(bytes.NewReader(blobData(300000)), iotest.ErrReader(errRead))
+		require.ErrorIs(t, b.PutReader([]byte("failed"), r), errRead)
+		require.Nil(t, b.Get([]byte("failed")))
+		return nil
+	}))
+	db.MustCheck()
+
+	require.NoError(t, db.View(func(tx *bolt.Tx) error {
+		err := tx.Bucket([]byte("widgets")).PutReader([]byte("foo"), bytes.NewReader(nil))
+		require.ErrorIs(t, err, berrors.ErrTxNotWritable)
+		return nil
+	}))
+}
+
+// Ensure that blobs are stored in WAL mode, with page checksums, encrypted,
+// and without freelist sync.
+func TestBucket_PutReader_Options(t *testing.T) {
+	for name, o := range map[string]*bolt.Options{
+		"wal":            {WALMode: true},
+		"checksums":      {PageChecksums: true, ChecksumVerification: bolt.ChecksumVerifyAlways},
+		"encryption":     {Encryption: testEncryption(testEncryptionKey)},
+		"noFreelistSync": {NoFreelistSync: true},
+	} {
+		t.Run(name, func(t *testing.T) {
+			db := btesting.MustCreateDBWithOption(t, o)
+			putBlobs(t, db, blobSizes...)
+			require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+				return tx.Bucket([]byte("blobs")).Delete(blobKey(blobSizes[2]))
+			}))
+			sizes := []int{blobSizes[0], blobSizes[1], blobSizes[3]}
+			requireBlobs(t, db.DB, sizes...)
+			db.MustCheck()
+
+			db.MustClose()
+			db.MustReopen()
+			requireBlobs(t, db.DB, sizes...)
+			db.MustCheck()
+		})
+	}
+}
+
+// Ensure that Compact copies blobs as blobs.
+func TestCompact_Blobs(t *testing.T) {
+	src := btesting.MustCreateDB(t)
+	putBlobs(t, src, blobSizes...)
+
+	dst, err := bolt.Open(filepath.Join(t.TempDir(), "dst"), 0600, nil)
+	require.NoError(t, err)
+	defer dst.Close()
+	require.NoError(t, bolt.Compact(dst, src.DB, 65536))
+	requireBlobs(t, dst, blobSizes...)
+}
+
+// Ensure that Shrink moves the chunks of blobs, and that incremental backups
+// copy them.
+func TestDB_Shrink_Blobs(t *testing.T) {
+	db := btesting.MustCreateDB(t)
+	require.NoError(t, db.Fill([]byte("low"), 10, 1000, keyGen, valueGen("low")))
+	putBlobs(t, db, blobSizes...)
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		return tx.DeleteBucket([]byte("low"))
+	}))
+
+	path := filepath.Join(t.TempDir(), "backup")
+	var since int
+	require.NoError(t, db.View(func(tx *bolt.Tx) error {
+		since = tx.ID()
+		return tx.CopyFile(path, 0600)
+	}))
+
+	before := fileSize(db.Path())
+	require.NoError(t, db.Shrink(context.Background()))
+	require.Less(t, fileSize(db.Path()), before-200*1024)
+	requireBlobs(t, db.DB, blobSizes...)
+	db.MustCheck()
+
+	var buf bytes.Buffer
+	require.NoError(t, db.View(func(tx *bolt.Tx) error {
+		_, err := tx.WriteIncrementalTo(&buf, since)
+		return err
+	}))
+	require.NoError(t, bolt.ApplyIncremental(path, &buf))
+	restored, err := bolt.Open(path, 0600, &bolt.Options{ReadOnly: true})
+	require.NoError(t, err)
+	defer restored.Close()
+	requireBlobs(t, restored, blobSizes...)
+}
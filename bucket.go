@@ -3,6 +3,7 @@ package bbolt
 import (
 	"bytes"
 	"fmt"
+	"sort"
 	"unsafe"
 
 	"go.etcd.io/bbolt/errors"
@@ -29,11 +30,16 @@ const DefaultFillPercent = 0.5
 // Bucket represents a collection of key/value pairs inside the database.
 type Bucket struct {
 	*common.InBucket
-	tx       *Tx                   // the associated transaction
-	buckets  map[string]*Bucket    // subbucket cache
-	page     *common.Page          // inline page reference
-	rootNode *node                 // materialized node for the root page.
-	nodes    map[common.Pgid]*node // node cache
+	tx         *Tx                   // the associated transaction
+	buckets    map[string]*Bucket    // subbucket cache
+	page       *common.Page          // inline page reference
+	rootNode   *node                 // materialized node for the root page.
+	nodes      map[common.Pgid]*node // node cache
+	ext        common.InBucketExt    // persisted bucket options
+	codec      Codec                 // codec of the values, nil if unset or unknown
+	comparator Comparator            // comparator of the keys, nil if unset or unknown
+	parent     *Bucket               // parent bucket, nil for the root bucket
+	name       []byte                // name of the bucket in its parent
 
 	// Sets the threshold for filling nodes when they split. By default,
 	// the bucket will fill to 50% but it can be useful to increase this
@@ -43,6 +49,23 @@ type Bucket struct {
 	FillPercent float64
 }
 
+// BucketOptions represents the options of a bucket, which are set when it is
+// created and persisted in its header.
+type BucketOptions struct {
+	// Codec compresses the values of the bucket. Keys and nested buckets
+	// aren't compressed. Get and Cursor return the decompressed values.
+	Codec Codec
+
+	// Counted makes the branch pages of the bucket store the number of keys
+	// under each element, so that KeyCount, CountRange, Cursor.SeekIndex and
+	// Cursor.Rank don't iterate over the keys.
+	Counted bool
+
+	// Comparator orders the keys of the bucket, instead of bytes.Compare.
+	// The keys of a bucket with a comparator aren't prefix compressed.
+	Comparator Comparator
+}
+
 // newBucket returns a new bucket associated with a transaction.
 func newBucket(tx *Tx) Bucket {
 	var b = Bucket{tx: tx, FillPercent: DefaultFillPercent}
@@ -71,6 +94,7 @@ func (b *Bucket) Writable() bool {
 // Cursor creates a cursor associated with the bucket.
 // The cursor is only valid as long as the transaction is open.
 // Do not use a cursor after the transaction is closed.
+// See Cursor.Err for the pages which fail checksum verification or decryption.
 func (b *Bucket) Cursor() *Cursor {
 	// Update transaction statistics.
 	b.tx.stats.IncCursorCount(1)
@@ -83,9 +107,23 @@ func (b *Bucket) Cursor() *Cursor {
 }
 
 // Bucket retrieves a nested bucket by name.
-// Returns nil if the bucket does not exist.
+// Returns nil if the bucket does not exist, or if its comparator isn't
+// registered.
 // The bucket instance is only valid for the lifetime of the transaction.
 func (b *Bucket) Bucket(name []byte) *Bucket {
+	if b.reservedBucket(name) {
+		return nil
+	}
+	if child := b.nestedBucket(name); child != nil && child.comparatorKnown() {
+		return child
+	}
+	return nil
+}
+
+// nestedBucket retrieves a nested bucket by name, like Bucket, even if its
+// comparator isn't registered, for the operations which don't compare its
+// keys.
+func (b *Bucket) nestedBucket(name []byte) *Bucket {
 	if b.buckets != nil {
 		if child := b.buckets[string(name)]; child != nil {
 			return child
@@ -102,8 +140,9 @@ func (b *Bucket) Bucket(name []byte) *Bucket {
 	}
 
 	// Otherwise create a bucket and cache it.
-	var child = b.openBucket(v)
+	var child = b.openBucket(v, flags)
 	if b.buckets != nil {
+		child.parent, child.name = b, cloneBytes(name)
 		b.buckets[string(name)] = child
 	}
 
@@ -112,7 +151,7 @@ func (b *Bucket) Bucket(name []byte) *Bucket {
 
 // Helper method that re-interprets a sub-bucket value
 // from a parent into a Bucket
-func (b *Bucket) openBucket(value []byte) *Bucket {
+func (b *Bucket) openBucket(value []byte, flags uint32) *Bucket {
 	var child = newBucket(b.tx)
 
 	// Unaligned access requires a copy to be made.
@@ -134,9 +173,20 @@ func (b *Bucket) openBucket(value []byte) *Bucket {
 		child.InBucket = (*common.InBucket)(unsafe.Pointer(&value[0]))
 	}
 
+	// Load the bucket options.
+	if ext := common.LoadBucketExt(value, flags); ext != nil {
+		child.ext = *ext
+		if id := ext.Codec(); id != 0 {
+			child.codec = lookupCodec(id)
+		}
+		if id := ext.Comparator(); id != 0 {
+			child.comparator = lookupComparator(id)
+		}
+	}
+
 	// Save a reference to the inline page if the bucket is inline.
 	if child.RootPage() == 0 {
-		child.page = (*common.Page)(unsafe.Pointer(&value[common.BucketHeaderSize]))
+		child.page = child.InlinePage(value, flags)
 	}
 
 	return &child
@@ -146,6 +196,15 @@ func (b *Bucket) openBucket(value []byte) *Bucket {
 // Returns an error if the key already exists, if the bucket name is blank, or if the bucket name is too long.
 // The bucket instance is only valid for the lifetime of the transaction.
 func (b *Bucket) CreateBucket(key []byte) (rb *Bucket, err error) {
+	return b.CreateBucketWithOptions(key, nil)
+}
+
+// CreateBucketWithOptions creates a new bucket at the given key with the given
+// persisted options, and returns the new bucket.
+// Returns an error if the key already exists, if the bucket name is blank, if the bucket name is too long,
+// or if the codec isn't registered.
+// The bucket instance is only valid for the lifetime of the transaction.
+func (b *Bucket) CreateBucketWithOptions(key []byte, opts *BucketOptions) (rb *Bucket, err error) {
 	lg := b.tx.db.Logger()
 	lg.Debugf("Creating bucket %q", string(key))
 	defer func() {
@@ -161,6 +220,31 @@ func (b *Bucket) CreateBucket(key []byte) (rb *Bucket, err error) {
 		return nil, errors.ErrTxNotWritable
 	} else if len(key) == 0 {
 		return nil, errors.ErrBucketNameRequired
+	} else if b.reservedBucket(key) {
+		return nil, errors.ErrBucketNameReserved
+	}
+
+	// Create empty, inline bucket.
+	var bucket = Bucket{
+		InBucket:    &common.InBucket{},
+		rootNode:    &node{isLeaf: true},
+		FillPercent: DefaultFillPercent,
+	}
+	if opts != nil && opts.Codec != nil {
+		if lookupCodec(opts.Codec.ID()) == nil {
+			return nil, errors.ErrUnknownCodec
+		}
+		bucket.ext.SetCodec(opts.Codec.ID())
+	}
+	if opts != nil && opts.Counted {
+		bucket.ext.SetFlags(common.BucketCountedFlag)
+	}
+	if opts != nil && opts.Comparator != nil {
+		id := comparatorID(opts.Comparator.Name())
+		if lookupComparator(id) == nil {
+			return nil, errors.ErrUnknownComparator
+		}
+		bucket.ext.SetComparator(id)
 	}
 
 	// Insert into node.
@@ -180,15 +264,11 @@ func (b *Bucket) CreateBucket(key []byte) (rb *Bucket, err error) {
 		return nil, errors.ErrIncompatibleValue
 	}
 
-	// Create empty, inline bucket.
-	var bucket = Bucket{
-		InBucket:    &common.InBucket{},
-		rootNode:    &node{isLeaf: true},
-		FillPercent: DefaultFillPercent,
-	}
 	var value = bucket.write()
 
-	c.node().put(newKey, newKey, value, 0, common.BucketLeafFlag)
+	b.tx.addBucketFeatures(&bucket.ext)
+	c.node().put(newKey, newKey, value, 0, bucket.leafFlags())
+	b.recordChange(ChangeCreateBucket, newKey, nil, 0, nil)
 
 	// Since subbuckets are not allowed on inline buckets, we need to
 	// dereference the inline page, if it exists. This will cause the bucket
@@ -218,6 +298,8 @@ func (b *Bucket) CreateBucketIfNotExists(key []byte) (rb *Bucket, err error) {
 		return nil, errors.ErrTxNotWritable
 	} else if len(key) == 0 {
 		return nil, errors.ErrBucketNameRequired
+	} else if b.reservedBucket(key) {
+		return nil, errors.ErrBucketNameReserved
 	}
 
 	// Insert into node.
@@ -238,8 +320,12 @@ func (b *Bucket) CreateBucketIfNotExists(key []byte) (rb *Bucket, err error) {
 	// Return an error if there is an existing non-bucket key.
 	if bytes.Equal(newKey, k) {
 		if (flags & common.BucketLeafFlag) != 0 {
-			var child = b.openBucket(v)
+			var child = b.openBucket(v, flags)
+			if !child.comparatorKnown() {
+				return nil, errors.ErrUnknownComparator
+			}
 			if b.buckets != nil {
+				child.parent, child.name = b, newKey
 				b.buckets[string(newKey)] = child
 			}
 
@@ -257,6 +343,7 @@ func (b *Bucket) CreateBucketIfNotExists(key []byte) (rb *Bucket, err error) {
 	var value = bucket.write()
 
 	c.node().put(newKey, newKey, value, 0, common.BucketLeafFlag)
+	b.recordChange(ChangeCreateBucket, newKey, nil, 0, nil)
 
 	// Since subbuckets are not allowed on inline buckets, we need to
 	// dereference the inline page, if it exists. This will cause the bucket
@@ -289,39 +376,60 @@ func (b *Bucket) DeleteBucket(key []byte) (err error) {
 
 	// Move cursor to correct position.
 	c := b.Cursor()
-	k, _, flags := c.seek(newKey)
+	k, v, flags := c.seek(newKey)
 
 	// Return an error if bucket doesn't exist or is not a bucket.
-	if !bytes.Equal(newKey, k) {
+	if !bytes.Equal(newKey, k) || b.reservedBucket(newKey) {
 		return errors.ErrBucketNotFound
 	} else if (flags & common.BucketLeafFlag) == 0 {
 		return errors.ErrIncompatibleValue
 	}
 
-	// Recursively delete all child buckets.
-	child := b.Bucket(newKey)
-	err = child.ForEachBucket(func(k []byte) error {
-		if err := child.DeleteBucket(k); err != nil {
-			return fmt.Errorf("delete bucket: %s", err)
-		}
-		return nil
-	})
-	if err != nil {
+	if err := b.releaseBucket(newKey, v, flags); err != nil {
 		return err
 	}
 
+	// Delete the node if we have a matching key.
+	c.node().del(newKey)
+
+	return nil
+}
+
+// releaseBucket deletes the nested buckets of the nested bucket at key, whose
+// leaf value v has the given flags, and releases its pages before its key is
+// deleted.
+func (b *Bucket) releaseBucket(key []byte, v []byte, flags uint32) error {
+	child := b.buckets[string(key)]
+	if child == nil {
+		child = b.openBucket(v, flags)
+		child.parent, child.name = b, cloneBytes(key)
+	}
+
+	// Recursively release all child buckets, without seeking their keys, so
+	// that buckets whose comparator isn't registered can be deleted.
+	c := child.Cursor()
+	for k, _, flags := c.first(); k != nil; k, _, flags = c.next() {
+		if (flags & common.BucketLeafFlag) != 0 {
+			// The cursor hides the values of buckets, so read the header.
+			_, v, _ := c.keyValue()
+			if err := child.releaseBucket(k, v, flags); err != nil {
+				return fmt.Errorf("delete bucket: %s", err)
+			}
+		}
+	}
+
 	// Remove cached copy.
-	delete(b.buckets, string(newKey))
+	delete(b.buckets, string(key))
 
-	// Release all bucket pages to freelist.
+	// Release the blob chunks, and all bucket pages to freelist.
+	child.freeBlobs()
 	child.nodes = nil
 	child.rootNode = nil
 	child.free()
+	b.recordChange(ChangeDeleteBucket, key, nil, 0, nil)
 
-	// Delete the node if we have a matching key.
-	c.node().del(newKey)
-
-	return nil
+	// Clear the indexes of the bucket.
+	return b.tx.clearIndexesOf(child.path())
 }
 
 // MoveBucket moves a sub-bucket from the source bucket to the destination bucket.
@@ -356,14 +464,16 @@ func (b *Bucket) MoveBucket(key []byte, dstBucket *Bucket) (err error) {
 
 	// Move cursor to correct position.
 	c := b.Cursor()
-	k, v, flags := c.seek(newKey)
+	k, v, srcFlags := c.seek(newKey)
 
 	// Return an error if bucket doesn't exist or is not a bucket.
-	if !bytes.Equal(newKey, k) {
+	if !bytes.Equal(newKey, k) || b.reservedBucket(newKey) {
 		return errors.ErrBucketNotFound
-	} else if (flags & common.BucketLeafFlag) == 0 {
+	} else if (srcFlags & common.BucketLeafFlag) == 0 {
 		lg.Errorf("An incompatible key %s exists in the source bucket", string(newKey))
 		return errors.ErrIncompatibleValue
+	} else if dstBucket.reservedBucket(newKey) {
+		return errors.ErrBucketNameReserved
 	}
 
 	// Do nothing (return true directly) if the source bucket and the
@@ -375,7 +485,7 @@ func (b *Bucket) MoveBucket(key []byte, dstBucket *Bucket) (err error) {
 
 	// check whether the key already exists in the destination bucket
 	curDst := dstBucket.Cursor()
-	k, _, flags = curDst.seek(newKey)
+	k, _, flags := curDst.seek(newKey)
 
 	// Return an error if there is an existing key in the destination bucket.
 	if bytes.Equal(newKey, k) {
@@ -386,13 +496,28 @@ func (b *Bucket) MoveBucket(key []byte, dstBucket *Bucket) (err error) {
 		return errors.ErrIncompatibleValue
 	}
 
+	// The expiry entries of the keys of the sub-bucket hold its path.
+	if err := b.tx.moveExpiryEntries(b.nestedBucket(newKey), append(b.path(), newKey), append(dstBucket.path(), newKey)); err != nil {
+		return err
+	}
+
 	// remove the sub-bucket from the source bucket
 	delete(b.buckets, string(newKey))
 	c.node().del(newKey)
 
 	// add te sub-bucket to the destination bucket
 	newValue := cloneBytes(v)
-	curDst.node().put(newKey, newKey, newValue, 0, common.BucketLeafFlag)
+	curDst.node().put(newKey, newKey, newValue, 0, srcFlags)
+
+	if b.tx.watching {
+		b.tx.changes = append(b.tx.changes, ChangeEvent{
+			Type:      ChangeMoveBucket,
+			TxID:      b.tx.ID(),
+			Bucket:    b.path(),
+			Key:       newKey,
+			DstBucket: dstBucket.path(),
+		})
+	}
 
 	return nil
 }
@@ -408,8 +533,10 @@ func (b *Bucket) recursivelyInspect(name []byte) BucketStructure {
 	keyN := 0
 	c := b.Cursor()
 	for k, _, flags := c.first(); k != nil; k, _, flags = c.next() {
-		if flags&common.BucketLeafFlag != 0 {
-			childBucket := b.Bucket(k)
+		if b.reservedBucket(k) {
+			continue
+		} else if flags&common.BucketLeafFlag != 0 {
+			childBucket := b.nestedBucket(k)
 			childBS := childBucket.recursivelyInspect(k)
 			bs.Children = append(bs.Children, childBS)
 		} else {
@@ -422,7 +549,10 @@ func (b *Bucket) recursivelyInspect(name []byte) BucketStructure {
 }
 
 // Get retrieves the value for a key in the bucket.
-// Returns a nil value if the key does not exist or if the key is a nested bucket.
+// Returns a nil value if the key does not exist or if the key is a nested bucket,
+// and an empty value if the value is a blob, which is read by GetReader.
+// A page which fails checksum verification or decryption is read as empty, so
+// its keys are reported missing; use Lookup to tell them apart, or Tx.Err.
 // The returned value is only valid for the life of the transaction.
 // The returned memory is owned by bbolt and must never be modified; writing to this memory might corrupt the database.
 func (b *Bucket) Get(key []byte) []byte {
@@ -434,17 +564,70 @@ func (b *Bucket) Get(key []byte) []byte {
 	}
 
 	// If our target node isn't the same key as what's passed in then return nil.
-	if !bytes.Equal(key, k) {
+	if !bytes.Equal(key, k) || b.expired(v, flags) {
 		return nil
 	}
-	return v
+	return b.value(v, flags)
+}
+
+// Lookup retrieves the value for a key like Get, but returns the error of
+// Tx.Err if a page read by the transaction failed checksum verification or
+// decryption, rather than a value which may be missing because of it.
+func (b *Bucket) Lookup(key []byte) ([]byte, error) {
+	v := b.Get(key)
+	if err := b.tx.Err(); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// GetMany retrieves the values of several keys, like Get, and calls fn with
+// the index of each key in keys, the key and its value, in the order of keys.
+// The keys are looked up in sorted order with a single cursor, whose search
+// starts from the lowest page holding both the previous key and the next one,
+// rather than from the root. If fn returns an error, GetMany stops and
+// returns it. If a page read by the transaction failed checksum verification
+// or decryption, fn isn't called, and the error of Tx.Err is returned.
+// The values are only valid for the life of the transaction.
+func (b *Bucket) GetMany(keys [][]byte, fn func(i int, key, value []byte) error) error {
+	order := make([]int, len(keys))
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(i, j int) bool { return b.compare(keys[order[i]], keys[order[j]]) < 0 })
+
+	values := make([][]byte, len(keys))
+	c := b.Cursor()
+	for _, i := range order {
+		k, v, flags := c.seekNear(keys[i])
+		if (flags&common.BucketLeafFlag) == 0 && bytes.Equal(keys[i], k) && !b.expired(v, flags) {
+			values[i] = b.value(v, flags)
+		}
+	}
+	if err := b.tx.Err(); err != nil {
+		return err
+	}
+
+	for i, key := range keys {
+		if err := fn(i, key, values[i]); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 // Put sets the value for a key in the bucket.
 // If the key exist then its previous value will be overwritten.
 // Supplied value must remain valid for the life of the transaction.
 // Returns an error if the bucket was created from a read-only transaction, if the key is blank, if the key is too large, or if the value is too large.
-func (b *Bucket) Put(key []byte, value []byte) (err error) {
+func (b *Bucket) Put(key []byte, value []byte) error {
+	return b.put(b.Cursor(), key, value, 0)
+}
+
+// put sets the value for a key in the bucket, which expires at the given
+// time, in nanoseconds since the Unix epoch, or never if it's 0. The key is
+// searched from the position of the cursor c, which is left on the key.
+func (b *Bucket) put(c *Cursor, key []byte, value []byte, expiry int64) (err error) {
 	lg := b.tx.db.Logger()
 	lg.Debugf("Putting key %q", string(key))
 	defer func() {
@@ -465,6 +648,11 @@ func (b *Bucket) Put(key []byte, value []byte) (err error) {
 	} else if int64(len(value)) > MaxValueSize {
 		return errors.ErrValueTooLarge
 	}
+	plain := value
+	value, leafFlags, err := b.leafValue(value, expiry)
+	if err != nil {
+		return err
+	}
 
 	// Insert into node.
 	// Tip: Use a new variable `newKey` instead of reusing the existing `key` to prevent
@@ -472,8 +660,7 @@ func (b *Bucket) Put(key []byte, value []byte) (err error) {
 	newKey := cloneBytes(key)
 
 	// Move cursor to correct position.
-	c := b.Cursor()
-	k, _, flags := c.seek(newKey)
+	k, v, flags := c.seekNear(newKey)
 
 	// Return an error if there is an existing key with a bucket value.
 	if bytes.Equal(newKey, k) && (flags&common.BucketLeafFlag) != 0 {
@@ -482,11 +669,77 @@ func (b *Bucket) Put(key []byte, value []byte) (err error) {
 
 	// gofail: var beforeBucketPut struct{}
 
-	c.node().put(newKey, newKey, value, 0, 0)
+	// Return an error if the expiry entry of the key is too large.
+	var entry []byte
+	if expiry != 0 {
+		if entry = expiryEntry(expiry, b.path(), newKey); len(entry) > MaxKeySize {
+			return errors.ErrKeyTooLarge
+		}
+	}
+
+	// Update the indexes of the bucket.
+	if defs := b.indexes(); len(defs) > 0 {
+		var old []byte
+		if bytes.Equal(newKey, k) {
+			if old, err = b.indexValue(v, flags); err != nil {
+				return err
+			}
+		}
+		newValue := plain
+		if newValue == nil {
+			newValue = []byte{}
+		}
+		if err := b.updateIndexes(defs, newKey, old, newValue); err != nil {
+			return err
+		}
+	}
+
+	// Free the chunks of a blob value being overwritten.
+	if bytes.Equal(newKey, k) && (flags&common.BlobLeafFlag) != 0 {
+		b.tx.freeBlob(v)
+	}
+	if !bytes.Equal(newKey, k) {
+		v = nil
+	}
+	b.recordChange(ChangePut, newKey, v, flags, plain)
+
+	// Replace the expiry entry of the key.
+	if err := b.deleteExpiry(newKey, v, flags); err != nil {
+		return err
+	}
+	if entry != nil {
+		if err := b.tx.putExpiryEntry(entry); err != nil {
+			return err
+		}
+	}
+
+	c.leafNode().put(newKey, newKey, value, 0, leafFlags)
 
 	return nil
 }
 
+// leafValue returns the value stored in the leaf of a key set to value, which
+// is compressed if the bucket has a codec and prefixed with the expiry time if
+// it's not 0, and the flags of the key.
+func (b *Bucket) leafValue(value []byte, expiry int64) ([]byte, uint32, error) {
+	// Compress the value if the bucket has a codec.
+	if b.ext.Codec() != 0 {
+		if b.codec == nil {
+			return nil, 0, errors.ErrUnknownCodec
+		}
+		var err error
+		if value, err = encodeValue(b.codec, value); err != nil {
+			return nil, 0, fmt.Errorf("encode value: %w", err)
+		}
+	}
+
+	// Prepend the expiry time of an expiring value.
+	if expiry == 0 {
+		return value, 0, nil
+	}
+	return appendExpiry(make([]byte, 0, expirySize+len(value)), expiry, value), common.ExpiringLeafFlag, nil
+}
+
 // Delete removes a key from the bucket.
 // If the key does not exist then nothing is done and a nil error is returned.
 // Returns an error if the bucket was created from a read-only transaction.
@@ -509,7 +762,7 @@ func (b *Bucket) Delete(key []byte) (err error) {
 
 	// Move cursor to correct position.
 	c := b.Cursor()
-	k, _, flags := c.seek(key)
+	k, v, flags := c.seek(key)
 
 	// Return nil if the key doesn't exist.
 	if !bytes.Equal(key, k) {
@@ -521,12 +774,42 @@ func (b *Bucket) Delete(key []byte) (err error) {
 		return errors.ErrIncompatibleValue
 	}
 
+	if err := b.releaseValue(key, v, flags); err != nil {
+		return err
+	}
+
 	// Delete the node if we have a matching key.
 	c.node().del(key)
 
 	return nil
 }
 
+// releaseValue updates the indexes, frees the blob chunks and deletes the
+// expiry time of a key, whose leaf value v has the given flags, before the
+// key is deleted.
+func (b *Bucket) releaseValue(key []byte, v []byte, flags uint32) error {
+	// Update the indexes of the bucket.
+	if defs := b.indexes(); len(defs) > 0 {
+		old, err := b.indexValue(v, flags)
+		if err != nil {
+			return err
+		}
+		if err := b.updateIndexes(defs, key, old, nil); err != nil {
+			return err
+		}
+	}
+
+	// Free the chunks of a blob value.
+	if (flags & common.BlobLeafFlag) != 0 {
+		b.tx.freeBlob(v)
+	}
+	if err := b.deleteExpiry(key, v, flags); err != nil {
+		return err
+	}
+	b.recordChange(ChangeDelete, key, v, flags, nil)
+	return nil
+}
+
 // Sequence returns the current integer for the bucket without incrementing it.
 func (b *Bucket) Sequence() uint64 {
 	return b.InSequence()
@@ -575,17 +858,22 @@ func (b *Bucket) NextSequence() (uint64, error) {
 // If the provided function returns an error then the iteration is stopped and
 // the error is returned to the caller. The provided function must not modify
 // the bucket; this will result in undefined behavior.
+// If a page read by the transaction fails checksum verification or
+// decryption, the iteration is stopped and the error of Tx.Err is returned.
 func (b *Bucket) ForEach(fn func(k, v []byte) error) error {
 	if b.tx.db == nil {
 		return errors.ErrTxClosed
 	}
 	c := b.Cursor()
 	for k, v := c.First(); k != nil; k, v = c.Next() {
+		if err := b.tx.Err(); err != nil {
+			return err
+		}
 		if err := fn(k, v); err != nil {
 			return err
 		}
 	}
-	return nil
+	return b.tx.Err()
 }
 
 func (b *Bucket) ForEachBucket(fn func(k []byte) error) error {
@@ -594,13 +882,16 @@ func (b *Bucket) ForEachBucket(fn func(k []byte) error) error {
 	}
 	c := b.Cursor()
 	for k, _, flags := c.first(); k != nil; k, _, flags = c.next() {
-		if flags&common.BucketLeafFlag != 0 {
+		if err := b.tx.Err(); err != nil {
+			return err
+		}
+		if flags&common.BucketLeafFlag != 0 && !b.reservedBucket(k) {
 			if err := fn(k); err != nil {
 				return err
 			}
 		}
 	}
-	return nil
+	return b.tx.Err()
 }
 
 // Stats returns stats on a bucket.
@@ -615,6 +906,34 @@ func (b *Bucket) Stats() BucketStats {
 		if p.IsLeafPage() {
 			s.KeyN += int(p.Count())
 
+			// Add the value sizes, before and after compression.
+			for i := uint16(0); i < p.Count(); i++ {
+				e := p.LeafPageElement(i)
+				if e.IsBucketEntry() {
+					continue
+				}
+				if e.IsBlobEntry() {
+					ref := common.LoadBlobRef(e.Value())
+					s.BlobN++
+					s.LogicalValueBytes += int(ref.Size())
+					s.PhysicalValueBytes += int(ref.Size())
+					if ref.Index() != 0 {
+						x := b.tx.page(ref.Index())
+						s.BlobPageN += int(x.Overflow()) + 1
+						for _, id := range x.BlobChunkIds() {
+							s.BlobPageN += int(b.tx.page(id).Overflow()) + 1
+						}
+					}
+					continue
+				}
+				s.PhysicalValueBytes += int(e.Vsize())
+				if b.ext.Codec() != 0 {
+					s.LogicalValueBytes += valueSize(e.Value())
+				} else {
+					s.LogicalValueBytes += int(e.Vsize())
+				}
+			}
+
 			// used totals the used bytes for the page
 			used := common.PageHeaderSize
 
@@ -629,6 +948,11 @@ func (b *Bucket) Stats() BucketStats {
 				// It also includes the last element's header.
 				lastElement := p.LeafPageElement(p.Count() - 1)
 				used += uintptr(lastElement.Pos() + lastElement.Ksize() + lastElement.Vsize())
+
+				// Add the bytes saved by storing the key prefix once.
+				if prefix := p.LeafKeyPrefix(); prefix != nil {
+					s.LeafPrefixSaved += common.KeyPrefixSavedBytes(int(p.Count()), len(prefix))
+				}
 			}
 
 			if b.RootPage() == 0 {
@@ -646,9 +970,13 @@ func (b *Bucket) Stats() BucketStats {
 				for i := uint16(0); i < p.Count(); i++ {
 					e := p.LeafPageElement(i)
 					if (e.Flags() & common.BucketLeafFlag) != 0 {
+						if b.reservedBucket(e.Key()) {
+							s.KeyN--
+							continue
+						}
 						// For any bucket element, open the element value
 						// and recursively call Stats on the contained bucket.
-						subStats.Add(b.openBucket(e.Value()).Stats())
+						subStats.Add(b.openBucket(e.Value(), e.Flags()).Stats())
 					}
 				}
 			}
@@ -664,6 +992,9 @@ func (b *Bucket) Stats() BucketStats {
 			// Again, use the fact that last element's position equals to
 			// the total of key, value sizes of all previous elements.
 			used += uintptr(lastElement.Pos() + lastElement.Ksize())
+			if b.ext.IsCounted() {
+				used += common.SubtreeKeyCountSize
+			}
 			s.BranchInuse += int(used)
 			s.BranchOverflowN += int(p.Overflow())
 		}
@@ -748,9 +1079,8 @@ func (b *Bucket) spill() error {
 			}
 
 			// Update the child bucket header in this bucket.
-			value = make([]byte, unsafe.Sizeof(common.InBucket{}))
-			var bucket = (*common.InBucket)(unsafe.Pointer(&value[0]))
-			*bucket = *child.InBucket
+			value = make([]byte, common.BucketValueHeaderSize(child.leafFlags()))
+			child.writeHeader(value)
 		}
 
 		// Skip writing the bucket if there are no materialized nodes.
@@ -759,15 +1089,7 @@ func (b *Bucket) spill() error {
 		}
 
 		// Update parent node.
-		var c = b.Cursor()
-		k, _, flags := c.seek([]byte(name))
-		if !bytes.Equal([]byte(name), k) {
-			panic(fmt.Sprintf("misplaced bucket header: %x -> %x", []byte(name), k))
-		}
-		if flags&common.BucketLeafFlag == 0 {
-			panic(fmt.Sprintf("unexpected bucket header flag: %x", flags))
-		}
-		c.node().put([]byte(name), []byte(name), value, 0, common.BucketLeafFlag)
+		b.putBucketHeader([]byte(name), value, child.leafFlags())
 	}
 
 	// Ignore if there's not a materialized root node.
@@ -790,6 +1112,19 @@ func (b *Bucket) spill() error {
 	return nil
 }
 
+// putBucketHeader replaces the value of the nested bucket name with value.
+func (b *Bucket) putBucketHeader(name []byte, value []byte, leafFlags uint32) {
+	var c = b.Cursor()
+	k, _, flags := c.seek(name)
+	if !bytes.Equal(name, k) {
+		panic(fmt.Sprintf("misplaced bucket header: %x -> %x", name, k))
+	}
+	if flags&common.BucketLeafFlag == 0 {
+		panic(fmt.Sprintf("unexpected bucket header flag: %x", flags))
+	}
+	c.node().put(name, name, value, 0, leafFlags)
+}
+
 // inlineable returns true if a bucket is small enough to be written inline
 // and if it contains no subbuckets. Otherwise, returns false.
 func (b *Bucket) inlineable() bool {
@@ -825,19 +1160,97 @@ func (b *Bucket) maxInlineBucketSize() uintptr {
 func (b *Bucket) write() []byte {
 	// Allocate the appropriate size.
 	var n = b.rootNode
-	var value = make([]byte, common.BucketHeaderSize+n.size())
+	var headerSize = common.BucketValueHeaderSize(b.leafFlags())
+	var value = make([]byte, headerSize+n.size())
 
 	// Write a bucket header.
-	var bucket = (*common.InBucket)(unsafe.Pointer(&value[0]))
-	*bucket = *b.InBucket
+	b.writeHeader(value)
 
 	// Convert byte slice to a fake page and write the root node.
-	var p = (*common.Page)(unsafe.Pointer(&value[common.BucketHeaderSize]))
+	var p = (*common.Page)(unsafe.Pointer(&value[headerSize]))
 	n.write(p)
 
 	return value
 }
 
+// writeHeader writes the bucket header, and the extended header if the
+// bucket has options, to the start of value.
+func (b *Bucket) writeHeader(value []byte) {
+	var bucket = (*common.InBucket)(unsafe.Pointer(&value[0]))
+	*bucket = *b.InBucket
+
+	if !b.ext.IsZero() {
+		var ext = (*common.InBucketExt)(unsafe.Pointer(&value[common.BucketHeaderSize]))
+		*ext = b.ext
+	}
+}
+
+// leafFlags returns the flags of the bucket key in its parent bucket.
+func (b *Bucket) leafFlags() uint32 {
+	if b.ext.IsZero() {
+		return common.BucketLeafFlag
+	}
+	return common.BucketLeafFlag | common.BucketExtLeafFlag
+}
+
+// addBucketFeatures flags the features of the format used by a bucket with
+// the extended header ext in the meta, when it's created, so that the
+// versions of bbolt which can't read them refuse the file.
+func (tx *Tx) addBucketFeatures(ext *common.InBucketExt) {
+	if !ext.IsZero() {
+		tx.meta.AddFeature(common.FeatureBucketOptions)
+	}
+	// Writers which don't maintain the key counts would corrupt them.
+	if ext.IsCounted() {
+		tx.meta.AddFeature(common.FeatureCountedBuckets)
+	}
+	// Writers which order the keys with bytes.Compare would misplace them.
+	if ext.Comparator() != 0 {
+		tx.meta.AddFeature(common.FeatureComparators)
+	}
+}
+
+// prefixCompression returns whether the leaf pages of the bucket are written
+// with a key prefix. Only the keys in bytes.Compare order share the prefix of
+// the first and the last keys of their page, so the keys of buckets with a
+// comparator aren't prefix compressed.
+func (b *Bucket) prefixCompression() bool {
+	return b.tx.db.PrefixCompression && b.ext.Comparator() == 0
+}
+
+// Options returns the persisted options of the bucket.
+func (b *Bucket) Options() BucketOptions {
+	var opts BucketOptions
+	if id := b.ext.Codec(); id != 0 {
+		opts.Codec = lookupCodec(id)
+	}
+	opts.Counted = b.ext.IsCounted()
+	if id := b.ext.Comparator(); id != 0 {
+		opts.Comparator = lookupComparator(id)
+	}
+	return opts
+}
+
+// value returns the original value of a value stored in the bucket, given
+// the flags of its key. Blobs aren't read, they're returned as an empty value,
+// and the other values are decompressed if the bucket has a codec.
+func (b *Bucket) value(v []byte, flags uint32) []byte {
+	if v != nil && (flags&common.BlobLeafFlag) != 0 {
+		return v[:0:0]
+	}
+	if (flags & common.ExpiringLeafFlag) != 0 {
+		v = v[expirySize:]
+	}
+	if v == nil || b.ext.Codec() == 0 {
+		return v
+	}
+	dv, err := decodeValue(b.codec, v)
+	if err != nil {
+		panic(fmt.Sprintf("decode value of bucket with codec %d: %v", b.ext.Codec(), err))
+	}
+	return dv
+}
+
 // rebalance attempts to balance all nodes.
 func (b *Bucket) rebalance() {
 	for _, n := range b.nodes {
@@ -898,17 +1311,45 @@ func (b *Bucket) free() {
 	b.SetRootPage(0)
 }
 
+// freeBlobs frees the chunks of every blob value in the bucket, including
+// the ones written by the current transaction.
+func (b *Bucket) freeBlobs() {
+	c := b.Cursor()
+	for k, v, flags := c.first(); k != nil; k, v, flags = c.next() {
+		if (flags & common.BlobLeafFlag) != 0 {
+			b.tx.freeBlob(v)
+		}
+	}
+}
+
 // dereference removes all references to the old mmap.
 func (b *Bucket) dereference() {
 	if b.rootNode != nil {
 		b.rootNode.root().dereference()
 	}
 
+	// The inline page may point into the bucket value in the mmap, which is
+	// remapped before commit when blobs are written.
+	if b.page != nil {
+		b.page = cloneInlinePage(b.page)
+	}
+
 	for _, child := range b.buckets {
 		child.dereference()
 	}
 }
 
+// cloneInlinePage returns a copy of the inline page of a bucket.
+func cloneInlinePage(p *common.Page) *common.Page {
+	sz := int(common.PageHeaderSize)
+	if p.Count() != 0 {
+		last := p.LeafPageElement(p.Count() - 1)
+		sz += int(common.LeafPageElementSize)*int(p.Count()-1) + int(last.Pos()+last.Ksize()+last.Vsize())
+	}
+	buf := cloneBytes(unsafe.Slice((*byte)(unsafe.Pointer(p)), sz))
+	return (*common.Page)(unsafe.Pointer(&buf[0]))
+}
+
 // pageNode returns the in-memory node, if it exists.
 // Otherwise, returns the underlying page.
 func (b *Bucket) pageNode(id common.Pgid) (*common.Page, *node) {
@@ -947,12 +1388,22 @@ type BucketStats struct {
 	KeyN  int // number of keys/value pairs
 	Depth int // number of levels in B+tree
 
+	// Value size statistics.
+	LogicalValueBytes  int // total size of the values, as returned by Get
+	PhysicalValueBytes int // total size of the values as stored, after compression
+
+	// Blob statistics.
+	BlobN     int // number of values stored as blobs
+	BlobPageN int // number of physical blob chunk pages
+
 	// Page size utilization.
 	BranchAlloc int // bytes allocated for physical branch pages
 	BranchInuse int // bytes actually used for branch data
 	LeafAlloc   int // bytes allocated for physical leaf pages
 	LeafInuse   int // bytes actually used for leaf data
 
+	LeafPrefixSaved int // bytes of leaf keys saved by prefix compression
+
 	// Bucket statistics
 	BucketN           int // total number of buckets including the top bucket
 	InlineBucketN     int // total number on inlined buckets
@@ -965,6 +1416,10 @@ func (s *BucketStats) Add(other BucketStats) {
 	s.LeafPageN += other.LeafPageN
 	s.LeafOverflowN += other.LeafOverflowN
 	s.KeyN += other.KeyN
+	s.LogicalValueBytes += other.LogicalValueBytes
+	s.PhysicalValueBytes += other.PhysicalValueBytes
+	s.BlobN += other.BlobN
+	s.BlobPageN += other.BlobPageN
 	if s.Depth < other.Depth {
 		s.Depth = other.Depth
 	}
@@ -972,6 +1427,7 @@ func (s *BucketStats) Add(other BucketStats) {
 	s.BranchInuse += other.BranchInuse
 	s.LeafAlloc += other.LeafAlloc
 	s.LeafInuse += other.LeafInuse
+	s.LeafPrefixSaved += other.LeafPrefixSaved
 
 	s.BucketN += other.BucketN
 	s.InlineBucketN += other.InlineBucketN
@@ -21,6 +21,66 @@ import (
 	"go.etcd.io/bbolt/internal/btesting"
 )
 
+// Ensure that GetMany returns the same values as Get, in the order of the
+// keys, for missing, duplicate and nested bucket keys.
+func TestBucket_GetMany(t *testing.T) {
+	for _, opts := range []bolt.BucketOptions{{}, {Codec: bolt.FlateCodec}, {Comparator: bolt.ReverseComparator}} {
+		t.Run(fmt.Sprintf("codec %v, comparator %v", opts.Codec != nil, opts.Comparator != nil), func(t *testing.T) {
+			db := btesting.MustCreateDB(t)
+			const n = 5000
+			require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+				b, err := tx.CreateBucketWithOptions([]byte("widgets"), &opts)
+				require.NoError(t, err)
+				for i := 0; i < n; i += 2 {
+					require.NoError(t, b.Put([]byte(fmt.Sprintf("%05d", i)), []byte(fmt.Sprintf("value-%d", i))))
+				}
+				_, err = b.CreateBucket([]byte("00001"))
+				return err
+			}))
+
+			r := rand.New(rand.NewSource(42))
+			keys := [][]byte{[]byte("00001"), []byte("00002"), []byte("zzz"), []byte("00002")}
+			for i := 0; i < 2000; i++ {
+				keys = append(keys, []byte(fmt.Sprintf("%05d", r.Intn(n+10))))
+			}
+			require.NoError(t, db.View(func(tx *bolt.Tx) error {
+				b := tx.Bucket([]byte("widgets"))
+				var calls int
+				require.NoError(t, b.GetMany(keys, func(i int, key, value []byte) error {
+					require.Equal(t, calls, i)
+					require.Equal(t, keys[i], key)
+					require.Equal(t, b.Get(key), value)
+					calls++
+					return nil
+				}))
+				require.Equal(t, len(keys), calls)
+
+				// The values of the missing keys and of the nested buckets are nil.
+				var values [][]byte
+				require.NoError(t, b.GetMany(keys[:4], func(i int, key, value []byte) error {
+					values = append(values, value)
+					return nil
+				}))
+				require.Equal(t, [][]byte{nil, []byte("value-2"), nil, []byte("value-2")}, values)
+
+				errStop := errors.New("stop")
+				calls = 0
+				require.ErrorIs(t, b.GetMany(keys, func(i int, key, value []byte) error {
+					calls++
+					return errStop
+				}), errStop)
+				require.Equal(t, 1, calls)
+
+				require.NoError(t, b.GetMany(nil, func(i int, key, value []byte) error {
+					t.Fatal("unexpected call")
+					return nil
+				}))
+				return nil
+			}))
+		})
+	}
+}
+
 // Ensure that a bucket that gets a non-existent key returns nil.
 func TestBucket_Get_NonExistent(t *testing.T) {
 	db := btesting.MustCreateDB(t)
@@ -1264,56 +1324,71 @@ func TestBucket_Stats(t *testing.T) {
 
 	pageSize2stats := map[int]bolt.BucketStats{
 		4096: {
-			BranchPageN:     1,
-			BranchOverflowN: 0,
-			LeafPageN:       7,
-			LeafOverflowN:   10,
-			KeyN:            501,
-			Depth:           2,
-			BranchAlloc:     4096,
-			BranchInuse:     149,
-			LeafAlloc:       69632,
+			BranchPageN:        1,
+			BranchOverflowN:    0,
+			LeafPageN:          7,
+			LeafOverflowN:      10,
+			KeyN:               501,
+			Depth:              2,
+			LogicalValueBytes:  1*10 + 2*90 + 3*400 + longKeyLength,
+			PhysicalValueBytes: 1*10 + 2*90 + 3*400 + longKeyLength,
+			BlobN:              0,
+			BlobPageN:          0,
+			BranchAlloc:        4096,
+			BranchInuse:        149,
+			LeafAlloc:          69632,
 			LeafInuse: 0 +
 				7*16 + // leaf page header (x LeafPageN)
 				501*16 + // leaf elements
 				500*3 + len(bigKey) + // leaf keys
 				1*10 + 2*90 + 3*400 + longKeyLength, // leaf values: 10 * 1digit, 90*2digits, ...
+			LeafPrefixSaved:   0,
 			BucketN:           1,
 			InlineBucketN:     0,
 			InlineBucketInuse: 0},
 		16384: {
-			BranchPageN:     1,
-			BranchOverflowN: 0,
-			LeafPageN:       3,
-			LeafOverflowN:   10,
-			KeyN:            501,
-			Depth:           2,
-			BranchAlloc:     16384,
-			BranchInuse:     73,
-			LeafAlloc:       212992,
+			BranchPageN:        1,
+			BranchOverflowN:    0,
+			LeafPageN:          3,
+			LeafOverflowN:      10,
+			KeyN:               501,
+			Depth:              2,
+			LogicalValueBytes:  1*10 + 2*90 + 3*400 + longKeyLength,
+			PhysicalValueBytes: 1*10 + 2*90 + 3*400 + longKeyLength,
+			BlobN:              0,
+			BlobPageN:          0,
+			BranchAlloc:        16384,
+			BranchInuse:        73,
+			LeafAlloc:          212992,
 			LeafInuse: 0 +
 				3*16 + // leaf page header (x LeafPageN)
 				501*16 + // leaf elements
 				500*3 + len(bigKey) + // leaf keys
 				1*10 + 2*90 + 3*400 + longKeyLength, // leaf values: 10 * 1digit, 90*2digits, ...
+			LeafPrefixSaved:   0,
 			BucketN:           1,
 			InlineBucketN:     0,
 			InlineBucketInuse: 0},
 		65536: {
-			BranchPageN:     1,
-			BranchOverflowN: 0,
-			LeafPageN:       2,
-			LeafOverflowN:   10,
-			KeyN:            501,
-			Depth:           2,
-			BranchAlloc:     65536,
-			BranchInuse:     54,
-			LeafAlloc:       786432,
+			BranchPageN:        1,
+			BranchOverflowN:    0,
+			LeafPageN:          2,
+			LeafOverflowN:      10,
+			KeyN:               501,
+			Depth:              2,
+			LogicalValueBytes:  1*10 + 2*90 + 3*400 + longKeyLength,
+			PhysicalValueBytes: 1*10 + 2*90 + 3*400 + longKeyLength,
+			BlobN:              0,
+			BlobPageN:          0,
+			BranchAlloc:        65536,
+			BranchInuse:        54,
+			LeafAlloc:          786432,
 			LeafInuse: 0 +
 				2*16 + // leaf page header (x LeafPageN)
 				501*16 + // leaf elements
 				500*3 + len(bigKey) + // leaf keys
 				1*10 + 2*90 + 3*400 + longKeyLength, // leaf values: 10 * 1digit, 90*2digits, ...
+			LeafPrefixSaved:   0,
 			BucketN:           1,
 			InlineBucketN:     0,
 			InlineBucketInuse: 0},
@@ -1760,47 +1835,62 @@ func TestBucket_Stats_Large(t *testing.T) {
 
 	pageSize2stats := map[int]bolt.BucketStats{
 		4096: {
-			BranchPageN:       13,
-			BranchOverflowN:   0,
-			LeafPageN:         1196,
-			LeafOverflowN:     0,
-			KeyN:              100000,
-			Depth:             3,
-			BranchAlloc:       53248,
-			BranchInuse:       25257,
-			LeafAlloc:         4898816,
-			LeafInuse:         2596916,
-			BucketN:           1,
-			InlineBucketN:     0,
-			InlineBucketInuse: 0},
+			BranchPageN:        13,
+			BranchOverflowN:    0,
+			LeafPageN:          1196,
+			LeafOverflowN:      0,
+			KeyN:               100000,
+			Depth:              3,
+			LogicalValueBytes:  488890,
+			PhysicalValueBytes: 488890,
+			BlobN:              0,
+			BlobPageN:          0,
+			BranchAlloc:        53248,
+			BranchInuse:        25257,
+			LeafAlloc:          4898816,
+			LeafInuse:          2596916,
+			LeafPrefixSaved:    0,
+			BucketN:            1,
+			InlineBucketN:      0,
+			InlineBucketInuse:  0},
 		16384: {
-			BranchPageN:       1,
-			BranchOverflowN:   0,
-			LeafPageN:         292,
-			LeafOverflowN:     0,
-			KeyN:              100000,
-			Depth:             2,
-			BranchAlloc:       16384,
-			BranchInuse:       6094,
-			LeafAlloc:         4784128,
-			LeafInuse:         2582452,
-			BucketN:           1,
-			InlineBucketN:     0,
-			InlineBucketInuse: 0},
+			BranchPageN:        1,
+			BranchOverflowN:    0,
+			LeafPageN:          292,
+			LeafOverflowN:      0,
+			KeyN:               100000,
+			Depth:              2,
+			LogicalValueBytes:  488890,
+			PhysicalValueBytes: 488890,
+			BlobN:              0,
+			BlobPageN:          0,
+			BranchAlloc:        16384,
+			BranchInuse:        6094,
+			LeafAlloc:          4784128,
+			LeafInuse:          2582452,
+			LeafPrefixSaved:    0,
+			BucketN:            1,
+			InlineBucketN:      0,
+			InlineBucketInuse:  0},
 		65536: {
-			BranchPageN:       1,
-			BranchOverflowN:   0,
-			LeafPageN:         73,
-			LeafOverflowN:     0,
-			KeyN:              100000,
-			Depth:             2,
-			BranchAlloc:       65536,
-			BranchInuse:       1534,
-			LeafAlloc:         4784128,
-			LeafInuse:         2578948,
-			BucketN:           1,
-			InlineBucketN:     0,
-			InlineBucketInuse: 0},
+			BranchPageN:        1,
+			BranchOverflowN:    0,
+			LeafPageN:          73,
+			LeafOverflowN:      0,
+			KeyN:               100000,
+			Depth:              2,
+			LogicalValueBytes:  488890,
+			PhysicalValueBytes: 488890,
+			BlobN:              0,
+			BlobPageN:          0,
+			BranchAlloc:        65536,
+			BranchInuse:        1534,
+			LeafAlloc:          4784128,
+			LeafInuse:          2578948,
+			LeafPrefixSaved:    0,
+			BucketN:            1,
+			InlineBucketN:      0,
+			InlineBucketInuse:  0},
 	}
 
 	if err := db.View(func(tx *bolt.Tx) error {
@@ -2016,6 +2106,45 @@ func BenchmarkBucket_CreateBucketIfNotExists(b *testing.B) {
 	}
 }
 
+func BenchmarkBucket_GetMany(b *testing.B) {
+	db := btesting.MustCreateDB(b)
+	defer db.MustClose()
+
+	const keyCount = 100_000
+	keys := make([][]byte, 0, keyCount)
+	require.NoError(b, db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucket([]byte("widgets"))
+		require.NoError(b, err)
+		for i := 0; i < keyCount; i++ {
+			key := []byte(fmt.Sprintf("key_%08d", i))
+			require.NoError(b, bucket.Put(key, []byte("value")))
+			if i%10 == 0 {
+				keys = append(keys, key)
+			}
+		}
+		return nil
+	}))
+	rand.New(rand.NewSource(42)).Shuffle(len(keys), func(i, j int) { keys[i], keys[j] = keys[j], keys[i] })
+
+	for _, many := range []bool{false, true} {
+		b.Run(fmt.Sprintf("many %v", many), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				require.NoError(b, db.View(func(tx *bolt.Tx) error {
+					bucket := tx.Bucket([]byte("widgets"))
+					if many {
+						return bucket.GetMany(keys, func(i int, key, value []byte) error { return nil })
+					}
+					for _, key := range keys {
+						bucket.Get(key)
+					}
+					return nil
+				}))
+			}
+		})
+	}
+}
+
 func ExampleBucket_Put() {
 	// Open the database.
 	db, err := bolt.Open(tempfile(), 0600, nil)
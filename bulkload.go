@@ -0,0 +1,301 @@
+package bbolt
+
+import (
+	"encoding/binary"
+
+	"go.etcd.io/bbolt/errors"
+	"go.etcd.io/bbolt/internal/common"
+)
+
+// BulkLoad loads the keys and values returned by next into the bucket, which
+// must be empty, until next returns a nil key. The keys must be in strictly
+// ascending order.
+//
+// Unlike Put, the leaf pages are filled completely, whatever the FillPercent,
+// and written to the file as soon as they're full, and the branch pages are
+// built on top of them, so loading is much faster and the tree is as compact
+// as possible. The indexes of the bucket are updated and the watchers notified
+// like by Put.
+//
+// If next returns an error, or an invalid or unordered key, the keys before it
+// remain loaded and the error is returned. Returns ErrBucketNotEmpty if the
+// bucket has keys, and an error if the bucket was created from a read-only
+// transaction.
+func (b *Bucket) BulkLoad(next func() (key, value []byte, err error)) (err error) {
+	lg := b.tx.db.Logger()
+	lg.Debugf("Bulk loading keys")
+	var loaded int
+	defer func() {
+		if err != nil {
+			lg.Errorf("Bulk loading keys failed after %d keys: %v", loaded, err)
+		} else {
+			lg.Debugf("Bulk loading %d keys successfully", loaded)
+		}
+	}()
+
+	if b.tx.db == nil {
+		return errors.ErrTxClosed
+	} else if !b.Writable() {
+		return errors.ErrTxNotWritable
+	}
+
+	l, err := b.newBulkLoader()
+	if err != nil {
+		return err
+	}
+	err = l.load(func() ([]byte, []byte, uint32, error) {
+		key, value, err := next()
+		if err != nil || key == nil {
+			return nil, nil, 0, err
+		} else if len(key) == 0 {
+			return nil, nil, 0, errors.ErrKeyRequired
+		} else if len(key) > MaxKeySize {
+			return nil, nil, 0, errors.ErrKeyTooLarge
+		} else if int64(len(value)) > MaxValueSize {
+			return nil, nil, 0, errors.ErrValueTooLarge
+		}
+		value, flags, err := b.leafValue(value, 0)
+		if err == nil {
+			loaded++
+		}
+		return key, value, flags, err
+	})
+	b.saveHeader()
+	return err
+}
+
+// saveHeader puts the header of a bulk loaded bucket into its parent, if its
+// root was written to a page, as spill only updates the headers of the
+// buckets with a root node.
+func (b *Bucket) saveHeader() {
+	if b.rootNode != nil {
+		return
+	}
+	if b.parent == nil {
+		b.node(b.RootPage(), nil)
+		return
+	}
+	value := make([]byte, common.BucketValueHeaderSize(b.leafFlags()))
+	b.writeHeader(value)
+	b.parent.putBucketHeader(b.name, value, b.leafFlags())
+}
+
+// bulkLoader builds the tree of a bucket from sorted keys, bottom-up. Each
+// level holds the elements of the page being filled, which is written when
+// the next element doesn't fit, adding an element to the level above.
+type bulkLoader struct {
+	b       *Bucket
+	indexes []*indexDef
+	path    [][]byte
+	levels  []bulkLevel
+	last    []byte
+}
+
+// bulkLevel is a level of the tree built by a bulkLoader, from the leaves.
+type bulkLevel struct {
+	inodes common.Inodes
+	size   int    // total size of the keys and values of the inodes
+	keys   uint64 // number of keys under the inodes
+}
+
+// newBulkLoader returns a loader of the empty bucket, whose pages are freed.
+func (b *Bucket) newBulkLoader() (*bulkLoader, error) {
+	if k, _, _ := b.Cursor().first(); k != nil {
+		return nil, errors.ErrBucketNotEmpty
+	}
+
+	// Free the pages of the keys deleted from the bucket.
+	b.nodes, b.rootNode = nil, nil
+	b.free()
+	b.nodes = make(map[common.Pgid]*node)
+	b.page = nil
+
+	return &bulkLoader{b: b, indexes: b.indexes(), path: b.path()}, nil
+}
+
+// load adds the keys, leaf values and flags returned by next, until it
+// returns a nil key, and finishes the tree. The keys added before an error
+// remain loaded.
+func (l *bulkLoader) load(next func() (key, value []byte, flags uint32, err error)) error {
+	var err error
+	for {
+		var key, value []byte
+		var flags uint32
+		if key, value, flags, err = next(); err != nil || key == nil {
+			break
+		}
+		if err = l.add(key, value, flags); err != nil {
+			break
+		}
+	}
+	if ferr := l.finish(); ferr != nil {
+		return ferr
+	}
+	return err
+}
+
+// add appends a key, with its leaf value and flags, to the leaf level.
+func (l *bulkLoader) add(key, value []byte, flags uint32) error {
+	b := l.b
+	if l.last != nil && b.compare(key, l.last) <= 0 {
+		return errors.ErrKeysUnordered
+	}
+
+	// Add the expiry entry of an expiring value.
+	if (flags & common.ExpiringLeafFlag) != 0 {
+		entry := expiryEntry(loadExpiry(value), l.path, key)
+		if len(entry) > MaxKeySize {
+			return errors.ErrKeyTooLarge
+		}
+		if err := b.tx.putExpiryEntry(entry); err != nil {
+			return err
+		}
+	}
+
+	switch {
+	case (flags & common.BucketLeafFlag) != 0:
+		b.recordChange(ChangeCreateBucket, key, nil, 0, nil)
+	default:
+		if len(l.indexes) > 0 {
+			newValue, err := b.indexValue(value, flags)
+			if err != nil {
+				return err
+			}
+			if err := b.updateIndexes(l.indexes, key, nil, newValue); err != nil {
+				return err
+			}
+		}
+		if (flags&common.BlobLeafFlag) == 0 && b.tx.watching {
+			b.recordChange(ChangePut, key, nil, 0, b.value(value, flags))
+		} else {
+			b.recordChange(ChangePut, key, nil, 0, nil)
+		}
+	}
+
+	// Copy the key and the value, which may point into the mmap, remapped
+	// when pages are allocated.
+	var inode common.Inode
+	inode.SetFlags(flags)
+	inode.SetKey(cloneBytes(key))
+	inode.SetValue(cloneBytes(value))
+	l.last = inode.Key()
+	return l.push(0, inode, 1)
+}
+
+// push appends an element to a level, writing the page of the level first if
+// the element doesn't fit in it.
+func (l *bulkLoader) push(level int, inode common.Inode, keys uint64) error {
+	if level == len(l.levels) {
+		l.levels = append(l.levels, bulkLevel{})
+	}
+	if lv := &l.levels[level]; len(lv.inodes) > 0 && l.pageSize(level, inode) > l.b.tx.db.pageSize-l.b.tx.db.pageTrailerSize() {
+		if err := l.flush(level); err != nil {
+			return err
+		}
+	}
+	lv := &l.levels[level]
+	lv.inodes = append(lv.inodes, inode)
+	lv.size += len(inode.Key()) + len(inode.Value())
+	lv.keys += keys
+	return nil
+}
+
+// pageSize returns the size of the page of a level with another element.
+func (l *bulkLoader) pageSize(level int, inode common.Inode) int {
+	lv := &l.levels[level]
+	n := len(lv.inodes) + 1
+	if level > 0 {
+		return int(common.PageHeaderSize) + n*int(common.BranchPageElementSize) + lv.size + len(inode.Key()) + len(inode.Value())
+	}
+	sz := int(common.PageHeaderSize) + n*int(common.LeafPageElementSize) + lv.size + len(inode.Key()) + len(inode.Value())
+	if l.b.prefixCompression() {
+		plen := common.CommonPrefixSize(lv.inodes[0].Key(), inode.Key())
+		if saved := common.KeyPrefixSavedBytes(n, plen); saved > 0 {
+			sz -= saved
+		}
+	}
+	return sz
+}
+
+// flush writes the page of a level, and appends its element to the level
+// above.
+func (l *bulkLoader) flush(level int) error {
+	lv := &l.levels[level]
+	pgId, err := l.write(level == 0, lv.inodes)
+	if err != nil {
+		return err
+	}
+
+	var inode common.Inode
+	inode.SetKey(lv.inodes[0].Key())
+	inode.SetPgid(pgId)
+	if l.b.ext.IsCounted() {
+		inode.SetValue(binary.BigEndian.AppendUint64(make([]byte, 0, common.SubtreeKeyCountSize), lv.keys))
+	}
+	keys := lv.keys
+	*lv = bulkLevel{}
+	return l.push(level+1, inode, keys)
+}
+
+// write writes the elements of a leaf or a branch page to a new page, and
+// returns its id.
+func (l *bulkLoader) write(isLeaf bool, inodes common.Inodes) (common.Pgid, error) {
+	tx := l.b.tx
+	n := &node{bucket: l.b, isLeaf: isLeaf, inodes: inodes}
+	flags := uint16(common.BranchPageFlag)
+	if isLeaf {
+		flags = common.LeafPageFlag
+	}
+	p, err := tx.allocateEagerPage(flags, n.size())
+	if err != nil {
+		return 0, err
+	}
+	p.SetCount(uint16(len(inodes)))
+	if n.prefixCompression() {
+		common.WritePrefixedInodeToPage(inodes, p)
+	} else {
+		common.WriteInodeToPage(inodes, p)
+	}
+
+	// The page is released once written.
+	pgId := p.Id()
+	if err := tx.writeEagerPage(p); err != nil {
+		return 0, err
+	}
+	return pgId, nil
+}
+
+// finish writes the pages of every level but the top one, which becomes the
+// root of the bucket. A leaf root is kept as a node, which is written or
+// inlined when the bucket is spilled, and a branch root is written.
+func (l *bulkLoader) finish() error {
+	b := l.b
+	for level := 0; level < len(l.levels)-1; level++ {
+		if len(l.levels[level].inodes) > 0 {
+			if err := l.flush(level); err != nil {
+				return err
+			}
+		}
+	}
+
+	var root bulkLevel
+	if len(l.levels) > 0 {
+		root = l.levels[len(l.levels)-1]
+	}
+	if b.ext.IsCounted() {
+		b.ext.SetKeyCount(root.keys)
+	}
+	if len(l.levels) <= 1 {
+		b.rootNode = &node{bucket: b, isLeaf: true, inodes: root.inodes}
+		b.nodes[0] = b.rootNode
+		b.SetRootPage(0)
+		return nil
+	}
+
+	pgId, err := l.write(false, root.inodes)
+	if err != nil {
+		return err
+	}
+	b.SetRootPage(pgId)
+	return nil
+}
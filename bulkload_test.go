@@ -0,0 +1,313 @@
+package bbolt_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	bolt "go.etcd.io/bbolt"
+	berrors "go.etcd.io/bbolt/errors"
+	"go.etcd.io/bbolt/internal/btesting"
+)
+
+// sliceLoader returns a function returning the keys and values of a bulk
+// load, followed by err if it's not nil.
+func sliceLoader(keys, values []string, err error) func() ([]byte, []byte, error) {
+	var i int
+	return func() ([]byte, []byte, error) {
+		if i == len(keys) {
+			return nil, nil, err
+		}
+		i++
+		return []byte(keys[i-1]), []byte(values[i-1]), nil
+	}
+}
+
+// Ensure that BulkLoad builds the same buckets as Put, with full leaf pages.
+func TestBucket_BulkLoad(t *testing.T) {
+	for _, n := range []int{0, 1, 100, 20000} {
+		for _, opts := range []bolt.BucketOptions{{}, {Counted: true}, {Codec: bolt.FlateCodec}} {
+			t.Run(fmt.Sprintf("%d keys, counted %v, codec %v", n, opts.Counted, opts.Codec != nil), func(t *testing.T) {
+				db := btesting.MustCreateDB(t)
+				keys, values := make([]string, n), make([]string, n)
+				for i := range keys {
+					keys[i] = fmt.Sprintf("key-%08d", i)
+					values[i] = fmt.Sprintf("value-%d", i)
+					if i%1000 == 999 {
+						// Large values nearly fill their overflow pages.
+						values[i] = string(bytes.Repeat([]byte("large"), 2400))
+					}
+				}
+
+				require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+					b, err := tx.CreateBucketWithOptions([]byte("put"), &opts)
+					require.NoError(t, err)
+					for i := range keys {
+						require.NoError(t, b.Put([]byte(keys[i]), []byte(values[i])))
+					}
+					b, err = tx.CreateBucketWithOptions([]byte("loaded"), &opts)
+					require.NoError(t, err)
+					require.NoError(t, b.BulkLoad(sliceLoader(keys, values, nil)))
+
+					// The loaded keys can be read and written in the same transaction.
+					forward, backward := cursorKeys(b)
+					require.Equal(t, keys, append([]string{}, forward...))
+					require.Len(t, backward, n)
+					if opts.Counted {
+						requireRanks(t, b, keys)
+					}
+					return nil
+				}))
+				db.MustCheck()
+
+				require.NoError(t, db.View(func(tx *bolt.Tx) error {
+					put, loaded := tx.Bucket([]byte("put")), tx.Bucket([]byte("loaded"))
+					for i := range keys {
+						require.Equal(t, []byte(values[i]), loaded.Get([]byte(keys[i])))
+					}
+					forward, _ := cursorKeys(loaded)
+					require.Equal(t, keys, append([]string{}, forward...))
+					require.Equal(t, opts, loaded.Options())
+					if opts.Counted {
+						requireRanks(t, loaded, keys)
+					}
+
+					// The leaf pages are full, apart from the ones before the large
+					// values, which don't fit in them.
+					stats, putStats := loaded.Stats(), put.Stats()
+					require.Equal(t, n, stats.KeyN)
+					if n > 1000 {
+						require.Less(t, stats.LeafPageN, putStats.LeafPageN*2/3)
+						require.Greater(t, float64(stats.LeafInuse), 0.9*float64(stats.LeafAlloc))
+					}
+					return nil
+				}))
+
+				// The pages split and merge as usual.
+				require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+					b := tx.Bucket([]byte("loaded"))
+					for i := 0; i < n; i += 3 {
+						require.NoError(t, b.Delete([]byte(keys[i])))
+						require.NoError(t, b.Put([]byte(keys[i]+"-"), []byte("new")))
+					}
+					return nil
+				}))
+				db.MustCheck()
+			})
+		}
+	}
+}
+
+// Ensure that BulkLoad rejects non-empty buckets and unordered keys, and
+// keeps the keys loaded before an error.
+func TestBucket_BulkLoad_Errors(t *testing.T) {
+	db := btesting.MustCreateDB(t)
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		require.NoError(t, err)
+		require.NoError(t, b.Put([]byte("foo"), []byte("bar")))
+		require.ErrorIs(t, b.BulkLoad(sliceLoader([]string{"a"}, []string{"b"}, nil)), berrors.ErrBucketNotEmpty)
+
+		// A bucket whose keys were deleted is empty.
+		require.NoError(t, b.Delete([]byte("foo")))
+		require.NoError(t, b.BulkLoad(sliceLoader([]string{"a", "b"}, []string{"1", "2"}, nil)))
+
+		b, err = tx.CreateBucket([]byte("unordered"))
+		require.NoError(t, err)
+		require.ErrorIs(t, b.BulkLoad(sliceLoader([]string{"a", "c", "b"}, []string{"1", "2", "3"}, nil)), berrors.ErrKeysUnordered)
+		keys, _ := cursorKeys(b)
+		require.Equal(t, []string{"a", "c"}, keys)
+
+		b, err = tx.CreateBucket([]byte("duplicate"))
+		require.NoError(t, err)
+		require.ErrorIs(t, b.BulkLoad(sliceLoader([]string{"a", "a"}, []string{"1", "2"}, nil)), berrors.ErrKeysUnordered)
+
+		b, err = tx.CreateBucket([]byte("blank"))
+		require.NoError(t, err)
+		require.ErrorIs(t, b.BulkLoad(sliceLoader([]string{"a", ""}, []string{"1", "2"}, nil)), berrors.ErrKeyRequired)
+
+		errFailed := errors.New("failed")
+		b, err = tx.CreateBucket([]byte("failed"))
+		require.NoError(t, err)
+		require.ErrorIs(t, b.BulkLoad(sliceLoader([]string{"a", "b"}, []string{"1", "2"}, errFailed)), errFailed)
+		keys, _ = cursorKeys(b)
+		require.Equal(t, []string{"a", "b"}, keys)
+		return nil
+	}))
+	db.MustCheck()
+
+	require.NoError(t, db.View(func(tx *bolt.Tx) error {
+		require.Equal(t, []byte("2"), tx.Bucket([]byte("widgets")).Get([]byte("b")))
+		require.Equal(t, []byte("2"), tx.Bucket([]byte("unordered")).Get([]byte("c")))
+		require.ErrorIs(t, tx.Bucket([]byte("widgets")).BulkLoad(sliceLoader(nil, nil, nil)), berrors.ErrTxNotWritable)
+		return nil
+	}))
+}
+
+// Ensure that BulkLoad updates the indexes and notifies the watchers, and
+// that rolling back to a savepoint frees the written pages.
+func TestBucket_BulkLoad_IndexWatch(t *testing.T) {
+	db := btesting.MustCreateDBWithOption(t, &bolt.Options{WatchBufferSize: 10000})
+	path := bucketPath("parent", "widgets")
+	require.NoError(t, db.RegisterIndex([]byte("tags"), path, tagsIndex))
+
+	const n = 5000
+	keys, values := make([]string, n), make([]string, n)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("%05d", i)
+		values[i] = fmt.Sprintf("t%d", i%10)
+	}
+
+	ch, err := db.Watch(context.Background(), path, nil)
+	require.NoError(t, err)
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		parent, err := tx.CreateBucket([]byte("parent"))
+		require.NoError(t, err)
+		b, err := parent.CreateBucket([]byte("widgets"))
+		require.NoError(t, err)
+
+		sp, err := tx.Savepoint()
+		require.NoError(t, err)
+		require.NoError(t, b.BulkLoad(sliceLoader(keys, values, nil)))
+		require.NoError(t, sp.RollbackTo())
+		return b.BulkLoad(sliceLoader(keys, values, nil))
+	}))
+	db.MustCheck()
+
+	events := receiveEvents(ch)
+	require.Len(t, events, n)
+	require.Equal(t, bolt.ChangePut, events[0].Type)
+	require.Equal(t, []byte(values[0]), events[0].NewValue)
+	require.Len(t, indexEntries(t, db.DB, "tags"), n)
+	require.Empty(t, indexCheckErrors(t, db.DB))
+}
+
+// Ensure that Compact copies nested buckets, blobs and expiring keys, and
+// builds smaller trees than Put, whether it bulk loads the buckets or, with a
+// limited transaction size, copies the keys one by one and commits in the
+// middle of the buckets.
+func TestCompact_BulkLoad(t *testing.T) {
+	for _, txMaxSize := range []int64{0, 4096} {
+		t.Run(fmt.Sprintf("txMaxSize=%d", txMaxSize), func(t *testing.T) {
+			testCompact(t, txMaxSize)
+		})
+	}
+}
+
+func testCompact(t *testing.T, txMaxSize int64) {
+	src := btesting.MustCreateDB(t)
+	require.NoError(t, src.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		require.NoError(t, err)
+		require.NoError(t, b.SetSequence(42))
+		for i := 0; i < 10000; i++ {
+			k := []byte(fmt.Sprintf("%05d", i))
+			switch i % 1000 {
+			case 1:
+				nested, err := b.CreateBucketWithOptions(k, &bolt.BucketOptions{Counted: true})
+				require.NoError(t, err)
+				for j := 0; j < i/10; j++ {
+					require.NoError(t, nested.Put([]byte(fmt.Sprintf("%04d", j)), []byte("nested")))
+				}
+			case 2:
+				require.NoError(t, b.PutReader(k, bytes.NewReader(bytes.Repeat([]byte("blob"), 5000))))
+			case 3:
+				require.NoError(t, b.PutWithTTL(k, []byte("expiring"), time.Hour))
+			default:
+				require.NoError(t, b.Put(k, []byte("value")))
+			}
+		}
+		return nil
+	}))
+
+	dst := btesting.MustCreateDB(t)
+	require.NoError(t, bolt.Compact(dst.DB, src.DB, txMaxSize))
+	require.Equal(t, dumpDB(t, src.DB), dumpDB(t, dst.DB))
+	dst.MustCheck()
+	require.NoError(t, dst.View(func(tx *bolt.Tx) error {
+		if txMaxSize == 0 {
+			require.Equal(t, 2, tx.ID())
+		} else {
+			require.Greater(t, tx.ID(), 10)
+		}
+		return nil
+	}))
+	require.Equal(t, 10, expiryEntries(t, dst.DB))
+	require.NoError(t, dst.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("widgets"))
+		require.Equal(t, uint64(42), b.Sequence())
+		require.Equal(t, bytes.Repeat([]byte("blob"), 5000), readBlob(t, b, []byte("05002")))
+		nested := b.Bucket([]byte("09001"))
+		require.True(t, nested.Options().Counted)
+		require.Equal(t, 900, nested.KeyCount())
+		require.Zero(t, b.Bucket([]byte("00001")).KeyCount())
+		return nil
+	}))
+
+	var srcStats, dstStats bolt.BucketStats
+	require.NoError(t, src.View(func(tx *bolt.Tx) error {
+		srcStats = tx.Bucket([]byte("widgets")).Stats()
+		return nil
+	}))
+	require.NoError(t, dst.View(func(tx *bolt.Tx) error {
+		dstStats = tx.Bucket([]byte("widgets")).Stats()
+		return nil
+	}))
+	require.Equal(t, srcStats.KeyN, dstStats.KeyN)
+	require.Equal(t, srcStats.BucketN, dstStats.BucketN)
+	require.Equal(t, srcStats.InlineBucketN, dstStats.InlineBucketN)
+	require.Less(t, dstStats.LeafPageN, srcStats.LeafPageN)
+}
+
+func ExampleBucket_BulkLoad() {
+	// Open the database.
+	db, err := bolt.Open(tempfile(), 0600, nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.Remove(db.Path())
+
+	// Load sorted keys into a new bucket.
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("events"))
+		if err != nil {
+			return err
+		}
+		var i int
+		return b.BulkLoad(func() ([]byte, []byte, error) {
+			if i == 100000 {
+				return nil, nil, nil
+			}
+			i++
+			return []byte(fmt.Sprintf("event-%06d", i)), []byte("data"), nil
+		})
+	}); err != nil {
+		log.Fatal(err)
+	}
+
+	// Read the keys back.
+	if err := db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("events"))
+		stats := b.Stats()
+		k, _ := b.Cursor().Last()
+		fmt.Printf("%d keys, the last one is %s\n", stats.KeyN, k)
+		return nil
+	}); err != nil {
+		log.Fatal(err)
+	}
+
+	// Close database to release the file lock.
+	if err := db.Close(); err != nil {
+		log.Fatal(err)
+	}
+
+	// Output:
+	// 100000 keys, the last one is event-100000
+}
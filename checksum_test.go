@@ -0,0 +1,194 @@
+package bbolt_test
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	bolt "go.etcd.io/bbolt"
+	berrors "go.etcd.io/bbolt/errors"
+	"go.etcd.io/bbolt/internal/btesting"
+	"go.etcd.io/bbolt/internal/common"
+)
+
+// Ensure that a database with page checksums can be written, verified and reopened.
+func TestDB_PageChecksums(t *testing.T) {
+	db := btesting.MustCreateDBWithOption(t, &bolt.Options{
+		PageChecksums:        true,
+		ChecksumVerification: bolt.ChecksumVerifyAlways,
+	})
+	require.NoError(t, db.Fill([]byte("widgets"), 10, 100, keyGen, valueGen("v")))
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		// Overflow pages are covered by the checksum too.
+		return tx.Bucket([]byte("widgets")).Put([]byte("large"), make([]byte, 3*db.Info().PageSize))
+	}))
+	expected := dumpDB(t, db.DB)
+	db.MustCheck()
+
+	db.MustClose()
+	db.MustReopen()
+	require.Equal(t, expected, dumpDB(t, db.DB))
+}
+
+// Ensure that a corrupted page is reported by reads, and by Tx.Check.
+func TestDB_PageChecksums_Corrupted(t *testing.T) {
+	path, pgid := corruptedChecksumDB(t)
+
+	db, err := bolt.Open(path, 0600, &bolt.Options{ChecksumVerification: bolt.ChecksumVerifyAlways})
+	require.NoError(t, err)
+	defer db.Close()
+
+	err = db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte("widgets")).ForEach(func(k, v []byte) error { return nil })
+	})
+	require.ErrorIs(t, err, berrors.ErrPageChecksum)
+	require.ErrorContains(t, err, fmt.Sprintf("page %d:", pgid))
+
+	var checkErrs []error
+	require.NoError(t, db.View(func(tx *bolt.Tx) error {
+		for err := range tx.Check() {
+			checkErrs = append(checkErrs, err)
+		}
+		return nil
+	}))
+	require.Len(t, checkErrs, 1)
+	require.ErrorIs(t, checkErrs[0], berrors.ErrPageChecksum)
+
+	// The corruption isn't detected when verification is off.
+	db.ChecksumVerification = bolt.ChecksumVerifyOff
+	require.NoError(t, db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte("widgets")).ForEach(func(k, v []byte) error { return nil })
+	}))
+}
+
+// Ensure that a corrupted page fails transactions started with DB.Begin
+// without panicking.
+func TestDB_PageChecksums_Corrupted_Begin(t *testing.T) {
+	path, pgid := corruptedChecksumDB(t)
+
+	db, err := bolt.Open(path, 0600, &bolt.Options{ChecksumVerification: bolt.ChecksumVerifyAlways})
+	require.NoError(t, err)
+	defer db.Close()
+
+	tx, err := db.Begin(false)
+	require.NoError(t, err)
+	require.NoError(t, tx.Err())
+	require.Nil(t, tx.Bucket([]byte("widgets")).Get(keyGen(0, 0)))
+	require.ErrorIs(t, tx.Err(), berrors.ErrPageChecksum)
+	require.ErrorContains(t, tx.Err(), fmt.Sprintf("page %d:", pgid))
+	require.ErrorIs(t, tx.Rollback(), berrors.ErrPageChecksum)
+
+	tx, err = db.Begin(true)
+	require.NoError(t, err)
+	require.NoError(t, tx.Bucket([]byte("widgets")).Put([]byte("key"), []byte("value")))
+	require.ErrorIs(t, tx.Commit(), berrors.ErrPageChecksum)
+
+	// The failed commit didn't change the database.
+	db.ChecksumVerification = bolt.ChecksumVerifyOff
+	require.NoError(t, db.View(func(tx *bolt.Tx) error {
+		require.Nil(t, tx.Bucket([]byte("widgets")).Get([]byte("key")))
+		require.NotNil(t, tx.Bucket([]byte("widgets")).Get(keyGen(0, 0)))
+		return nil
+	}))
+}
+
+// Ensure that the read paths return the error of a corrupted page rather
+// than report its keys as missing.
+func TestDB_PageChecksums_Corrupted_Reads(t *testing.T) {
+	path, _ := corruptedChecksumDB(t)
+
+	db, err := bolt.Open(path, 0600, &bolt.Options{ChecksumVerification: bolt.ChecksumVerifyAlways})
+	require.NoError(t, err)
+	defer db.Close()
+
+	err = db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("widgets"))
+		v, err := b.Lookup(keyGen(0, 0))
+		require.ErrorIs(t, err, berrors.ErrPageChecksum)
+		require.Nil(t, v)
+
+		c := b.Cursor()
+		k, _ := c.First()
+		require.Nil(t, k)
+		require.ErrorIs(t, c.Err(), berrors.ErrPageChecksum)
+
+		// The callbacks aren't called with incomplete results.
+		var called bool
+		require.ErrorIs(t, b.ForEach(func(k, v []byte) error {
+			called = true
+			return nil
+		}), berrors.ErrPageChecksum)
+		require.ErrorIs(t, b.GetMany([][]byte{keyGen(0, 0)}, func(i int, key, value []byte) error {
+			called = true
+			return nil
+		}), berrors.ErrPageChecksum)
+		require.ErrorIs(t, tx.Walk(func(path [][]byte, k, v []byte, seq uint64) error {
+			called = true
+			return nil
+		}), berrors.ErrPageChecksum)
+		require.False(t, called)
+		return nil
+	})
+	require.ErrorIs(t, err, berrors.ErrPageChecksum)
+}
+
+// Ensure that sampled verification checks a fraction of the page reads.
+func TestDB_PageChecksums_Sampled(t *testing.T) {
+	path, _ := corruptedChecksumDB(t)
+
+	db, err := bolt.Open(path, 0600, &bolt.Options{ChecksumVerification: bolt.ChecksumVerifySampled})
+	require.NoError(t, err)
+	defer db.Close()
+
+	var failed int
+	for i := 0; i < 4*common.ChecksumSampleRate; i++ {
+		err := db.View(func(tx *bolt.Tx) error {
+			tx.Bucket([]byte("widgets")).Get(keyGen(0, 0))
+			return nil
+		})
+		if err != nil {
+			require.True(t, errors.Is(err, berrors.ErrPageChecksum))
+			failed++
+		}
+	}
+	require.NotZero(t, failed)
+	require.Less(t, failed, common.ChecksumSampleRate)
+}
+
+// Ensure that a database without page checksums can still be read with verification on.
+func TestDB_PageChecksums_OldVersion(t *testing.T) {
+	db := btesting.MustCreateDBWithOption(t, &bolt.Options{ChecksumVerification: bolt.ChecksumVerifyAlways})
+	require.NoError(t, db.Fill([]byte("widgets"), 2, 100, keyGen, valueGen("v")))
+	require.Len(t, dumpDB(t, db.DB), 201)
+}
+
+// corruptedChecksumDB creates a database with page checksums, and flips a
+// byte in the unused space of the root page of the "widgets" bucket. It
+// returns the path of the database and the id of the corrupted page.
+func corruptedChecksumDB(t *testing.T) (string, int) {
+	const pageSize = 4096
+	db := btesting.MustCreateDBWithOption(t, &bolt.Options{PageSize: pageSize, PageChecksums: true})
+	require.NoError(t, db.Fill([]byte("widgets"), 10, 100, keyGen, valueGen("v")))
+
+	var pgid int
+	require.NoError(t, db.View(func(tx *bolt.Tx) error {
+		pgid = int(tx.Bucket([]byte("widgets")).Root())
+		return nil
+	}))
+	db.MustClose()
+
+	f, err := os.OpenFile(db.Path(), os.O_RDWR, 0600)
+	require.NoError(t, err)
+	_, err = f.WriteAt([]byte{0xff}, int64(pgid*pageSize+pageSize/2))
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	// Move the file away, so that the test cleanup doesn't check it.
+	path := filepath.Join(t.TempDir(), "corrupted")
+	require.NoError(t, os.Rename(db.Path(), path))
+	return path, pgid
+}
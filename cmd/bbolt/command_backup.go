@@ -0,0 +1,188 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+
+	bolt "go.etcd.io/bbolt"
+	"go.etcd.io/bbolt/internal/common"
+	"go.etcd.io/bbolt/internal/guts_cli"
+)
+
+type backupOptions struct {
+	outputFilePath string
+	sinceTxid      int
+}
+
+func (o *backupOptions) AddFlags(fs *pflag.FlagSet) {
+	fs.StringVar(&o.outputFilePath, "output", o.outputFilePath, "path to the backup file")
+	fs.IntVar(&o.sinceTxid, "since", -1, "write an increment of the pages written after the given txid, instead of a full copy")
+	_ = cobra.MarkFlagRequired(fs, "output")
+}
+
+func (o *backupOptions) Validate() error {
+	if o.outputFilePath == "" {
+		return errors.New("output file path wasn't given, specify output file path with --output option")
+	}
+	return nil
+}
+
+func newBackupCobraCommand() *cobra.Command {
+	var o backupOptions
+	backupCmd := &cobra.Command{
+		Use:   "backup <bbolt-file> --output <file> [--since <txid>]",
+		Short: "Write a full or incremental backup of the database",
+		Long: "Write a full copy of the database, or with --since an increment which can be applied to\n" +
+			"a backup taken at or after the given txid. Page writes are tracked by the processes\n" +
+			"which modify the database, and saved next to it when it's closed cleanly. The increment\n" +
+			"fails if they aren't tracked since the given txid; take a full backup instead.",
+		Args: func(cmd *cobra.Command, args []string) error {
+			if len(args) == 0 {
+				return errors.New("db file path not provided")
+			}
+			if len(args) > 1 {
+				return errors.New("too many arguments")
+			}
+			return nil
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := o.Validate(); err != nil {
+				return err
+			}
+			return backupFunc(args[0], o)
+		},
+	}
+	o.AddFlags(backupCmd.Flags())
+	return backupCmd
+}
+
+func backupFunc(srcDBPath string, cfg backupOptions) error {
+	if _, err := checkSourceDBPath(srcDBPath); err != nil {
+		return err
+	}
+	if _, err := os.Stat(cfg.outputFilePath); err == nil {
+		return fmt.Errorf("output file %q already exists", cfg.outputFilePath)
+	}
+
+	db, err := bolt.Open(srcDBPath, 0600, &bolt.Options{ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	return db.View(func(tx *bolt.Tx) error {
+		if cfg.sinceTxid < 0 {
+			if err := tx.CopyFile(cfg.outputFilePath, 0600); err != nil {
+				return fmt.Errorf("[backup] copy file failed: %w", err)
+			}
+			fmt.Fprintf(os.Stdout, "Full backup of txid %d written to %s\n", tx.ID(), cfg.outputFilePath)
+			return nil
+		}
+
+		f, err := os.OpenFile(cfg.outputFilePath, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0600)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.WriteIncrementalTo(f, cfg.sinceTxid); err != nil {
+			_ = f.Close()
+			return fmt.Errorf("[backup] write increment failed: %w", err)
+		}
+		if err := f.Close(); err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stdout, "Incremental backup of txid %d since txid %d written to %s\n", tx.ID(), cfg.sinceTxid, cfg.outputFilePath)
+		return nil
+	})
+}
+
+type restoreOptions struct {
+	outputDBFilePath string
+}
+
+func (o *restoreOptions) AddFlags(fs *pflag.FlagSet) {
+	fs.StringVar(&o.outputDBFilePath, "output", o.outputDBFilePath, "path to the restored db file")
+	_ = cobra.MarkFlagRequired(fs, "output")
+}
+
+func (o *restoreOptions) Validate() error {
+	if o.outputDBFilePath == "" {
+		return errors.New("output database path wasn't given, specify output database file path with --output option")
+	}
+	return nil
+}
+
+func newRestoreCobraCommand() *cobra.Command {
+	var o restoreOptions
+	restoreCmd := &cobra.Command{
+		Use:   "restore <full-backup> [increment...] --output <bbolt-file>",
+		Short: "Restore a database from a full backup and a chain of increments",
+		Args: func(cmd *cobra.Command, args []string) error {
+			if len(args) == 0 {
+				return errors.New("full backup path not provided")
+			}
+			return nil
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := o.Validate(); err != nil {
+				return err
+			}
+			return restoreFunc(args[0], args[1:], o)
+		},
+	}
+	o.AddFlags(restoreCmd.Flags())
+	return restoreCmd
+}
+
+func restoreFunc(basePath string, increments []string, cfg restoreOptions) error {
+	if _, err := checkSourceDBPath(basePath); err != nil {
+		return err
+	}
+
+	if err := common.CopyFile(basePath, cfg.outputDBFilePath); err != nil {
+		return fmt.Errorf("[restore] copy file failed: %w", err)
+	}
+
+	for _, path := range increments {
+		if err := applyIncrementFile(cfg.outputDBFilePath, path); err != nil {
+			return fmt.Errorf("[restore] apply increment %q failed: %w", path, err)
+		}
+	}
+
+	db, err := bolt.Open(cfg.outputDBFilePath, 0600, &bolt.Options{
+		ReadOnly:        true,
+		PreLoadFreelist: true,
+	})
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	return db.View(func(tx *bolt.Tx) error {
+		var count int
+		for err := range tx.Check(bolt.WithKVStringer(CmdKvStringer())) {
+			fmt.Fprintln(os.Stdout, err)
+			count++
+		}
+		if count > 0 {
+			fmt.Fprintf(os.Stdout, "%d errors found\n", count)
+			return guts_cli.ErrCorrupt
+		}
+
+		fmt.Fprintf(os.Stdout, "Restored txid %d to %s\n", tx.ID(), cfg.outputDBFilePath)
+		return nil
+	})
+}
+
+func applyIncrementFile(dbPath, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return bolt.ApplyIncremental(dbPath, f)
+}
@@ -0,0 +1,121 @@
+package main_test
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	bolt "go.etcd.io/bbolt"
+	main "go.etcd.io/bbolt/cmd/bbolt"
+	"go.etcd.io/bbolt/internal/btesting"
+)
+
+func TestBackupAndRestore(t *testing.T) {
+	pageSize := 4096
+	db := btesting.MustCreateDBWithOption(t, &bolt.Options{PageSize: pageSize})
+	srcPath := db.Path()
+	dir := t.TempDir()
+
+	fill := func(prefix string) {
+		err := db.Update(func(tx *bolt.Tx) error {
+			b, err := tx.CreateBucketIfNotExists([]byte("data"))
+			if err != nil {
+				return err
+			}
+			return fillBucket(b, []byte(prefix))
+		})
+		require.NoError(t, err)
+	}
+	txid := func() int {
+		var id int
+		require.NoError(t, db.View(func(tx *bolt.Tx) error {
+			id = tx.ID()
+			return nil
+		}))
+		return id
+	}
+	backup := func(output string, args ...string) {
+		db.Close()
+		defer db.MustReopen()
+		defer requireDBNoChange(t, dbData(t, srcPath), srcPath)
+
+		rootCmd := main.NewRootCommand()
+		rootCmd.SetArgs(append([]string{"backup", srcPath, "--output", output}, args...))
+		require.NoError(t, rootCmd.Execute())
+	}
+
+	t.Log("Taking the full backup")
+	fill("base")
+	since := txid()
+	fullPath := filepath.Join(dir, "full")
+	backup(fullPath)
+
+	t.Log("Taking the increments")
+	var increments []string
+	for i := 0; i < 2; i++ {
+		fill(fmt.Sprintf("inc%d", i))
+		incPath := filepath.Join(dir, fmt.Sprintf("inc%d", i))
+		backup(incPath, "--since", strconv.Itoa(since))
+		increments = append(increments, incPath)
+		since = txid()
+	}
+	db.Close()
+
+	t.Log("Restoring the backups")
+	restoredPath := filepath.Join(dir, "restored")
+	rootCmd := main.NewRootCommand()
+	rootCmd.SetArgs(append([]string{"restore", fullPath, "--output", restoredPath}, increments...))
+	require.NoError(t, rootCmd.Execute())
+
+	expected, err := chkdb(srcPath)
+	require.NoError(t, err)
+	restored, err := chkdb(restoredPath)
+	require.NoError(t, err)
+	require.Equal(t, expected, restored)
+}
+
+func TestRestore_IncrementOutOfOrder(t *testing.T) {
+	db := btesting.MustCreateDB(t)
+	srcPath := db.Path()
+	dir := t.TempDir()
+
+	err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("data"))
+		if err != nil {
+			return err
+		}
+		return fillBucket(b, []byte("base"))
+	})
+	require.NoError(t, err)
+	db.Close()
+
+	fullPath := filepath.Join(dir, "full")
+	rootCmd := main.NewRootCommand()
+	rootCmd.SetArgs([]string{"backup", srcPath, "--output", fullPath})
+	require.NoError(t, rootCmd.Execute())
+
+	// An increment of the current transaction can't be applied to an older full backup.
+	db.MustReopen()
+	err = db.Update(func(tx *bolt.Tx) error {
+		return fillBucket(tx.Bucket([]byte("data")), []byte("inc"))
+	})
+	require.NoError(t, err)
+	var since int
+	require.NoError(t, db.View(func(tx *bolt.Tx) error {
+		since = tx.ID()
+		return nil
+	}))
+	db.Close()
+
+	incPath := filepath.Join(dir, "inc")
+	rootCmd = main.NewRootCommand()
+	rootCmd.SetArgs([]string{"backup", srcPath, "--output", incPath, "--since", strconv.Itoa(since)})
+	require.NoError(t, rootCmd.Execute())
+
+	rootCmd = main.NewRootCommand()
+	rootCmd.SetArgs([]string{"restore", fullPath, incPath, "--output", filepath.Join(dir, "restored")})
+	require.ErrorContains(t, rootCmd.Execute(), "out of range")
+}
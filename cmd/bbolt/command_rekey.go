@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+type rekeyOptions struct {
+	outputDBFilePath string
+	keyFile          string
+	newKeyFile       string
+	txMaxSize        int64
+}
+
+func (o *rekeyOptions) AddFlags(fs *pflag.FlagSet) {
+	fs.StringVar(&o.outputDBFilePath, "output", o.outputDBFilePath, "path to the re-encrypted db file")
+	fs.StringVar(&o.keyFile, "key-file", o.keyFile, "file holding the hex encoded key of the source db; the source db isn't encrypted if not set")
+	fs.StringVar(&o.newKeyFile, "new-key-file", o.newKeyFile, "file holding the hex encoded key of the output db; the output db isn't encrypted if not set")
+	fs.Int64Var(&o.txMaxSize, "tx-max-size", 65536, "commit the copy in transactions of about the given size in bytes")
+	_ = cobra.MarkFlagRequired(fs, "output")
+}
+
+func (o *rekeyOptions) Validate() error {
+	if o.outputDBFilePath == "" {
+		return errors.New("output database path wasn't given, specify output database file path with --output option")
+	}
+	if o.keyFile == "" && o.newKeyFile == "" {
+		return errors.New("neither --key-file nor --new-key-file was given")
+	}
+	return nil
+}
+
+func newRekeyCobraCommand() *cobra.Command {
+	var o rekeyOptions
+	rekeyCmd := &cobra.Command{
+		Use:   "rekey <bbolt-file> --output <bbolt-file> [--key-file <file>] [--new-key-file <file>]",
+		Short: "Copy the database into a file encrypted with a new key",
+		Long: "Copy every bucket of the database, decrypted with the key in --key-file, into a new file\n" +
+			"encrypted with the key in --new-key-file. Omit --key-file to encrypt a plain database, or\n" +
+			"--new-key-file to decrypt an encrypted one. The source database isn't modified.",
+		Args: func(cmd *cobra.Command, args []string) error {
+			if len(args) == 0 {
+				return errors.New("db file path not provided")
+			}
+			if len(args) > 1 {
+				return errors.New("too many arguments")
+			}
+			return nil
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := o.Validate(); err != nil {
+				return err
+			}
+			return rekeyFunc(args[0], o)
+		},
+	}
+	o.AddFlags(rekeyCmd.Flags())
+	return rekeyCmd
+}
+
+func rekeyFunc(srcDBPath string, cfg rekeyOptions) error {
+	fi, err := checkSourceDBPath(srcDBPath)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(cfg.outputDBFilePath); err == nil {
+		return fmt.Errorf("output file %q already exists", cfg.outputDBFilePath)
+	}
+
+	srcEnc, err := readEncryptionKeyFile(cfg.keyFile)
+	if err != nil {
+		return err
+	}
+	dstEnc, err := readEncryptionKeyFile(cfg.newKeyFile)
+	if err != nil {
+		return err
+	}
+
+	src, err := bolt.Open(srcDBPath, 0400, &bolt.Options{ReadOnly: true, Encryption: srcEnc})
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := bolt.Open(cfg.outputDBFilePath, fi.Mode(), &bolt.Options{
+		PageSize:   src.Info().PageSize,
+		Encryption: dstEnc,
+	})
+	if err != nil {
+		return err
+	}
+	defer dst.Close()
+
+	if err := bolt.Compact(dst, src, cfg.txMaxSize); err != nil {
+		return fmt.Errorf("[rekey] copy failed: %w", err)
+	}
+
+	fmt.Fprintf(os.Stdout, "Rekeyed %s to %s\n", srcDBPath, cfg.outputDBFilePath)
+	return nil
+}
+
+// readEncryptionKeyFile returns the encryption using the hex encoded key in
+// path, or nil if path is empty.
+func readEncryptionKeyFile(path string) (*bolt.Encryption, error) {
+	if path == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	key, err := hex.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil {
+		return nil, fmt.Errorf("invalid key in %q: %w", path, err)
+	}
+	return &bolt.Encryption{KeyProvider: bolt.StaticKey(key)}, nil
+}
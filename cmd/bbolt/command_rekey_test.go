@@ -0,0 +1,74 @@
+package main_test
+
+import (
+	"bytes"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	bolt "go.etcd.io/bbolt"
+	main "go.etcd.io/bbolt/cmd/bbolt"
+	berrors "go.etcd.io/bbolt/errors"
+	"go.etcd.io/bbolt/internal/btesting"
+)
+
+func TestRekeyCommand_Run(t *testing.T) {
+	dir := t.TempDir()
+	writeKey := func(name string, b byte) ([]byte, string) {
+		key := bytes.Repeat([]byte{b}, 32)
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.WriteFile(path, []byte(hex.EncodeToString(key)+"\n"), 0600))
+		return key, path
+	}
+	oldKey, oldKeyPath := writeKey("old.key", 0x01)
+	newKey, newKeyPath := writeKey("new.key", 0x02)
+
+	db := btesting.MustCreateDBWithOption(t, &bolt.Options{Encryption: &bolt.Encryption{KeyProvider: bolt.StaticKey(oldKey)}})
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("data"))
+		if err != nil {
+			return err
+		}
+		return fillBucket(b, []byte("data"))
+	}))
+	srcPath := db.Path()
+	db.Close()
+	defer db.MustReopen()
+	defer requireDBNoChange(t, dbData(t, srcPath), srcPath)
+
+	t.Log("Rekeying the database")
+	dstPath := filepath.Join(dir, "rekeyed")
+	rootCmd := main.NewRootCommand()
+	rootCmd.SetArgs([]string{"rekey", srcPath, "--output", dstPath, "--key-file", oldKeyPath, "--new-key-file", newKeyPath})
+	require.NoError(t, rootCmd.Execute())
+
+	t.Log("Checking the rekeyed database")
+	_, err := bolt.Open(dstPath, 0600, &bolt.Options{Encryption: &bolt.Encryption{KeyProvider: bolt.StaticKey(oldKey)}})
+	require.ErrorIs(t, err, berrors.ErrPageDecrypt)
+	require.Equal(t, dumpEncryptedDB(t, srcPath, oldKey), dumpEncryptedDB(t, dstPath, newKey))
+
+	t.Log("Decrypting the database")
+	plainPath := filepath.Join(dir, "plain")
+	rootCmd = main.NewRootCommand()
+	rootCmd.SetArgs([]string{"rekey", dstPath, "--output", plainPath, "--key-file", newKeyPath})
+	require.NoError(t, rootCmd.Execute())
+	plain, err := chkdb(plainPath)
+	require.NoError(t, err)
+	require.Equal(t, dumpEncryptedDB(t, srcPath, oldKey), plain)
+}
+
+func dumpEncryptedDB(t *testing.T, path string, key []byte) []byte {
+	db, err := bolt.Open(path, 0600, &bolt.Options{ReadOnly: true, Encryption: &bolt.Encryption{KeyProvider: bolt.StaticKey(key)}})
+	require.NoError(t, err)
+	defer db.Close()
+	var buf bytes.Buffer
+	require.NoError(t, db.View(func(tx *bolt.Tx) error {
+		return tx.ForEach(func(name []byte, b *bolt.Bucket) error {
+			return walkBucket(b, name, nil, &buf)
+		})
+	}))
+	return buf.Bytes()
+}
@@ -20,6 +20,9 @@ func NewRootCommand() *cobra.Command {
 		newVersionCobraCommand(),
 		newSurgeryCobraCommand(),
 		newInspectCobraCommand(),
+		newBackupCobraCommand(),
+		newRestoreCobraCommand(),
+		newRekeyCobraCommand(),
 	)
 
 	return rootCmd
@@ -229,11 +229,12 @@ func updateMetaField(m *common.Meta, fields map[string]uint64) bool {
 		m.SetMagic(common.Magic)
 		changed = true
 	}
-	if m.Version() != common.Version {
+	if m.Version() != common.Version && m.Version() != common.PageChecksumVersion && m.Version() != common.EncryptedVersion && m.Version() != common.FeatureVersion {
 		m.SetVersion(common.Version)
 		changed = true
 	}
-	if m.Flags() != common.MetaPageFlag {
+	// The flags of the FeatureVersion format hold the features of the file.
+	if m.Version() != common.FeatureVersion && m.Flags() != common.MetaPageFlag {
 		m.SetFlags(common.MetaPageFlag)
 		changed = true
 	}
@@ -249,7 +249,7 @@ usage: bolt check PATH
 
 Check opens a database at PATH and runs an exhaustive check to verify that
 all pages are accessible or are marked as freed. It also verifies that no
-pages are double referenced.
+pages are double referenced, and the page checksums if the database has them.
 
 Verification errors will stream out as they are found and the process will
 return after all pages have been checked.
@@ -786,6 +786,7 @@ func (cmd *statsCommand) Run(args ...string) error {
 			percentage = int(float32(s.LeafInuse) * 100.0 / float32(s.LeafAlloc))
 		}
 		fmt.Fprintf(cmd.Stdout, "\tBytes actually used for leaf data: %d (%d%%)\n", s.LeafInuse, percentage)
+		fmt.Fprintf(cmd.Stdout, "\tBytes saved by leaf key prefix compression: %d\n", s.LeafPrefixSaved)
 
 		fmt.Fprintln(cmd.Stdout, "Bucket statistics")
 		fmt.Fprintf(cmd.Stdout, "\tTotal number of buckets: %d\n", s.BucketN)
@@ -831,7 +832,7 @@ The following errors can be reported:
         A page is referenced by more than one other page.
 
     invalid type
-        The page type is not "meta", "leaf", "branch", or "freelist".
+        The page type is not "meta", "leaf", "branch", "freelist", "blob", or "blobindex".
 
 No errors should occur in your database. However, if for some reason you
 experience corruption, please submit a ticket to the etcd-io/bbolt project page:
@@ -946,9 +947,9 @@ func (cmd *keysCommand) Run(args ...string) error {
 	// Print keys.
 	return db.View(func(tx *bolt.Tx) error {
 		// Find bucket.
-		lastBucket, err := findLastBucket(tx, buckets)
-		if err != nil {
-			return err
+		lastBucket := tx.BucketAt(bucketPath(buckets)...)
+		if lastBucket == nil {
+			return berrors.ErrBucketNotFound
 		}
 
 		// Iterate over each key.
@@ -1034,9 +1035,9 @@ func (cmd *getCommand) Run(args ...string) error {
 	// Print value.
 	return db.View(func(tx *bolt.Tx) error {
 		// Find bucket.
-		lastBucket, err := findLastBucket(tx, buckets)
-		if err != nil {
-			return err
+		lastBucket := tx.BucketAt(bucketPath(buckets)...)
+		if lastBucket == nil {
+			return berrors.ErrBucketNotFound
 		}
 
 		// Find value for given key.
@@ -1717,16 +1718,12 @@ func CmdKvStringer() bolt.KVStringer {
 	return cmdKvStringer{}
 }
 
-func findLastBucket(tx *bolt.Tx, bucketNames []string) (*bolt.Bucket, error) {
-	var lastbucket *bolt.Bucket = tx.Bucket([]byte(bucketNames[0]))
-	if lastbucket == nil {
-		return nil, berrors.ErrBucketNotFound
-	}
-	for _, bucket := range bucketNames[1:] {
-		lastbucket = lastbucket.Bucket([]byte(bucket))
-		if lastbucket == nil {
-			return nil, berrors.ErrBucketNotFound
-		}
+// bucketPath returns the path of a bucket from the names of the buckets given
+// on the command line.
+func bucketPath(bucketNames []string) [][]byte {
+	path := make([][]byte, len(bucketNames))
+	for i, name := range bucketNames {
+		path[i] = []byte(name)
 	}
-	return lastbucket, nil
+	return path
 }
@@ -9,12 +9,14 @@ import (
 	"io"
 	"math/rand"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"sync"
 	"testing"
 
 	"go.etcd.io/bbolt/internal/btesting"
+	"go.etcd.io/bbolt/internal/common"
 	"go.etcd.io/bbolt/internal/guts_cli"
 
 	"github.com/stretchr/testify/assert"
@@ -65,6 +67,7 @@ func TestStatsCommand_Run_EmptyDatabase(t *testing.T) {
 		"\tBytes actually used for branch data: 0 (0%)\n" +
 		"\tBytes allocated for physical leaf pages: 0\n" +
 		"\tBytes actually used for leaf data: 0 (0%)\n" +
+		"\tBytes saved by leaf key prefix compression: 0\n" +
 		"Bucket statistics\n" +
 		"\tTotal number of buckets: 0\n" +
 		"\tTotal number on inlined buckets: 0 (0%)\n" +
@@ -93,6 +96,40 @@ func TestCheckCommand_Run(t *testing.T) {
 	}
 }
 
+func TestCheckCommand_PageChecksum(t *testing.T) {
+	pageSize := 4096
+	db := btesting.MustCreateDBWithOption(t, &bolt.Options{PageSize: pageSize, PageChecksums: true})
+	err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("data"))
+		if err != nil {
+			return err
+		}
+		return fillBucket(b, []byte("data"))
+	})
+	require.NoError(t, err)
+	var pgid int
+	require.NoError(t, db.View(func(tx *bolt.Tx) error {
+		pgid = int(tx.Bucket([]byte("data")).Root())
+		return nil
+	}))
+	db.Close()
+
+	// Flip a byte in the unused space of the bucket's root page.
+	path := filepath.Join(t.TempDir(), "corrupted")
+	require.NoError(t, common.CopyFile(db.Path(), path))
+	f, err := os.OpenFile(path, os.O_RDWR, 0600)
+	require.NoError(t, err)
+	_, err = f.WriteAt([]byte{0xff}, int64(pgid*pageSize+pageSize-100))
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	m := NewMain()
+	err = m.Run("check", path)
+	require.ErrorIs(t, err, guts_cli.ErrCorrupt)
+	require.Contains(t, m.Stdout.String(), fmt.Sprintf("page %d: page checksum mismatch", pgid))
+	require.Contains(t, m.Stdout.String(), "1 errors found")
+}
+
 func TestDumpCommand_Run(t *testing.T) {
 	db := btesting.MustCreateDBWithOption(t, &bolt.Options{PageSize: 4096})
 	db.Close()
@@ -274,6 +311,7 @@ func TestStatsCommand_Run(t *testing.T) {
 		"\tBytes actually used for branch data: 0 (0%)\n" +
 		"\tBytes allocated for physical leaf pages: 4096\n" +
 		"\tBytes actually used for leaf data: 1996 (48%)\n" +
+		"\tBytes saved by leaf key prefix compression: 0\n" +
 		"Bucket statistics\n" +
 		"\tTotal number of buckets: 3\n" +
 		"\tTotal number on inlined buckets: 2 (66%)\n" +
@@ -128,6 +128,10 @@ func (cmd *pageCommand) printPage(path string, pageID uint64, formatValue string
 		err = cmd.PrintBranch(cmd.Stdout, buf)
 	case "freelist":
 		err = cmd.PrintFreelist(cmd.Stdout, buf)
+	case "blob":
+		err = cmd.PrintBlob(cmd.Stdout, buf)
+	case "blobindex":
+		err = cmd.PrintBlobIndex(cmd.Stdout, buf)
 	}
 	if err != nil {
 		return 0, err
@@ -146,8 +150,15 @@ func (cmd *pageCommand) PrintMeta(w io.Writer, buf []byte) error {
 func (cmd *pageCommand) PrintLeaf(w io.Writer, buf []byte, formatValue string) error {
 	p := common.LoadPage(buf)
 
-	// Print number of items.
+	// Print number of items, and the key prefix of a prefix-compressed page.
 	fmt.Fprintf(w, "Item Count: %d\n", p.Count())
+	if prefix := p.LeafKeyPrefix(); prefix != nil {
+		if isPrintable(string(prefix)) {
+			fmt.Fprintf(w, "Key Prefix: %q\n", string(prefix))
+		} else {
+			fmt.Fprintf(w, "Key Prefix: %x\n", string(prefix))
+		}
+	}
 	fmt.Fprintf(w, "\n")
 
 	// Print each key/value.
@@ -167,6 +178,11 @@ func (cmd *pageCommand) PrintLeaf(w io.Writer, buf []byte, formatValue string) e
 		if e.IsBucketEntry() {
 			b := e.Bucket()
 			v = b.String()
+			if ext := e.BucketExt(); ext != nil {
+				v += ext.String()
+			}
+		} else if e.IsBlobEntry() {
+			v = common.LoadBlobRef(e.Value()).String()
 		} else {
 			var err error
 			v, err = formatBytes(e.Value(), formatValue)
@@ -185,8 +201,15 @@ func (cmd *pageCommand) PrintLeaf(w io.Writer, buf []byte, formatValue string) e
 func (cmd *pageCommand) PrintBranch(w io.Writer, buf []byte) error {
 	p := common.LoadPage(buf)
 
-	// Print number of items.
+	// Print number of items, and the key prefix of a prefix-compressed page.
 	fmt.Fprintf(w, "Item Count: %d\n", p.Count())
+	if prefix := p.LeafKeyPrefix(); prefix != nil {
+		if isPrintable(string(prefix)) {
+			fmt.Fprintf(w, "Key Prefix: %q\n", string(prefix))
+		} else {
+			fmt.Fprintf(w, "Key Prefix: %x\n", string(prefix))
+		}
+	}
 	fmt.Fprintf(w, "\n")
 
 	// Print each key/value.
@@ -227,6 +250,31 @@ func (cmd *pageCommand) PrintFreelist(w io.Writer, buf []byte) error {
 	return nil
 }
 
+// PrintBlob prints the header of a blob chunk page.
+func (cmd *pageCommand) PrintBlob(w io.Writer, buf []byte) error {
+	c := common.LoadPage(buf).BlobChunk()
+	fmt.Fprintf(w, "Chunk Size: %d bytes\n", c.Size())
+	fmt.Fprintf(w, "\n")
+	return nil
+}
+
+// PrintBlobIndex prints the data for a blob index page.
+func (cmd *pageCommand) PrintBlobIndex(w io.Writer, buf []byte) error {
+	p := common.LoadPage(buf)
+
+	// Print number of chunks.
+	fmt.Fprintf(w, "Chunk Count: %d\n", p.BlobIndex().Count())
+	fmt.Fprintf(w, "Chunk Size: %d bytes\n", p.BlobIndex().ChunkSize())
+	fmt.Fprintf(w, "\n")
+
+	// Print the page id of each chunk.
+	for _, id := range p.BlobChunkIds() {
+		fmt.Fprintf(w, "%d\n", id)
+	}
+	fmt.Fprintf(w, "\n")
+	return nil
+}
+
 // PrintPage prints a given page as hexadecimal.
 func (cmd *pageCommand) PrintPage(w io.Writer, r io.ReaderAt, pageID int, pageSize int) error {
 	const bytesPerLineN = 16
@@ -0,0 +1,171 @@
+package bbolt
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sync"
+
+	"go.etcd.io/bbolt/errors"
+)
+
+// Codec compresses the values of a bucket. The id of the codec is persisted
+// in the bucket header, so a codec must be registered with RegisterCodec
+// before a bucket using it is opened, in every process reading the database.
+type Codec interface {
+	// ID returns the persisted id of the codec. Zero is reserved.
+	ID() uint32
+
+	// Encode appends the compressed form of src to dst and returns the
+	// extended buffer.
+	Encode(dst, src []byte) ([]byte, error)
+
+	// Decode appends the decompressed form of src to dst and returns the
+	// extended buffer.
+	Decode(dst, src []byte) ([]byte, error)
+}
+
+// FlateCodec compresses values with DEFLATE (RFC 1951) at the default
+// compression level. It is registered with id 1.
+var FlateCodec Codec = flateCodec{}
+
+var (
+	codecsMu sync.RWMutex
+	codecs   = map[uint32]Codec{}
+)
+
+func init() {
+	RegisterCodec(FlateCodec)
+}
+
+// RegisterCodec makes a codec available to the buckets using its id.
+// It panics if the id is zero or already registered.
+func RegisterCodec(c Codec) {
+	codecsMu.Lock()
+	defer codecsMu.Unlock()
+	id := c.ID()
+	if id == 0 {
+		panic("bbolt: codec id 0 is reserved")
+	}
+	if _, ok := codecs[id]; ok {
+		panic(fmt.Sprintf("bbolt: codec %d registered twice", id))
+	}
+	codecs[id] = c
+}
+
+// lookupCodec returns the registered codec with the given id, or nil.
+func lookupCodec(id uint32) Codec {
+	codecsMu.RLock()
+	defer codecsMu.RUnlock()
+	return codecs[id]
+}
+
+// Every value of a bucket with a codec starts with one of these markers.
+// Values which don't get smaller are stored as is, after valueRaw. Other
+// values are stored after valueEncoded and their uvarint encoded size.
+const (
+	valueRaw     = 0x00
+	valueEncoded = 0x01
+)
+
+// encodeValue returns the value stored for v in a bucket compressed by c.
+func encodeValue(c Codec, v []byte) ([]byte, error) {
+	buf := make([]byte, 1, 1+binary.MaxVarintLen64+len(v))
+	buf[0] = valueEncoded
+	buf = binary.AppendUvarint(buf, uint64(len(v)))
+	buf, err := c.Encode(buf, v)
+	if err != nil {
+		return nil, err
+	}
+	if len(buf) <= len(v) {
+		return buf, nil
+	}
+
+	// Store incompressible values as is.
+	buf = append(buf[:0], valueRaw)
+	return append(buf, v...), nil
+}
+
+// decodeValue returns the original value of a value stored in a bucket
+// compressed by c.
+func decodeValue(c Codec, v []byte) ([]byte, error) {
+	if len(v) == 0 {
+		return nil, fmt.Errorf("missing value marker")
+	}
+	switch v[0] {
+	case valueRaw:
+		return v[1:], nil
+	case valueEncoded:
+		size, n := binary.Uvarint(v[1:])
+		if n <= 0 {
+			return nil, fmt.Errorf("invalid value size")
+		}
+		if c == nil {
+			return nil, errors.ErrUnknownCodec
+		}
+		buf, err := c.Decode(make([]byte, 0, size), v[1+n:])
+		if err != nil {
+			return nil, err
+		}
+		if uint64(len(buf)) != size {
+			return nil, fmt.Errorf("decoded value size %d, expected %d", len(buf), size)
+		}
+		return buf, nil
+	default:
+		return nil, fmt.Errorf("invalid value marker %x", v[0])
+	}
+}
+
+// valueSize returns the size of the original value of a value stored in a
+// bucket with a codec, without decoding it.
+func valueSize(v []byte) int {
+	if len(v) == 0 {
+		return 0
+	}
+	if v[0] == valueEncoded {
+		size, _ := binary.Uvarint(v[1:])
+		return int(size)
+	}
+	return len(v) - 1
+}
+
+type flateCodec struct{}
+
+var flateWriters = sync.Pool{
+	New: func() any {
+		w, _ := flate.NewWriter(nil, flate.DefaultCompression)
+		return w
+	},
+}
+
+func (flateCodec) ID() uint32 {
+	return 1
+}
+
+func (flateCodec) Encode(dst, src []byte) ([]byte, error) {
+	buf := bytes.NewBuffer(dst)
+	w := flateWriters.Get().(*flate.Writer)
+	defer flateWriters.Put(w)
+	w.Reset(buf)
+	if _, err := w.Write(src); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (flateCodec) Decode(dst, src []byte) ([]byte, error) {
+	buf := bytes.NewBuffer(dst)
+	r := flate.NewReader(bytes.NewReader(src))
+	if _, err := io.Copy(buf, r); err != nil {
+		return nil, err
+	}
+	if err := r.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
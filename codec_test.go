@@ -0,0 +1,192 @@
+package bbolt_test
+
+import (
+	crand "crypto/rand"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	bolt "go.etcd.io/bbolt"
+	berrors "go.etcd.io/bbolt/errors"
+	"go.etcd.io/bbolt/internal/btesting"
+	"go.etcd.io/bbolt/internal/common"
+)
+
+func jsonValue(i int) []byte {
+	var items []string
+	for j := 0; j < 20; j++ {
+		items = append(items, fmt.Sprintf(`{"id":%d,"name":"widget","tags":["a","b","c"],"count":%d}`, j, i))
+	}
+	return []byte(fmt.Sprintf(`{"id":%d,"items":[%s]}`, i, strings.Join(items, ",")))
+}
+
+// Ensure that values of a bucket with a codec are compressed, and read back transparently.
+func TestBucket_Codec(t *testing.T) {
+	db := btesting.MustCreateDB(t)
+
+	random := make([]byte, 512)
+	_, err := crand.Read(random)
+	require.NoError(t, err)
+
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketWithOptions([]byte("widgets"), &bolt.BucketOptions{Codec: bolt.FlateCodec})
+		require.NoError(t, err)
+		require.Equal(t, bolt.FlateCodec, b.Options().Codec)
+		for i := 0; i < 1000; i++ {
+			require.NoError(t, b.Put([]byte(fmt.Sprintf("%04d", i)), jsonValue(i)))
+		}
+		// Incompressible and empty values are stored as is.
+		require.NoError(t, b.Put([]byte("random"), random))
+		require.NoError(t, b.Put([]byte("empty"), []byte{}))
+		_, err = b.CreateBucket([]byte("nested"))
+		require.NoError(t, err)
+		return nil
+	}))
+
+	check := func() {
+		require.NoError(t, db.View(func(tx *bolt.Tx) error {
+			b := tx.Bucket([]byte("widgets"))
+			require.Equal(t, bolt.FlateCodec, b.Options().Codec)
+			require.Equal(t, jsonValue(7), b.Get([]byte("0007")))
+			require.Equal(t, random, b.Get([]byte("random")))
+			require.Equal(t, []byte{}, b.Get([]byte("empty")))
+			require.Nil(t, b.Get([]byte("nested")))
+			require.NotNil(t, b.Bucket([]byte("nested")))
+
+			c := b.Cursor()
+			k, v := c.First()
+			require.Equal(t, []byte("0000"), k)
+			require.Equal(t, jsonValue(0), v)
+			k, v = c.Seek([]byte("0999"))
+			require.Equal(t, []byte("0999"), k)
+			require.Equal(t, jsonValue(999), v)
+			k, v = c.Last()
+			require.Equal(t, []byte("random"), k)
+			require.Equal(t, random, v)
+			k, v = c.Prev()
+			require.Equal(t, []byte("nested"), k)
+			require.Nil(t, v)
+
+			s := b.Stats()
+			require.Equal(t, 1003, s.KeyN)
+			logical := len(random)
+			for i := 0; i < 1000; i++ {
+				logical += len(jsonValue(i))
+			}
+			require.Equal(t, logical, s.LogicalValueBytes)
+			require.Less(t, s.PhysicalValueBytes, logical/2)
+			return nil
+		}))
+	}
+	check()
+	db.MustCheck()
+	db.MustClose()
+	db.MustReopen()
+	check()
+}
+
+// Ensure that the codec of a small inline bucket is persisted.
+func TestBucket_Codec_Inline(t *testing.T) {
+	db := btesting.MustCreateDB(t)
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		parent, err := tx.CreateBucket([]byte("parent"))
+		require.NoError(t, err)
+		b, err := parent.CreateBucketWithOptions([]byte("child"), &bolt.BucketOptions{Codec: bolt.FlateCodec})
+		require.NoError(t, err)
+		return b.Put([]byte("foo"), jsonValue(1))
+	}))
+
+	require.NoError(t, db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("parent")).Bucket([]byte("child"))
+		require.Zero(t, b.Root())
+		require.Equal(t, bolt.FlateCodec, b.Options().Codec)
+		require.Equal(t, jsonValue(1), b.Get([]byte("foo")))
+		return nil
+	}))
+
+	// Moving the bucket keeps its codec.
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		dst, err := tx.CreateBucket([]byte("dst"))
+		require.NoError(t, err)
+		return tx.Bucket([]byte("parent")).MoveBucket([]byte("child"), dst)
+	}))
+	require.NoError(t, db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("dst")).Bucket([]byte("child"))
+		require.Equal(t, bolt.FlateCodec, b.Options().Codec)
+		require.Equal(t, jsonValue(1), b.Get([]byte("foo")))
+		return nil
+	}))
+}
+
+// Ensure that Compact keeps the codec of the buckets.
+func TestCompact_Codec(t *testing.T) {
+	src := btesting.MustCreateDB(t)
+	require.NoError(t, src.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketWithOptions([]byte("widgets"), &bolt.BucketOptions{Codec: bolt.FlateCodec})
+		require.NoError(t, err)
+		for i := 0; i < 100; i++ {
+			require.NoError(t, b.Put([]byte(fmt.Sprintf("%04d", i)), jsonValue(i)))
+		}
+		return nil
+	}))
+
+	dst, err := bolt.Open(filepath.Join(t.TempDir(), "dst"), 0600, nil)
+	require.NoError(t, err)
+	defer dst.Close()
+	require.NoError(t, bolt.Compact(dst, src.DB, 0))
+
+	require.Equal(t, dumpDB(t, src.DB), dumpDB(t, dst))
+	require.NoError(t, dst.View(func(tx *bolt.Tx) error {
+		require.Equal(t, bolt.FlateCodec, tx.Bucket([]byte("widgets")).Options().Codec)
+		return nil
+	}))
+}
+
+// Ensure that the meta page flags the buckets with options once one is
+// created, directly or by Compact, so that the versions of bbolt which can't
+// read their header refuse the file.
+func TestBucket_Options_Format(t *testing.T) {
+	src := btesting.MustCreateDB(t)
+	require.NoError(t, src.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucket([]byte("parent"))
+		return err
+	}))
+	src.MustClose()
+	require.False(t, fileMeta(t, src.Path()).HasFeature(common.FeatureBucketOptions))
+
+	src.MustReopen()
+	require.NoError(t, src.Update(func(tx *bolt.Tx) error {
+		b, err := tx.Bucket([]byte("parent")).CreateBucketWithOptions([]byte("widgets"), &bolt.BucketOptions{Codec: bolt.FlateCodec})
+		require.NoError(t, err)
+		return b.Put([]byte("foo"), []byte("bar"))
+	}))
+	src.MustClose()
+	require.True(t, fileMeta(t, src.Path()).HasFeature(common.FeatureBucketOptions))
+	src.MustReopen()
+
+	path := filepath.Join(t.TempDir(), "dst")
+	dst, err := bolt.Open(path, 0600, nil)
+	require.NoError(t, err)
+	require.NoError(t, bolt.Compact(dst, src.DB, 0))
+	require.NoError(t, dst.Close())
+	require.True(t, fileMeta(t, path).HasFeature(common.FeatureBucketOptions))
+}
+
+type testCodec struct{}
+
+func (testCodec) ID() uint32                             { return 0xfff0 }
+func (testCodec) Encode(dst, src []byte) ([]byte, error) { return append(dst, src...), nil }
+func (testCodec) Decode(dst, src []byte) ([]byte, error) { return append(dst, src...), nil }
+
+// Ensure that a bucket can't be created with a codec which isn't registered.
+func TestBucket_Codec_Unknown(t *testing.T) {
+	db := btesting.MustCreateDB(t)
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketWithOptions([]byte("widgets"), &bolt.BucketOptions{Codec: testCodec{}})
+		require.ErrorIs(t, err, berrors.ErrUnknownCodec)
+		return nil
+	}))
+}
@@ -1,13 +1,27 @@
 package bbolt
 
+import (
+	"go.etcd.io/bbolt/errors"
+	"go.etcd.io/bbolt/internal/common"
+)
+
 // Compact will create a copy of the source DB and in the destination DB. This may
 // reclaim space that the source database no longer has use for. txMaxSize can be
 // used to limit the transactions size of this process and may trigger intermittent
 // commits. A value of zero will ignore transaction sizes.
+//
+// The keys are read with Tx.Walk, so the expired keys are skipped if src hides
+// them. With a zero txMaxSize, the buckets are bulk loaded, so their leaf pages
+// are full, and written to the file as they're filled rather than held in
+// memory until commit. Otherwise, the keys are copied one by one, so that a
+// commit can happen in the middle of a bucket.
 // TODO: merge with: https://github.com/etcd-io/etcd/blob/b7f0f52a16dbf83f18ca1d803f7892d750366a94/mvcc/backend/backend.go#L349
 func Compact(dst, src *DB, txMaxSize int64) error {
 	// commit regularly, or we'll run out of memory for large datasets if using one transaction.
-	var size int64
+	if txMaxSize != 0 {
+		return compactKeys(dst, src, txMaxSize)
+	}
+
 	tx, err := dst.Begin(true)
 	if err != nil {
 		return err
@@ -18,102 +32,218 @@ func Compact(dst, src *DB, txMaxSize int64) error {
 		}
 	}()
 
-	if err := walk(src, func(keys [][]byte, k, v []byte, seq uint64) error {
-		// On each key/value, check if we have exceeded tx size.
-		sz := int64(len(k) + len(v))
-		if size+sz > txMaxSize && txMaxSize != 0 {
-			// Commit previous transaction.
-			if err := tx.Commit(); err != nil {
-				return err
-			}
+	// The expiry bucket is hidden from the walk, as its entries are added
+	// again when the expiring keys are loaded.
+	c := &bulkCompactor{tx: tx}
+	if err := src.View(func(srcTx *Tx) error {
+		if err := srcTx.walk(c.copyKey, WalkOptions{}); err != nil {
+			return err
+		}
+		return c.finish(0)
+	}); err != nil {
+		return err
+	}
+	err = tx.Commit()
 
-			// Start new transaction.
-			tx, err = dst.Begin(true)
-			if err != nil {
-				return err
-			}
-			size = 0
+	return err
+}
+
+// bulkCompactor copies the keys walked in a source database into the
+// transaction tx, by bulk loading each bucket. The keys of a bucket are walked
+// right after it, so the loaders of the buckets being copied form a stack.
+type bulkCompactor struct {
+	tx      *Tx
+	loaders []*bulkLoader // loaders of the buckets being copied, from the top level one
+}
+
+// copyKey adds the key k of the bucket src, whose leaf value v has the given
+// flags, to the loader of the bucket with the same path. Nested buckets and
+// blobs are copied into dst first, and the expiry times are kept.
+func (c *bulkCompactor) copyKey(path [][]byte, src *Bucket, k, v []byte, flags uint32) error {
+	// The buckets deeper than k were walked entirely.
+	if err := c.finish(len(path)); err != nil {
+		return err
+	}
+
+	if (flags & common.BucketLeafFlag) != 0 {
+		child := src.nestedBucket(k)
+		if !child.comparatorKnown() {
+			return errors.ErrUnknownComparator
 		}
-		size += sz
+		return c.startBucket(k, child)
+	}
 
-		// Create bucket on the root transaction if this is the first level.
-		nk := len(keys)
-		if nk == 0 {
-			bkt, err := tx.CreateBucket(k)
-			if err != nil {
-				return err
-			}
-			if err := bkt.SetSequence(seq); err != nil {
-				return err
-			}
-			return nil
+	l := c.loaders[len(c.loaders)-1]
+	dst := l.b
+	switch {
+	case (flags & common.BlobLeafFlag) != 0:
+		// Blobs are copied chunk by chunk.
+		ref, err := dst.tx.writeBlob(newBlobReader(src.tx, *common.LoadBlobRef(v)))
+		if err != nil {
+			return err
+		}
+		return l.add(k, ref.Bytes(), common.BlobLeafFlag)
+	case dst.ext.Codec() == src.ext.Codec():
+		// The values are copied as stored, compressed by the same codec.
+		return l.add(k, v, flags)
+	default:
+		value, flags, err := dst.leafValue(src.value(v, flags), leafExpiry(v, flags))
+		if err != nil {
+			return err
 		}
+		return l.add(k, value, flags)
+	}
+}
 
-		// Create buckets on subsequent levels, if necessary.
-		b := tx.Bucket(keys[0])
-		if nk > 1 {
-			for _, k := range keys[1:] {
-				b = b.Bucket(k)
-			}
+// startBucket begins loading the copy of the bucket src, named name. A top
+// level bucket is created in tx, and a nested one is added to its parent once
+// it's loaded. The copy keeps the options and the sequence of src.
+func (c *bulkCompactor) startBucket(name []byte, src *Bucket) error {
+	var b *Bucket
+	if len(c.loaders) == 0 {
+		opts := src.Options()
+		var err error
+		if b, err = c.tx.CreateBucketWithOptions(name, &opts); err != nil {
+			return err
 		}
+	} else {
+		parent := c.loaders[len(c.loaders)-1].b
+		nb := newBucket(parent.tx)
+		b = &nb
+		b.InBucket = &common.InBucket{}
+		b.rootNode = &node{bucket: b, isLeaf: true}
+		b.ext, b.codec, b.comparator = src.ext, src.codec, src.comparator
+		b.parent, b.name = parent, cloneBytes(name)
+		b.tx.addBucketFeatures(&b.ext)
+	}
+	b.SetInSequence(src.Sequence())
 
-		// Fill the entire page for best compaction.
-		b.FillPercent = 1.0
+	l, err := b.newBulkLoader()
+	if err != nil {
+		return err
+	}
+	c.loaders = append(c.loaders, l)
+	return nil
+}
 
-		// If there is no value then this is a bucket call.
-		if v == nil {
-			bkt, err := b.CreateBucket(k)
-			if err != nil {
-				return err
-			}
-			if err := bkt.SetSequence(seq); err != nil {
+// finish finishes loading the buckets of the stack deeper than depth, and adds
+// the nested ones to their parent.
+func (c *bulkCompactor) finish(depth int) error {
+	for len(c.loaders) > depth {
+		l := c.loaders[len(c.loaders)-1]
+		c.loaders = c.loaders[:len(c.loaders)-1]
+		if err := l.finish(); err != nil {
+			return err
+		}
+		b := l.b
+		if len(c.loaders) == 0 {
+			b.saveHeader()
+			continue
+		}
+
+		// Write the bucket inline if it's small enough, like spill.
+		parent := c.loaders[len(c.loaders)-1]
+		if b.inlineable() {
+			if err := parent.add(b.name, b.write(), b.leafFlags()); err != nil {
 				return err
 			}
-			return nil
+			continue
 		}
+		if err := b.spill(); err != nil {
+			return err
+		}
+		value := make([]byte, common.BucketValueHeaderSize(b.leafFlags()))
+		b.writeHeader(value)
+		if err := parent.add(b.name, value, b.leafFlags()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
-		// Otherwise treat it as a key/value pair.
-		return b.Put(k, v)
-	}); err != nil {
+// compactKeys copies src into dst like Compact, one key at a time, committing
+// the transaction before its keys and values exceed txMaxSize bytes.
+func compactKeys(dst, src *DB, txMaxSize int64) error {
+	tx, err := dst.Begin(true)
+	if err != nil {
 		return err
 	}
-	err = tx.Commit()
+	c := &keyCompactor{dst: dst, tx: tx, txMaxSize: txMaxSize}
+	defer func() {
+		_ = c.tx.Rollback()
+	}()
 
-	return err
+	// The expiry bucket is hidden from the walk, as its entries are added
+	// again when the expiring keys are put.
+	if err := src.View(func(srcTx *Tx) error {
+		return srcTx.walk(c.copyKey, WalkOptions{})
+	}); err != nil {
+		return err
+	}
+	return c.tx.Commit()
 }
 
-// walkFunc is the type of the function called for keys (buckets and "normal"
-// values) discovered by Walk. keys is the list of keys to descend to the bucket
-// owning the discovered key/value pair k/v.
-type walkFunc func(keys [][]byte, k, v []byte, seq uint64) error
-
-// walk walks recursively the bolt database db, calling walkFn for each key it finds.
-func walk(db *DB, walkFn walkFunc) error {
-	return db.View(func(tx *Tx) error {
-		return tx.ForEach(func(name []byte, b *Bucket) error {
-			return walkBucket(b, nil, name, nil, b.Sequence(), walkFn)
-		})
-	})
+// keyCompactor copies the keys walked in a source database one by one into
+// the transaction tx, which is committed and replaced when it reaches
+// txMaxSize bytes.
+type keyCompactor struct {
+	dst       *DB
+	tx        *Tx
+	size      int64
+	txMaxSize int64
 }
 
-func walkBucket(b *Bucket, keypath [][]byte, k, v []byte, seq uint64, fn walkFunc) error {
-	// Execute callback.
-	if err := fn(keypath, k, v, seq); err != nil {
+// copyKey copies the key k of the bucket src, whose leaf value v has the
+// given flags, into the bucket with the same path. The options and the
+// sequence of nested buckets, the expiry times and the blobs are kept.
+func (c *keyCompactor) copyKey(path [][]byte, src *Bucket, k, v []byte, flags uint32) error {
+	sz := int64(len(k) + len(v))
+	if (flags & common.BlobLeafFlag) != 0 {
+		sz = int64(len(k)) + int64(common.LoadBlobRef(v).Size())
+	}
+	if err := c.reserve(sz); err != nil {
 		return err
 	}
 
-	// If this is not a bucket then stop.
-	if v != nil {
-		return nil
+	b := c.tx.bucketAt(path)
+	if b == nil {
+		return errors.ErrBucketNotFound
 	}
+	// Fill the entire page for best compaction.
+	b.FillPercent = 1.0
 
-	// Iterate over each child key/value.
-	keypath = append(keypath, k)
-	return b.ForEach(func(k, v []byte) error {
-		if v == nil {
-			bkt := b.Bucket(k)
-			return walkBucket(bkt, keypath, k, nil, bkt.Sequence(), fn)
+	switch {
+	case (flags & common.BucketLeafFlag) != 0:
+		child := src.nestedBucket(k)
+		if !child.comparatorKnown() {
+			return errors.ErrUnknownComparator
 		}
-		return walkBucket(b, keypath, k, v, b.Sequence(), fn)
-	})
+		opts := child.Options()
+		nb, err := b.CreateBucketWithOptions(k, &opts)
+		if err != nil {
+			return err
+		}
+		return nb.SetSequence(child.Sequence())
+	case (flags & common.BlobLeafFlag) != 0:
+		return b.PutReader(k, newBlobReader(src.tx, *common.LoadBlobRef(v)))
+	default:
+		return b.put(b.Cursor(), k, src.value(v, flags), leafExpiry(v, flags))
+	}
+}
+
+// reserve commits the transaction and begins a new one if adding sz bytes
+// would exceed txMaxSize, and adds them to its size.
+func (c *keyCompactor) reserve(sz int64) error {
+	if c.size+sz > c.txMaxSize && c.size > 0 {
+		if err := c.tx.Commit(); err != nil {
+			return err
+		}
+		tx, err := c.dst.Begin(true)
+		if err != nil {
+			return err
+		}
+		c.tx, c.size = tx, 0
+	}
+	c.size += sz
+	return nil
 }
@@ -0,0 +1,95 @@
+package bbolt
+
+import (
+	"bytes"
+	"fmt"
+	"hash/fnv"
+	"sync"
+)
+
+// Comparator orders the keys of a bucket. A 64-bit hash of the name of the
+// comparator is persisted in the bucket header, so a comparator must be
+// registered with RegisterComparator before a bucket using it is opened, in
+// every process reading the database. Buckets without a comparator order
+// their keys with bytes.Compare.
+type Comparator interface {
+	// Name returns the name identifying the comparator, which must be unique
+	// and never change, e.g. prefixed with the import path of its package.
+	Name() string
+
+	// Compare returns -1, 0 or 1 if a is less than, equal to or greater
+	// than b. It must be a total order, where only identical keys are equal,
+	// and must never change once a bucket uses it.
+	Compare(a, b []byte) int
+}
+
+// ReverseComparator orders keys in descending bytes.Compare order, so that
+// cursors iterate from the greatest key with First and Next. It is registered
+// with the name "go.etcd.io/bbolt.reverse".
+var ReverseComparator Comparator = reverseComparator{}
+
+var (
+	comparatorsMu sync.RWMutex
+	comparators   = map[uint64]Comparator{}
+)
+
+func init() {
+	RegisterComparator(ReverseComparator)
+}
+
+// RegisterComparator makes a comparator available to the buckets using its
+// name. It panics if the name is empty or already registered, or if its hash
+// is the hash of the name of another registered comparator.
+func RegisterComparator(c Comparator) {
+	comparatorsMu.Lock()
+	defer comparatorsMu.Unlock()
+	name := c.Name()
+	if name == "" {
+		panic("bbolt: comparator name is empty")
+	}
+	id := comparatorID(name)
+	if other, ok := comparators[id]; ok {
+		if other.Name() == name {
+			panic(fmt.Sprintf("bbolt: comparator %q registered twice", name))
+		}
+		panic(fmt.Sprintf("bbolt: comparators %q and %q have the same hash", other.Name(), name))
+	}
+	comparators[id] = c
+}
+
+// comparatorID returns the id of the comparator with the given name, persisted
+// in the bucket headers: the 64-bit FNV-1a hash of the name, which is never
+// zero.
+func comparatorID(name string) uint64 {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(name))
+	return max(h.Sum64(), 1)
+}
+
+// lookupComparator returns the registered comparator with the given id, or
+// nil.
+func lookupComparator(id uint64) Comparator {
+	comparatorsMu.RLock()
+	defer comparatorsMu.RUnlock()
+	return comparators[id]
+}
+
+// compare compares two keys of the bucket, with its comparator if it has one.
+func (b *Bucket) compare(x, y []byte) int {
+	if b.comparator != nil {
+		return b.comparator.Compare(x, y)
+	}
+	return bytes.Compare(x, y)
+}
+
+// comparatorKnown returns whether the bucket has no comparator, or one which
+// is registered. Buckets whose comparator isn't registered can't be opened.
+func (b *Bucket) comparatorKnown() bool {
+	return b.ext.Comparator() == 0 || b.comparator != nil
+}
+
+type reverseComparator struct{}
+
+func (reverseComparator) Name() string { return "go.etcd.io/bbolt.reverse" }
+
+func (reverseComparator) Compare(a, b []byte) int { return bytes.Compare(b, a) }
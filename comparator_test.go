@@ -0,0 +1,249 @@
+package bbolt_test
+
+import (
+	"encoding/binary"
+	"fmt"
+	"log"
+	"math/rand"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	bolt "go.etcd.io/bbolt"
+	berrors "go.etcd.io/bbolt/errors"
+	"go.etcd.io/bbolt/internal/btesting"
+	"go.etcd.io/bbolt/internal/common"
+)
+
+// uint64Comparator orders 8 byte little-endian integers numerically.
+type uint64Comparator struct{}
+
+func (uint64Comparator) Name() string { return "go.etcd.io/bbolt_test.uint64" }
+
+func (uint64Comparator) Compare(a, b []byte) int {
+	x, y := binary.LittleEndian.Uint64(a), binary.LittleEndian.Uint64(b)
+	switch {
+	case x < y:
+		return -1
+	case x > y:
+		return 1
+	}
+	return 0
+}
+
+func init() {
+	bolt.RegisterComparator(uint64Comparator{})
+}
+
+func uint64Key(i uint64) []byte {
+	return binary.LittleEndian.AppendUint64(nil, i)
+}
+
+// uint64Keys returns the keys of a bucket ordered by uint64Comparator.
+func uint64Keys(b *bolt.Bucket) []uint64 {
+	var keys []uint64
+	c := b.Cursor()
+	for k, _ := c.First(); k != nil; k, _ = c.Next() {
+		keys = append(keys, binary.LittleEndian.Uint64(k))
+	}
+	return keys
+}
+
+// Ensure that the keys of a bucket with a comparator are inserted, split,
+// sought, counted and deleted in the order of the comparator.
+func TestBucket_Comparator(t *testing.T) {
+	for _, counted := range []bool{false, true} {
+		t.Run(fmt.Sprintf("counted %v", counted), func(t *testing.T) {
+			db := btesting.MustCreateDB(t)
+			const n = 5000
+			opts := &bolt.BucketOptions{Counted: counted, Comparator: uint64Comparator{}}
+			require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+				b, err := tx.CreateBucketWithOptions([]byte("widgets"), opts)
+				require.NoError(t, err)
+				for _, i := range rand.New(rand.NewSource(42)).Perm(n) {
+					require.NoError(t, b.Put(uint64Key(uint64(i)*10), []byte(fmt.Sprintf("%d", i))))
+				}
+				_, err = b.CreateBucket(uint64Key(5))
+				return err
+			}))
+			db.MustCheck()
+
+			var keys []string
+			for i := 0; i < n; i++ {
+				keys = append(keys, string(uint64Key(uint64(i)*10)))
+				if i == 0 {
+					keys = append(keys, string(uint64Key(5)))
+				}
+			}
+			require.NoError(t, db.View(func(tx *bolt.Tx) error {
+				b := tx.Bucket([]byte("widgets"))
+				require.Equal(t, *opts, b.Options())
+				forward, backward := cursorKeys(b)
+				require.Equal(t, keys, forward)
+				require.Len(t, backward, n+1)
+				require.Equal(t, []byte("1234"), b.Get(uint64Key(12340)))
+				require.NotNil(t, b.Bucket(uint64Key(5)))
+
+				// Seek returns the next key in the order of the comparator.
+				k, v := b.Cursor().Seek(uint64Key(12341))
+				require.Equal(t, uint64Key(12350), k)
+				require.Equal(t, []byte("1235"), v)
+				if counted {
+					c := b.Cursor()
+					for i := 0; i < len(keys); i += 97 {
+						k, _ := c.SeekIndex(i)
+						require.Equal(t, keys[i], string(k))
+						c.Seek([]byte(keys[i]))
+						require.Equal(t, i, c.Rank())
+					}
+					require.Equal(t, 1000, b.CountRange(uint64Key(10), uint64Key(10010)))
+				}
+				return nil
+			}))
+
+			require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+				b := tx.Bucket([]byte("widgets"))
+				deleted, err := b.DeleteRange(uint64Key(100), uint64Key(40000))
+				require.NoError(t, err)
+				require.Equal(t, 3990, deleted)
+				for i := 0; i < n; i += 2 {
+					require.NoError(t, b.Delete(uint64Key(uint64(i)*10)))
+				}
+				require.Equal(t, []uint64{5, 10, 30, 50, 70, 90}, uint64Keys(b)[:6])
+				return nil
+			}))
+			db.MustCheck()
+		})
+	}
+}
+
+// Ensure that buckets in reverse order can be bulk loaded and compacted, and
+// aren't prefix compressed.
+func TestBucket_Comparator_Reverse(t *testing.T) {
+	src := btesting.MustCreateDBWithOption(t, &bolt.Options{PrefixCompression: true})
+	keys, values := make([]string, 10000), make([]string, 10000)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("key-%05d", len(keys)-i)
+		values[i] = fmt.Sprintf("value-%d", i)
+	}
+	require.NoError(t, src.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketWithOptions([]byte("widgets"), &bolt.BucketOptions{Comparator: bolt.ReverseComparator})
+		require.NoError(t, err)
+		require.ErrorIs(t, b.BulkLoad(sliceLoader([]string{"a", "b"}, []string{"1", "2"}, nil)), berrors.ErrKeysUnordered)
+		require.NoError(t, b.Delete([]byte("a")))
+		require.NoError(t, b.BulkLoad(sliceLoader(keys, values, nil)))
+
+		nested, err := b.CreateBucketWithOptions([]byte("nested"), &bolt.BucketOptions{Comparator: bolt.ReverseComparator})
+		require.NoError(t, err)
+		require.NoError(t, nested.Put([]byte("a"), []byte("1")))
+		require.NoError(t, nested.Put([]byte("b"), []byte("2")))
+		return nil
+	}))
+	src.MustCheck()
+
+	dst := btesting.MustCreateDB(t)
+	require.NoError(t, bolt.Compact(dst.DB, src.DB, 0))
+	dst.MustCheck()
+	require.Equal(t, dumpDB(t, src.DB), dumpDB(t, dst.DB))
+
+	for _, db := range []*btesting.DB{src, dst} {
+		require.NoError(t, db.View(func(tx *bolt.Tx) error {
+			b := tx.Bucket([]byte("widgets"))
+			require.Equal(t, bolt.ReverseComparator, b.Options().Comparator)
+			forward, _ := cursorKeys(b)
+			require.Equal(t, append([]string{"nested"}, keys...), forward)
+			require.Zero(t, b.Stats().LeafPrefixSaved)
+
+			forward, _ = cursorKeys(b.Bucket([]byte("nested")))
+			require.Equal(t, []string{"b", "a"}, forward)
+			return nil
+		}))
+	}
+}
+
+// Ensure that the meta page flags the buckets with a comparator once one is
+// created, so that the versions of bbolt which order keys with bytes.Compare
+// refuse the file.
+func TestBucket_Comparator_Format(t *testing.T) {
+	db := btesting.MustCreateDB(t)
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketWithOptions([]byte("counted"), &bolt.BucketOptions{Counted: true})
+		return err
+	}))
+	db.MustClose()
+	require.False(t, fileMeta(t, db.Path()).HasFeature(common.FeatureComparators))
+
+	db.MustReopen()
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketWithOptions([]byte("numbers"), &bolt.BucketOptions{Comparator: uint64Comparator{}})
+		return err
+	}))
+	db.MustClose()
+	require.True(t, fileMeta(t, db.Path()).HasFeature(common.FeatureComparators))
+	db.MustReopen()
+}
+
+// namedComparator is a comparator with any name.
+type namedComparator string
+
+func (c namedComparator) Name() string          { return string(c) }
+func (namedComparator) Compare(a, b []byte) int { return 0 }
+
+// Ensure that a bucket can't be created with a comparator which isn't
+// registered, and that a comparator name can't be registered twice, or be
+// empty.
+func TestBucket_Comparator_Unregistered(t *testing.T) {
+	db := btesting.MustCreateDB(t)
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketWithOptions([]byte("widgets"), &bolt.BucketOptions{Comparator: namedComparator("go.etcd.io/bbolt_test.unregistered")})
+		require.ErrorIs(t, err, berrors.ErrUnknownComparator)
+		return nil
+	}))
+
+	require.Panics(t, func() { bolt.RegisterComparator(uint64Comparator{}) })
+	require.Panics(t, func() { bolt.RegisterComparator(namedComparator(uint64Comparator{}.Name())) })
+	require.Panics(t, func() { bolt.RegisterComparator(namedComparator("")) })
+}
+
+func ExampleRegisterComparator() {
+	// Open the database.
+	db, err := bolt.Open(tempfile(), 0600, nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.Remove(db.Path())
+
+	// Store numbers as little-endian keys, ordered numerically by the
+	// comparator registered in init.
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketWithOptions([]byte("numbers"), &bolt.BucketOptions{Comparator: uint64Comparator{}})
+		if err != nil {
+			return err
+		}
+		for _, i := range []uint64{300, 2, 1000, 45} {
+			if err := b.Put(uint64Key(i), nil); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		log.Fatal(err)
+	}
+
+	// Iterate over the keys in numerical order.
+	if err := db.View(func(tx *bolt.Tx) error {
+		fmt.Println(uint64Keys(tx.Bucket([]byte("numbers"))))
+		return nil
+	}); err != nil {
+		log.Fatal(err)
+	}
+
+	// Close database to release the file lock.
+	if err := db.Close(); err != nil {
+		log.Fatal(err)
+	}
+
+	// Output:
+	// [2 45 300 1000]
+}
@@ -0,0 +1,88 @@
+package bbolt
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"go.etcd.io/bbolt/errors"
+)
+
+// lengthComparator orders shorter keys first.
+type lengthComparator struct{}
+
+func (lengthComparator) Name() string { return "go.etcd.io/bbolt.length" }
+
+func (lengthComparator) Compare(a, b []byte) int {
+	if len(a) != len(b) {
+		return len(a) - len(b)
+	}
+	return bytes.Compare(a, b)
+}
+
+// Ensure that buckets whose comparator isn't registered can't be opened,
+// checked, walked through or compacted, but can be deleted.
+func TestBucket_Comparator_Unknown(t *testing.T) {
+	db, err := Open(filepath.Join(t.TempDir(), "db"), 0600, nil)
+	require.NoError(t, err)
+	defer db.Close()
+
+	RegisterComparator(lengthComparator{})
+	require.NoError(t, db.Update(func(tx *Tx) error {
+		parent, err := tx.CreateBucket([]byte("parent"))
+		require.NoError(t, err)
+		b, err := parent.CreateBucketWithOptions([]byte("widgets"), &BucketOptions{Comparator: lengthComparator{}})
+		require.NoError(t, err)
+		for _, k := range []string{"ccc", "a", "bb", "b"} {
+			require.NoError(t, b.Put([]byte(k), []byte(k)))
+		}
+		_, err = b.CreateBucket([]byte("nested"))
+		return err
+	}))
+
+	comparatorsMu.Lock()
+	delete(comparators, comparatorID(lengthComparator{}.Name()))
+	comparatorsMu.Unlock()
+
+	require.NoError(t, db.View(func(tx *Tx) error {
+		parent := tx.Bucket([]byte("parent"))
+		require.Nil(t, parent.Bucket([]byte("widgets")))
+
+		var errs []string
+		for err := range tx.Check() {
+			errs = append(errs, err.Error())
+		}
+		require.Len(t, errs, 1)
+		require.Contains(t, errs[0], fmt.Sprintf("unknown comparator %016x", comparatorID(lengthComparator{}.Name())))
+
+		// Walk calls fn for the bucket, but skips its keys.
+		var walked []string
+		require.NoError(t, tx.Walk(func(path [][]byte, k, v []byte, seq uint64) error {
+			walked = append(walked, string(k))
+			return nil
+		}))
+		require.Equal(t, []string{"parent", "widgets"}, walked)
+		return nil
+	}))
+
+	dst, err := Open(filepath.Join(t.TempDir(), "dst"), 0600, nil)
+	require.NoError(t, err)
+	defer dst.Close()
+	require.ErrorIs(t, Compact(dst, db, 0), errors.ErrUnknownComparator)
+
+	require.NoError(t, db.Update(func(tx *Tx) error {
+		parent := tx.Bucket([]byte("parent"))
+		_, err := parent.CreateBucketIfNotExists([]byte("widgets"))
+		require.ErrorIs(t, err, errors.ErrUnknownComparator)
+		return parent.DeleteBucket([]byte("widgets"))
+	}))
+	require.NoError(t, db.View(func(tx *Tx) error {
+		for err := range tx.Check() {
+			t.Error(err)
+		}
+		return nil
+	}))
+}
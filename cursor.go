@@ -11,17 +11,22 @@ import (
 
 // Cursor represents an iterator that can traverse over all key/value pairs in a bucket
 // in lexicographical order.
-// Cursors see nested buckets with value == nil.
+// Cursors see nested buckets with value == nil, and blobs with an empty value.
 // Cursors can be obtained from a transaction and are valid as long as the transaction is open.
 //
 // Keys and values returned from the cursor are only valid for the life of the transaction.
 //
+// A page which fails checksum verification or decryption is read as empty, so
+// the cursor skips its keys, and may return a nil key before the end of the
+// bucket. Check Err when a nil key is returned to tell them apart.
+//
 // Changing data while traversing with a cursor may cause it to be invalidated
 // and return unexpected keys and/or values. You must reposition your cursor
-// after mutating data.
+// after mutating data, unless it was written with Cursor.Put or Cursor.Insert.
 type Cursor struct {
-	bucket *Bucket
-	stack  []elemRef
+	bucket  *Bucket
+	stack   []elemRef
+	reverse bool // whether the cursor last moved backwards, recorded by Token
 }
 
 // Bucket returns the bucket that this cursor was created from.
@@ -29,16 +34,27 @@ func (c *Cursor) Bucket() *Bucket {
 	return c.bucket
 }
 
+// Err returns the error of Tx.Err: the error of the first page read by the
+// transaction of the cursor which failed checksum verification or decryption,
+// or nil.
+func (c *Cursor) Err() error {
+	return c.bucket.tx.Err()
+}
+
 // First moves the cursor to the first item in the bucket and returns its key and value.
 // If the bucket is empty then a nil key and value are returned.
 // The returned key and value are only valid for the life of the transaction.
 func (c *Cursor) First() (key []byte, value []byte) {
 	common.Assert(c.bucket.tx.db != nil, "tx closed")
+	c.reverse = false
 	k, v, flags := c.first()
+	for k != nil && c.bucket.hidden(k, v, flags) {
+		k, v, flags = c.next()
+	}
 	if (flags & uint32(common.BucketLeafFlag)) != 0 {
 		return k, nil
 	}
-	return k, v
+	return k, c.bucket.value(v, flags)
 }
 
 func (c *Cursor) first() (key []byte, value []byte, flags uint32) {
@@ -65,28 +81,15 @@ func (c *Cursor) first() (key []byte, value []byte, flags uint32) {
 // The returned key and value are only valid for the life of the transaction.
 func (c *Cursor) Last() (key []byte, value []byte) {
 	common.Assert(c.bucket.tx.db != nil, "tx closed")
-	c.stack = c.stack[:0]
-	p, n := c.bucket.pageNode(c.bucket.RootPage())
-	ref := elemRef{page: p, node: n}
-	ref.index = ref.count() - 1
-	c.stack = append(c.stack, ref)
-	c.last()
-
-	// If this is an empty page (calling Delete may result in empty pages)
-	// we call prev to find the last page that is not empty
-	for len(c.stack) > 0 && c.stack[len(c.stack)-1].count() == 0 {
-		c.prev()
+	c.reverse = true
+	k, v, flags := c.lastElement()
+	for k != nil && c.bucket.hidden(k, v, flags) {
+		k, v, flags = c.prev()
 	}
-
-	if len(c.stack) == 0 {
-		return nil, nil
-	}
-
-	k, v, flags := c.keyValue()
 	if (flags & uint32(common.BucketLeafFlag)) != 0 {
 		return k, nil
 	}
-	return k, v
+	return k, c.bucket.value(v, flags)
 }
 
 // Next moves the cursor to the next item in the bucket and returns its key and value.
@@ -94,11 +97,15 @@ func (c *Cursor) Last() (key []byte, value []byte) {
 // The returned key and value are only valid for the life of the transaction.
 func (c *Cursor) Next() (key []byte, value []byte) {
 	common.Assert(c.bucket.tx.db != nil, "tx closed")
+	c.reverse = false
 	k, v, flags := c.next()
+	for k != nil && c.bucket.hidden(k, v, flags) {
+		k, v, flags = c.next()
+	}
 	if (flags & uint32(common.BucketLeafFlag)) != 0 {
 		return k, nil
 	}
-	return k, v
+	return k, c.bucket.value(v, flags)
 }
 
 // Prev moves the cursor to the previous item in the bucket and returns its key and value.
@@ -106,11 +113,15 @@ func (c *Cursor) Next() (key []byte, value []byte) {
 // The returned key and value are only valid for the life of the transaction.
 func (c *Cursor) Prev() (key []byte, value []byte) {
 	common.Assert(c.bucket.tx.db != nil, "tx closed")
+	c.reverse = true
 	k, v, flags := c.prev()
+	for k != nil && c.bucket.hidden(k, v, flags) {
+		k, v, flags = c.prev()
+	}
 	if (flags & uint32(common.BucketLeafFlag)) != 0 {
 		return k, nil
 	}
-	return k, v
+	return k, c.bucket.value(v, flags)
 }
 
 // Seek moves the cursor to a given key using a b-tree search and returns it.
@@ -119,20 +130,85 @@ func (c *Cursor) Prev() (key []byte, value []byte) {
 // The returned key and value are only valid for the life of the transaction.
 func (c *Cursor) Seek(seek []byte) (key []byte, value []byte) {
 	common.Assert(c.bucket.tx.db != nil, "tx closed")
+	c.reverse = false
 
-	k, v, flags := c.seek(seek)
+	k, v, flags := c.seekGE(seek)
+	for k != nil && c.bucket.hidden(k, v, flags) {
+		k, v, flags = c.next()
+	}
 
-	// If we ended up after the last element of a page then move to the next one.
-	if ref := &c.stack[len(c.stack)-1]; ref.index >= ref.count() {
+	if k == nil {
+		return nil, nil
+	} else if (flags & uint32(common.BucketLeafFlag)) != 0 {
+		return k, nil
+	}
+	return k, c.bucket.value(v, flags)
+}
+
+// SeekGT moves the cursor to the first key greater than a given key and
+// returns it. If no keys follow, a nil key is returned.
+// The returned key and value are only valid for the life of the transaction.
+func (c *Cursor) SeekGT(seek []byte) (key []byte, value []byte) {
+	common.Assert(c.bucket.tx.db != nil, "tx closed")
+	c.reverse = false
+
+	k, v, flags := c.seekGE(seek)
+	if k != nil && bytes.Equal(k, seek) {
+		k, v, flags = c.next()
+	}
+	for k != nil && c.bucket.hidden(k, v, flags) {
 		k, v, flags = c.next()
 	}
+	return c.userKeyValue(k, v, flags)
+}
+
+// SeekLE moves the cursor to the last key less than or equal to a given key
+// and returns it. If no keys precede it, a nil key is returned.
+// The returned key and value are only valid for the life of the transaction.
+func (c *Cursor) SeekLE(seek []byte) (key []byte, value []byte) {
+	common.Assert(c.bucket.tx.db != nil, "tx closed")
+	c.reverse = true
+
+	k, v, flags := c.seekGE(seek)
+	if k == nil {
+		k, v, flags = c.lastElement()
+	} else if !bytes.Equal(k, seek) {
+		k, v, flags = c.prev()
+	}
+	for k != nil && c.bucket.hidden(k, v, flags) {
+		k, v, flags = c.prev()
+	}
+	return c.userKeyValue(k, v, flags)
+}
+
+// SeekLT moves the cursor to the last key less than a given key and returns
+// it. If no keys precede it, a nil key is returned.
+// The returned key and value are only valid for the life of the transaction.
+func (c *Cursor) SeekLT(seek []byte) (key []byte, value []byte) {
+	common.Assert(c.bucket.tx.db != nil, "tx closed")
+	c.reverse = true
+
+	k, v, flags := c.seekGE(seek)
+	if k == nil {
+		k, v, flags = c.lastElement()
+	} else {
+		k, v, flags = c.prev()
+	}
+	for k != nil && c.bucket.hidden(k, v, flags) {
+		k, v, flags = c.prev()
+	}
+	return c.userKeyValue(k, v, flags)
+}
 
+// userKeyValue returns the key and the value of an element, as returned to
+// the caller: the value of a bucket is nil, and the others are decoded.
+func (c *Cursor) userKeyValue(k, v []byte, flags uint32) ([]byte, []byte) {
 	if k == nil {
 		return nil, nil
 	} else if (flags & uint32(common.BucketLeafFlag)) != 0 {
 		return k, nil
 	}
-	return k, v
+	return k, c.bucket.value(v, flags)
 }
 
 // Delete removes the current key/value under the cursor from the bucket.
@@ -144,16 +220,50 @@ func (c *Cursor) Delete() error {
 		return errors.ErrTxNotWritable
 	}
 
-	key, _, flags := c.keyValue()
+	key, v, flags := c.keyValue()
 	// Return an error if current value is a bucket.
 	if (flags & common.BucketLeafFlag) != 0 {
 		return errors.ErrIncompatibleValue
 	}
+	if err := c.bucket.releaseValue(key, v, flags); err != nil {
+		return err
+	}
 	c.node().del(key)
 
 	return nil
 }
 
+// Put sets the value of the current key under the cursor, like Bucket.Put,
+// without searching the key from the root of the bucket. The cursor remains
+// on the key, so that Next and Prev move from it.
+// Put fails if the cursor isn't on a key, if the current value is a bucket or
+// if the transaction is not writable.
+func (c *Cursor) Put(value []byte) error {
+	if c.bucket.tx.db == nil {
+		return errors.ErrTxClosed
+	} else if !c.bucket.Writable() {
+		return errors.ErrTxNotWritable
+	}
+
+	if len(c.stack) == 0 {
+		return errors.ErrCursorNotPositioned
+	}
+	key, _, _ := c.keyValue()
+	if key == nil {
+		return errors.ErrCursorNotPositioned
+	}
+	return c.bucket.put(c, key, value, 0)
+}
+
+// Insert sets the value for a key like Bucket.Put, and moves the cursor to it.
+// The key is searched from the lowest page of the cursor's path which holds
+// it, rather than from the root of the bucket, so writing keys in order with
+// Insert is cheaper than with Bucket.Put.
+// Insert fails for the same reasons as Bucket.Put.
+func (c *Cursor) Insert(key []byte, value []byte) error {
+	return c.bucket.put(c, key, value, 0)
+}
+
 // seek moves the cursor to a given key and returns it.
 // If the key does not exist then the next key is used.
 func (c *Cursor) seek(seek []byte) (key []byte, value []byte, flags uint32) {
@@ -165,6 +275,74 @@ func (c *Cursor) seek(seek []byte) (key []byte, value []byte, flags uint32) {
 	return c.keyValue()
 }
 
+// seekGE moves the cursor to the first key equal to or greater than a given
+// key, including the expired ones, and returns it.
+func (c *Cursor) seekGE(seek []byte) (key []byte, value []byte, flags uint32) {
+	k, v, flags := c.seek(seek)
+
+	// If we ended up after the last element of a page then move to the next one.
+	if ref := &c.stack[len(c.stack)-1]; ref.index >= ref.count() {
+		k, v, flags = c.next()
+	}
+	return k, v, flags
+}
+
+// lastElement moves the cursor to the last element of the bucket, including
+// the expired ones, and returns it.
+func (c *Cursor) lastElement() (key []byte, value []byte, flags uint32) {
+	c.stack = c.stack[:0]
+	p, n := c.bucket.pageNode(c.bucket.RootPage())
+	ref := elemRef{page: p, node: n}
+	ref.index = ref.count() - 1
+	c.stack = append(c.stack, ref)
+	c.last()
+
+	// If this is an empty page (calling Delete may result in empty pages)
+	// we call prev to find the last page that is not empty
+	for len(c.stack) > 0 && c.stack[len(c.stack)-1].count() == 0 {
+		c.prev()
+	}
+
+	if len(c.stack) == 0 {
+		return nil, nil, 0
+	}
+	return c.keyValue()
+}
+
+// seekNear moves the cursor to a given key like seek, but starts searching
+// from the lowest page of the stack whose subtree holds the key, rather than
+// from the root, so that searching keys near the current one is cheaper.
+func (c *Cursor) seekNear(key []byte) ([]byte, []byte, uint32) {
+	i := c.commonAncestor(key)
+	if i < 0 {
+		return c.seek(key)
+	}
+	ref := c.stack[i]
+	c.stack = c.stack[:i]
+	c.searchRef(key, elemRef{page: ref.page, node: ref.node})
+	return c.keyValue()
+}
+
+// commonAncestor returns the index in the stack of the lowest page whose
+// subtree holds a given key, as a child of an element holds the keys between
+// the key of the element and of the next one, or -1 if the cursor isn't on a
+// leaf.
+func (c *Cursor) commonAncestor(key []byte) int {
+	if len(c.stack) == 0 || !c.stack[len(c.stack)-1].isLeaf() {
+		return -1
+	}
+	for i := range c.stack[:len(c.stack)-1] {
+		ref := &c.stack[i]
+		if ref.index > 0 && c.bucket.compare(key, ref.branchKey(ref.index)) < 0 {
+			return i
+		}
+		if ref.index < ref.count()-1 && c.bucket.compare(key, ref.branchKey(ref.index+1)) >= 0 {
+			return i
+		}
+	}
+	return len(c.stack) - 1
+}
+
 // first moves the cursor to the first leaf element under the last page in the stack.
 func (c *Cursor) goToFirstElementOnTheStack() {
 	for {
@@ -276,7 +454,12 @@ func (c *Cursor) search(key []byte, pgId common.Pgid) {
 	if p != nil && !p.IsBranchPage() && !p.IsLeafPage() {
 		panic(fmt.Sprintf("invalid page type: %d: %x", p.Id(), p.Flags()))
 	}
-	e := elemRef{page: p, node: n}
+	c.searchRef(key, elemRef{page: p, node: n})
+}
+
+// searchRef pushes a page/node on the stack and searches it for a given key.
+func (c *Cursor) searchRef(key []byte, e elemRef) {
+	p, n := e.page, e.node
 	c.stack = append(c.stack, e)
 
 	// If we're on a leaf page/node then find the specific node.
@@ -297,7 +480,7 @@ func (c *Cursor) searchNode(key []byte, n *node) {
 	index := sort.Search(len(n.inodes), func(i int) bool {
 		// TODO(benbjohnson): Optimize this range search. It's a bit hacky right now.
 		// sort.Search() finds the lowest index where f() != -1 but we need the highest index.
-		ret := bytes.Compare(n.inodes[i].Key(), key)
+		ret := c.bucket.compare(n.inodes[i].Key(), key)
 		if ret == 0 {
 			exact = true
 		}
@@ -320,7 +503,7 @@ func (c *Cursor) searchPage(key []byte, p *common.Page) {
 	index := sort.Search(int(p.Count()), func(i int) bool {
 		// TODO(benbjohnson): Optimize this range search. It's a bit hacky right now.
 		// sort.Search() finds the lowest index where f() != -1 but we need the highest index.
-		ret := bytes.Compare(inodes[i].Key(), key)
+		ret := c.bucket.compare(inodes[i].Key(), key)
 		if ret == 0 {
 			exact = true
 		}
@@ -343,7 +526,7 @@ func (c *Cursor) nsearch(key []byte) {
 	// If we have a node then search its inodes.
 	if n != nil {
 		index := sort.Search(len(n.inodes), func(i int) bool {
-			return bytes.Compare(n.inodes[i].Key(), key) != -1
+			return c.bucket.compare(n.inodes[i].Key(), key) != -1
 		})
 		e.index = index
 		return
@@ -352,7 +535,7 @@ func (c *Cursor) nsearch(key []byte) {
 	// If we have a page then search its leaf elements.
 	inodes := p.LeafPageElements()
 	index := sort.Search(int(p.Count()), func(i int) bool {
-		return bytes.Compare(inodes[i].Key(), key) != -1
+		return c.bucket.compare(inodes[i].Key(), key) != -1
 	})
 	e.index = index
 }
@@ -399,6 +582,17 @@ func (c *Cursor) node() *node {
 	return n
 }
 
+// leafNode returns the leaf node that the cursor is positioned on, like node,
+// and points the stack to the nodes, so that the cursor follows the changes of
+// the node.
+func (c *Cursor) leafNode() *node {
+	n := c.node()
+	for i, p := len(c.stack)-1, n; i >= 0 && p != nil; i, p = i-1, p.parent {
+		c.stack[i].node = p
+	}
+	return n
+}
+
 // elemRef represents a reference to an element on a given page/node.
 type elemRef struct {
 	page  *common.Page
@@ -414,6 +608,32 @@ func (r *elemRef) isLeaf() bool {
 	return r.page.IsLeafPage()
 }
 
+// branchKey returns the key of an element of a branch page/node.
+func (r *elemRef) branchKey(index int) []byte {
+	if r.node != nil {
+		return r.node.inodes[index].Key()
+	}
+	return r.page.BranchPageElement(uint16(index)).Key()
+}
+
+// branchPgid returns the child page id of an element of a branch page/node.
+func (r *elemRef) branchPgid(index int) common.Pgid {
+	if r.node != nil {
+		return r.node.inodes[index].Pgid()
+	}
+	return r.page.BranchPageElement(uint16(index)).Pgid()
+}
+
+// leafKeyValue returns the key and value of an element of a leaf page/node.
+func (r *elemRef) leafKeyValue(index int) ([]byte, []byte) {
+	if r.node != nil {
+		inode := &r.node.inodes[index]
+		return inode.Key(), inode.Value()
+	}
+	elem := r.page.LeafPageElement(uint16(index))
+	return elem.Key(), elem.Value()
+}
+
 // count returns the number of inodes or page elements.
 func (r *elemRef) count() int {
 	if r.node != nil {
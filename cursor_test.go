@@ -10,6 +10,9 @@ import (
 	"sort"
 	"testing"
 	"testing/quick"
+	"time"
+
+	"github.com/stretchr/testify/require"
 
 	bolt "go.etcd.io/bbolt"
 	"go.etcd.io/bbolt/errors"
@@ -160,6 +163,140 @@ func TestCursor_Delete(t *testing.T) {
 	}
 }
 
+// Ensure that a cursor can replace the values of a scan in place, and keeps
+// iterating from the replaced keys.
+func TestCursor_Put(t *testing.T) {
+	for _, opts := range []bolt.BucketOptions{{}, {Counted: true}, {Codec: bolt.FlateCodec}} {
+		t.Run(fmt.Sprintf("counted %v, codec %v", opts.Counted, opts.Codec != nil), func(t *testing.T) {
+			db := btesting.MustCreateDB(t)
+			const count = 5000
+			require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+				b, err := tx.CreateBucketWithOptions([]byte("widgets"), &opts)
+				require.NoError(t, err)
+				for i := 0; i < count; i++ {
+					require.NoError(t, b.Put([]byte(fmt.Sprintf("%05d", i)), []byte(fmt.Sprintf("%d", i))))
+				}
+				require.NoError(t, b.PutWithTTL([]byte("expiring"), []byte("value"), time.Hour))
+				_, err = b.CreateBucket([]byte("sub"))
+				return err
+			}))
+
+			require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+				c := tx.Bucket([]byte("widgets")).Cursor()
+				require.ErrorIs(t, c.Put([]byte("value")), errors.ErrCursorNotPositioned)
+
+				var n int
+				for k, v := c.First(); k != nil && k[0] != 'e'; k, v = c.Next() {
+					require.NoError(t, c.Put(append([]byte("new-"), v...)))
+					k2, v2 := c.Prev()
+					if n > 0 {
+						require.Equal(t, fmt.Sprintf("%05d", n-1), string(k2))
+						require.Equal(t, fmt.Sprintf("new-%d", n-1), string(v2))
+						c.Next()
+					} else {
+						require.Nil(t, k2)
+						c.Seek(k)
+					}
+					n++
+				}
+				require.Equal(t, count, n)
+
+				// The expiry time of a value replaced by Put is cleared, like
+				// with Bucket.Put.
+				k, _ := c.Seek([]byte("expiring"))
+				require.Equal(t, []byte("expiring"), k)
+				require.NoError(t, c.Put([]byte("kept")))
+
+				c.Seek([]byte("sub"))
+				require.ErrorIs(t, c.Put([]byte("value")), errors.ErrIncompatibleValue)
+
+				c.Seek([]byte("zzz"))
+				require.ErrorIs(t, c.Put([]byte("value")), errors.ErrCursorNotPositioned)
+				return nil
+			}))
+			db.MustCheck()
+			require.Zero(t, expiryEntries(t, db.DB))
+
+			require.NoError(t, db.View(func(tx *bolt.Tx) error {
+				b := tx.Bucket([]byte("widgets"))
+				for i := 0; i < count; i++ {
+					require.Equal(t, fmt.Sprintf("new-%d", i), string(b.Get([]byte(fmt.Sprintf("%05d", i)))))
+				}
+				require.Equal(t, []byte("kept"), b.Get([]byte("expiring")))
+				require.Equal(t, count+2, b.Stats().KeyN)
+
+				c := b.Cursor()
+				c.First()
+				require.ErrorIs(t, c.Put([]byte("value")), errors.ErrTxNotWritable)
+				return nil
+			}))
+		})
+	}
+}
+
+// Ensure that a cursor can insert keys in and out of the leaf it's on, and
+// moves to the inserted keys.
+func TestCursor_Insert(t *testing.T) {
+	db := btesting.MustCreateDB(t)
+	var keys []string
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketWithOptions([]byte("widgets"), &bolt.BucketOptions{Counted: true})
+		require.NoError(t, err)
+		for i := 0; i < 5000; i += 2 {
+			keys = append(keys, fmt.Sprintf("%05d", i))
+			require.NoError(t, b.Put([]byte(keys[len(keys)-1]), []byte("old")))
+		}
+		return nil
+	}))
+
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("widgets"))
+		c := b.Cursor()
+		require.ErrorIs(t, c.Insert(nil, []byte("value")), errors.ErrKeyRequired)
+
+		// Insert a key after every key, from an existing key or from the
+		// inserted one.
+		for k, _ := c.First(); k != nil; k, _ = c.Next() {
+			var i int
+			_, err := fmt.Sscanf(string(k), "%05d", &i)
+			require.NoError(t, err)
+			if i%2 == 0 {
+				next := fmt.Sprintf("%05d", i+1)
+				require.NoError(t, c.Insert([]byte(next), []byte("new")))
+				keys = append(keys, next)
+				k, v := c.Prev()
+				require.Equal(t, fmt.Sprintf("%05d", i), string(k))
+				require.Equal(t, []byte("old"), v)
+				c.Next()
+			}
+		}
+
+		// Keys out of the leaf are sought from the root, and existing keys
+		// are overwritten.
+		sort.Strings(keys)
+		for _, k := range []string{"a", "00000", "0", "02500"} {
+			require.NoError(t, c.Insert([]byte(k), []byte("value")))
+			if k != "00000" && k != "02500" {
+				keys = append(keys, k)
+				sort.Strings(keys)
+			}
+			next, _ := c.Next()
+			if i := sort.SearchStrings(keys, k); i < len(keys)-1 {
+				require.Equal(t, keys[i+1], string(next))
+			} else {
+				require.Nil(t, next)
+			}
+			require.Equal(t, []byte("value"), b.Get([]byte(k)))
+		}
+		forward, backward := cursorKeys(b)
+		require.Equal(t, keys, forward)
+		require.Len(t, backward, len(keys))
+		require.Equal(t, len(keys), b.KeyCount())
+		return nil
+	}))
+	db.MustCheck()
+}
+
 // Ensure that a Tx cursor can seek to the appropriate keys when there are a
 // large number of keys. This test also checks that seek will always move
 // forward to the next key.
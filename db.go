@@ -1,6 +1,7 @@
 package bbolt
 
 import (
+	"crypto/cipher"
 	"errors"
 	"fmt"
 	"io"
@@ -8,6 +9,7 @@ import (
 	"runtime"
 	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 	"unsafe"
 
@@ -32,6 +34,21 @@ const (
 	FreelistMapType = FreelistType("hashmap")
 )
 
+// ChecksumVerification is the policy for verifying page checksums when pages
+// are read. It only applies to databases created with Options.PageChecksums.
+type ChecksumVerification string
+
+const (
+	// ChecksumVerifyOff never verifies page checksums on read. Tx.Check
+	// still verifies them.
+	ChecksumVerifyOff = ChecksumVerification("off")
+	// ChecksumVerifySampled verifies the checksum of one in every
+	// common.ChecksumSampleRate page reads.
+	ChecksumVerifySampled = ChecksumVerification("sampled")
+	// ChecksumVerifyAlways verifies the checksum of every page read.
+	ChecksumVerifyAlways = ChecksumVerification("always")
+)
+
 // DB represents a collection of buckets persisted to a file on disk.
 // All data access is performed through transactions which can be obtained through the DB.
 // All the functions on DB will return a ErrDatabaseNotOpen if accessed before Open() is called.
@@ -72,6 +89,38 @@ type DB struct {
 	// The default type is array
 	FreelistType FreelistType
 
+	// FreelistPreferLowest makes the hashmap freelist allocate the lowest
+	// free pages, like the array freelist does, so that the free pages gather
+	// at the end of the file where DB.Shrink can reclaim them.
+	FreelistPreferLowest bool
+
+	// PrefixCompression makes leaf pages store the key prefix shared by their
+	// elements once, followed by the key suffixes. Pages are written in the
+	// format chosen when they are modified, and both formats are readable, so
+	// it can be changed on an existing database. The leaf pages of buckets
+	// with a comparator aren't prefix compressed.
+	//
+	// The first commit with PrefixCompression set upgrades the file to a
+	// format which the versions of bbolt without prefix compression refuse
+	// to open, even if it's unset later.
+	//
+	// Do not change concurrently with write transactions.
+	PrefixCompression bool
+
+	// HideExpired makes Bucket.Get and the cursors skip the keys set by
+	// Bucket.PutWithTTL whose expiry time has passed, but which weren't
+	// reaped yet.
+	HideExpired bool
+
+	// ChecksumVerification sets when page checksums are verified on read.
+	// A page that fails verification is read as an empty page, and the
+	// transaction fails with an error wrapping errors.ErrPageChecksum, which
+	// is returned by Tx.Err, Tx.Commit and Tx.Rollback, and by DB.View and
+	// DB.Update. The default is ChecksumVerifyOff.
+	//
+	// Do not change concurrently with transactions.
+	ChecksumVerification ChecksumVerification
+
 	// When true, skips the truncate call when growing the database.
 	// Setting this to true is only safe on non-ext3/ext4 systems.
 	// Skipping truncation avoids preallocation of hard drive space and
@@ -137,15 +186,51 @@ type DB struct {
 	freelist     *freelist
 	freelistLoad sync.Once
 
+	// aead encrypts the pages if the file is encrypted, and pageCache holds
+	// the decrypted pages.
+	aead      cipher.AEAD
+	pageCache *pageCache
+
+	// pageChecksums is set if the file was created with page checksums,
+	// and pageReads counts page reads to sample checksum verification.
+	pageChecksums bool
+	pageReads     atomic.Uint64
+
+	// wal is the write-ahead log, if Options.WALMode is set or a log
+	// was left to replay.
+	wal *wal
+
 	pagePool sync.Pool
 
 	batchMu sync.Mutex
 	batch   *batch
 
+	// watchers are the subscriptions of DB.Watch, and watchBufferSize the
+	// number of events they buffer.
+	watchMu         sync.Mutex
+	watchers        []*watcher
+	watchBufferSize int
+
+	// indexes are the indexes registered by DB.RegisterIndex.
+	indexMu sync.RWMutex
+	indexes []*indexDef
+
+	// reaper deletes the expired keys, if Options.ReapInterval is set.
+	reaper *reaper
+
 	rwlock   sync.Mutex   // Allows only one writer at a time.
 	metalock sync.Mutex   // Protects meta page access.
 	mmaplock sync.RWMutex // Protects mmap access during remapping.
 	statlock sync.RWMutex // Protects stats access.
+	txidlock sync.Mutex   // Protects pageTxids access.
+
+	// trackedTxid is the txid the writes of pages are tracked from, and
+	// pageTxids records the txid that last wrote each group of
+	// pageTxidGroupSize pages since then. They are used to find the pages
+	// written by incremental backups, and saved next to the database file
+	// when it's closed.
+	trackedTxid common.Txid
+	pageTxids   []common.Txid
 
 	ops struct {
 		writeAt func(b []byte, off int64) (n int, err error)
@@ -190,12 +275,19 @@ func Open(path string, mode os.FileMode, options *Options) (db *DB, err error) {
 	db.NoFreelistSync = options.NoFreelistSync
 	db.PreLoadFreelist = options.PreLoadFreelist
 	db.FreelistType = options.FreelistType
+	db.FreelistPreferLowest = options.FreelistPreferLowest
+	db.PrefixCompression = options.PrefixCompression
+	db.HideExpired = options.HideExpired
+	db.ChecksumVerification = options.ChecksumVerification
 	db.Mlock = options.Mlock
 
 	// Set default values for later DB operations.
 	db.MaxBatchSize = common.DefaultMaxBatchSize
 	db.MaxBatchDelay = common.DefaultMaxBatchDelay
 	db.AllocSize = common.DefaultAllocSize
+	if db.watchBufferSize = options.WatchBufferSize; db.watchBufferSize <= 0 {
+		db.watchBufferSize = common.DefaultWatchBufferSize
+	}
 
 	if options.Logger == nil {
 		db.logger = getDiscardLogger()
@@ -257,6 +349,19 @@ func Open(path string, mode os.FileMode, options *Options) (db *DB, err error) {
 		db.pageSize = common.DefaultPageSize
 	}
 
+	if options.Encryption != nil {
+		if db.aead, err = options.Encryption.newAEAD(); err != nil {
+			_ = db.close()
+			lg.Errorf("failed to set up encryption of db file (%s): %v", path, err)
+			return nil, err
+		}
+		cacheSize := options.EncryptionCacheSize
+		if cacheSize <= 0 {
+			cacheSize = common.DefaultEncryptionCacheSize
+		}
+		db.pageCache = newPageCache(cacheSize)
+	}
+
 	// Initialize the database if it doesn't exist.
 	if info, statErr := db.file.Stat(); statErr != nil {
 		_ = db.close()
@@ -264,7 +369,7 @@ func Open(path string, mode os.FileMode, options *Options) (db *DB, err error) {
 		return nil, statErr
 	} else if info.Size() == 0 {
 		// Initialize new files with meta pages.
-		if err = db.init(); err != nil {
+		if err = db.init(options.PageChecksums); err != nil {
 			// clean up file descriptor on initialization fail
 			_ = db.close()
 			lg.Errorf("failed to initialize db file (%s): %v", path, err)
@@ -293,6 +398,33 @@ func Open(path string, mode os.FileMode, options *Options) (db *DB, err error) {
 		return nil, err
 	}
 
+	db.pageChecksums = db.meta().HasFeature(common.FeaturePageChecksums)
+	if encrypted := db.meta().HasFeature(common.FeatureEncryption); encrypted != (db.aead != nil) {
+		_ = db.close()
+		if encrypted {
+			return nil, berrors.ErrEncryptionRequired
+		}
+		return nil, berrors.ErrNotEncrypted
+	}
+
+	// Recover the commits logged since the last checkpoint.
+	if err = db.openWAL(mode, options); err != nil {
+		_ = db.close()
+		lg.Errorf("failed to open write-ahead log of db file (%s): %v", path, err)
+		return nil, err
+	}
+
+	// Verify the encryption key by decrypting the root page.
+	if db.aead != nil {
+		if _, err = db.decryptedPage(db.meta().RootBucket().RootPage()); err != nil {
+			_ = db.close()
+			lg.Errorf("failed to decrypt db file (%s): %v", path, err)
+			return nil, err
+		}
+	}
+
+	db.loadPageTxids()
+
 	if db.PreLoadFreelist {
 		db.loadFreelist()
 	}
@@ -315,6 +447,11 @@ func Open(path string, mode os.FileMode, options *Options) (db *DB, err error) {
 		}
 	}
 
+	// Start reaping the expired keys.
+	if options.ReapInterval > 0 {
+		db.reaper = newReaper(db, options.ReapInterval)
+	}
+
 	// Mark the database as opened and return.
 	return db, nil
 }
@@ -477,6 +614,9 @@ func (db *DB) mmap(minsz int) (err error) {
 	// Dereference all mmap references before unmapping.
 	if db.rwtx != nil {
 		db.rwtx.root.dereference()
+		for _, sp := range db.rwtx.savepoints {
+			sp.dereference()
+		}
 	}
 
 	// Unmap existing data before continuing.
@@ -617,7 +757,18 @@ func (db *DB) mrelock(fileSizeFrom, fileSizeTo int) error {
 }
 
 // init creates a new database file and initializes its meta pages.
-func (db *DB) init() error {
+// If the database is encrypted, the file is created in the EncryptedVersion
+// format. Otherwise, if pageChecksums is set, it's created in the
+// PageChecksumVersion format.
+func (db *DB) init(pageChecksums bool) error {
+	version := common.Version
+	if db.aead != nil {
+		version = common.EncryptedVersion
+		pageChecksums = false
+	} else if pageChecksums {
+		version = common.PageChecksumVersion
+	}
+
 	// Create two meta pages on a buffer.
 	buf := make([]byte, db.pageSize*4)
 	for i := 0; i < 2; i++ {
@@ -628,7 +779,7 @@ func (db *DB) init() error {
 		// Initialize the meta page.
 		m := p.Meta()
 		m.SetMagic(common.Magic)
-		m.SetVersion(common.Version)
+		m.SetVersion(version)
 		m.SetPageSize(uint32(db.pageSize))
 		m.SetFreelist(2)
 		m.SetRootBucket(common.NewInBucket(3, 0))
@@ -649,6 +800,20 @@ func (db *DB) init() error {
 	p.SetFlags(common.LeafPageFlag)
 	p.SetCount(0)
 
+	if pageChecksums {
+		db.pageInBuffer(buf, common.Pgid(2)).SetChecksum(db.pageSize)
+		db.pageInBuffer(buf, common.Pgid(3)).SetChecksum(db.pageSize)
+	}
+	if db.aead != nil {
+		sealed, err := db.sealPages(common.Pages{db.pageInBuffer(buf, 2), db.pageInBuffer(buf, 3)})
+		if err != nil {
+			return err
+		}
+		for _, p := range sealed {
+			copy(buf[int(p.Id())*db.pageSize:], common.UnsafeByteSlice(unsafe.Pointer(p), 0, 0, db.pageSize))
+		}
+	}
+
 	// Write the buffer to our data file.
 	if _, err := db.ops.writeAt(buf, 0); err != nil {
 		db.Logger().Errorf("writeAt failed: %w", err)
@@ -666,6 +831,14 @@ func (db *DB) init() error {
 // It will block waiting for any open transactions to finish
 // before closing the database and returning.
 func (db *DB) Close() error {
+	// Stop the checkpointer before it can wait on the writer lock.
+	if db.wal != nil {
+		db.wal.stop()
+	}
+	if db.reaper != nil {
+		db.reaper.stop()
+	}
+
 	db.rwlock.Lock()
 	defer db.rwlock.Unlock()
 
@@ -683,14 +856,31 @@ func (db *DB) close() error {
 		return nil
 	}
 
+	var errs []error
+	// Checkpoint and close the write-ahead log.
+	if db.wal != nil {
+		if err := db.closeWAL(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	// Save the page txids for the incremental backups of the next opening.
+	if !db.readOnly && db.trackedTxid != 0 {
+		if err := db.savePageTxids(); err != nil {
+			db.Logger().Warningf("failed to save page txids of db file (%s): %v", db.path, err)
+		}
+	}
+
 	db.opened = false
 
+	// Close the channels of the watchers.
+	db.closeWatchers()
+
 	db.freelist = nil
 
 	// Clear ops.
 	db.ops.writeAt = nil
 
-	var errs []error
 	// Close the mmap.
 	if err := db.munmap(); err != nil {
 		errs = append(errs, err)
@@ -836,6 +1026,7 @@ func (db *DB) beginRWTx() (*Tx, error) {
 	// Create a transaction associated with the database.
 	t := &Tx{writable: true}
 	t.init(db)
+	t.watching = db.hasWatchers()
 	db.rwtx = t
 	db.freePages()
 	return t, nil
@@ -921,7 +1112,7 @@ func (db *DB) Update(fn func(*Tx) error) error {
 	t.managed = true
 
 	// If an error is returned from the function then rollback and return error.
-	err = fn(t)
+	err = callTx(fn, t)
 	t.managed = false
 	if err != nil {
 		_ = t.Rollback()
@@ -933,6 +1124,9 @@ func (db *DB) Update(fn func(*Tx) error) error {
 
 // View executes a function within the context of a managed read-only transaction.
 // Any error that is returned from the function is returned from the View() method.
+// If a page read by the transaction failed checksum verification or decryption,
+// the error of Tx.Err is returned instead, as the function may have seen keys
+// as missing.
 //
 // Attempting to manually rollback within the function will cause a panic.
 func (db *DB) View(fn func(*Tx) error) error {
@@ -952,7 +1146,7 @@ func (db *DB) View(fn func(*Tx) error) error {
 	t.managed = true
 
 	// If an error is returned from the function then pass it through.
-	err = fn(t)
+	err = callTx(fn, t)
 	t.managed = false
 	if err != nil {
 		_ = t.Rollback()
@@ -1081,6 +1275,17 @@ func (p panicked) Error() string {
 	return fmt.Sprintf("panic: %v", p.reason)
 }
 
+// callTx calls fn, and returns the page checksum or decryption failure
+// detected while reading the pages of tx, if any, instead of the error of fn,
+// which is likely caused by the missing data.
+func callTx(fn func(*Tx) error, tx *Tx) error {
+	err := fn(tx)
+	if perr := tx.Err(); perr != nil {
+		return perr
+	}
+	return err
+}
+
 func safelyCall(fn func(*Tx) error, tx *Tx) (err error) {
 	defer func() {
 		if p := recover(); p != nil {
@@ -1123,7 +1328,35 @@ func (db *DB) Info() *Info {
 }
 
 // page retrieves a page reference from the mmap based on the current page size.
+// Pages of an encrypted database are decrypted; a page which fails decryption
+// panics with an error wrapping ErrPageDecrypt.
 func (db *DB) page(id common.Pgid) *common.Page {
+	p, err := db.readPage(id)
+	if err != nil {
+		panic(err)
+	}
+	return p
+}
+
+// readPage retrieves a page reference like page, but returns an error
+// wrapping ErrPageDecrypt if the page fails decryption.
+func (db *DB) readPage(id common.Pgid) (*common.Page, error) {
+	if db.aead != nil && id > 1 {
+		return db.decryptedPage(id)
+	}
+	return db.rawPage(id), nil
+}
+
+// rawPage retrieves a page reference as it is stored, which is encrypted if
+// the database is encrypted.
+func (db *DB) rawPage(id common.Pgid) *common.Page {
+	// Pages logged since the last checkpoint are only in the write-ahead log.
+	if db.wal != nil {
+		if p := db.wal.page(id); p != nil {
+			return p
+		}
+	}
+
 	pos := id * common.Pgid(db.pageSize)
 	return (*common.Page)(unsafe.Pointer(&db.data[pos]))
 }
@@ -1133,8 +1366,89 @@ func (db *DB) pageInBuffer(b []byte, id common.Pgid) *common.Page {
 	return (*common.Page)(unsafe.Pointer(&b[id*common.Pgid(db.pageSize)]))
 }
 
+// recordPageWrites remembers that the given pages were written by txid.
+func (db *DB) recordPageWrites(pages common.Pages, txid common.Txid) {
+	db.txidlock.Lock()
+	defer db.txidlock.Unlock()
+
+	for _, p := range pages {
+		g := int(p.Id() / pageTxidGroupSize)
+		if g >= len(db.pageTxids) {
+			db.pageTxids = append(db.pageTxids, make([]common.Txid, g+1-len(db.pageTxids))...)
+		}
+		db.pageTxids[g] = txid
+	}
+}
+
+// pageWrittenAfter returns true if the page, or another page of its group,
+// was written by a transaction later than txid, which must not be older than
+// trackedTxid.
+func (db *DB) pageWrittenAfter(id common.Pgid, txid common.Txid) bool {
+	db.txidlock.Lock()
+	defer db.txidlock.Unlock()
+
+	g := int(id / pageTxidGroupSize)
+	return g < len(db.pageTxids) && db.pageTxids[g] > txid
+}
+
+// pageTrailerSize returns the number of bytes reserved at the end of every page.
+func (db *DB) pageTrailerSize() int {
+	if db.aead != nil {
+		return db.aead.NonceSize() + db.aead.Overhead()
+	}
+	if db.pageChecksums {
+		return common.PageChecksumSize
+	}
+	return 0
+}
+
+// setPageChecksums stores the checksum of each page before it is written.
+func (db *DB) setPageChecksums(pages common.Pages) {
+	if !db.pageChecksums {
+		return
+	}
+	for _, p := range pages {
+		p.SetChecksum(db.pageSize)
+	}
+}
+
+// verifyPageChecksum returns an error wrapping ErrPageChecksum if the
+// checksum of a page read from the file doesn't match, according to the
+// ChecksumVerification policy. hwm is the high water mark of the reader.
+func (db *DB) verifyPageChecksum(p *common.Page, hwm common.Pgid) error {
+	// Meta pages are protected by their own checksum.
+	if !db.pageChecksums || p.IsMetaPage() {
+		return nil
+	}
+	switch db.ChecksumVerification {
+	case ChecksumVerifyAlways:
+	case ChecksumVerifySampled:
+		if db.pageReads.Add(1)%common.ChecksumSampleRate != 0 {
+			return nil
+		}
+	default:
+		return nil
+	}
+	return db.checkPageChecksum(p, hwm)
+}
+
+// checkPageChecksum returns an error wrapping ErrPageChecksum if the checksum
+// of the page doesn't match its content.
+func (db *DB) checkPageChecksum(p *common.Page, hwm common.Pgid) error {
+	// A corrupted overflow could make the page run past the mapped data.
+	if p.Id()+common.Pgid(p.Overflow()) >= hwm {
+		return fmt.Errorf("page %d: %w (overflow %d exceeds high water mark %d)", p.Id(), berrors.ErrPageChecksum, p.Overflow(), hwm)
+	}
+	return p.VerifyChecksum(db.pageSize)
+}
+
 // meta retrieves the current meta page reference.
 func (db *DB) meta() *common.Meta {
+	// The write-ahead log holds the latest committed meta.
+	if db.wal != nil && db.wal.meta != nil {
+		return db.wal.meta
+	}
+
 	// We have to return the meta with the highest txid which doesn't fail
 	// validation. Otherwise, we can cause errors when in fact the database is
 	// in a consistent state. metaA is the one with the higher txid.
@@ -1170,7 +1484,11 @@ func (db *DB) allocate(txid common.Txid, count int) (*common.Page, error) {
 	p.SetOverflow(uint32(count - 1))
 
 	// Use pages from the freelist if they are available.
-	p.SetId(db.freelist.allocate(txid, count))
+	if db.FreelistPreferLowest || (db.rwtx != nil && db.rwtx.allocLowest) {
+		p.SetId(db.freelist.allocateLowest(txid, count))
+	} else {
+		p.SetId(db.freelist.allocate(txid, count))
+	}
 	if p.Id() != 0 {
 		return p, nil
 	}
@@ -1191,6 +1509,43 @@ func (db *DB) allocate(txid common.Txid, count int) (*common.Page, error) {
 	return p, nil
 }
 
+// shrinkFile truncates the database file at the high water mark pgid. In WAL
+// mode the log is checkpointed first, so that the file holds the pages below
+// pgid.
+func (db *DB) shrinkFile(pgid common.Pgid) error {
+	if db.wal != nil {
+		if err := db.wal.checkpoint(); err != nil {
+			return err
+		}
+	}
+
+	// A mapped file can't be truncated on Windows.
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+
+	fileSize, err := db.fileSize()
+	if err != nil {
+		return err
+	}
+	sz := int(pgid) * db.pageSize
+	if sz >= fileSize {
+		return nil
+	}
+	if err := db.file.Truncate(int64(sz)); err != nil {
+		return fmt.Errorf("file resize error: %s", err)
+	}
+	if err := db.file.Sync(); err != nil {
+		return fmt.Errorf("file sync error: %s", err)
+	}
+	if db.Mlock {
+		if err := db.mrelock(fileSize, sz); err != nil {
+			return fmt.Errorf("mlock/munlock error: %s", err)
+		}
+	}
+	return nil
+}
+
 // grow grows the size of the database to the given sz.
 func (db *DB) grow(sz int) error {
 	// Ignore if the new size is less than available file size.
@@ -1301,6 +1656,15 @@ type Options struct {
 	// The default type is array
 	FreelistType FreelistType
 
+	// FreelistPreferLowest makes the freelist allocate the lowest free pages.
+	FreelistPreferLowest bool
+
+	// PrefixCompression sets the DB.PrefixCompression flag.
+	PrefixCompression bool
+
+	// HideExpired sets the DB.HideExpired flag.
+	HideExpired bool
+
 	// Open database in read-only mode. Uses flock(..., LOCK_SH |LOCK_NB) to
 	// grab a shared lock (UNIX).
 	ReadOnly bool
@@ -1337,6 +1701,49 @@ type Options struct {
 
 	// Logger is the logger used for bbolt.
 	Logger Logger
+
+	// PageChecksums creates new database files in a format where every page
+	// ends with a checksum of its content, which is verified according to
+	// ChecksumVerification. It has no effect on existing files; use Compact
+	// to copy a database into a new file with page checksums.
+	PageChecksums bool
+
+	// ChecksumVerification sets the DB.ChecksumVerification field.
+	ChecksumVerification ChecksumVerification
+
+	// Encryption encrypts the pages of new database files, and is required
+	// to open encrypted files. It has no effect on existing files which aren't
+	// encrypted; use Compact to copy a database into an encrypted file.
+	Encryption *Encryption
+
+	// EncryptionCacheSize is the number of decrypted pages which are cached
+	// when the database is encrypted. Default value is copied from
+	// DefaultEncryptionCacheSize in Open.
+	EncryptionCacheSize int
+
+	// WALMode makes commits append their dirty pages and meta page to a
+	// write-ahead log next to the database file, with a single sync, instead
+	// of writing them in place. The logged pages are copied into the database
+	// file by a background checkpoint, and on Close.
+	//
+	// A log left behind by a crash is always replayed on Open, even if
+	// WALMode isn't set.
+	WALMode bool
+
+	// WALCheckpointSize is the size in bytes the write-ahead log may reach
+	// before a checkpoint is started. Default value is copied from
+	// DefaultWALCheckpointSize in Open.
+	WALCheckpointSize int
+
+	// WatchBufferSize is the number of change events buffered for each
+	// watcher of DB.Watch, before it overflows. Default value is copied from
+	// DefaultWatchBufferSize in Open.
+	WatchBufferSize int
+
+	// ReapInterval is the interval at which a background goroutine deletes
+	// the expired keys, with DB.ReapExpired. The keys aren't reaped in the
+	// background if it's 0, or if the database is read-only.
+	ReapInterval time.Duration
 }
 
 func (o *Options) String() string {
@@ -1344,8 +1751,8 @@ func (o *Options) String() string {
 		return "{}"
 	}
 
-	return fmt.Sprintf("{Timeout: %s, NoGrowSync: %t, NoFreelistSync: %t, PreLoadFreelist: %t, FreelistType: %s, ReadOnly: %t, MmapFlags: %x, InitialMmapSize: %d, PageSize: %d, NoSync: %t, OpenFile: %p, Mlock: %t, Logger: %p}",
-		o.Timeout, o.NoGrowSync, o.NoFreelistSync, o.PreLoadFreelist, o.FreelistType, o.ReadOnly, o.MmapFlags, o.InitialMmapSize, o.PageSize, o.NoSync, o.OpenFile, o.Mlock, o.Logger)
+	return fmt.Sprintf("{Timeout: %s, NoGrowSync: %t, NoFreelistSync: %t, PreLoadFreelist: %t, FreelistType: %s, FreelistPreferLowest: %t, PrefixCompression: %t, HideExpired: %t, ReadOnly: %t, MmapFlags: %x, InitialMmapSize: %d, PageSize: %d, NoSync: %t, OpenFile: %p, Mlock: %t, Logger: %p, PageChecksums: %t, ChecksumVerification: %s, Encryption: %t, EncryptionCacheSize: %d, WALMode: %t, WALCheckpointSize: %d, WatchBufferSize: %d, ReapInterval: %s}",
+		o.Timeout, o.NoGrowSync, o.NoFreelistSync, o.PreLoadFreelist, o.FreelistType, o.FreelistPreferLowest, o.PrefixCompression, o.HideExpired, o.ReadOnly, o.MmapFlags, o.InitialMmapSize, o.PageSize, o.NoSync, o.OpenFile, o.Mlock, o.Logger, o.PageChecksums, o.ChecksumVerification, o.Encryption != nil, o.EncryptionCacheSize, o.WALMode, o.WALCheckpointSize, o.WatchBufferSize, o.ReapInterval)
 
 }
 
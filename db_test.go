@@ -164,11 +164,12 @@ func TestOpen_ErrVersionMismatch(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	// Rewrite meta pages.
+	// Rewrite meta pages. Versions 3 to 5 are the page checksum, the
+	// encrypted and the feature formats, so skip them.
 	meta0 := (*meta)(unsafe.Pointer(&buf[pageHeaderSize]))
-	meta0.version++
+	meta0.version += 4
 	meta1 := (*meta)(unsafe.Pointer(&buf[pageSize+pageHeaderSize]))
-	meta1.version++
+	meta1.version += 4
 	if err := os.WriteFile(path, buf, 0666); err != nil {
 		t.Fatal(err)
 	}
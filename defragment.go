@@ -0,0 +1,219 @@
+package bbolt
+
+import (
+	"bytes"
+
+	"go.etcd.io/bbolt/internal/common"
+)
+
+// DefaultDefragmentTxPages is the default number of leaf pages rewritten by
+// each transaction of DB.Defragment.
+const DefaultDefragmentTxPages = 1024
+
+// DefragmentOptions configures DB.Defragment.
+type DefragmentOptions struct {
+	// MaxTxPages is the maximum number of leaf pages rewritten by each write
+	// transaction, which bounds how long writers are blocked. The default is
+	// DefaultDefragmentTxPages.
+	MaxTxPages int
+
+	// FillPercent is how full the rewritten leaf pages are. The default is
+	// 1.0, as Compact does.
+	FillPercent float64
+
+	// Progress is called after each transaction commits. If it returns an
+	// error, Defragment stops and returns the error.
+	Progress func(DefragmentProgress) error
+
+	// Resume continues from the position of a progress report of an earlier
+	// call, instead of from the first bucket.
+	Resume *DefragmentProgress
+}
+
+// DefragmentProgress reports the position reached by DB.Defragment.
+type DefragmentProgress struct {
+	// Bucket is the path of the bucket the next transaction starts in,
+	// and Key the key it starts from. An empty path is the root bucket,
+	// which holds the top level buckets.
+	Bucket [][]byte
+	Key    []byte
+
+	// Done is set when every bucket was defragmented.
+	Done bool
+
+	// TxN is the number of transactions committed, and LeafN the number of
+	// leaf pages rewritten so far.
+	TxN   int
+	LeafN int
+}
+
+// Defragment rewrites the leaf pages of every bucket in place, in key order.
+// Adjacent leaves are merged and split again at opts.FillPercent, and the
+// new pages are allocated from the lowest free pages, so that the leaves of
+// a bucket are laid out contiguously. Nested buckets are defragmented after
+// their parent.
+//
+// Defragment runs in a series of write transactions of bounded size, so
+// other writers are only blocked for short periods. It can be stopped
+// through opts.Progress, and resumed later with opts.Resume.
+func (db *DB) Defragment(opts *DefragmentOptions) error {
+	var o DefragmentOptions
+	if opts != nil {
+		o = *opts
+	}
+	if o.MaxTxPages <= 0 {
+		o.MaxTxPages = DefaultDefragmentTxPages
+	}
+	if o.FillPercent <= 0 {
+		o.FillPercent = 1.0
+	}
+
+	var pos DefragmentProgress
+	if o.Resume != nil {
+		for _, name := range o.Resume.Bucket {
+			pos.Bucket = append(pos.Bucket, cloneBytes(name))
+		}
+		if o.Resume.Key != nil {
+			pos.Key = cloneBytes(o.Resume.Key)
+		}
+		pos.Done = o.Resume.Done
+	}
+
+	for !pos.Done {
+		if err := db.Update(func(tx *Tx) error {
+			tx.allocLowest = true
+			for budget := o.MaxTxPages; budget > 0; {
+				var next []byte
+				if b := tx.bucketAt(pos.Bucket); b != nil {
+					var n int
+					next, n = b.defragment(pos.Key, budget, o.FillPercent)
+					pos.LeafN += n
+					budget -= n
+				}
+				if next != nil {
+					pos.Key = next
+					continue
+				}
+
+				pos.Bucket, pos.Done = tx.nextDefragmentBucket(pos.Bucket)
+				pos.Key = nil
+				if pos.Done {
+					break
+				}
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+
+		pos.TxN++
+		if o.Progress != nil {
+			if err := o.Progress(pos); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// defragment loads up to max leaves of b in key order, starting with the leaf
+// holding start, and merges the adjacent ones so that they are split again
+// when the transaction commits. It returns the key the next leaf starts with,
+// or nil if the last leaf was reached, and the number of leaves loaded.
+func (b *Bucket) defragment(start []byte, max int, fillPercent float64) ([]byte, int) {
+	if b.RootPage() == 0 {
+		// Inline buckets are stored in their parent's page.
+		return nil, 0
+	}
+
+	c := b.Cursor()
+	k, _, _ := c.seek(start)
+	if ref := &c.stack[len(c.stack)-1]; ref.index >= ref.count() {
+		k, _, _ = c.next()
+	}
+
+	var leaves []*node
+	for k != nil && len(leaves) < max {
+		leaves = append(leaves, c.node())
+
+		// Move to the first element of the next leaf.
+		ref := &c.stack[len(c.stack)-1]
+		ref.index = ref.count() - 1
+		k, _, _ = c.next()
+	}
+
+	// Merge the leaves into their left sibling, as rebalance does.
+	b.FillPercent = fillPercent
+	var left *node
+	for _, n := range leaves {
+		if left == nil || n.parent == nil || n.parent != left.parent {
+			left = n
+			continue
+		}
+		left.inodes = append(left.inodes, n.inodes...)
+		n.parent.del(n.key)
+		n.parent.removeChild(n)
+		n.parent.unbalanced = true
+		delete(b.nodes, n.pgid)
+		n.free()
+	}
+
+	if k == nil {
+		return nil, len(leaves)
+	}
+	return cloneBytes(k), len(leaves)
+}
+
+// nextDefragmentBucket returns the path of the bucket following path in
+// depth-first order, or true if path is the last bucket.
+func (tx *Tx) nextDefragmentBucket(path [][]byte) ([][]byte, bool) {
+	// Descend into the first nested bucket.
+	if b := tx.bucketAt(path); b != nil {
+		if name := b.nextBucketName(nil); name != nil {
+			return append(append([][]byte{}, path...), name), false
+		}
+	}
+
+	// Otherwise move on to the next sibling of the bucket or of its parents.
+	for len(path) > 0 {
+		parent := path[:len(path)-1]
+		if b := tx.bucketAt(parent); b != nil {
+			if name := b.nextBucketName(path[len(path)-1]); name != nil {
+				return append(append([][]byte{}, parent...), name), false
+			}
+		}
+		path = parent
+	}
+	return nil, true
+}
+
+// bucketAt returns the bucket at the given path, the root bucket if the path
+// is empty, or nil if it doesn't exist.
+func (tx *Tx) bucketAt(path [][]byte) *Bucket {
+	b := &tx.root
+	for _, name := range path {
+		if b = b.Bucket(name); b == nil {
+			return nil
+		}
+	}
+	return b
+}
+
+// nextBucketName returns the name of the first nested bucket of b after the
+// given name, or the first one if after is nil.
+func (b *Bucket) nextBucketName(after []byte) []byte {
+	c := b.Cursor()
+	k, _, flags := c.seek(after)
+	if ref := &c.stack[len(c.stack)-1]; ref.index >= ref.count() {
+		k, _, flags = c.next()
+	}
+	if after != nil && bytes.Equal(k, after) {
+		k, _, flags = c.next()
+	}
+	for ; k != nil; k, _, flags = c.next() {
+		if flags&common.BucketLeafFlag != 0 {
+			return cloneBytes(k)
+		}
+	}
+	return nil
+}
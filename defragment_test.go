@@ -0,0 +1,132 @@
+package bbolt_test
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	bolt "go.etcd.io/bbolt"
+	"go.etcd.io/bbolt/internal/btesting"
+)
+
+// fillFragmentedDB fills the buckets "widgets" and "widgets/nested" in random
+// order, and deletes most of their keys, so that the leaves are under-filled.
+func fillFragmentedDB(t *testing.T, db *btesting.DB) {
+	r := rand.New(rand.NewSource(42))
+	for _, path := range [][]string{{"widgets"}, {"widgets", "nested"}} {
+		keys := r.Perm(5000)
+		for i := 0; i < len(keys); i += 500 {
+			require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+				b, err := tx.CreateBucketIfNotExists([]byte(path[0]))
+				if err != nil {
+					return err
+				}
+				if len(path) > 1 {
+					if b, err = b.CreateBucketIfNotExists([]byte(path[1])); err != nil {
+						return err
+					}
+				}
+				for _, k := range keys[i : i+500] {
+					if err := b.Put([]byte(fmt.Sprintf("%08d", k)), []byte(fmt.Sprintf("value-%d", k))); err != nil {
+						return err
+					}
+				}
+				return nil
+			}))
+		}
+	}
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		for _, b := range []*bolt.Bucket{tx.Bucket([]byte("widgets")), tx.Bucket([]byte("widgets")).Bucket([]byte("nested"))} {
+			for k := 0; k < 5000; k++ {
+				if k%4 != 0 {
+					if err := b.Delete([]byte(fmt.Sprintf("%08d", k))); err != nil {
+						return err
+					}
+				}
+			}
+		}
+		return nil
+	}))
+}
+
+func leafPageN(t *testing.T, db *btesting.DB) int {
+	var n int
+	require.NoError(t, db.View(func(tx *bolt.Tx) error {
+		n = tx.Bucket([]byte("widgets")).Stats().LeafPageN
+		return nil
+	}))
+	return n
+}
+
+// Ensure that Defragment merges under-filled leaves, and keeps the data.
+func TestDB_Defragment(t *testing.T) {
+	db := btesting.MustCreateDB(t)
+	fillFragmentedDB(t, db)
+	expected := dumpDB(t, db.DB)
+	before := leafPageN(t, db)
+
+	var reports []bolt.DefragmentProgress
+	require.NoError(t, db.Defragment(&bolt.DefragmentOptions{
+		MaxTxPages: 16,
+		Progress: func(p bolt.DefragmentProgress) error {
+			reports = append(reports, p)
+			return nil
+		},
+	}))
+	require.Greater(t, len(reports), 1)
+	last := reports[len(reports)-1]
+	require.True(t, last.Done)
+	require.Equal(t, len(reports), last.TxN)
+	require.GreaterOrEqual(t, last.LeafN, before)
+
+	require.Less(t, leafPageN(t, db), before*2/3)
+	require.Equal(t, expected, dumpDB(t, db.DB))
+	db.MustCheck()
+
+	db.MustClose()
+	db.MustReopen()
+	require.Equal(t, expected, dumpDB(t, db.DB))
+}
+
+// Ensure that Defragment can be stopped, and resumed from its last progress report.
+func TestDB_Defragment_Resume(t *testing.T) {
+	db := btesting.MustCreateDB(t)
+	fillFragmentedDB(t, db)
+	expected := dumpDB(t, db.DB)
+	before := leafPageN(t, db)
+
+	errStop := errors.New("stop")
+	var stopped bolt.DefragmentProgress
+	err := db.Defragment(&bolt.DefragmentOptions{
+		MaxTxPages: 4,
+		Progress: func(p bolt.DefragmentProgress) error {
+			stopped = p
+			return errStop
+		},
+	})
+	require.ErrorIs(t, err, errStop)
+	require.False(t, stopped.Done)
+	require.Equal(t, 1, stopped.TxN)
+	require.Equal(t, 4, stopped.LeafN)
+
+	// Writes in between are kept.
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte("widgets")).Put([]byte("new"), []byte("value"))
+	}))
+	expected["widgets/new"] = "value"
+
+	var done bolt.DefragmentProgress
+	require.NoError(t, db.Defragment(&bolt.DefragmentOptions{
+		Resume: &stopped,
+		Progress: func(p bolt.DefragmentProgress) error {
+			done = p
+			return nil
+		},
+	}))
+	require.True(t, done.Done)
+	require.Less(t, leafPageN(t, db), before*2/3)
+	require.Equal(t, expected, dumpDB(t, db.DB))
+}
@@ -0,0 +1,56 @@
+package bbolt
+
+import (
+	"fmt"
+	"math/rand"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"go.etcd.io/bbolt/internal/common"
+)
+
+// Ensure that Defragment lays out the leaves of a bucket in key order.
+func TestDB_Defragment_LeafOrder(t *testing.T) {
+	db, err := Open(filepath.Join(t.TempDir(), "db"), 0600, nil)
+	require.NoError(t, err)
+	defer db.Close()
+
+	r := rand.New(rand.NewSource(42))
+	for _, k := range r.Perm(2000) {
+		require.NoError(t, db.Update(func(tx *Tx) error {
+			b, err := tx.CreateBucketIfNotExists([]byte("widgets"))
+			if err != nil {
+				return err
+			}
+			return b.Put([]byte(fmt.Sprintf("%08d", k)), make([]byte, 100))
+		}))
+	}
+
+	leaves := func() []common.Pgid {
+		var ids []common.Pgid
+		require.NoError(t, db.View(func(tx *Tx) error {
+			tx.Bucket([]byte("widgets")).forEachPage(func(p *common.Page, _ int, _ []common.Pgid) {
+				if p.IsLeafPage() {
+					ids = append(ids, p.Id())
+				}
+			})
+			return nil
+		}))
+		return ids
+	}
+	require.False(t, isAscending(leaves()))
+
+	require.NoError(t, db.Defragment(nil))
+	require.True(t, isAscending(leaves()))
+}
+
+func isAscending(ids []common.Pgid) bool {
+	for i := 1; i < len(ids); i++ {
+		if ids[i] <= ids[i-1] {
+			return false
+		}
+	}
+	return true
+}
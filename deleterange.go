@@ -0,0 +1,243 @@
+package bbolt
+
+import (
+	"bytes"
+	"sort"
+
+	"go.etcd.io/bbolt/errors"
+	"go.etcd.io/bbolt/internal/common"
+)
+
+// DeleteRange removes the keys which are equal to or greater than start, and
+// less than end, from the bucket, and returns the number of keys removed.
+// A nil start deletes from the first key, and a nil end up to the last key.
+// The nested buckets in the range are deleted with their keys.
+//
+// Unlike deleting the keys one by one with Cursor.Delete, the subtrees fully
+// inside the range are freed from the branch pages without loading their
+// leaves as nodes, only the two leaves at the ends of the range are modified,
+// and the tree is rebalanced once. The keys of the freed subtrees are only
+// read to release their nested buckets, blobs and expiry times, and, if the
+// bucket has indexes or the database has watchers, to update the indexes and
+// publish their deletion.
+// Returns an error if the bucket was created from a read-only transaction, or
+// if the range holds a bucket maintained by bbolt at the top level.
+func (b *Bucket) DeleteRange(start, end []byte) (deleted int, err error) {
+	lg := b.tx.db.Logger()
+	lg.Debugf("Deleting keys from %q to %q", string(start), string(end))
+	defer func() {
+		if err != nil {
+			lg.Errorf("Deleting keys from %q to %q failed: %v", string(start), string(end), err)
+		} else {
+			lg.Debugf("Deleting %d keys from %q to %q successfully", deleted, string(start), string(end))
+		}
+	}()
+
+	if b.tx.db == nil {
+		return 0, errors.ErrTxClosed
+	} else if !b.Writable() {
+		return 0, errors.ErrTxNotWritable
+	} else if name := []byte(expiryBucket); b.reservedBucket(name) && b.tx.expiryBucket() != nil &&
+		(start == nil || bytes.Compare(start, name) <= 0) && (end == nil || bytes.Compare(name, end) < 0) {
+		return 0, errors.ErrBucketNameReserved
+	}
+
+	// Return early, without loading any node, if the range is empty.
+	c := b.Cursor()
+	k, _, _ := c.seek(start)
+	if ref := &c.stack[len(c.stack)-1]; ref.index >= ref.count() {
+		k, _, _ = c.next()
+	}
+	if k == nil || (end != nil && b.compare(k, end) >= 0) {
+		return 0, nil
+	}
+
+	var touched []*node
+	deleted, err = b.deleteRange(b.node(b.RootPage(), nil), start, end, &touched)
+	b.addKeyCount(-deleted)
+	if err != nil {
+		return deleted, err
+	}
+	b.rebalanceNodes(touched)
+	return deleted, nil
+}
+
+// deleteRange deletes the keys in the range from the subtree of a node, and
+// appends the modified nodes to touched, parents first.
+func (b *Bucket) deleteRange(n *node, start, end []byte, touched *[]*node) (int, error) {
+	*touched = append(*touched, n)
+
+	// Trim the leaf at the end of the range.
+	if n.isLeaf {
+		from, to := 0, len(n.inodes)
+		if start != nil {
+			from = sort.Search(len(n.inodes), func(i int) bool { return b.compare(n.inodes[i].Key(), start) != -1 })
+		}
+		if end != nil {
+			to = sort.Search(len(n.inodes), func(i int) bool { return b.compare(n.inodes[i].Key(), end) != -1 })
+		}
+		if from >= to {
+			return 0, nil
+		}
+		for i := from; i < to; i++ {
+			inode := &n.inodes[i]
+			if err := b.releaseElement(inode.Key(), inode.Value(), inode.Flags()); err != nil {
+				return 0, err
+			}
+		}
+		n.inodes = append(n.inodes[:from], n.inodes[to:]...)
+		n.unbalanced = true
+		return to - from, nil
+	}
+
+	// Find the children holding the first and the last key of the range. The
+	// first child also holds the keys less than its key, which were inserted
+	// since the node was last spilled.
+	last := len(n.inodes) - 1
+	first, final := 0, last
+	if start != nil {
+		first = max(sort.Search(len(n.inodes), func(i int) bool { return b.compare(n.inodes[i].Key(), start) == 1 })-1, 0)
+	}
+	if end != nil {
+		final = max(sort.Search(len(n.inodes), func(i int) bool { return b.compare(n.inodes[i].Key(), end) != -1 })-1, 0)
+	}
+	contained := func(i int) bool {
+		return (start == nil || (i > 0 && b.compare(n.inodes[i].Key(), start) != -1)) &&
+			(end == nil || (i < last && b.compare(n.inodes[i+1].Key(), end) != 1))
+	}
+
+	// Descend into the children partially inside the range, and free the
+	// ones between them.
+	var deleted int
+	from, to := first, final+1
+	if !contained(first) {
+		count, err := b.deleteRange(n.childAt(first), start, end, touched)
+		deleted += count
+		if err != nil {
+			return deleted, err
+		}
+		from++
+	}
+	if final > first && !contained(final) {
+		count, err := b.deleteRange(n.childAt(final), start, end, touched)
+		deleted += count
+		if err != nil {
+			return deleted, err
+		}
+		to--
+	}
+	for i := from; i < to; i++ {
+		count, err := b.deleteSubtree(n.inodes[i].Pgid())
+		deleted += count
+		if err != nil {
+			return deleted, err
+		}
+	}
+	if from < to {
+		n.inodes = append(n.inodes[:from], n.inodes[to:]...)
+		n.unbalanced = true
+	}
+
+	// A root without children becomes an empty leaf.
+	if n.parent == nil && len(n.inodes) == 0 {
+		n.isLeaf = true
+	}
+	return deleted, nil
+}
+
+// releasedLeafFlags are the flags of the keys which DeleteRange releases even
+// if the bucket has neither indexes nor watchers: their nested buckets, blobs
+// and expiry entries would be leaked otherwise.
+const releasedLeafFlags = common.BucketLeafFlag | common.BlobLeafFlag | common.ExpiringLeafFlag
+
+// releasesAllKeys returns whether every key deleted by DeleteRange must be
+// released, to update the indexes of the bucket or to publish its deletion to
+// watchers. Otherwise, only the keys with releasedLeafFlags are.
+func (b *Bucket) releasesAllKeys() bool {
+	return b.tx.watching || len(b.indexes()) > 0
+}
+
+// deleteSubtree releases the keys under a page, or the node loaded from it,
+// and frees it with the pages under it. It returns the number of keys. The
+// leaves are read for their key counts and overflows, but only the keys which
+// must be released are loaded.
+func (b *Bucket) deleteSubtree(pgId common.Pgid) (int, error) {
+	var deleted int
+	p, n := b.pageNode(pgId)
+	if n != nil {
+		all := n.isLeaf && b.releasesAllKeys()
+		for i := range n.inodes {
+			inode := &n.inodes[i]
+			if n.isLeaf {
+				if all || (inode.Flags()&releasedLeafFlags) != 0 {
+					if err := b.releaseElement(inode.Key(), inode.Value(), inode.Flags()); err != nil {
+						return deleted, err
+					}
+				}
+				deleted++
+				continue
+			}
+			count, err := b.deleteSubtree(inode.Pgid())
+			deleted += count
+			if err != nil {
+				return deleted, err
+			}
+		}
+		if n.parent != nil {
+			n.parent.removeChild(n)
+		}
+		delete(b.nodes, n.pgid)
+		n.free()
+		return deleted, nil
+	}
+
+	if p.IsLeafPage() {
+		all := b.releasesAllKeys()
+		for i := 0; i < int(p.Count()); i++ {
+			elem := p.LeafPageElement(uint16(i))
+			if all || (elem.Flags()&releasedLeafFlags) != 0 {
+				if err := b.releaseElement(elem.Key(), elem.Value(), elem.Flags()); err != nil {
+					return deleted, err
+				}
+			}
+			deleted++
+		}
+	} else {
+		for i := 0; i < int(p.Count()); i++ {
+			count, err := b.deleteSubtree(p.BranchPageElement(uint16(i)).Pgid())
+			deleted += count
+			if err != nil {
+				return deleted, err
+			}
+		}
+	}
+	b.tx.db.freelist.free(b.tx.meta.Txid(), p)
+	return deleted, nil
+}
+
+// releaseElement releases a key deleted by DeleteRange, whose leaf value v
+// has the given flags.
+func (b *Bucket) releaseElement(key []byte, v []byte, flags uint32) error {
+	if (flags & common.BucketLeafFlag) != 0 {
+		return b.releaseBucket(key, v, flags)
+	}
+	return b.releaseValue(key, v, flags)
+}
+
+// rebalanceNodes rebalances the nodes modified by DeleteRange, parents first,
+// so that the nodes rebalanced after them have siblings to merge with.
+func (b *Bucket) rebalanceNodes(touched []*node) {
+	for _, n := range touched {
+		// Skip the nodes merged into their siblings.
+		if b.nodes[n.pgid] != n {
+			continue
+		}
+		n.rebalance()
+
+		// Collapse the root as long as it has a single child.
+		for root := b.rootNode; !root.isLeaf && len(root.inodes) == 1; {
+			root.unbalanced = true
+			root.rebalance()
+		}
+	}
+}
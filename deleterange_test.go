@@ -0,0 +1,266 @@
+package bbolt_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	bolt "go.etcd.io/bbolt"
+	"go.etcd.io/bbolt/internal/btesting"
+)
+
+// Ensure that DeleteRange deletes the same keys as a cursor, from clean and
+// dirty trees, and only loads the nodes at the ends of the range.
+func TestBucket_DeleteRange(t *testing.T) {
+	db := btesting.MustCreateDB(t)
+	const n = 20000
+	value := bytes.Repeat([]byte("v"), 200)
+	ranges := []struct{ start, end string }{
+		{"", ""},
+		{"", "01000"},
+		{"13000", ""},
+		{"01234", "14321"},
+		{"02000", "02001"},
+		{"02500", "02500"},
+		{"03000", "02000"},
+		{"a", "b"},
+		{"05000-", "05999-"},
+	}
+	rangeKey := func(k string) []byte {
+		if k == "" {
+			return nil
+		}
+		return []byte(k)
+	}
+
+	for i, r := range ranges {
+		require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+			for _, name := range []string{"ranged", "looped"} {
+				b, err := tx.CreateBucketWithOptions([]byte(name), &bolt.BucketOptions{Counted: true})
+				require.NoError(t, err)
+				for j := 0; j < n; j++ {
+					require.NoError(t, b.Put([]byte(fmt.Sprintf("%05d", j)), value))
+				}
+			}
+			return nil
+		}))
+
+		require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+			start, end := rangeKey(r.start), rangeKey(r.end)
+			ranged, looped := tx.Bucket([]byte("ranged")), tx.Bucket([]byte("looped"))
+
+			// Load some of the nodes of every other tree.
+			dirty := i%2 == 1
+			if dirty {
+				for _, b := range []*bolt.Bucket{ranged, looped} {
+					for j := 0; j < n; j += 997 {
+						require.NoError(t, b.Put([]byte(fmt.Sprintf("%05d-", j)), []byte("new")))
+						require.NoError(t, b.Delete([]byte(fmt.Sprintf("%05d", j+1))))
+					}
+				}
+			}
+
+			var count int
+			c := looped.Cursor()
+			for k, _ := c.Seek(start); k != nil && (end == nil || bytes.Compare(k, end) < 0); k, _ = c.Seek(start) {
+				require.NoError(t, c.Delete())
+				count++
+			}
+
+			stats := tx.Stats()
+			deleted, err := ranged.DeleteRange(start, end)
+			require.NoError(t, err)
+			require.Equal(t, count, deleted)
+			if !dirty {
+				loaded := tx.Stats()
+				require.Less(t, loaded.GetNodeCount()-stats.GetNodeCount(), int64(20))
+			}
+
+			// Iterating backwards over the keys deleted by the cursor would stop
+			// at the leaves it emptied, until they're rebalanced on commit.
+			forward, backward := cursorKeys(ranged)
+			expected, _ := cursorKeys(looped)
+			require.Equal(t, expected, forward)
+			slices.Reverse(backward)
+			require.Equal(t, expected, backward)
+			requireRanks(t, ranged, forward)
+			return nil
+		}))
+		db.MustCheck()
+
+		require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+			ranged, looped := tx.Bucket([]byte("ranged")), tx.Bucket([]byte("looped"))
+			forward, _ := cursorKeys(ranged)
+			expected, _ := cursorKeys(looped)
+			require.Equal(t, expected, forward)
+			requireRanks(t, ranged, forward)
+
+			require.NoError(t, tx.DeleteBucket([]byte("ranged")))
+			return tx.DeleteBucket([]byte("looped"))
+		}))
+	}
+}
+
+// Ensure that DeleteRange deletes the nested buckets in the range, frees the
+// blobs, and updates the indexes, the expiry times and the watchers.
+func TestBucket_DeleteRange_Release(t *testing.T) {
+	db := btesting.MustCreateDBWithOption(t, &bolt.Options{WatchBufferSize: 10000})
+	path := bucketPath("parent", "widgets")
+	require.NoError(t, db.RegisterIndex([]byte("tags"), path, tagsIndex))
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		parent, err := tx.CreateBucket([]byte("parent"))
+		require.NoError(t, err)
+		b, err := parent.CreateBucket([]byte("widgets"))
+		require.NoError(t, err)
+		for i := 0; i < 1000; i++ {
+			k := []byte(fmt.Sprintf("%04d", i))
+			switch i % 4 {
+			case 0:
+				require.NoError(t, b.Put(k, []byte(fmt.Sprintf("t%d", i))))
+			case 1:
+				require.NoError(t, b.PutWithTTL(k, []byte("value"), time.Hour))
+			case 2:
+				require.NoError(t, b.PutReader(k, bytes.NewReader(bytes.Repeat([]byte("blob"), 2000))))
+			case 3:
+				nested, err := b.CreateBucket(k)
+				require.NoError(t, err)
+				require.NoError(t, nested.Put([]byte("foo"), bytes.Repeat([]byte("bar"), 2000)))
+				child, err := nested.CreateBucket([]byte("child"))
+				require.NoError(t, err)
+				require.NoError(t, child.PutWithTTL([]byte("baz"), []byte("bat"), time.Hour))
+			}
+		}
+		return nil
+	}))
+	require.Len(t, indexEntries(t, db.DB, "tags"), 750)
+	require.Equal(t, 500, expiryEntries(t, db.DB))
+
+	ch, err := db.Watch(context.Background(), path, nil)
+	require.NoError(t, err)
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		deleted, err := tx.Bucket([]byte("parent")).Bucket([]byte("widgets")).DeleteRange([]byte("0100"), []byte("0900"))
+		require.Equal(t, 800, deleted)
+		return err
+	}))
+	db.MustCheck()
+
+	events := make(map[bolt.ChangeType]int)
+	for _, e := range receiveEvents(ch) {
+		if len(e.Bucket) == len(path) {
+			events[e.Type]++
+		}
+	}
+	require.Equal(t, map[bolt.ChangeType]int{bolt.ChangeDelete: 600, bolt.ChangeDeleteBucket: 200}, events)
+	require.Len(t, indexEntries(t, db.DB, "tags"), 150)
+	require.Empty(t, indexCheckErrors(t, db.DB))
+
+	// The keys of the deleted nested buckets leave stale expiry entries.
+	require.Equal(t, 100+200, expiryEntries(t, db.DB))
+	require.NoError(t, db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("parent")).Bucket([]byte("widgets"))
+		stats := b.Stats()
+		require.Equal(t, 50, stats.BlobN)
+		require.Equal(t, 1+2*50, stats.BucketN)
+		require.Nil(t, b.Bucket([]byte("0103")))
+		require.NotNil(t, b.Bucket([]byte("0903")).Bucket([]byte("child")))
+		return nil
+	}))
+}
+
+// Ensure that DeleteRange releases the nested buckets, blobs and expiry
+// entries of the freed subtrees when it doesn't release every key.
+func TestBucket_DeleteRange_Release_Unindexed(t *testing.T) {
+	db := btesting.MustCreateDB(t)
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		require.NoError(t, err)
+		for i := 0; i < 1000; i++ {
+			k := []byte(fmt.Sprintf("%04d", i))
+			switch i % 4 {
+			case 0:
+				require.NoError(t, b.Put(k, []byte("value")))
+			case 1:
+				require.NoError(t, b.PutWithTTL(k, []byte("value"), time.Hour))
+			case 2:
+				require.NoError(t, b.PutReader(k, bytes.NewReader(bytes.Repeat([]byte("blob"), 2000))))
+			case 3:
+				nested, err := b.CreateBucket(k)
+				require.NoError(t, err)
+				require.NoError(t, nested.Put([]byte("foo"), bytes.Repeat([]byte("bar"), 2000)))
+			}
+		}
+		return nil
+	}))
+	require.Equal(t, 250, expiryEntries(t, db.DB))
+
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		deleted, err := tx.Bucket([]byte("widgets")).DeleteRange([]byte("0100"), []byte("0900"))
+		require.Equal(t, 800, deleted)
+		return err
+	}))
+	db.MustCheck()
+	require.Equal(t, 50, expiryEntries(t, db.DB))
+	require.NoError(t, db.View(func(tx *bolt.Tx) error {
+		stats := tx.Bucket([]byte("widgets")).Stats()
+		require.Equal(t, 50, stats.BlobN)
+		require.Equal(t, 1+50, stats.BucketN)
+		return nil
+	}))
+}
+
+func ExampleBucket_DeleteRange() {
+	// Open the database.
+	db, err := bolt.Open(tempfile(), 0600, nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.Remove(db.Path())
+
+	// Log events by date, then delete the events of January.
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("events"))
+		if err != nil {
+			return err
+		}
+		for _, date := range []string{"2024-01-05", "2024-01-20", "2024-01-31", "2024-02-01", "2024-02-14"} {
+			if err := b.Put([]byte(date), []byte("event")); err != nil {
+				return err
+			}
+		}
+		deleted, err := b.DeleteRange([]byte("2024-01"), []byte("2024-02"))
+		if err != nil {
+			return err
+		}
+		fmt.Printf("deleted %d events\n", deleted)
+		return nil
+	}); err != nil {
+		log.Fatal(err)
+	}
+
+	// Read the remaining events.
+	if err := db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte("events")).ForEach(func(k, v []byte) error {
+			fmt.Printf("%s: %s\n", k, v)
+			return nil
+		})
+	}); err != nil {
+		log.Fatal(err)
+	}
+
+	// Close database to release the file lock.
+	if err := db.Close(); err != nil {
+		log.Fatal(err)
+	}
+
+	// Output:
+	// deleted 3 events
+	// 2024-02-01: event
+	// 2024-02-14: event
+}
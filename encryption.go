@@ -0,0 +1,176 @@
+package bbolt
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"fmt"
+	"sync"
+	"unsafe"
+
+	berrors "go.etcd.io/bbolt/errors"
+	"go.etcd.io/bbolt/internal/common"
+)
+
+// Encryption configures the encryption of a database file at rest.
+//
+// Every page but the two meta pages is encrypted when it's written, and
+// authenticated and decrypted when it's read. The header of each page stays
+// in plain text so that its size is known before it's decrypted. The meta
+// pages hold no keys or values, and must be readable to find the page size
+// and the format of the file before it can be decrypted.
+type Encryption struct {
+	// KeyProvider returns the key the pages are encrypted with. It's called
+	// once when the database is opened.
+	KeyProvider func() ([]byte, error)
+
+	// Cipher returns the AEAD which encrypts the pages with the given key.
+	// The default is AESGCM.
+	Cipher func(key []byte) (cipher.AEAD, error)
+}
+
+// AESGCM returns an AES-GCM AEAD, with a 16, 24 or 32 byte key.
+func AESGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// StaticKey returns a KeyProvider which always returns key.
+func StaticKey(key []byte) func() ([]byte, error) {
+	return func() ([]byte, error) {
+		return key, nil
+	}
+}
+
+// newAEAD returns the AEAD configured by e.
+func (e *Encryption) newAEAD() (cipher.AEAD, error) {
+	if e.KeyProvider == nil {
+		return nil, fmt.Errorf("encryption key provider not set")
+	}
+	key, err := e.KeyProvider()
+	if err != nil {
+		return nil, fmt.Errorf("get encryption key: %w", err)
+	}
+	newCipher := e.Cipher
+	if newCipher == nil {
+		newCipher = AESGCM
+	}
+	return newCipher(key)
+}
+
+// sealPages returns encrypted copies of pages, if the database is encrypted.
+// The nonce is stored in the last bytes of each page, after the encrypted
+// content and its authentication tag. The page header is authenticated too.
+func (db *DB) sealPages(pages common.Pages) (common.Pages, error) {
+	if db.aead == nil {
+		return pages, nil
+	}
+
+	nonceSize := db.aead.NonceSize()
+	sealed := make(common.Pages, 0, len(pages))
+	for _, p := range pages {
+		sz := (int(p.Overflow()) + 1) * db.pageSize
+		src := common.UnsafeByteSlice(unsafe.Pointer(p), 0, 0, sz)
+		buf := make([]byte, sz)
+		hdr := buf[:common.PageHeaderSize]
+		copy(hdr, src)
+
+		nonce := buf[sz-nonceSize:]
+		if _, err := rand.Read(nonce); err != nil {
+			return nil, fmt.Errorf("generate nonce: %w", err)
+		}
+		db.aead.Seal(buf[common.PageHeaderSize:common.PageHeaderSize], nonce, src[common.PageHeaderSize:sz-db.pageTrailerSize()], hdr)
+		sealed = append(sealed, common.LoadPage(buf))
+	}
+
+	// The pages being written aren't reachable by any open transaction, so
+	// their decrypted copies are stale.
+	db.pageCache.drop(pages)
+
+	return sealed, nil
+}
+
+// openPage returns a decrypted copy of the encrypted page p.
+func (db *DB) openPage(p *common.Page) (*common.Page, error) {
+	sz := (int(p.Overflow()) + 1) * db.pageSize
+	src := common.UnsafeByteSlice(unsafe.Pointer(p), 0, 0, sz)
+	buf := make([]byte, sz)
+	hdr := src[:common.PageHeaderSize]
+	copy(buf, hdr)
+
+	nonce := src[sz-db.aead.NonceSize():]
+	if _, err := db.aead.Open(buf[common.PageHeaderSize:common.PageHeaderSize], nonce, src[common.PageHeaderSize:sz-db.aead.NonceSize()], hdr); err != nil {
+		return nil, fmt.Errorf("page %d: %w", p.Id(), berrors.ErrPageDecrypt)
+	}
+	return common.LoadPage(buf), nil
+}
+
+// decryptedPage returns the decrypted page with the given id, from the page
+// cache if possible.
+func (db *DB) decryptedPage(id common.Pgid) (*common.Page, error) {
+	if p := db.pageCache.get(id); p != nil {
+		return p, nil
+	}
+
+	var raw *common.Page
+	if db.wal != nil {
+		raw = db.wal.page(id)
+	}
+	if raw == nil {
+		raw = db.rawPage(id)
+		// A corrupted overflow could make the page run past the mapped data.
+		if end := (int(id) + int(raw.Overflow()) + 1) * db.pageSize; end > db.datasz {
+			return nil, fmt.Errorf("page %d: %w (overflow %d exceeds mapped size)", id, berrors.ErrPageDecrypt, raw.Overflow())
+		}
+	}
+	p, err := db.openPage(raw)
+	if err != nil {
+		return nil, err
+	}
+	db.pageCache.put(id, p)
+	return p, nil
+}
+
+// pageCache holds the decrypted copies of the pages of an encrypted database.
+// Transactions keep references to the pages they read, so evicting a page
+// never invalidates it for a reader.
+type pageCache struct {
+	mu    sync.Mutex
+	pages map[common.Pgid]*common.Page
+	size  int
+}
+
+func newPageCache(size int) *pageCache {
+	return &pageCache{pages: make(map[common.Pgid]*common.Page), size: size}
+}
+
+func (c *pageCache) get(id common.Pgid) *common.Page {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.pages[id]
+}
+
+func (c *pageCache) put(id common.Pgid, p *common.Page) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.pages) >= c.size {
+		// Evict an arbitrary page.
+		for evict := range c.pages {
+			delete(c.pages, evict)
+			break
+		}
+	}
+	c.pages[id] = p
+}
+
+// drop removes pages which are being rewritten.
+func (c *pageCache) drop(pages common.Pages) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, p := range pages {
+		delete(c.pages, p.Id())
+	}
+}
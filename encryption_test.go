@@ -0,0 +1,163 @@
+package bbolt_test
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	bolt "go.etcd.io/bbolt"
+	berrors "go.etcd.io/bbolt/errors"
+	"go.etcd.io/bbolt/internal/btesting"
+)
+
+var testEncryptionKey = bytes.Repeat([]byte{0x42}, 32)
+
+func testEncryption(key []byte) *bolt.Encryption {
+	return &bolt.Encryption{KeyProvider: bolt.StaticKey(key)}
+}
+
+// Ensure that an encrypted database can be written, checked and reopened,
+// and that the values aren't stored in plain text.
+func TestDB_Encryption(t *testing.T) {
+	db := btesting.MustCreateDBWithOption(t, &bolt.Options{
+		Encryption:          testEncryption(testEncryptionKey),
+		EncryptionCacheSize: 4,
+	})
+	require.NoError(t, db.Fill([]byte("widgets"), 10, 100, keyGen, valueGen("secret-value-")))
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		// Overflow pages are encrypted as one run.
+		return tx.Bucket([]byte("widgets")).Put([]byte("large"), bytes.Repeat([]byte("secret-value-"), db.Info().PageSize))
+	}))
+	expected := dumpDB(t, db.DB)
+	db.MustCheck()
+
+	db.MustClose()
+	data, err := os.ReadFile(db.Path())
+	require.NoError(t, err)
+	require.False(t, bytes.Contains(data, []byte("secret-value-")))
+
+	db.MustReopen()
+	require.Equal(t, expected, dumpDB(t, db.DB))
+}
+
+// Ensure that an encrypted database can't be opened without its key, and a
+// plain database can't be opened with one.
+func TestOpen_Encryption_Key(t *testing.T) {
+	db := btesting.MustCreateDBWithOption(t, &bolt.Options{Encryption: testEncryption(testEncryptionKey)})
+	require.NoError(t, db.Fill([]byte("widgets"), 1, 10, keyGen, valueGen("v")))
+	db.MustClose()
+
+	_, err := bolt.Open(db.Path(), 0600, nil)
+	require.ErrorIs(t, err, berrors.ErrEncryptionRequired)
+
+	_, err = bolt.Open(db.Path(), 0600, &bolt.Options{Encryption: testEncryption(bytes.Repeat([]byte{0x24}, 32))})
+	require.ErrorIs(t, err, berrors.ErrPageDecrypt)
+
+	_, err = bolt.Open(filepath.Join(t.TempDir(), "db"), 0600, &bolt.Options{Encryption: testEncryption([]byte("short"))})
+	require.Error(t, err)
+
+	db.MustReopen()
+
+	plain := btesting.MustCreateDB(t)
+	plain.MustClose()
+	_, err = bolt.Open(plain.Path(), 0600, &bolt.Options{Encryption: testEncryption(testEncryptionKey)})
+	require.ErrorIs(t, err, berrors.ErrNotEncrypted)
+	plain.MustReopen()
+}
+
+// Ensure that a tampered page is reported by reads, and by Tx.Check.
+func TestDB_Encryption_Tampered(t *testing.T) {
+	const pageSize = 4096
+	db := btesting.MustCreateDBWithOption(t, &bolt.Options{PageSize: pageSize, Encryption: testEncryption(testEncryptionKey)})
+	require.NoError(t, db.Fill([]byte("widgets"), 10, 100, keyGen, valueGen("v")))
+
+	var pgid int
+	require.NoError(t, db.View(func(tx *bolt.Tx) error {
+		pgid = int(tx.Bucket([]byte("widgets")).Root())
+		return nil
+	}))
+	db.MustClose()
+
+	f, err := os.OpenFile(db.Path(), os.O_RDWR, 0600)
+	require.NoError(t, err)
+	_, err = f.WriteAt([]byte{0xff}, int64(pgid*pageSize+pageSize/2))
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	// Move the file away, so that the test cleanup doesn't check it.
+	path := filepath.Join(t.TempDir(), "tampered")
+	require.NoError(t, os.Rename(db.Path(), path))
+
+	tampered, err := bolt.Open(path, 0600, &bolt.Options{Encryption: testEncryption(testEncryptionKey)})
+	require.NoError(t, err)
+	defer tampered.Close()
+
+	err = tampered.View(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte("widgets")).ForEach(func(k, v []byte) error { return nil })
+	})
+	require.ErrorIs(t, err, berrors.ErrPageDecrypt)
+	require.ErrorContains(t, err, fmt.Sprintf("page %d:", pgid))
+
+	var checkErrs []error
+	require.NoError(t, tampered.View(func(tx *bolt.Tx) error {
+		for err := range tx.Check() {
+			checkErrs = append(checkErrs, err)
+		}
+		return nil
+	}))
+	require.Len(t, checkErrs, 1)
+	require.ErrorIs(t, checkErrs[0], berrors.ErrPageDecrypt)
+}
+
+// Ensure that the pages of an encrypted database are encrypted in the
+// write-ahead log too.
+func TestDB_Encryption_WAL(t *testing.T) {
+	db := btesting.MustCreateDBWithOption(t, &bolt.Options{
+		WALMode:    true,
+		Encryption: testEncryption(testEncryptionKey),
+	})
+	require.NoError(t, db.Fill([]byte("widgets"), 10, 100, keyGen, valueGen("secret-value-")))
+	expected := dumpDB(t, db.DB)
+
+	data, err := os.ReadFile(db.Path() + "-wal")
+	require.NoError(t, err)
+	require.False(t, bytes.Contains(data, []byte("secret-value-")))
+
+	require.NoError(t, db.Checkpoint())
+	require.Equal(t, expected, dumpDB(t, db.DB))
+	db.MustClose()
+	db.MustReopen()
+	require.Equal(t, expected, dumpDB(t, db.DB))
+}
+
+// Ensure that backups of an encrypted database stay encrypted, and can be
+// restored.
+func TestDB_Encryption_Backup(t *testing.T) {
+	db := btesting.MustCreateDBWithOption(t, &bolt.Options{Encryption: testEncryption(testEncryptionKey)})
+	require.NoError(t, db.Fill([]byte("widgets"), 5, 100, keyGen, valueGen("secret-value-")))
+
+	path := filepath.Join(t.TempDir(), "backup")
+	var since int
+	require.NoError(t, db.View(func(tx *bolt.Tx) error {
+		since = tx.ID()
+		return tx.CopyFile(path, 0600)
+	}))
+
+	require.NoError(t, db.Fill([]byte("widgets"), 1, 10, keyGen, valueGen("secret-inc-")))
+	var buf bytes.Buffer
+	require.NoError(t, db.View(func(tx *bolt.Tx) error {
+		_, err := tx.WriteIncrementalTo(&buf, since)
+		return err
+	}))
+	require.False(t, bytes.Contains(buf.Bytes(), []byte("secret-inc-")))
+	require.NoError(t, bolt.ApplyIncremental(path, &buf))
+
+	restored, err := bolt.Open(path, 0600, &bolt.Options{ReadOnly: true, Encryption: testEncryption(testEncryptionKey)})
+	require.NoError(t, err)
+	defer restored.Close()
+	require.Equal(t, dumpDB(t, db.DB), dumpDB(t, restored))
+}
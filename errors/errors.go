@@ -24,6 +24,23 @@ var (
 	// ErrChecksum is returned when a checksum mismatch occurs on either of the two meta pages.
 	ErrChecksum = errors.New("checksum error")
 
+	// ErrPageChecksum is returned when the checksum stored in a page doesn't
+	// match its content. It is wrapped in an error naming the page id.
+	ErrPageChecksum = errors.New("page checksum mismatch")
+
+	// ErrPageDecrypt is returned when a page of an encrypted database can't
+	// be decrypted, because the key is wrong or the page is corrupted. It is
+	// wrapped in an error naming the page id.
+	ErrPageDecrypt = errors.New("page decryption failed")
+
+	// ErrEncryptionRequired is returned when opening an encrypted database
+	// without Options.Encryption.
+	ErrEncryptionRequired = errors.New("database is encrypted")
+
+	// ErrNotEncrypted is returned when opening a database which isn't
+	// encrypted with Options.Encryption.
+	ErrNotEncrypted = errors.New("database is not encrypted")
+
 	// ErrTimeout is returned when a database cannot obtain an exclusive lock
 	// on the data file after the timeout passed to Open().
 	ErrTimeout = errors.New("timeout")
@@ -46,6 +63,14 @@ var (
 	// ErrFreePagesNotLoaded is returned when a readonly transaction without
 	// preloading the free pages is trying to access the free pages.
 	ErrFreePagesNotLoaded = errors.New("free pages are not pre-loaded")
+
+	// ErrSavepointReleased is returned when rolling back to or releasing a
+	// savepoint which was released, or rolled back past.
+	ErrSavepointReleased = errors.New("savepoint released")
+
+	// ErrPageWritesUntracked is returned when writing an increment since a
+	// transaction older than the one the writes of pages are tracked from.
+	ErrPageWritesUntracked = errors.New("page writes not tracked since the given txid")
 )
 
 // These errors can occur when putting or deleting a value or a bucket.
@@ -60,6 +85,10 @@ var (
 	// ErrBucketNameRequired is returned when creating a bucket with a blank name.
 	ErrBucketNameRequired = errors.New("bucket name required")
 
+	// ErrBucketNameReserved is returned when creating a top level bucket with
+	// the name of a bucket maintained by bbolt.
+	ErrBucketNameReserved = errors.New("bucket name reserved")
+
 	// ErrKeyRequired is returned when inserting a zero-length key.
 	ErrKeyRequired = errors.New("key required")
 
@@ -74,6 +103,14 @@ var (
 	// non-bucket key on an existing bucket key.
 	ErrIncompatibleValue = errors.New("incompatible value")
 
+	// ErrCursorNotPositioned is returned when putting a value at the position
+	// of a cursor which isn't on a key.
+	ErrCursorNotPositioned = errors.New("cursor not positioned on a key")
+
+	// ErrInvalidToken is returned when resuming an iteration from a token
+	// which is malformed or was returned for a bucket at another path.
+	ErrInvalidToken = errors.New("invalid token")
+
 	// ErrSameBuckets is returned when trying to move a sub-bucket between
 	// source and target buckets, while source and target buckets are the same.
 	ErrSameBuckets = errors.New("the source and target are the same bucket")
@@ -81,4 +118,36 @@ var (
 	// ErrDifferentDB is returned when trying to move a sub-bucket between
 	// source and target buckets, while source and target buckets are in different database files.
 	ErrDifferentDB = errors.New("the source and target buckets are in different database files")
+
+	// ErrUnknownCodec is returned when writing to a bucket whose values are
+	// compressed by a codec which isn't registered.
+	ErrUnknownCodec = errors.New("unknown codec")
+
+	// ErrUnknownComparator is returned when creating a bucket with a
+	// comparator which isn't registered, or by operations which need the
+	// comparator of an existing bucket which isn't registered.
+	ErrUnknownComparator = errors.New("unknown comparator")
+
+	// ErrBucketNotEmpty is returned when bulk loading keys into a bucket
+	// which already has keys.
+	ErrBucketNotEmpty = errors.New("bucket not empty")
+
+	// ErrKeysUnordered is returned when bulk loading a key which isn't
+	// greater than the previous one.
+	ErrKeysUnordered = errors.New("keys not in ascending order")
+)
+
+// These errors can occur when registering or rebuilding an index.
+var (
+	// ErrIndexExists is returned when registering an index with the name of
+	// a registered index.
+	ErrIndexExists = errors.New("index already registered")
+
+	// ErrIndexNotFound is returned when rebuilding an index which isn't
+	// registered.
+	ErrIndexNotFound = errors.New("index not registered")
+
+	// ErrInvalidIndex is returned when registering an index with a blank
+	// name or bucket path, or which would be stored in the indexed bucket.
+	ErrInvalidIndex = errors.New("invalid index")
 )
@@ -0,0 +1,164 @@
+package bbolt
+
+import (
+	"math"
+	"math/rand/v2"
+	"sort"
+
+	"go.etcd.io/bbolt/internal/common"
+)
+
+// estimateSampleN is the number of pages of each level of the tree read by
+// EstimateRange once a range spans more pages.
+const estimateSampleN = 64
+
+// RangeEstimate is an approximation of the size of a range of keys of a
+// bucket, returned by Bucket.EstimateRange.
+type RangeEstimate struct {
+	KeyN      int // number of keys
+	LeafPageN int // number of leaf pages holding keys of the range
+	Bytes     int // size of the keys, and of the values as stored
+}
+
+// EstimateRange returns an approximation of the number of keys which are equal
+// to or greater than start, and less than end, and of the leaf pages and bytes
+// they use. A nil start counts from the first key, and a nil end up to the
+// last key. Expired keys which weren't reaped yet are counted.
+//
+// It descends the branch pages from the root into the children holding keys
+// of the range. Once a level has more than 64 of them, it only descends into
+// 64 children spread across the range, and extrapolates from their fan-out,
+// so the estimate is exact if the range spans at most 64 pages of each level.
+func (b *Bucket) EstimateRange(start, end []byte) RangeEstimate {
+	common.Assert(b.tx.db != nil, "tx closed")
+	if start != nil && end != nil && b.compare(start, end) >= 0 {
+		return RangeEstimate{}
+	}
+
+	var keyN, leafPageN, bytes float64
+	pgIds := []common.Pgid{b.RootPage()}
+	scale := 1.0
+	for len(pgIds) > 0 {
+		// Only read a sample of the pages of large ranges, each standing for
+		// the pages around it.
+		if len(pgIds) > estimateSampleN {
+			sample := make([]common.Pgid, estimateSampleN)
+			for i := range sample {
+				sample[i] = pgIds[i*(len(pgIds)-1)/(estimateSampleN-1)]
+			}
+			scale *= float64(len(pgIds)) / estimateSampleN
+			pgIds = sample
+		}
+
+		var children []common.Pgid
+		for _, pgId := range pgIds {
+			p, n := b.pageNode(pgId)
+			ref := elemRef{page: p, node: n}
+			lo, hi := b.searchRange(&ref, start, end)
+			if !ref.isLeaf() {
+				// The keys of a child are less than the key of the next
+				// element, and the first child also holds the keys less than
+				// its key.
+				lo, hi = max(lo-1, 0), max(hi, 1)
+				for i := lo; i < hi; i++ {
+					children = append(children, ref.branchPgid(i))
+				}
+				continue
+			}
+
+			if hi <= lo {
+				continue
+			}
+			keyN += float64(hi-lo) * scale
+			leafPageN += scale
+			for i := lo; i < hi; i++ {
+				k, v := ref.leafKeyValue(i)
+				bytes += float64(len(k)+len(v)) * scale
+			}
+		}
+		pgIds = children
+	}
+
+	return RangeEstimate{
+		KeyN:      int(math.Round(keyN)),
+		LeafPageN: int(math.Round(leafPageN)),
+		Bytes:     int(math.Round(bytes)),
+	}
+}
+
+// searchRange returns the indexes of the first elements of a page/node whose
+// keys are equal to or greater than start, and end. A nil start returns 0,
+// and a nil end the number of elements.
+func (b *Bucket) searchRange(ref *elemRef, start, end []byte) (lo, hi int) {
+	key := ref.branchKey
+	if ref.isLeaf() {
+		key = func(i int) []byte {
+			k, _ := ref.leafKeyValue(i)
+			return k
+		}
+	}
+	if hi = ref.count(); end != nil {
+		hi = sort.Search(hi, func(i int) bool { return b.compare(key(i), end) >= 0 })
+	}
+	if start != nil {
+		lo = sort.Search(hi, func(i int) bool { return b.compare(key(i), start) >= 0 })
+	}
+	return lo, hi
+}
+
+// SampleKeys returns n keys of the bucket picked at random, with replacement,
+// or nil if the bucket is empty. Expired keys which weren't reaped yet can be
+// picked. The keys are only valid for the life of the transaction.
+//
+// In counted buckets, the keys are picked uniformly. In other buckets, each
+// key is picked by descending from the root into a random element of each
+// page, so the keys of pages holding fewer keys are picked more often.
+func (b *Bucket) SampleKeys(n int) [][]byte {
+	common.Assert(b.tx.db != nil, "tx closed")
+	var keys [][]byte
+	c := b.Cursor()
+	for len(keys) < n {
+		k := c.randomKey()
+		if k == nil {
+			break
+		}
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// randomKey moves the cursor to a random key and returns it, or nil if the
+// bucket is empty.
+func (c *Cursor) randomKey() []byte {
+	if c.bucket.ext.IsCounted() {
+		if n := c.bucket.ext.KeyCount(); n > 0 {
+			k, _, _ := c.seekIndex(rand.Uint64N(n))
+			return k
+		}
+		return nil
+	}
+
+	c.stack = c.stack[:0]
+	pgId := c.bucket.RootPage()
+	for {
+		p, n := c.bucket.pageNode(pgId)
+		ref := elemRef{page: p, node: n}
+		if ref.count() > 0 {
+			ref.index = rand.IntN(ref.count())
+		}
+		c.stack = append(c.stack, ref)
+		if ref.isLeaf() {
+			break
+		}
+		pgId = ref.branchPgid(ref.index)
+	}
+
+	// Leaf nodes may be left empty by deletes until they're rebalanced.
+	k, _, _ := c.keyValue()
+	if k == nil {
+		if k, _, _ = c.next(); k == nil {
+			k, _, _ = c.first()
+		}
+	}
+	return k
+}
@@ -0,0 +1,133 @@
+package bbolt_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	bolt "go.etcd.io/bbolt"
+	"go.etcd.io/bbolt/internal/btesting"
+)
+
+// rangeSize returns the number of keys of a range, and the size of their keys
+// and values.
+func rangeSize(b *bolt.Bucket, start, end []byte) (keyN, bytes int) {
+	c := b.Cursor()
+	for k, v := c.Seek(start); k != nil && (end == nil || string(k) < string(end)); k, v = c.Next() {
+		keyN++
+		bytes += len(k) + len(v)
+	}
+	return keyN, bytes
+}
+
+// Ensure that EstimateRange is exact for small ranges, and close for large
+// ones, in counted and other buckets.
+func TestBucket_EstimateRange(t *testing.T) {
+	for _, counted := range []bool{false, true} {
+		t.Run(fmt.Sprintf("counted %v", counted), func(t *testing.T) {
+			db := btesting.MustCreateDB(t)
+			require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+				b, err := tx.CreateBucketWithOptions([]byte("widgets"), &bolt.BucketOptions{Counted: counted})
+				require.NoError(t, err)
+				for i := 0; i < 100000; i++ {
+					require.NoError(t, b.Put([]byte(fmt.Sprintf("%06d", i)), []byte(fmt.Sprintf("value%d", i%100))))
+				}
+				inline, err := tx.CreateBucket([]byte("inline"))
+				require.NoError(t, err)
+				require.NoError(t, inline.Put([]byte("foo"), []byte("bar")))
+				return nil
+			}))
+
+			require.NoError(t, db.View(func(tx *bolt.Tx) error {
+				b := tx.Bucket([]byte("widgets"))
+				stats := b.Stats()
+				for _, r := range []struct{ start, end string }{
+					{"001000", "002000"},
+					{"005000", "005001"},
+					{"0990", ""},
+				} {
+					var start, end []byte
+					if r.start != "" {
+						start = []byte(r.start)
+					}
+					if r.end != "" {
+						end = []byte(r.end)
+					}
+					est := b.EstimateRange(start, end)
+					keyN, bytes := rangeSize(b, start, end)
+					require.Equal(t, keyN, est.KeyN, "%v", r)
+					require.Equal(t, bytes, est.Bytes, "%v", r)
+					require.Positive(t, est.LeafPageN)
+					require.LessOrEqual(t, est.LeafPageN, stats.LeafPageN)
+				}
+
+				for _, r := range []struct{ start, end []byte }{
+					{nil, nil},
+					{[]byte("025000"), []byte("075000")},
+					{nil, []byte("01")},
+				} {
+					est := b.EstimateRange(r.start, r.end)
+					keyN, bytes := rangeSize(b, r.start, r.end)
+					require.InEpsilon(t, keyN, est.KeyN, 0.1, "%q", r)
+					require.InEpsilon(t, bytes, est.Bytes, 0.1, "%q", r)
+					require.InEpsilon(t, stats.LeafPageN*keyN/100000, est.LeafPageN, 0.1, "%q", r)
+				}
+
+				require.Zero(t, b.EstimateRange([]byte("5"), []byte("5")))
+				require.Zero(t, b.EstimateRange([]byte("6"), []byte("5")))
+				require.Zero(t, b.EstimateRange([]byte("a"), nil))
+
+				inline := tx.Bucket([]byte("inline"))
+				require.Equal(t, bolt.RangeEstimate{KeyN: 1, LeafPageN: 1, Bytes: 6}, inline.EstimateRange(nil, nil))
+				return nil
+			}))
+		})
+	}
+}
+
+// Ensure that SampleKeys returns keys of the bucket spread across it.
+func TestBucket_SampleKeys(t *testing.T) {
+	for _, counted := range []bool{false, true} {
+		t.Run(fmt.Sprintf("counted %v", counted), func(t *testing.T) {
+			db := btesting.MustCreateDB(t)
+			require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+				b, err := tx.CreateBucketWithOptions([]byte("widgets"), &bolt.BucketOptions{Counted: counted})
+				require.NoError(t, err)
+				require.Nil(t, b.SampleKeys(10))
+				for i := 0; i < 10000; i++ {
+					require.NoError(t, b.Put([]byte(fmt.Sprintf("%05d", i)), []byte("value")))
+				}
+				return nil
+			}))
+
+			require.NoError(t, db.View(func(tx *bolt.Tx) error {
+				b := tx.Bucket([]byte("widgets"))
+				require.Nil(t, b.SampleKeys(0))
+				keys := b.SampleKeys(1000)
+				require.Len(t, keys, 1000)
+				var low int
+				for _, k := range keys {
+					require.NotNil(t, b.Get(k))
+					if string(k) < "05000" {
+						low++
+					}
+				}
+				require.InDelta(t, 500, low, 100)
+				return nil
+			}))
+
+			// Empty leaf nodes are skipped in a write transaction.
+			require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+				b := tx.Bucket([]byte("widgets"))
+				for i := 0; i < 9990; i++ {
+					require.NoError(t, b.Delete([]byte(fmt.Sprintf("%05d", i))))
+				}
+				for _, k := range b.SampleKeys(100) {
+					require.GreaterOrEqual(t, string(k), "09990")
+				}
+				return nil
+			}))
+		})
+	}
+}
@@ -0,0 +1,10 @@
+package bbolt
+
+// ExpiryEntryN returns the number of entries of the expiry bucket, which is
+// hidden from the public API.
+func ExpiryEntryN(tx *Tx) int {
+	if eb := tx.expiryBucket(); eb != nil {
+		return eb.Stats().KeyN
+	}
+	return 0
+}
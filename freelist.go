@@ -151,6 +151,38 @@ func (f *freelist) arrayAllocate(txid common.Txid, n int) common.Pgid {
 	return 0
 }
 
+// allocateLowest serves the same purpose as allocate, but always returns the
+// lowest contiguous list of free pages of the given size.
+func (f *freelist) allocateLowest(txid common.Txid, n int) common.Pgid {
+	if f.freelistType == FreelistMapType {
+		return f.hashmapAllocateLowest(txid, n)
+	}
+	// The array version allocates the lowest pages anyway.
+	return f.arrayAllocate(txid, n)
+}
+
+// trimTail removes the free pages just below the high water mark hwm, and
+// returns the new high water mark.
+func (f *freelist) trimTail(hwm common.Pgid) common.Pgid {
+	var n common.Pgid
+	if f.freelistType == FreelistMapType {
+		if size, ok := f.backwardMap[hwm-1]; ok {
+			f.delSpan(hwm-common.Pgid(size), size)
+			n = common.Pgid(size)
+		}
+	} else {
+		for len(f.ids) > 0 && f.ids[len(f.ids)-1] == hwm-n-1 {
+			f.ids = f.ids[:len(f.ids)-1]
+			n++
+		}
+	}
+
+	for id := hwm - n; id < hwm; id++ {
+		delete(f.cache, id)
+	}
+	return hwm - n
+}
+
 // free releases a page and its overflow for a given transaction id.
 // If the page is already free then a panic will occur.
 func (f *freelist) free(txid common.Txid, p *common.Page) {
@@ -258,6 +290,40 @@ func (f *freelist) rollback(txid common.Txid) {
 	f.mergeSpans(m)
 }
 
+// rollbackTo undoes the changes made by txid after a savepoint. The pages
+// freed after the first n are removed from the pending list, and the given
+// pages allocated after the savepoint are returned to the free list, unless
+// they are above the high water mark hwm at the savepoint.
+func (f *freelist) rollbackTo(txid common.Txid, n int, allocated []*common.Page, hwm common.Pgid) {
+	if txp := f.pending[txid]; txp != nil {
+		for i := n; i < len(txp.ids); i++ {
+			delete(f.cache, txp.ids[i])
+			if tx := txp.alloctx[i]; tx != 0 {
+				// Pending free aborted; restore page back to alloc list.
+				f.allocs[txp.ids[i]] = tx
+			}
+		}
+		txp.ids = txp.ids[:n]
+		txp.alloctx = txp.alloctx[:n]
+		if n == 0 {
+			delete(f.pending, txid)
+		}
+	}
+
+	var m common.Pgids
+	for _, p := range allocated {
+		delete(f.allocs, p.Id())
+		if p.Id() >= hwm {
+			continue
+		}
+		for id := p.Id(); id <= p.Id()+common.Pgid(p.Overflow()); id++ {
+			m = append(m, id)
+			f.cache[id] = struct{}{}
+		}
+	}
+	f.mergeSpans(m)
+}
+
 // freed returns whether a given page is in the free list.
 func (f *freelist) freed(pgId common.Pgid) bool {
 	_, ok := f.cache[pgId]
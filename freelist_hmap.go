@@ -72,6 +72,35 @@ func (f *freelist) hashmapAllocate(txid common.Txid, n int) common.Pgid {
 	return 0
 }
 
+// hashmapAllocateLowest serves the same purpose as hashmapAllocate, but
+// allocates from the lowest free span which is large enough.
+func (f *freelist) hashmapAllocateLowest(txid common.Txid, n int) common.Pgid {
+	if n == 0 {
+		return 0
+	}
+
+	var pid common.Pgid
+	var size uint64
+	for start, sz := range f.forwardMap {
+		if sz >= uint64(n) && (pid == 0 || start < pid) {
+			pid, size = start, sz
+		}
+	}
+	if pid == 0 {
+		return 0
+	}
+
+	f.delSpan(pid, size)
+	f.allocs[pid] = txid
+	if remain := size - uint64(n); remain > 0 {
+		f.addSpan(pid+common.Pgid(n), remain)
+	}
+	for i := common.Pgid(0); i < common.Pgid(n); i++ {
+		delete(f.cache, pid+i)
+	}
+	return pid
+}
+
 // hashmapReadIDs reads pgids as input an initial the freelist(hashmap version)
 func (f *freelist) hashmapReadIDs(pgids []common.Pgid) {
 	f.init(pgids)
@@ -255,6 +255,54 @@ func TestFreelistArray_allocate(t *testing.T) {
 	}
 }
 
+// Ensure that allocateLowest returns the lowest contiguous block of pages.
+func TestFreelist_allocateLowest(t *testing.T) {
+	f := newTestFreelist()
+	f.readIDs([]common.Pgid{3, 4, 5, 6, 7, 9, 12, 13, 18})
+	if id := int(f.allocateLowest(1, 2)); id != 3 {
+		t.Fatalf("exp=3; got=%v", id)
+	}
+	if id := int(f.allocateLowest(1, 1)); id != 5 {
+		t.Fatalf("exp=5; got=%v", id)
+	}
+	if id := int(f.allocateLowest(1, 2)); id != 6 {
+		t.Fatalf("exp=6; got=%v", id)
+	}
+	if id := int(f.allocateLowest(1, 2)); id != 12 {
+		t.Fatalf("exp=12; got=%v", id)
+	}
+	if id := int(f.allocateLowest(1, 2)); id != 0 {
+		t.Fatalf("exp=0; got=%v", id)
+	}
+	if exp := []common.Pgid{9, 18}; !reflect.DeepEqual(exp, f.getFreePageIDs()) {
+		t.Fatalf("exp=%v; got=%v", exp, f.getFreePageIDs())
+	}
+}
+
+// Ensure that trimTail removes the free pages below the high water mark.
+func TestFreelist_trimTail(t *testing.T) {
+	f := newTestFreelist()
+	f.readIDs([]common.Pgid{3, 4, 7, 8, 9})
+	if hwm := int(f.trimTail(12)); hwm != 12 {
+		t.Fatalf("exp=12; got=%v", hwm)
+	}
+	if hwm := int(f.trimTail(10)); hwm != 7 {
+		t.Fatalf("exp=7; got=%v", hwm)
+	}
+	if exp := []common.Pgid{3, 4}; !reflect.DeepEqual(exp, f.getFreePageIDs()) {
+		t.Fatalf("exp=%v; got=%v", exp, f.getFreePageIDs())
+	}
+	if f.freed(8) {
+		t.Fatal("expected page 8 to be removed from the cache")
+	}
+	if hwm := int(f.trimTail(5)); hwm != 3 {
+		t.Fatalf("exp=3; got=%v", hwm)
+	}
+	if x := f.free_count(); x != 0 {
+		t.Fatalf("exp=0; got=%v", x)
+	}
+}
+
 // Ensure that a freelist can deserialize from a freelist page.
 func TestFreelist_read(t *testing.T) {
 	// Create a page.
@@ -0,0 +1,400 @@
+package bbolt
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"unsafe"
+
+	berrors "go.etcd.io/bbolt/errors"
+	"go.etcd.io/bbolt/internal/common"
+)
+
+const (
+	// incrementalMagic identifies a stream written by Tx.WriteIncrementalTo.
+	incrementalMagic uint32 = 0xB0171AC7
+
+	// incrementalVersion is the version of the incremental stream format.
+	incrementalVersion uint32 = 1
+
+	// pageTxidGroupSize is the number of consecutive pages sharing the txid
+	// tracked for incremental backups, which bounds the memory used.
+	pageTxidGroupSize = 64
+
+	// pageTxidsSuffix is the suffix of the file the page txids are saved to
+	// when the database is closed.
+	pageTxidsSuffix = "-txids"
+
+	// pageTxidsMagic identifies a file of page txids.
+	pageTxidsMagic uint32 = 0xB0171D5
+)
+
+// pageTxidsHeader starts a file of page txids. It is followed by the txids of
+// the groups of pages, and by the CRC-32 of the file.
+type pageTxidsHeader struct {
+	Magic       uint32
+	_           uint32
+	Txid        uint64 // txid of the database when the file was saved
+	TrackedTxid uint64 // txid the writes of pages are tracked from
+	N           uint64 // number of groups of pages
+}
+
+// incrementalHeader starts every incremental stream. It is followed by a
+// sequence of page records, the last of which always holds the meta page.
+type incrementalHeader struct {
+	Magic    uint32
+	Version  uint32
+	PageSize uint32
+	_        uint32
+	Since    uint64 // oldest base txid the increment can be applied to
+	Txid     uint64 // txid of the database after applying the increment
+	Pgid     uint64 // high water mark after applying the increment
+}
+
+// incrementalRecord precedes the data of (overflow+1) pages in the stream.
+type incrementalRecord struct {
+	Pgid     uint64
+	Overflow uint32
+	_        uint32
+}
+
+// WriteIncrementalTo writes every page reachable from this transaction that
+// was written after the transaction sinceTxid, followed by the freelist and
+// the meta page. The stream can be applied using ApplyIncremental to a copy
+// of the database taken at any transaction between sinceTxid and tx.ID().
+//
+// The writes of pages are tracked by groups of 64 pages, so the stream may
+// also hold unchanged pages written along with changed ones. They're tracked
+// while the database is open, and saved to a file next to the database file
+// when it's closed, so the tracking resumes at the next opening. If the
+// database wasn't closed cleanly, or the file was modified by another
+// program, the tracking restarts from the transaction the database is opened
+// at. Returns ErrPageWritesUntracked if sinceTxid is older than the
+// transaction the tracking started from.
+func (tx *Tx) WriteIncrementalTo(w io.Writer, sinceTxid int) (n int64, err error) {
+	if tx.db == nil {
+		return 0, berrors.ErrTxClosed
+	}
+	since := common.Txid(sinceTxid)
+	if since > tx.meta.Txid() {
+		return 0, fmt.Errorf("since txid (%d) is newer than the transaction (%d)", since, tx.meta.Txid())
+	} else if since < tx.db.trackedTxid {
+		return 0, fmt.Errorf("%w: since txid (%d) is older than the tracking (%d)", berrors.ErrPageWritesUntracked, since, tx.db.trackedTxid)
+	}
+
+	hdr := incrementalHeader{
+		Magic:    incrementalMagic,
+		Version:  incrementalVersion,
+		PageSize: uint32(tx.db.pageSize),
+		Since:    uint64(since),
+		Txid:     uint64(tx.meta.Txid()),
+		Pgid:     uint64(tx.meta.Pgid()),
+	}
+	if err := binary.Write(w, binary.LittleEndian, &hdr); err != nil {
+		return n, fmt.Errorf("header: %s", err)
+	}
+	n += int64(binary.Size(&hdr))
+
+	writePage := func(p *common.Page) error {
+		rec := incrementalRecord{Pgid: uint64(p.Id()), Overflow: p.Overflow()}
+		if err := binary.Write(w, binary.LittleEndian, &rec); err != nil {
+			return err
+		}
+		n += int64(binary.Size(&rec))
+
+		sz := (int(p.Overflow()) + 1) * tx.db.pageSize
+		nn, err := w.Write(common.UnsafeByteSlice(unsafe.Pointer(p), 0, 0, sz))
+		n += int64(nn)
+		return err
+	}
+
+	// Write the changed pages of every bucket.
+	var walkErr error
+	tx.forEachCommittedPage(tx.meta.RootBucket().RootPage(), func(p *common.Page) {
+		if walkErr == nil && tx.db.pageWrittenAfter(p.Id(), since) {
+			// Encrypted pages are copied as they are stored.
+			if err := writePage(tx.db.rawPage(p.Id())); err != nil {
+				walkErr = fmt.Errorf("page %d copy: %s", p.Id(), err)
+			}
+		}
+	})
+	if walkErr != nil {
+		return n, walkErr
+	} else if err := tx.Err(); err != nil {
+		return n, err
+	}
+
+	// Write the freelist if it has been rewritten.
+	if fl := tx.meta.Freelist(); fl != common.PgidNoFreelist && tx.db.pageWrittenAfter(fl, since) {
+		if err := writePage(tx.db.rawPage(fl)); err != nil {
+			return n, fmt.Errorf("freelist copy: %s", err)
+		}
+	}
+
+	// Write the meta page last, so it also marks the end of the stream.
+	buf := make([]byte, tx.db.pageSize)
+	page := (*common.Page)(unsafe.Pointer(&buf[0]))
+	page.SetFlags(common.MetaPageFlag)
+	*page.Meta() = *tx.meta
+	page.SetId(0)
+	page.Meta().SetChecksum(page.Meta().Sum64())
+	if err := writePage(page); err != nil {
+		return n, fmt.Errorf("meta copy: %s", err)
+	}
+
+	return n, nil
+}
+
+// loadPageTxids starts tracking the writes of pages from the current
+// transaction, or from where the previous opening stopped, if it saved its
+// page txids when the database was last modified. The saved txids are
+// removed if the database is writable, as they become stale once it's
+// modified.
+func (db *DB) loadPageTxids() {
+	db.trackedTxid = db.meta().Txid()
+
+	path := db.path + pageTxidsSuffix
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return
+	}
+	if !db.readOnly {
+		_ = os.Remove(path)
+	}
+
+	var hdr pageTxidsHeader
+	hdrSize := binary.Size(&hdr)
+	if len(data) < hdrSize+4 {
+		return
+	}
+	body, sum := data[:len(data)-4], binary.LittleEndian.Uint32(data[len(data)-4:])
+	if crc32.ChecksumIEEE(body) != sum {
+		return
+	}
+	if err := binary.Read(bytes.NewReader(body), binary.LittleEndian, &hdr); err != nil {
+		return
+	}
+	if hdr.Magic != pageTxidsMagic || common.Txid(hdr.Txid) != db.meta().Txid() || uint64(len(body)-hdrSize) != hdr.N*8 {
+		return
+	}
+
+	txids := make([]common.Txid, hdr.N)
+	for i := range txids {
+		txids[i] = common.Txid(binary.LittleEndian.Uint64(body[hdrSize+i*8:]))
+	}
+	db.trackedTxid, db.pageTxids = common.Txid(hdr.TrackedTxid), txids
+}
+
+// savePageTxids saves the page txids to a file next to the database file, so
+// the next opening can resume the tracking.
+func (db *DB) savePageTxids() error {
+	db.txidlock.Lock()
+	defer db.txidlock.Unlock()
+
+	hdr := pageTxidsHeader{
+		Magic:       pageTxidsMagic,
+		Txid:        uint64(db.meta().Txid()),
+		TrackedTxid: uint64(db.trackedTxid),
+		N:           uint64(len(db.pageTxids)),
+	}
+	var buf bytes.Buffer
+	if err := binary.Write(&buf, binary.LittleEndian, &hdr); err != nil {
+		return err
+	}
+	for _, txid := range db.pageTxids {
+		buf.Write(binary.LittleEndian.AppendUint64(nil, uint64(txid)))
+	}
+	buf.Write(binary.LittleEndian.AppendUint32(nil, crc32.ChecksumIEEE(buf.Bytes())))
+
+	info, err := db.file.Stat()
+	if err != nil {
+		return err
+	}
+	path := db.path + pageTxidsSuffix
+	if err := os.WriteFile(path+".tmp", buf.Bytes(), info.Mode().Perm()); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// forEachCommittedPage iterates over every page reachable from the given
+// page, including the pages of nested buckets and the chunks of blobs, as
+// they are stored on disk.
+func (tx *Tx) forEachCommittedPage(id common.Pgid, fn func(*common.Page)) {
+	p := tx.storedPage(id)
+	fn(p)
+
+	switch {
+	case p.IsBranchPage():
+		for i := range p.BranchPageElements() {
+			tx.forEachCommittedPage(p.BranchPageElement(uint16(i)).Pgid(), fn)
+		}
+	case p.IsLeafPage():
+		tx.forEachCommittedLeafChild(p, fn)
+	}
+}
+
+// forEachCommittedLeafChild iterates over the pages referenced by the elements
+// of a leaf page: the index and chunks of blobs, and the pages of nested
+// buckets.
+func (tx *Tx) forEachCommittedLeafChild(p *common.Page, fn func(*common.Page)) {
+	for i := range p.LeafPageElements() {
+		elem := p.LeafPageElement(uint16(i))
+		switch {
+		case elem.IsBlobEntry():
+			if id := common.LoadBlobRef(elem.Value()).Index(); id != 0 {
+				x := tx.storedPage(id)
+				fn(x)
+				for _, cid := range x.BlobChunkIds() {
+					fn(tx.storedPage(cid))
+				}
+			}
+		case elem.IsBucketEntry():
+			// Inline buckets are stored within their parent page, but the
+			// chunks of their blobs aren't.
+			v := elem.Value()
+			if root := common.LoadBucket(v).RootPage(); root != 0 {
+				tx.forEachCommittedPage(root, fn)
+			} else {
+				tx.forEachCommittedLeafChild(common.LoadBucket(v).InlinePage(v, elem.Flags()), fn)
+			}
+		}
+	}
+}
+
+// ApplyIncremental applies a stream written by Tx.WriteIncrementalTo to the
+// database file at the given path, which must not be open. The transaction of
+// the file must lie between the since and target transactions of the stream.
+//
+// The pages are written before the meta page, but the file should still be
+// discarded if an error is returned.
+func ApplyIncremental(path string, r io.Reader) (err error) {
+	var hdr incrementalHeader
+	if err := binary.Read(r, binary.LittleEndian, &hdr); err != nil {
+		return fmt.Errorf("read header: %w", err)
+	}
+	if hdr.Magic != incrementalMagic {
+		return berrors.ErrInvalid
+	} else if hdr.Version != incrementalVersion {
+		return berrors.ErrVersionMismatch
+	}
+
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	// The page txids saved for the file don't cover the applied pages.
+	if err := os.Remove(path + pageTxidsSuffix); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	defer func() {
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}()
+
+	base, err := readIncrementalBase(f, int(hdr.PageSize))
+	if err != nil {
+		return err
+	}
+	if base.PageSize() != hdr.PageSize {
+		return fmt.Errorf("page size mismatch: base %d, increment %d", base.PageSize(), hdr.PageSize)
+	}
+	if txid := uint64(base.Txid()); txid < hdr.Since || txid > hdr.Txid {
+		return fmt.Errorf("base txid (%d) out of range [%d, %d]", txid, hdr.Since, hdr.Txid)
+	}
+
+	pageSize := int64(hdr.PageSize)
+	var meta []byte
+	for {
+		var rec incrementalRecord
+		if err := binary.Read(r, binary.LittleEndian, &rec); err != nil {
+			if errors.Is(err, io.EOF) {
+				err = io.ErrUnexpectedEOF
+			}
+			return fmt.Errorf("read record: %w", err)
+		}
+		if rec.Pgid == 0 && rec.Overflow != 0 {
+			return fmt.Errorf("meta page has overflow %d", rec.Overflow)
+		} else if rec.Pgid != 0 && (rec.Pgid < 2 || rec.Pgid+uint64(rec.Overflow) >= hdr.Pgid) {
+			return fmt.Errorf("page %d out of range [2, %d)", rec.Pgid, hdr.Pgid)
+		}
+
+		buf := make([]byte, (int64(rec.Overflow)+1)*pageSize)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return fmt.Errorf("read page %d: %w", rec.Pgid, err)
+		}
+		if id := common.LoadPage(buf).Id(); uint64(id) != rec.Pgid {
+			return fmt.Errorf("page %d: unexpected page id %d", rec.Pgid, id)
+		}
+
+		if rec.Pgid == 0 {
+			meta = buf
+			break
+		}
+		if _, err := f.WriteAt(buf, int64(rec.Pgid)*pageSize); err != nil {
+			return err
+		}
+	}
+
+	m := common.LoadPageMeta(meta)
+	if err := m.Validate(); err != nil {
+		return fmt.Errorf("meta: %w", err)
+	}
+	if uint64(m.Txid()) != hdr.Txid {
+		return fmt.Errorf("meta txid (%d) doesn't match the header (%d)", m.Txid(), hdr.Txid)
+	}
+
+	// Make sure the file covers the high water mark before the meta pages
+	// start referencing it.
+	if info, err := f.Stat(); err != nil {
+		return err
+	} else if sz := int64(hdr.Pgid) * pageSize; info.Size() < sz {
+		if err := f.Truncate(sz); err != nil {
+			return err
+		}
+	}
+	if err := f.Sync(); err != nil {
+		return err
+	}
+
+	// Write meta 0 and meta 1, with a lower transaction id, like WriteTo.
+	if _, err := f.WriteAt(meta, 0); err != nil {
+		return err
+	}
+	p := common.LoadPage(meta)
+	p.SetId(1)
+	m.DecTxid()
+	m.SetChecksum(m.Sum64())
+	if _, err := f.WriteAt(meta, pageSize); err != nil {
+		return err
+	}
+	return f.Sync()
+}
+
+// readIncrementalBase returns the newest valid meta of the database file.
+func readIncrementalBase(f *os.File, pageSize int) (*common.Meta, error) {
+	var metas []*common.Meta
+	for i := 0; i < 2; i++ {
+		buf := make([]byte, pageSize)
+		if _, err := f.ReadAt(buf, int64(i*pageSize)); err != nil {
+			return nil, fmt.Errorf("read meta %d: %w", i, err)
+		}
+		if m := common.LoadPageMeta(buf); m.Validate() == nil {
+			metas = append(metas, m)
+		}
+	}
+
+	switch {
+	case len(metas) == 0:
+		return nil, berrors.ErrInvalid
+	case len(metas) == 2 && metas[1].Txid() > metas[0].Txid():
+		return metas[1], nil
+	default:
+		return metas[0], nil
+	}
+}
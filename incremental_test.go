@@ -0,0 +1,238 @@
+package bbolt_test
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	bolt "go.etcd.io/bbolt"
+	berrors "go.etcd.io/bbolt/errors"
+	"go.etcd.io/bbolt/internal/btesting"
+)
+
+// Ensure that a chain of increments restores a base copy to the latest state.
+func TestTx_WriteIncrementalTo(t *testing.T) {
+	for _, noFreelistSync := range []bool{false, true} {
+		t.Run(fmt.Sprintf("noFreelistSync=%t", noFreelistSync), func(t *testing.T) {
+			db := btesting.MustCreateDBWithOption(t, &bolt.Options{NoFreelistSync: noFreelistSync})
+			require.NoError(t, db.Fill([]byte("widgets"), 10, 100, keyGen, valueGen("base")))
+
+			// Take the base copy.
+			path := filepath.Join(t.TempDir(), "backup")
+			var since int
+			require.NoError(t, db.View(func(tx *bolt.Tx) error {
+				since = tx.ID()
+				return tx.CopyFile(path, 0600)
+			}))
+
+			var full int64
+			for i := 0; i < 3; i++ {
+				require.NoError(t, db.Fill([]byte("widgets"), 1, 10, keyGen, valueGen(fmt.Sprintf("inc%d", i))))
+				require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+					b, err := tx.CreateBucketIfNotExists([]byte(fmt.Sprintf("nested%d", i)))
+					if err != nil {
+						return err
+					}
+					_, err = b.CreateBucket([]byte("child"))
+					return err
+				}))
+
+				var buf bytes.Buffer
+				require.NoError(t, db.View(func(tx *bolt.Tx) error {
+					if _, err := tx.WriteIncrementalTo(&buf, since); err != nil {
+						return err
+					}
+					since = tx.ID()
+					full = tx.Size()
+					return nil
+				}))
+				require.Less(t, int64(buf.Len()), full)
+				require.NoError(t, bolt.ApplyIncremental(path, &buf))
+			}
+
+			restored, err := bolt.Open(path, 0600, &bolt.Options{ReadOnly: true})
+			require.NoError(t, err)
+			defer restored.Close()
+			require.NoError(t, restored.View(func(tx *bolt.Tx) error {
+				require.Equal(t, since, tx.ID())
+				for err := range tx.Check() {
+					return err
+				}
+				return nil
+			}))
+			require.Equal(t, dumpDB(t, db.DB), dumpDB(t, restored))
+		})
+	}
+}
+
+// Ensure that an increment taken since the current transaction only contains the meta page.
+func TestTx_WriteIncrementalTo_NoChanges(t *testing.T) {
+	db := btesting.MustCreateDB(t)
+	require.NoError(t, db.Fill([]byte("widgets"), 1, 100, keyGen, valueGen("base")))
+
+	var buf bytes.Buffer
+	require.NoError(t, db.View(func(tx *bolt.Tx) error {
+		_, err := tx.WriteIncrementalTo(&buf, tx.ID())
+		return err
+	}))
+	require.Less(t, buf.Len(), 2*db.Info().PageSize)
+}
+
+// Ensure that WriteIncrementalTo rejects a since txid newer than the transaction.
+func TestTx_WriteIncrementalTo_FutureTxid(t *testing.T) {
+	db := btesting.MustCreateDB(t)
+	require.NoError(t, db.View(func(tx *bolt.Tx) error {
+		_, err := tx.WriteIncrementalTo(&bytes.Buffer{}, tx.ID()+1)
+		require.Error(t, err)
+		return nil
+	}))
+}
+
+// Ensure that the writes of pages tracked before the database was reopened
+// are kept, so the increment only holds the changed pages.
+func TestTx_WriteIncrementalTo_Reopen(t *testing.T) {
+	db := btesting.MustCreateDB(t)
+	for i := 0; i < 10; i++ {
+		require.NoError(t, db.Fill([]byte(fmt.Sprintf("widgets%d", i)), 5, 100, keyGen, valueGen("base")))
+	}
+
+	path := filepath.Join(t.TempDir(), "backup")
+	var since int
+	require.NoError(t, db.View(func(tx *bolt.Tx) error {
+		since = tx.ID()
+		return tx.CopyFile(path, 0600)
+	}))
+
+	require.NoError(t, db.Fill([]byte("widgets"), 1, 10, keyGen, valueGen("inc")))
+	db.MustClose()
+	db.MustReopen()
+	require.NoError(t, db.Fill([]byte("widgets"), 1, 10, keyGen, valueGen("reopened")))
+
+	var buf bytes.Buffer
+	require.NoError(t, db.View(func(tx *bolt.Tx) error {
+		_, err := tx.WriteIncrementalTo(&buf, since)
+		return err
+	}))
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	require.Less(t, int64(buf.Len()), info.Size()/2)
+	require.NoError(t, bolt.ApplyIncremental(path, &buf))
+
+	restored, err := bolt.Open(path, 0600, &bolt.Options{ReadOnly: true})
+	require.NoError(t, err)
+	defer restored.Close()
+	require.Equal(t, dumpDB(t, db.DB), dumpDB(t, restored))
+}
+
+// Ensure that WriteIncrementalTo fails when the writes of pages aren't tracked
+// since the given txid, because the page txids weren't saved.
+func TestTx_WriteIncrementalTo_Untracked(t *testing.T) {
+	db := btesting.MustCreateDB(t)
+	require.NoError(t, db.Fill([]byte("widgets"), 5, 100, keyGen, valueGen("base")))
+	var since int
+	require.NoError(t, db.View(func(tx *bolt.Tx) error {
+		since = tx.ID()
+		return nil
+	}))
+	require.NoError(t, db.Fill([]byte("widgets"), 1, 10, keyGen, valueGen("inc")))
+
+	db.MustClose()
+	require.FileExists(t, db.Path()+"-txids")
+	require.NoError(t, os.Remove(db.Path()+"-txids"))
+	db.MustReopen()
+
+	require.NoError(t, db.View(func(tx *bolt.Tx) error {
+		_, err := tx.WriteIncrementalTo(&bytes.Buffer{}, since)
+		require.ErrorIs(t, err, berrors.ErrPageWritesUntracked)
+		_, err = tx.WriteIncrementalTo(&bytes.Buffer{}, tx.ID())
+		require.NoError(t, err)
+		return nil
+	}))
+}
+
+// Ensure that ApplyIncremental rejects a base outside the increment's range.
+func TestApplyIncremental_BaseMismatch(t *testing.T) {
+	db := btesting.MustCreateDB(t)
+	require.NoError(t, db.Fill([]byte("widgets"), 2, 10, keyGen, valueGen("base")))
+
+	path := filepath.Join(t.TempDir(), "backup")
+	require.NoError(t, db.View(func(tx *bolt.Tx) error {
+		return tx.CopyFile(path, 0600)
+	}))
+	require.NoError(t, db.Fill([]byte("widgets"), 2, 10, keyGen, valueGen("inc")))
+
+	// The base is older than the since txid of the increment.
+	var buf bytes.Buffer
+	require.NoError(t, db.View(func(tx *bolt.Tx) error {
+		_, err := tx.WriteIncrementalTo(&buf, tx.ID()-1)
+		return err
+	}))
+	require.ErrorContains(t, bolt.ApplyIncremental(path, &buf), "out of range")
+}
+
+// Ensure that ApplyIncremental rejects a truncated increment.
+func TestApplyIncremental_Truncated(t *testing.T) {
+	db := btesting.MustCreateDB(t)
+	require.NoError(t, db.Fill([]byte("widgets"), 2, 10, keyGen, valueGen("base")))
+
+	path := filepath.Join(t.TempDir(), "backup")
+	var since int
+	require.NoError(t, db.View(func(tx *bolt.Tx) error {
+		since = tx.ID()
+		return tx.CopyFile(path, 0600)
+	}))
+	require.NoError(t, db.Fill([]byte("widgets"), 2, 10, keyGen, valueGen("inc")))
+
+	var buf bytes.Buffer
+	require.NoError(t, db.View(func(tx *bolt.Tx) error {
+		_, err := tx.WriteIncrementalTo(&buf, since)
+		return err
+	}))
+	truncated := bytes.NewReader(buf.Bytes()[:buf.Len()-100])
+	require.Error(t, bolt.ApplyIncremental(path, truncated))
+}
+
+func keyGen(tx int, key int) []byte {
+	return []byte(fmt.Sprintf("%04d-%04d", tx, key))
+}
+
+func valueGen(prefix string) func(tx int, key int) []byte {
+	return func(tx int, key int) []byte {
+		return []byte(fmt.Sprintf("%s-%04d-%04d", prefix, tx, key))
+	}
+}
+
+// dumpDB returns every key/value pair of a database, prefixed with its bucket path.
+func dumpDB(t testing.TB, db *bolt.DB) map[string]string {
+	var m map[string]string
+	require.NoError(t, db.View(func(tx *bolt.Tx) error {
+		m = dumpTx(t, tx)
+		return nil
+	}))
+	return m
+}
+
+// dumpTx returns every key/value pair seen by a transaction, like dumpDB.
+func dumpTx(t testing.TB, tx *bolt.Tx) map[string]string {
+	m := make(map[string]string)
+	var walk func(prefix string, b *bolt.Bucket) error
+	walk = func(prefix string, b *bolt.Bucket) error {
+		return b.ForEach(func(k, v []byte) error {
+			if v == nil {
+				m[prefix+string(k)+"/"] = ""
+				return walk(prefix+string(k)+"/", b.Bucket(k))
+			}
+			m[prefix+string(k)] = string(v)
+			return nil
+		})
+	}
+	require.NoError(t, tx.ForEach(func(name []byte, b *bolt.Bucket) error {
+		m[string(name)+"/"] = ""
+		return walk(string(name)+"/", b)
+	}))
+	return m
+}
@@ -0,0 +1,490 @@
+package bbolt
+
+import (
+	"bytes"
+	"fmt"
+
+	berrors "go.etcd.io/bbolt/errors"
+	"go.etcd.io/bbolt/internal/common"
+)
+
+// DefaultIndexBatchSize is the number of keys indexed by each transaction of
+// DB.RebuildIndex.
+const DefaultIndexBatchSize = 1000
+
+// IndexFunc returns the index keys of a key/value pair of an indexed bucket.
+// It must only depend on its arguments, and must not retain them.
+type IndexFunc func(k, v []byte) [][]byte
+
+// indexDef is the definition of a registered index.
+type indexDef struct {
+	name    []byte   // name of the top level bucket holding the index
+	path    [][]byte // path of the indexed bucket
+	extract IndexFunc
+}
+
+// RegisterIndex registers an index of the bucket at bucketPath, whose index
+// keys are returned by extract. The index is stored in the top level bucket
+// name, as entries mapping each index key to the primary keys. Put, PutReader
+// and Delete maintain the index in the same transaction as the change of the
+// indexed bucket, and deleting the indexed bucket clears the index. Moving
+// buckets doesn't update the index; call RebuildIndex afterwards.
+//
+// The index bucket is created if it doesn't exist, and filled with the keys
+// of the indexed bucket by RebuildIndex. Otherwise, the index is assumed to be
+// up to date. Indexes aren't persisted: they must be registered every time
+// the database is opened, before the indexed bucket is written to.
+//
+// Returns an error if the name or path is blank, if the index bucket would be
+// the indexed bucket or one of its parents, or if an index of that name is
+// registered.
+func (db *DB) RegisterIndex(name []byte, bucketPath [][]byte, extract IndexFunc) error {
+	if len(name) == 0 || len(bucketPath) == 0 || bytes.Equal(name, bucketPath[0]) {
+		return berrors.ErrInvalidIndex
+	}
+	def := &indexDef{name: cloneBytes(name), extract: extract}
+	for _, n := range bucketPath {
+		def.path = append(def.path, cloneBytes(n))
+	}
+
+	db.indexMu.Lock()
+	for _, d := range db.indexes {
+		if bytes.Equal(d.name, def.name) {
+			db.indexMu.Unlock()
+			return berrors.ErrIndexExists
+		}
+	}
+	db.indexes = append(db.indexes, def)
+	db.indexMu.Unlock()
+
+	if db.readOnly {
+		return nil
+	}
+	var created bool
+	if err := db.Update(func(tx *Tx) error {
+		created = tx.Bucket(def.name) == nil
+		return nil
+	}); err != nil {
+		return err
+	}
+	if created {
+		return db.RebuildIndex(name)
+	}
+	return nil
+}
+
+// RebuildIndex clears a registered index and indexes the keys of the indexed
+// bucket again. The keys are indexed in a series of write transactions of
+// DefaultIndexBatchSize keys, so other writers are only blocked for short
+// periods; their changes are indexed as usual meanwhile. Lookups may miss
+// keys until RebuildIndex returns.
+func (db *DB) RebuildIndex(name []byte) error {
+	def := db.index(name)
+	if def == nil {
+		return berrors.ErrIndexNotFound
+	}
+
+	if err := db.Update(func(tx *Tx) error {
+		return tx.clearIndex(def)
+	}); err != nil {
+		return err
+	}
+
+	var after []byte
+	for done := false; !done; {
+		if err := db.Update(func(tx *Tx) error {
+			b := tx.bucketAt(def.path)
+			if b == nil {
+				done = true
+				return nil
+			}
+
+			// Like Put, the expired keys which weren't reaped yet are
+			// indexed, and the index functions are passed the content of
+			// blobs.
+			c := b.Cursor()
+			k, v, flags := c.seek(after)
+			if after != nil && bytes.Equal(k, after) {
+				k, v, flags = c.next()
+			}
+			for n := 0; n < DefaultIndexBatchSize; k, v, flags = c.next() {
+				if k == nil {
+					done = true
+					return nil
+				}
+				if (flags & common.BucketLeafFlag) != 0 {
+					// Nested buckets aren't indexed.
+					continue
+				}
+				v, err := b.indexValue(v, flags)
+				if err != nil {
+					return err
+				}
+				if err := tx.updateIndex(def, k, nil, v); err != nil {
+					return err
+				}
+				after = cloneBytes(k)
+				n++
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// index returns the registered index of the given name, or nil.
+func (db *DB) index(name []byte) *indexDef {
+	db.indexMu.RLock()
+	defer db.indexMu.RUnlock()
+	for _, def := range db.indexes {
+		if bytes.Equal(def.name, name) {
+			return def
+		}
+	}
+	return nil
+}
+
+// indexesOf returns the indexes of the bucket at the given path.
+func (db *DB) indexesOf(path [][]byte) []*indexDef {
+	db.indexMu.RLock()
+	defer db.indexMu.RUnlock()
+	var defs []*indexDef
+	for _, def := range db.indexes {
+		if equalPath(def.path, path) {
+			defs = append(defs, def)
+		}
+	}
+	return defs
+}
+
+// equalPath returns whether the bucket paths a and b are equal.
+func equalPath(a, b [][]byte) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !bytes.Equal(a[i], b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// indexes returns the indexes of b, if the database has any.
+func (b *Bucket) indexes() []*indexDef {
+	if b.parent == nil || !b.tx.db.hasIndexes() {
+		return nil
+	}
+	return b.tx.db.indexesOf(b.path())
+}
+
+// hasIndexes returns whether any index is registered.
+func (db *DB) hasIndexes() bool {
+	db.indexMu.RLock()
+	defer db.indexMu.RUnlock()
+	return len(db.indexes) > 0
+}
+
+// indexValue returns the value v of an existing key with the given flags, as
+// it's passed to the index functions, which is never nil. Blobs are read from
+// their chunks.
+func (b *Bucket) indexValue(v []byte, flags uint32) ([]byte, error) {
+	if v != nil && (flags&common.BlobLeafFlag) != 0 {
+		return b.tx.readBlob(v)
+	}
+	if v = b.value(v, flags); v == nil {
+		return []byte{}, nil
+	}
+	return v, nil
+}
+
+// updateIndexes updates the indexes of b for the change of the value of key
+// from oldValue to newValue. A nil value is a key which doesn't exist.
+func (b *Bucket) updateIndexes(defs []*indexDef, key, oldValue, newValue []byte) error {
+	for _, def := range defs {
+		if err := b.tx.updateIndex(def, key, oldValue, newValue); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// updateIndex updates an index for the change of the value of key from
+// oldValue to newValue, deleting the entries which are no longer returned by
+// the index function, and adding the new ones. The index is unchanged if an
+// entry is too large.
+func (tx *Tx) updateIndex(def *indexDef, key, oldValue, newValue []byte) error {
+	var oldKeys, newKeys map[string]bool
+	if oldValue != nil {
+		oldKeys = indexEntries(def, key, oldValue)
+	}
+	if newValue != nil {
+		newKeys = indexEntries(def, key, newValue)
+	}
+	for k := range newKeys {
+		if len(k) > MaxKeySize {
+			return fmt.Errorf("index %q: %w", def.name, berrors.ErrKeyTooLarge)
+		}
+	}
+
+	ib, err := tx.root.CreateBucketIfNotExists(def.name)
+	if err != nil {
+		return fmt.Errorf("index %q: %w", def.name, err)
+	}
+	for k := range oldKeys {
+		if !newKeys[k] {
+			if err := ib.Delete([]byte(k)); err != nil {
+				return fmt.Errorf("index %q: %w", def.name, err)
+			}
+		}
+	}
+	for k := range newKeys {
+		if !oldKeys[k] {
+			if err := ib.Put([]byte(k), []byte{}); err != nil {
+				return fmt.Errorf("index %q: %w", def.name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// clearIndex empties the bucket of an index.
+func (tx *Tx) clearIndex(def *indexDef) error {
+	if tx.root.Bucket(def.name) != nil {
+		if err := tx.root.DeleteBucket(def.name); err != nil {
+			return err
+		}
+	}
+	_, err := tx.root.CreateBucket(def.name)
+	return err
+}
+
+// clearIndexesOf empties the indexes of the bucket at the given path, which
+// was deleted.
+func (tx *Tx) clearIndexesOf(path [][]byte) error {
+	if !tx.db.hasIndexes() {
+		return nil
+	}
+	for _, def := range tx.db.indexesOf(path) {
+		if err := tx.clearIndex(def); err != nil {
+			return fmt.Errorf("index %q: %w", def.name, err)
+		}
+	}
+	return nil
+}
+
+// indexEntries returns the keys of the index entries of a key/value pair.
+func indexEntries(def *indexDef, k, v []byte) map[string]bool {
+	entries := make(map[string]bool)
+	for _, ik := range def.extract(k, v) {
+		entries[string(encodeIndexEntry(ik, k))] = true
+	}
+	return entries
+}
+
+// The keys of index entries are the index key, with its zero bytes escaped,
+// followed by a terminator and the primary key. They are sorted by index key,
+// then by primary key.
+const (
+	indexEscape     = 0x00
+	indexEscapedNul = 0xff
+	indexTerminator = 0x01
+)
+
+// encodeIndexEntry returns the key of the index entry of an index key and a
+// primary key.
+func encodeIndexEntry(ik, pk []byte) []byte {
+	buf := make([]byte, 0, len(ik)+len(pk)+2)
+	buf = appendIndexKey(buf, ik)
+	buf = append(buf, indexEscape, indexTerminator)
+	return append(buf, pk...)
+}
+
+// appendIndexKey appends the escaped index key to buf.
+func appendIndexKey(buf, ik []byte) []byte {
+	for _, c := range ik {
+		if c == indexEscape {
+			buf = append(buf, indexEscape, indexEscapedNul)
+		} else {
+			buf = append(buf, c)
+		}
+	}
+	return buf
+}
+
+// decodeIndexEntry returns the index key and the primary key of the key of an
+// index entry, or false if it's invalid.
+func decodeIndexEntry(e []byte) (ik, pk []byte, ok bool) {
+	for i := 0; i+1 < len(e); i++ {
+		if e[i] != indexEscape {
+			ik = append(ik, e[i])
+			continue
+		}
+		i++
+		switch e[i] {
+		case indexEscapedNul:
+			ik = append(ik, indexEscape)
+		case indexTerminator:
+			if ik == nil {
+				ik = []byte{}
+			}
+			return ik, e[i+1:], true
+		default:
+			return nil, nil, false
+		}
+	}
+	return nil, nil, false
+}
+
+// Index is a registered index, as seen by a transaction.
+type Index struct {
+	tx  *Tx
+	def *indexDef
+}
+
+// Index returns the registered index of the given name, or nil if there is
+// none.
+func (tx *Tx) Index(name []byte) *Index {
+	def := tx.db.index(name)
+	if def == nil {
+		return nil
+	}
+	return &Index{tx: tx, def: def}
+}
+
+// Cursor creates a cursor over the entries of the index, sorted by index key
+// and then by primary key. The cursor is only valid as long as the
+// transaction is open.
+func (idx *Index) Cursor() *IndexCursor {
+	c := &IndexCursor{}
+	if b := idx.tx.Bucket(idx.def.name); b != nil {
+		c.c = b.Cursor()
+	}
+	return c
+}
+
+// Get returns the primary keys of the given index key.
+func (idx *Index) Get(indexKey []byte) [][]byte {
+	var pks [][]byte
+	c := idx.Cursor()
+	for ik, pk := c.Seek(indexKey); ik != nil && bytes.Equal(ik, indexKey); ik, pk = c.Next() {
+		pks = append(pks, pk)
+	}
+	return pks
+}
+
+// IndexCursor iterates over the entries of an index. Each entry is an index
+// key and a primary key of the indexed bucket.
+type IndexCursor struct {
+	c *Cursor // cursor of the index bucket, nil if it doesn't exist
+}
+
+// First moves the cursor to the first entry and returns it.
+// Returns nil keys if the index is empty.
+func (c *IndexCursor) First() (indexKey, primaryKey []byte) {
+	if c.c == nil {
+		return nil, nil
+	}
+	return c.entry(c.c.First())
+}
+
+// Last moves the cursor to the last entry and returns it.
+// Returns nil keys if the index is empty.
+func (c *IndexCursor) Last() (indexKey, primaryKey []byte) {
+	if c.c == nil {
+		return nil, nil
+	}
+	return c.entry(c.c.Last())
+}
+
+// Next moves the cursor to the next entry and returns it.
+// Returns nil keys if the cursor is at the end of the index.
+func (c *IndexCursor) Next() (indexKey, primaryKey []byte) {
+	if c.c == nil {
+		return nil, nil
+	}
+	return c.entry(c.c.Next())
+}
+
+// Prev moves the cursor to the previous entry and returns it.
+// Returns nil keys if the cursor is at the beginning of the index.
+func (c *IndexCursor) Prev() (indexKey, primaryKey []byte) {
+	if c.c == nil {
+		return nil, nil
+	}
+	return c.entry(c.c.Prev())
+}
+
+// Seek moves the cursor to the first entry whose index key is equal to or
+// greater than seek, and returns it.
+// Returns nil keys if there is no such entry.
+func (c *IndexCursor) Seek(seek []byte) (indexKey, primaryKey []byte) {
+	if c.c == nil {
+		return nil, nil
+	}
+	return c.entry(c.c.Seek(appendIndexKey(nil, seek)))
+}
+
+// entry decodes the index entry at the cursor.
+func (c *IndexCursor) entry(k, _ []byte) ([]byte, []byte) {
+	if k == nil {
+		return nil, nil
+	}
+	ik, pk, ok := decodeIndexEntry(k)
+	common.Assert(ok, "invalid index entry %x", k)
+	return ik, pk
+}
+
+// checkIndexes verifies that every key of the indexed buckets has the index
+// entries returned by the index function, and no other entries.
+func (tx *Tx) checkIndexes(kvStringer KVStringer, ch chan error) {
+	tx.db.indexMu.RLock()
+	defs := append([]*indexDef(nil), tx.db.indexes...)
+	tx.db.indexMu.RUnlock()
+
+	for _, def := range defs {
+		expected := make(map[string]bool)
+		if b := tx.bucketAt(def.path); b != nil {
+			// The expired keys keep their entries until they're reaped.
+			c := b.Cursor()
+			for k, v, flags := c.first(); k != nil; k, v, flags = c.next() {
+				if (flags & common.BucketLeafFlag) != 0 {
+					continue
+				}
+				// The index functions are passed the content of blobs.
+				v, err := b.indexValue(v, flags)
+				if err != nil {
+					ch <- fmt.Errorf("index %q: key %s: %w", def.name, kvStringer.KeyToString(k), err)
+					continue
+				}
+				for e := range indexEntries(def, k, v) {
+					expected[e] = true
+				}
+			}
+		}
+
+		ib := tx.Bucket(def.name)
+		if ib == nil {
+			if len(expected) > 0 {
+				ch <- fmt.Errorf("index %q: bucket not found", def.name)
+			}
+			continue
+		}
+		_ = ib.ForEach(func(k, _ []byte) error {
+			ik, pk, ok := decodeIndexEntry(k)
+			if !ok {
+				ch <- fmt.Errorf("index %q: invalid entry %s", def.name, kvStringer.KeyToString(k))
+			} else if !expected[string(k)] {
+				ch <- fmt.Errorf("index %q: stale entry %s for key %s", def.name, kvStringer.KeyToString(ik), kvStringer.KeyToString(pk))
+			}
+			delete(expected, string(k))
+			return nil
+		})
+		for e := range expected {
+			ik, pk, _ := decodeIndexEntry([]byte(e))
+			ch <- fmt.Errorf("index %q: missing entry %s for key %s", def.name, kvStringer.KeyToString(ik), kvStringer.KeyToString(pk))
+		}
+	}
+}
@@ -0,0 +1,305 @@
+package bbolt_test
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	bolt "go.etcd.io/bbolt"
+	berrors "go.etcd.io/bbolt/errors"
+	"go.etcd.io/bbolt/internal/btesting"
+)
+
+// tagsIndex indexes the comma separated tags of the values.
+func tagsIndex(_, v []byte) [][]byte {
+	if len(v) == 0 {
+		return nil
+	}
+	return bytes.Split(v, []byte(","))
+}
+
+// indexCheckErrors returns the errors of a check of the indexes.
+func indexCheckErrors(t *testing.T, db *bolt.DB) []string {
+	var errs []string
+	require.NoError(t, db.View(func(tx *bolt.Tx) error {
+		for err := range tx.Check(bolt.WithIndexes()) {
+			errs = append(errs, err.Error())
+		}
+		return nil
+	}))
+	return errs
+}
+
+// indexEntries returns the entries of an index, as "indexKey=primaryKey".
+func indexEntries(t *testing.T, db *bolt.DB, name string) []string {
+	var entries []string
+	require.NoError(t, db.View(func(tx *bolt.Tx) error {
+		c := tx.Index([]byte(name)).Cursor()
+		for ik, pk := c.First(); ik != nil; ik, pk = c.Next() {
+			entries = append(entries, fmt.Sprintf("%s=%s", ik, pk))
+		}
+		return nil
+	}))
+	return entries
+}
+
+// Ensure that registering an index backfills it, and that the changes of the
+// indexed bucket update it.
+func TestDB_RegisterIndex(t *testing.T) {
+	db := btesting.MustCreateDB(t)
+	const n = 2*bolt.DefaultIndexBatchSize + 10
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		require.NoError(t, err)
+		for i := 0; i < n; i++ {
+			require.NoError(t, b.Put([]byte(fmt.Sprintf("%05d", i)), []byte(fmt.Sprintf("t%d,all", i%3))))
+		}
+		_, err = b.CreateBucket([]byte("nested"))
+		return err
+	}))
+	require.NoError(t, db.RegisterIndex([]byte("tags"), [][]byte{[]byte("widgets")}, tagsIndex))
+	require.Empty(t, indexCheckErrors(t, db.DB))
+
+	require.NoError(t, db.View(func(tx *bolt.Tx) error {
+		idx := tx.Index([]byte("tags"))
+		require.Len(t, idx.Get([]byte("all")), n)
+		pks := idx.Get([]byte("t1"))
+		require.Len(t, pks, n/3)
+		require.Equal(t, []byte("00001"), pks[0])
+		require.Nil(t, idx.Get([]byte("missing")))
+		require.Nil(t, tx.Index([]byte("missing")))
+		return nil
+	}))
+
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("widgets"))
+		require.NoError(t, b.Put([]byte("00001"), []byte("t2,new")))
+		require.NoError(t, b.Put([]byte("00002"), nil))
+		require.NoError(t, b.Delete([]byte("00003")))
+		c := b.Cursor()
+		c.Seek([]byte("00004"))
+		require.NoError(t, c.Delete())
+		require.NoError(t, b.PutReader([]byte("blob"), strings.NewReader("new,blob")))
+
+		idx := tx.Index([]byte("tags"))
+		require.Equal(t, [][]byte{[]byte("00001"), []byte("blob")}, idx.Get([]byte("new")))
+		require.Len(t, idx.Get([]byte("all")), n-4)
+		return nil
+	}))
+	require.Empty(t, indexCheckErrors(t, db.DB))
+
+	// Changes rolled back don't update the index.
+	tx, err := db.Begin(true)
+	require.NoError(t, err)
+	require.NoError(t, tx.Bucket([]byte("widgets")).Put([]byte("rolledback"), []byte("new")))
+	require.NoError(t, tx.Rollback())
+	require.Len(t, indexEntries(t, db.DB, "tags"), 2*(n-4)+4)
+
+	// The index is kept when the database is reopened.
+	db.MustClose()
+	db.MustReopen()
+	require.NoError(t, db.RegisterIndex([]byte("tags"), [][]byte{[]byte("widgets")}, tagsIndex))
+	require.Empty(t, indexCheckErrors(t, db.DB))
+	require.Len(t, indexEntries(t, db.DB, "tags"), 2*(n-4)+4)
+}
+
+// Ensure that index entries are sorted by index key and primary key, with
+// index keys holding zero bytes or prefixes of each other.
+func TestIndex_Cursor(t *testing.T) {
+	db := btesting.MustCreateDB(t)
+	require.NoError(t, db.RegisterIndex([]byte("tags"), [][]byte{[]byte("widgets")}, tagsIndex))
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		require.NoError(t, err)
+		require.NoError(t, b.Put([]byte("2"), []byte("ab,a\x00,a")))
+		require.NoError(t, b.Put([]byte("1"), []byte("a,a\x00b,b")))
+		return b.Put([]byte("3"), []byte(",b"))
+	}))
+
+	require.Equal(t, []string{
+		"=3",
+		"a=1", "a=2",
+		"a\x00=2",
+		"a\x00b=1",
+		"ab=2",
+		"b=1", "b=3",
+	}, indexEntries(t, db.DB, "tags"))
+
+	require.NoError(t, db.View(func(tx *bolt.Tx) error {
+		c := tx.Index([]byte("tags")).Cursor()
+		ik, pk := c.Seek([]byte("a\x00"))
+		require.Equal(t, []byte("a\x00"), ik)
+		require.Equal(t, []byte("2"), pk)
+		ik, pk = c.Prev()
+		require.Equal(t, []byte("a"), ik)
+		require.Equal(t, []byte("2"), pk)
+		ik, pk = c.Last()
+		require.Equal(t, []byte("b"), ik)
+		require.Equal(t, []byte("3"), pk)
+		ik, _ = c.Seek([]byte("c"))
+		require.Nil(t, ik)
+		return nil
+	}))
+}
+
+// Ensure that deleting the indexed bucket, or one of its parents, clears the
+// index.
+func TestIndex_DeleteBucket(t *testing.T) {
+	db := btesting.MustCreateDB(t)
+	path := [][]byte{[]byte("parent"), []byte("widgets")}
+	require.NoError(t, db.RegisterIndex([]byte("tags"), path, tagsIndex))
+
+	for _, deleted := range []string{"widgets", "parent"} {
+		require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+			parent, err := tx.CreateBucketIfNotExists([]byte("parent"))
+			require.NoError(t, err)
+			b, err := parent.CreateBucket([]byte("widgets"))
+			require.NoError(t, err)
+			return b.Put([]byte("foo"), []byte("a,b"))
+		}))
+		require.Len(t, indexEntries(t, db.DB, "tags"), 2)
+
+		require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+			if deleted == "parent" {
+				return tx.DeleteBucket([]byte("parent"))
+			}
+			return tx.Bucket([]byte("parent")).DeleteBucket([]byte("widgets"))
+		}))
+		require.Empty(t, indexEntries(t, db.DB, "tags"))
+		require.Empty(t, indexCheckErrors(t, db.DB))
+	}
+}
+
+// Ensure that the index check reports stale and missing entries, which
+// RebuildIndex repairs, indexing the content of blobs.
+func TestDB_RebuildIndex(t *testing.T) {
+	db := btesting.MustCreateDB(t)
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		require.NoError(t, err)
+		require.NoError(t, b.Put([]byte("foo"), []byte("a")))
+		require.NoError(t, b.PutReader([]byte("qux"), strings.NewReader("d,e")))
+		return b.Put([]byte("bar"), []byte("b"))
+	}))
+	require.NoError(t, db.RegisterIndex([]byte("tags"), [][]byte{[]byte("widgets")}, tagsIndex))
+	require.Empty(t, indexCheckErrors(t, db.DB))
+	require.Equal(t, []string{"a=foo", "b=bar", "d=qux", "e=qux"}, indexEntries(t, db.DB, "tags"))
+
+	// Change the index bucket directly.
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		ib := tx.Bucket([]byte("tags"))
+		c := ib.Cursor()
+		c.First()
+		require.NoError(t, c.Delete())
+		return ib.Put([]byte("c\x00\x01baz"), []byte{})
+	}))
+	require.ElementsMatch(t, []string{
+		`index "tags": stale entry 63 for key 62617a`,
+		`index "tags": missing entry 61 for key 666f6f`,
+	}, indexCheckErrors(t, db.DB))
+
+	require.NoError(t, db.RebuildIndex([]byte("tags")))
+	require.Empty(t, indexCheckErrors(t, db.DB))
+	require.Equal(t, []string{"a=foo", "b=bar", "d=qux", "e=qux"}, indexEntries(t, db.DB, "tags"))
+}
+
+// Ensure that the expired keys which weren't reaped yet keep their index
+// entries, even when they're hidden, and that the check expects them.
+func TestDB_RegisterIndex_Expired(t *testing.T) {
+	db := btesting.MustCreateDBWithOption(t, &bolt.Options{HideExpired: true})
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		require.NoError(t, err)
+		require.NoError(t, b.Put([]byte("foo"), []byte("a")))
+		return b.PutWithTTL([]byte("bar"), []byte("b"), -time.Minute)
+	}))
+	require.NoError(t, db.RegisterIndex([]byte("tags"), [][]byte{[]byte("widgets")}, tagsIndex))
+	require.Equal(t, []string{"a=foo", "b=bar"}, indexEntries(t, db.DB, "tags"))
+	require.Empty(t, indexCheckErrors(t, db.DB))
+
+	reaped, err := db.ReapExpired()
+	require.NoError(t, err)
+	require.Equal(t, 1, reaped)
+	require.Equal(t, []string{"a=foo"}, indexEntries(t, db.DB, "tags"))
+	require.Empty(t, indexCheckErrors(t, db.DB))
+}
+
+// Ensure that invalid indexes aren't registered.
+func TestDB_RegisterIndex_Errors(t *testing.T) {
+	db := btesting.MustCreateDB(t)
+	path := [][]byte{[]byte("widgets")}
+	require.ErrorIs(t, db.RegisterIndex(nil, path, tagsIndex), berrors.ErrInvalidIndex)
+	require.ErrorIs(t, db.RegisterIndex([]byte("tags"), nil, tagsIndex), berrors.ErrInvalidIndex)
+	require.ErrorIs(t, db.RegisterIndex([]byte("widgets"), [][]byte{[]byte("widgets"), []byte("nested")}, tagsIndex), berrors.ErrInvalidIndex)
+	require.NoError(t, db.RegisterIndex([]byte("tags"), path, tagsIndex))
+	require.ErrorIs(t, db.RegisterIndex([]byte("tags"), path, tagsIndex), berrors.ErrIndexExists)
+	require.ErrorIs(t, db.RebuildIndex([]byte("missing")), berrors.ErrIndexNotFound)
+
+	// Index entries larger than MaxKeySize fail the change.
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		require.NoError(t, err)
+		require.ErrorIs(t, b.Put([]byte("foo"), make([]byte, bolt.MaxKeySize)), berrors.ErrKeyTooLarge)
+		require.Nil(t, b.Get([]byte("foo")))
+		return nil
+	}))
+}
+
+func ExampleDB_RegisterIndex() {
+	// Open the database.
+	db, err := bolt.Open(tempfile(), 0600, nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.Remove(db.Path())
+
+	// Index the users by city, stored after the name.
+	byCity := func(k, v []byte) [][]byte {
+		_, city, _ := bytes.Cut(v, []byte(","))
+		return [][]byte{city}
+	}
+	if err := db.RegisterIndex([]byte("users_by_city"), [][]byte{[]byte("users")}, byCity); err != nil {
+		log.Fatal(err)
+	}
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("users"))
+		if err != nil {
+			return err
+		}
+		for id, user := range map[string]string{"1": "alice,paris", "2": "bob,london", "3": "carol,paris"} {
+			if err := b.Put([]byte(id), []byte(user)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		log.Fatal(err)
+	}
+
+	// Look the users of a city up.
+	if err := db.View(func(tx *bolt.Tx) error {
+		users := tx.Bucket([]byte("users"))
+		for _, id := range tx.Index([]byte("users_by_city")).Get([]byte("paris")) {
+			fmt.Printf("%s: %s\n", id, users.Get(id))
+		}
+		return nil
+	}); err != nil {
+		log.Fatal(err)
+	}
+
+	// Close database to release the file lock.
+	if err := db.Close(); err != nil {
+		log.Fatal(err)
+	}
+
+	// Output:
+	// 1: alice,paris
+	// 3: carol,paris
+}
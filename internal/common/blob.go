@@ -0,0 +1,117 @@
+package common
+
+import (
+	"fmt"
+	"unsafe"
+)
+
+const BlobChunkHeaderSize = int(unsafe.Sizeof(BlobChunk{}))
+
+const BlobIndexHeaderSize = int(unsafe.Sizeof(BlobIndex{}))
+
+const BlobRefSize = int(unsafe.Sizeof(BlobRef{}))
+
+// BlobRef represents the on-file reference to a blob. It's stored as the value
+// of a leaf element which has the BlobLeafFlag set.
+type BlobRef struct {
+	size  uint64 // total size of the blob, in bytes
+	index Pgid   // page id of the blob index, 0 if the blob is empty
+}
+
+func NewBlobRef(size uint64, index Pgid) BlobRef {
+	return BlobRef{size: size, index: index}
+}
+
+func (r *BlobRef) Size() uint64 {
+	return r.size
+}
+
+func (r *BlobRef) Index() Pgid {
+	return r.index
+}
+
+// Bytes returns the blob reference as it's stored in a leaf element.
+func (r *BlobRef) Bytes() []byte {
+	return UnsafeByteSlice(unsafe.Pointer(r), 0, 0, BlobRefSize)
+}
+
+func (r *BlobRef) String() string {
+	return fmt.Sprintf("<blob size=%d,index=%d>", r.size, r.index)
+}
+
+// LoadBlobRef returns the blob reference stored in the leaf value buf.
+func LoadBlobRef(buf []byte) *BlobRef {
+	Assert(len(buf) == BlobRefSize, "invalid blob reference size: %d", len(buf))
+	return (*BlobRef)(unsafe.Pointer(&buf[0]))
+}
+
+// BlobIndex represents the header of a blob index page, which is stored after
+// the page header. The page ids of the chunks follow the header. Every chunk
+// but the last one holds chunkSize bytes.
+type BlobIndex struct {
+	count     uint32 // number of chunks
+	chunkSize uint32 // number of bytes of each chunk but the last one
+}
+
+func (x *BlobIndex) Count() uint32 {
+	return x.count
+}
+
+func (x *BlobIndex) SetCount(v uint32) {
+	x.count = v
+}
+
+func (x *BlobIndex) ChunkSize() uint32 {
+	return x.chunkSize
+}
+
+func (x *BlobIndex) SetChunkSize(v uint32) {
+	x.chunkSize = v
+}
+
+// BlobChunk represents the header of a blob chunk page, which is stored after
+// the page header. The data of the chunk follows the header.
+type BlobChunk struct {
+	size uint32 // number of bytes of data in the chunk
+	_    uint32
+}
+
+func (c *BlobChunk) Size() uint32 {
+	return c.size
+}
+
+func (c *BlobChunk) SetSize(v uint32) {
+	c.size = v
+}
+
+// BlobIndexPageSize returns the size of a blob index page of n chunks,
+// excluding the page trailer.
+func BlobIndexPageSize(n int) int {
+	return int(PageHeaderSize) + BlobIndexHeaderSize + n*int(pgidSize)
+}
+
+// BlobIndex returns the header of a blob index page.
+func (p *Page) BlobIndex() *BlobIndex {
+	return (*BlobIndex)(UnsafeAdd(unsafe.Pointer(p), unsafe.Sizeof(*p)))
+}
+
+// BlobChunkIds returns the page ids of the chunks of a blob index page.
+func (p *Page) BlobChunkIds() []Pgid {
+	n := int(p.BlobIndex().Count())
+	if n == 0 {
+		return nil
+	}
+	data := UnsafeAdd(unsafe.Pointer(p), unsafe.Sizeof(*p)+uintptr(BlobIndexHeaderSize))
+	return unsafe.Slice((*Pgid)(data), n)
+}
+
+// BlobChunk returns the header of a blob chunk page.
+func (p *Page) BlobChunk() *BlobChunk {
+	return (*BlobChunk)(UnsafeAdd(unsafe.Pointer(p), unsafe.Sizeof(*p)))
+}
+
+// BlobData returns the data of a blob chunk page.
+func (p *Page) BlobData() []byte {
+	i := int(PageHeaderSize) + BlobChunkHeaderSize
+	return UnsafeByteSlice(unsafe.Pointer(p), 0, i, i+int(p.BlobChunk().Size()))
+}
@@ -7,6 +7,8 @@ import (
 
 const BucketHeaderSize = int(unsafe.Sizeof(InBucket{}))
 
+const BucketExtHeaderSize = int(unsafe.Sizeof(InBucketExt{}))
+
 // InBucket represents the on-file representation of a bucket.
 // This is stored as the "value" of a bucket key. If the bucket is small enough,
 // then its root page can be stored inline in the "value", after the bucket
@@ -45,10 +47,86 @@ func (b *InBucket) IncSequence() {
 	b.sequence++
 }
 
-func (b *InBucket) InlinePage(v []byte) *Page {
-	return (*Page)(unsafe.Pointer(&v[BucketHeaderSize]))
+// InlinePage returns the inline page stored in the bucket value v, given the
+// flags of the bucket key.
+func (b *InBucket) InlinePage(v []byte, flags uint32) *Page {
+	return (*Page)(unsafe.Pointer(&v[BucketValueHeaderSize(flags)]))
 }
 
 func (b *InBucket) String() string {
 	return fmt.Sprintf("<pgid=%d,seq=%d>", b.root, b.sequence)
 }
+
+// BucketCountedFlag is set in the extended header of counted buckets, whose
+// branch elements hold the number of keys under them, and whose header holds
+// the number of keys of the bucket.
+const BucketCountedFlag = 0x01
+
+// InBucketExt represents the on-file extended header of a bucket, which holds
+// the options of the bucket. It's stored after the InBucket header if the
+// bucket key has the BucketExtLeafFlag set, so buckets without options keep
+// the original layout.
+type InBucketExt struct {
+	codec      uint32 // id of the codec compressing the values, 0 if none
+	flags      uint32 // flags of the options, 0 if none
+	keyCount   uint64 // number of keys of a counted bucket
+	comparator uint64 // hash of the name of the comparator of the keys, 0 if none
+}
+
+func (e *InBucketExt) Codec() uint32 {
+	return e.codec
+}
+
+func (e *InBucketExt) SetCodec(id uint32) {
+	e.codec = id
+}
+
+func (e *InBucketExt) Flags() uint32 {
+	return e.flags
+}
+
+func (e *InBucketExt) SetFlags(flags uint32) {
+	e.flags = flags
+}
+
+// Comparator returns the hash of the name of the comparator of the keys, 0
+// if none.
+func (e *InBucketExt) Comparator() uint64 {
+	return e.comparator
+}
+
+func (e *InBucketExt) SetComparator(id uint64) {
+	e.comparator = id
+}
+
+// IsCounted returns true if the BucketCountedFlag is set.
+func (e *InBucketExt) IsCounted() bool {
+	return e.flags&BucketCountedFlag != 0
+}
+
+func (e *InBucketExt) KeyCount() uint64 {
+	return e.keyCount
+}
+
+func (e *InBucketExt) SetKeyCount(n uint64) {
+	e.keyCount = n
+}
+
+// IsZero returns true if no option is set, in which case the extended
+// header doesn't need to be stored.
+func (e *InBucketExt) IsZero() bool {
+	return *e == InBucketExt{}
+}
+
+func (e *InBucketExt) String() string {
+	return fmt.Sprintf("<codec=%d,flags=%x,keys=%d,comparator=%x>", e.codec, e.flags, e.keyCount, e.comparator)
+}
+
+// BucketValueHeaderSize returns the size of the headers at the start of a
+// bucket value, given the flags of the bucket key.
+func BucketValueHeaderSize(flags uint32) int {
+	if flags&BucketExtLeafFlag != 0 {
+		return BucketHeaderSize + BucketExtHeaderSize
+	}
+	return BucketHeaderSize
+}
@@ -104,6 +104,77 @@ func WriteInodeToPage(inodes Inodes, p *Page) uint32 {
 	return uint32(off)
 }
 
+// WritePrefixedInodeToPage writes the inodes of a leaf like WriteInodeToPage,
+// but stores the prefix shared by their keys once, if that saves space. The
+// inodes must be sorted by key.
+func WritePrefixedInodeToPage(inodes Inodes, p *Page) uint32 {
+	plen := LeafKeyPrefixSize(inodes)
+	if plen == 0 {
+		return WriteInodeToPage(inodes, p)
+	}
+	Assert(p.IsLeafPage(), "write: key prefix on a %s page", p.Typ())
+
+	// Write the prefix after the elements.
+	off := unsafe.Sizeof(*p) + LeafPageElementSize*uintptr(len(inodes))
+	*(*uint16)(UnsafeAdd(unsafe.Pointer(p), off)) = uint16(plen)
+	copy(UnsafeByteSlice(unsafe.Pointer(p), off, KeyPrefixHeaderSize, KeyPrefixHeaderSize+plen), inodes[0].Key())
+	off += uintptr(KeyPrefixHeaderSize + plen)
+
+	for i, item := range inodes {
+		Assert(len(item.Key()) > 0, "write: zero-length inode key")
+
+		// The suffix is empty for a key equal to the prefix.
+		suffix := item.Key()[plen:]
+		sz := len(suffix) + len(item.Value())
+		b := UnsafeByteSlice(unsafe.Pointer(p), off, 0, sz)
+
+		elem := p.LeafPageElement(uint16(i))
+		elem.SetPos(uint32(off - uintptr(unsafe.Pointer(elem)) + uintptr(unsafe.Pointer(p))))
+		elem.SetFlags(item.Flags())
+		elem.SetKsize(uint32(len(inodes)-i)<<keyPrefixShift | uint32(len(suffix)))
+		elem.SetVsize(uint32(len(item.Value())))
+		off += uintptr(sz)
+
+		l := copy(b, suffix)
+		copy(b[l:], item.Value())
+	}
+
+	return uint32(off)
+}
+
+// LeafKeyPrefixSize returns the size of the key prefix which
+// WritePrefixedInodeToPage stores for the sorted inodes of a leaf, or 0 if it
+// writes their keys whole.
+func LeafKeyPrefixSize(inodes Inodes) int {
+	if len(inodes) < 2 {
+		return 0
+	}
+	plen := CommonPrefixSize(inodes[0].Key(), inodes[len(inodes)-1].Key())
+	if KeyPrefixSavedBytes(len(inodes), plen) <= 0 {
+		return 0
+	}
+	return plen
+}
+
+// CommonPrefixSize returns the size of the common prefix of a and b.
+func CommonPrefixSize(a, b []byte) int {
+	n := 0
+	for n < len(a) && n < len(b) && a[n] == b[n] {
+		n++
+	}
+	return n
+}
+
+// KeyPrefixSavedBytes returns the number of bytes saved by storing a key
+// prefix of plen bytes once for n elements, which is negative if it's not
+// worth it, or if there are more than MaxPrefixedElements elements.
+func KeyPrefixSavedBytes(n, plen int) int {
+	if n > MaxPrefixedElements {
+		return -1
+	}
+	return (n-1)*plen - KeyPrefixHeaderSize
+}
+
 func UsedSpaceInPage(inodes Inodes, p *Page) uint32 {
 	off := unsafe.Sizeof(*p) + p.PageElementSize()*uintptr(len(inodes))
 	for _, item := range inodes {
@@ -25,7 +25,9 @@ type Meta struct {
 func (m *Meta) Validate() error {
 	if m.magic != Magic {
 		return errors.ErrInvalid
-	} else if m.version != Version {
+	} else if m.version != Version && m.version != PageChecksumVersion && m.version != EncryptedVersion && m.version != FeatureVersion {
+		return errors.ErrVersionMismatch
+	} else if m.version == FeatureVersion && m.flags&^knownFeatures != 0 {
 		return errors.ErrVersionMismatch
 	} else if m.checksum != m.Sum64() {
 		return errors.ErrChecksum
@@ -96,6 +98,34 @@ func (m *Meta) SetFlags(v uint32) {
 	m.flags = v
 }
 
+// HasFeature returns whether the file uses the feature f, one of the
+// Feature flags.
+func (m *Meta) HasFeature(f uint32) bool {
+	return m.features()&f != 0
+}
+
+// AddFeature flags the feature f as used by the file, upgrading the meta to
+// the FeatureVersion format if needed.
+func (m *Meta) AddFeature(f uint32) {
+	if !m.HasFeature(f) {
+		m.version, m.flags = FeatureVersion, m.features()|f
+	}
+}
+
+// features returns the features used by the file, implied by the version of
+// the formats preceding FeatureVersion.
+func (m *Meta) features() uint32 {
+	switch m.version {
+	case PageChecksumVersion:
+		return FeaturePageChecksums
+	case EncryptedVersion:
+		return FeatureEncryption
+	case FeatureVersion:
+		return m.flags
+	}
+	return 0
+}
+
 func (m *Meta) SetRootBucket(b InBucket) {
 	m.root = b
 }
@@ -2,9 +2,12 @@ package common
 
 import (
 	"fmt"
+	"hash/crc32"
 	"os"
 	"sort"
 	"unsafe"
+
+	"go.etcd.io/bbolt/errors"
 )
 
 const PageHeaderSize = unsafe.Sizeof(Page{})
@@ -15,15 +18,31 @@ const BranchPageElementSize = unsafe.Sizeof(branchPageElement{})
 const LeafPageElementSize = unsafe.Sizeof(leafPageElement{})
 const pgidSize = unsafe.Sizeof(Pgid(0))
 
+// SubtreeKeyCountSize is the size of the number of keys under a branch
+// element of a counted bucket, stored as a big endian uint64.
+const SubtreeKeyCountSize = 8
+
 const (
-	BranchPageFlag   = 0x01
-	LeafPageFlag     = 0x02
-	MetaPageFlag     = 0x04
-	FreelistPageFlag = 0x10
+	BranchPageFlag    = 0x01
+	LeafPageFlag      = 0x02
+	MetaPageFlag      = 0x04
+	FreelistPageFlag  = 0x10
+	BlobPageFlag      = 0x20
+	BlobIndexPageFlag = 0x40
 )
 
 const (
 	BucketLeafFlag = 0x01
+	// BucketExtLeafFlag is set along with BucketLeafFlag on buckets whose
+	// value holds an InBucketExt after the InBucket header.
+	BucketExtLeafFlag = 0x02
+	// BlobLeafFlag is set on values stored in blob chunk pages, whose leaf
+	// value holds a BlobRef.
+	BlobLeafFlag = 0x04
+	// ExpiringLeafFlag is set on values which expire, whose leaf value
+	// starts with the expiry time, in nanoseconds since the Unix epoch, as a
+	// big endian uint64.
+	ExpiringLeafFlag = 0x08
 )
 
 type Pgid uint64
@@ -54,6 +73,10 @@ func (p *Page) Typ() string {
 		return "meta"
 	} else if p.IsFreelistPage() {
 		return "freelist"
+	} else if p.IsBlobPage() {
+		return "blob"
+	} else if p.IsBlobIndexPage() {
+		return "blobindex"
 	}
 	return fmt.Sprintf("unknown<%02x>", p.flags)
 }
@@ -74,6 +97,14 @@ func (p *Page) IsFreelistPage() bool {
 	return p.flags == FreelistPageFlag
 }
 
+func (p *Page) IsBlobPage() bool {
+	return p.flags == BlobPageFlag
+}
+
+func (p *Page) IsBlobIndexPage() bool {
+	return p.flags == BlobIndexPageFlag
+}
+
 // Meta returns a pointer to the metadata section of the page.
 func (p *Page) Meta() *Meta {
 	return (*Meta)(UnsafeAdd(unsafe.Pointer(p), unsafe.Sizeof(*p)))
@@ -85,7 +116,9 @@ func (p *Page) FastCheck(id Pgid) {
 	Assert(p.IsBranchPage() ||
 		p.IsLeafPage() ||
 		p.IsMetaPage() ||
-		p.IsFreelistPage(),
+		p.IsFreelistPage() ||
+		p.IsBlobPage() ||
+		p.IsBlobIndexPage(),
 		"page %v: has unexpected type/flags: %x", p.id, p.flags)
 }
 
@@ -95,6 +128,15 @@ func (p *Page) LeafPageElement(index uint16) *leafPageElement {
 		LeafPageElementSize, int(index)))
 }
 
+// LeafKeyPrefix returns the key prefix shared by the elements of a
+// prefix-compressed leaf page, or nil if its keys are stored whole.
+func (p *Page) LeafKeyPrefix() []byte {
+	if p.count == 0 {
+		return nil
+	}
+	return p.LeafPageElement(0).KeyPrefix()
+}
+
 // LeafPageElements retrieves a list of leaf nodes.
 func (p *Page) LeafPageElements() []leafPageElement {
 	if p.count == 0 {
@@ -199,6 +241,34 @@ func (p *Page) SetOverflow(target uint32) {
 	p.overflow = target
 }
 
+// castagnoli is the table of the CRC-32C checksum used for pages.
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// checksumRegion returns the page content covered by the checksum, and the
+// trailing bytes which store it.
+func (p *Page) checksumRegion(pageSize int) ([]byte, *uint32) {
+	buf := UnsafeByteSlice(unsafe.Pointer(p), 0, 0, (int(p.overflow)+1)*pageSize)
+	n := len(buf) - PageChecksumSize
+	return buf[:n], (*uint32)(unsafe.Pointer(&buf[n]))
+}
+
+// SetChecksum stores the checksum of the page, including its overflow
+// pages, in the last PageChecksumSize bytes of the page.
+func (p *Page) SetChecksum(pageSize int) {
+	data, sum := p.checksumRegion(pageSize)
+	*sum = crc32.Checksum(data, castagnoli)
+}
+
+// VerifyChecksum returns an error wrapping ErrPageChecksum if the checksum
+// stored in the page doesn't match its content.
+func (p *Page) VerifyChecksum(pageSize int) error {
+	data, sum := p.checksumRegion(pageSize)
+	if actual := crc32.Checksum(data, castagnoli); actual != *sum {
+		return fmt.Errorf("page %d: %w (stored: %08x, computed: %08x)", p.id, errors.ErrPageChecksum, *sum, actual)
+	}
+	return nil
+}
+
 func (p *Page) String() string {
 	return fmt.Sprintf("ID: %d, Type: %s, count: %d, overflow: %d", p.id, p.Typ(), p.count, p.overflow)
 }
@@ -245,6 +315,33 @@ func (n *branchPageElement) Key() []byte {
 	return UnsafeByteSlice(unsafe.Pointer(n), 0, int(n.pos), int(n.pos)+int(n.ksize))
 }
 
+// SubtreeKeyCount returns a byte slice of the number of keys under the
+// element, stored after its key on the branch pages of counted buckets.
+func (n *branchPageElement) SubtreeKeyCount() []byte {
+	i := int(n.pos) + int(n.ksize)
+	return UnsafeByteSlice(unsafe.Pointer(n), 0, i, i+SubtreeKeyCountSize)
+}
+
+// On a prefix-compressed leaf page, the prefix shared by the keys is stored
+// once after the elements, as a uint16 size followed by its bytes, and the
+// elements only store the key suffixes. The upper bits of ksize hold the
+// distance from the element to the end of the elements, in elements, and the
+// lower bits the size of the suffix. Keys are at most MaxKeySize long, so the
+// upper bits are zero on other pages.
+const (
+	keyPrefixShift = 16
+	keySuffixMask  = 1<<keyPrefixShift - 1
+
+	// MaxPrefixedElements is the number of elements of a prefix-compressed
+	// leaf page whose distance fits into the upper bits of ksize. Pages with
+	// more elements are written whole.
+	MaxPrefixedElements = 1<<(32-keyPrefixShift) - 1
+
+	// KeyPrefixHeaderSize is the size of the header of the key prefix of a
+	// prefix-compressed leaf page.
+	KeyPrefixHeaderSize = 2
+)
+
 // leafPageElement represents a node on a leaf page.
 type leafPageElement struct {
 	flags uint32
@@ -278,8 +375,10 @@ func (n *leafPageElement) SetPos(v uint32) {
 	n.pos = v
 }
 
+// Ksize returns the number of key bytes stored by the element, which is only
+// the suffix of the key on a prefix-compressed page.
 func (n *leafPageElement) Ksize() uint32 {
-	return n.ksize
+	return n.ksize & keySuffixMask
 }
 
 func (n *leafPageElement) SetKsize(v uint32) {
@@ -294,16 +393,37 @@ func (n *leafPageElement) SetVsize(v uint32) {
 	n.vsize = v
 }
 
-// Key returns a byte slice of the node key.
+// KeyPrefix returns the key prefix shared by the elements of a
+// prefix-compressed page, or nil if the key of the element is stored whole.
+func (n *leafPageElement) KeyPrefix() []byte {
+	d := n.ksize >> keyPrefixShift
+	if d == 0 {
+		return nil
+	}
+	off := uintptr(d) * LeafPageElementSize
+	sz := *(*uint16)(UnsafeAdd(unsafe.Pointer(n), off))
+	return UnsafeByteSlice(unsafe.Pointer(n), off, KeyPrefixHeaderSize, KeyPrefixHeaderSize+int(sz))
+}
+
+// Key returns a byte slice of the node key. The key of an element of a
+// prefix-compressed page is assembled into a new slice.
 func (n *leafPageElement) Key() []byte {
 	i := int(n.pos)
-	j := i + int(n.ksize)
-	return UnsafeByteSlice(unsafe.Pointer(n), 0, i, j)
+	j := i + int(n.Ksize())
+	suffix := UnsafeByteSlice(unsafe.Pointer(n), 0, i, j)
+	prefix := n.KeyPrefix()
+	if prefix == nil {
+		return suffix
+	}
+	key := make([]byte, len(prefix)+len(suffix))
+	copy(key, prefix)
+	copy(key[len(prefix):], suffix)
+	return key
 }
 
 // Value returns a byte slice of the node value.
 func (n *leafPageElement) Value() []byte {
-	i := int(n.pos) + int(n.ksize)
+	i := int(n.pos) + int(n.Ksize())
 	j := i + int(n.vsize)
 	return UnsafeByteSlice(unsafe.Pointer(n), 0, i, j)
 }
@@ -312,6 +432,11 @@ func (n *leafPageElement) IsBucketEntry() bool {
 	return n.flags&uint32(BucketLeafFlag) != 0
 }
 
+// IsBlobEntry returns true if the value of the element is a BlobRef.
+func (n *leafPageElement) IsBlobEntry() bool {
+	return n.flags&uint32(BlobLeafFlag) != 0
+}
+
 func (n *leafPageElement) Bucket() *InBucket {
 	if n.IsBucketEntry() {
 		return LoadBucket(n.Value())
@@ -320,6 +445,15 @@ func (n *leafPageElement) Bucket() *InBucket {
 	}
 }
 
+// BucketExt returns the extended header of the bucket, or nil if the element
+// isn't a bucket or has no extended header.
+func (n *leafPageElement) BucketExt() *InBucketExt {
+	if n.IsBucketEntry() {
+		return LoadBucketExt(n.Value(), n.flags)
+	}
+	return nil
+}
+
 // PageInfo represents human readable information about a page.
 type PageInfo struct {
 	ID            int
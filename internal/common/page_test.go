@@ -1,10 +1,14 @@
 package common
 
 import (
+	"errors"
 	"reflect"
 	"sort"
 	"testing"
 	"testing/quick"
+	"unsafe"
+
+	berrors "go.etcd.io/bbolt/errors"
 )
 
 // Ensure that the page type can be returned in human readable format.
@@ -21,6 +25,12 @@ func TestPage_typ(t *testing.T) {
 	if typ := (&Page{flags: FreelistPageFlag}).Typ(); typ != "freelist" {
 		t.Fatalf("exp=freelist; got=%v", typ)
 	}
+	if typ := (&Page{flags: BlobPageFlag}).Typ(); typ != "blob" {
+		t.Fatalf("exp=blob; got=%v", typ)
+	}
+	if typ := (&Page{flags: BlobIndexPageFlag}).Typ(); typ != "blobindex" {
+		t.Fatalf("exp=blobindex; got=%v", typ)
+	}
 	if typ := (&Page{flags: 20000}).Typ(); typ != "unknown<4e20>" {
 		t.Fatalf("exp=unknown<4e20>; got=%v", typ)
 	}
@@ -31,6 +41,92 @@ func TestPage_dump(t *testing.T) {
 	(&Page{id: 256}).hexdump(16)
 }
 
+// Ensure that a page checksum covers the overflow pages and detects corruption.
+func TestPage_Checksum(t *testing.T) {
+	const pageSize = 512
+	buf := make([]byte, 2*pageSize)
+	p := (*Page)(unsafe.Pointer(&buf[0]))
+	p.SetId(7)
+	p.SetFlags(LeafPageFlag)
+	p.SetOverflow(1)
+	copy(buf[PageHeaderSize:], "hello")
+
+	p.SetChecksum(pageSize)
+	if err := p.VerifyChecksum(pageSize); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	buf[pageSize+10] ^= 0xff
+	err := p.VerifyChecksum(pageSize)
+	if !errors.Is(err, berrors.ErrPageChecksum) {
+		t.Fatalf("exp=ErrPageChecksum; got=%v", err)
+	}
+	if got := err.Error(); got[:7] != "page 7:" {
+		t.Fatalf("exp page id in error; got=%v", got)
+	}
+}
+
+// Ensure that a prefix-compressed leaf page stores the common key prefix once,
+// and returns the whole keys and the values.
+func TestPage_WritePrefixedInodeToPage(t *testing.T) {
+	keys := []string{"tenant/1/object", "tenant/1/object/1", "tenant/1/object/2", "tenant/1/object/30"}
+	inodes := make(Inodes, len(keys))
+	for i, k := range keys {
+		inodes[i].SetFlags(uint32(i % 2))
+		inodes[i].SetKey([]byte(k))
+		inodes[i].SetValue([]byte(k[len(k)-1:]))
+	}
+
+	buf := make([]byte, 4096)
+	p := (*Page)(unsafe.Pointer(&buf[0]))
+	p.SetFlags(LeafPageFlag)
+	p.SetCount(uint16(len(inodes)))
+	n := WritePrefixedInodeToPage(inodes, p)
+
+	plen := len("tenant/1/object")
+	if got := string(p.LeafKeyPrefix()); got != keys[0] {
+		t.Fatalf("exp prefix=%q; got=%q", keys[0], got)
+	}
+	if exp := UsedSpaceInPage(inodes, p) - uint32(KeyPrefixSavedBytes(len(inodes), plen)); n != exp {
+		t.Fatalf("exp size=%d; got=%d", exp, n)
+	}
+	for i, k := range keys {
+		e := p.LeafPageElement(uint16(i))
+		if string(e.Key()) != k || string(e.Value()) != k[len(k)-1:] || e.Flags() != uint32(i%2) {
+			t.Fatalf("element %d: got key=%q value=%q flags=%d", i, e.Key(), e.Value(), e.Flags())
+		}
+		if e.Ksize() != uint32(len(k)-plen) {
+			t.Fatalf("element %d: exp ksize=%d; got=%d", i, len(k)-plen, e.Ksize())
+		}
+	}
+
+	// Keys without a long enough common prefix are stored whole.
+	inodes[0].SetKey([]byte("a"))
+	for i := range buf {
+		buf[i] = 0
+	}
+	p.SetFlags(LeafPageFlag)
+	p.SetCount(uint16(len(inodes)))
+	if n := WritePrefixedInodeToPage(inodes, p); n != UsedSpaceInPage(inodes, p) {
+		t.Fatalf("exp size=%d; got=%d", UsedSpaceInPage(inodes, p), n)
+	}
+	if prefix := p.LeafKeyPrefix(); prefix != nil {
+		t.Fatalf("unexpected prefix: %q", prefix)
+	}
+	if got := string(p.LeafPageElement(0).Key()); got != "a" {
+		t.Fatalf("exp key=a; got=%q", got)
+	}
+
+	// The distance of the elements to the end of the elements must fit into
+	// the upper bits of ksize.
+	if saved := KeyPrefixSavedBytes(MaxPrefixedElements, 10); saved <= 0 {
+		t.Fatalf("exp saved bytes for %d elements; got=%d", MaxPrefixedElements, saved)
+	}
+	if saved := KeyPrefixSavedBytes(MaxPrefixedElements+1, 10); saved > 0 {
+		t.Fatalf("exp no saved bytes for %d elements; got=%d", MaxPrefixedElements+1, saved)
+	}
+}
+
 func TestPgids_merge(t *testing.T) {
 	a := Pgids{4, 5, 6, 10, 11, 12, 13, 27}
 	b := Pgids{1, 3, 8, 9, 25, 30}
@@ -12,6 +12,58 @@ const MaxMmapStep = 1 << 30 // 1GB
 // Version represents the data file format version.
 const Version uint32 = 2
 
+// PageChecksumVersion is the data file format version in which every page
+// ends with a checksum of its content.
+const PageChecksumVersion uint32 = 3
+
+// PageChecksumSize is the size of the checksum at the end of every page in
+// the PageChecksumVersion format.
+const PageChecksumSize = 4
+
+// EncryptedVersion is the data file format version in which every page but
+// the meta pages is encrypted, and ends with the nonce and the authentication
+// tag of its encrypted content.
+const EncryptedVersion uint32 = 4
+
+// FeatureVersion is the data file format version in which the meta page
+// flags the features used by the file, so that the versions of bbolt which
+// don't know about them refuse to open it. The PageChecksumVersion and
+// EncryptedVersion formats are upgraded to it when another feature is used.
+const FeatureVersion uint32 = 5
+
+// The features flagged by the meta page of the FeatureVersion format.
+const (
+	// FeaturePageChecksums is the format of PageChecksumVersion.
+	FeaturePageChecksums uint32 = 1 << iota
+	// FeatureEncryption is the format of EncryptedVersion.
+	FeatureEncryption
+	// FeaturePrefixCompression is set once prefix-compressed leaf pages
+	// may have been written.
+	FeaturePrefixCompression
+	// FeatureBlobs is set once blob values, and their chunk and index
+	// pages, may have been written.
+	FeatureBlobs
+	// FeatureBucketOptions is set once buckets with an extended header,
+	// which moves their inline page, may have been written.
+	FeatureBucketOptions
+	// FeatureExpiringKeys is set once expiring values, prefixed with their
+	// expiry time, and the expiry bucket may have been written.
+	FeatureExpiringKeys
+	// FeatureCountedBuckets is set once counted buckets, whose branch
+	// elements hold the number of keys under them, may have been written.
+	FeatureCountedBuckets
+	// FeatureComparators is set once buckets whose keys are ordered by a
+	// Comparator may have been written.
+	FeatureComparators
+
+	knownFeatures = FeaturePageChecksums | FeatureEncryption | FeaturePrefixCompression | FeatureBlobs |
+		FeatureBucketOptions | FeatureExpiringKeys | FeatureCountedBuckets | FeatureComparators
+)
+
+// ChecksumSampleRate is the number of page reads per verified checksum when
+// checksums are sampled.
+const ChecksumSampleRate = 64
+
 // Magic represents a marker value to indicate that a file is a Bolt DB.
 const Magic uint32 = 0xED0CDAED
 
@@ -31,6 +83,12 @@ const (
 	DefaultMaxBatchSize  int = 1000
 	DefaultMaxBatchDelay     = 10 * time.Millisecond
 	DefaultAllocSize         = 16 * 1024 * 1024
+
+	DefaultWALCheckpointSize = 16 * 1024 * 1024
+
+	DefaultEncryptionCacheSize = 4096
+
+	DefaultWatchBufferSize = 1024
 )
 
 // DefaultPageSize is the default page size for db which is set to the OS page size.
@@ -11,6 +11,15 @@ func LoadBucket(buf []byte) *InBucket {
 	return (*InBucket)(unsafe.Pointer(&buf[0]))
 }
 
+// LoadBucketExt returns the extended header of the bucket value buf, or nil
+// if the bucket key flags don't have the BucketExtLeafFlag set.
+func LoadBucketExt(buf []byte, flags uint32) *InBucketExt {
+	if flags&BucketExtLeafFlag == 0 {
+		return nil
+	}
+	return (*InBucketExt)(unsafe.Pointer(&buf[BucketHeaderSize]))
+}
+
 func LoadPage(buf []byte) *Page {
 	return (*Page)(unsafe.Pointer(&buf[0]))
 }
@@ -79,6 +79,15 @@ func WritePage(path string, pageBuf []byte) error {
 	if expectedLen != uint64(len(pageBuf)) {
 		return fmt.Errorf("WritePage: len(buf):%d != pageSize*(overflow+1):%d", len(pageBuf), expectedLen)
 	}
+	if !page.IsMetaPage() {
+		trailer, err := PageTrailerSize(path)
+		if err != nil {
+			return err
+		}
+		if trailer > 0 {
+			page.SetChecksum(int(pageSize))
+		}
+	}
 	f, err := os.OpenFile(path, os.O_WRONLY, 0)
 	if err != nil {
 		return err
@@ -88,6 +97,20 @@ func WritePage(path string, pageBuf []byte) error {
 	return err
 }
 
+// PageTrailerSize returns the number of bytes reserved at the end of every
+// Page, which is the size of the Page checksum if the file has them.
+// This is not transactionally safe.
+func PageTrailerSize(path string) (int, error) {
+	_, buf, err := ReadPage(path, 0)
+	if err != nil {
+		return 0, err
+	}
+	if common.LoadPageMeta(buf).HasFeature(common.FeaturePageChecksums) {
+		return common.PageChecksumSize, nil
+	}
+	return 0, nil
+}
+
 // ReadPageAndHWMSize reads Page size and HWM (id of the last+1 Page).
 // This is not transactionally safe.
 func ReadPageAndHWMSize(path string) (uint64, common.Pgid, error) {
@@ -71,7 +71,10 @@ func ClearPageElements(path string, pgId common.Pgid, start, end int, abandonFre
 	var (
 		dataWritten uint32
 	)
-	if end == int(p.Count()) || end == -1 {
+	// The key prefix of a prefix-compressed page is stored after the
+	// elements, so the page is always rewritten.
+	prefixed := p.IsLeafPage() && p.LeafKeyPrefix() != nil
+	if (end == int(p.Count()) || end == -1) && !prefixed {
 		inodes := common.ReadInodeFromPage(p)
 		inodes = inodes[:start]
 
@@ -80,17 +83,34 @@ func ClearPageElements(path string, pgId common.Pgid, start, end int, abandonFre
 		// the data size which will be kept.
 		dataWritten = common.UsedSpaceInPage(inodes, p)
 	} else {
+		if end == -1 {
+			end = elementCnt
+		}
 		inodes := common.ReadInodeFromPage(p)
 		inodes = append(inodes[:start], inodes[end:]...)
 
 		p.SetCount(uint16(len(inodes)))
-		dataWritten = common.WriteInodeToPage(inodes, p)
+		if prefixed {
+			// The prefix may grow, moving the values of the page forward,
+			// so they are copied first.
+			for i := range inodes {
+				inodes[i].SetValue(append([]byte(nil), inodes[i].Value()...))
+			}
+			dataWritten = common.WritePrefixedInodeToPage(inodes, p)
+		} else {
+			dataWritten = common.WriteInodeToPage(inodes, p)
+		}
 	}
 
 	pageSize, _, err := guts_cli.ReadPageAndHWMSize(path)
 	if err != nil {
 		return false, fmt.Errorf("ReadPageAndHWMSize failed: %w", err)
 	}
+	trailer, err := guts_cli.PageTrailerSize(path)
+	if err != nil {
+		return false, fmt.Errorf("PageTrailerSize failed: %w", err)
+	}
+	dataWritten += uint32(trailer)
 	if dataWritten%uint32(pageSize) == 0 {
 		p.SetOverflow(dataWritten/uint32(pageSize) - 1)
 	} else {
@@ -0,0 +1,110 @@
+//go:build go1.23
+
+package bbolt
+
+import (
+	"iter"
+
+	"go.etcd.io/bbolt/internal/common"
+)
+
+// All returns an iterator over the keys and values of the bucket of the
+// cursor, from the first key, like a loop of First and Next. When the loop
+// is exited early, the cursor remains on the last key returned, so Next and
+// Prev move from it.
+func (c *Cursor) All() iter.Seq2[[]byte, []byte] {
+	return func(yield func([]byte, []byte) bool) {
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			if !yield(k, v) {
+				return
+			}
+		}
+	}
+}
+
+// Backward returns an iterator over the keys and values of the bucket of the
+// cursor, from the last key, like a loop of Last and Prev. When the loop is
+// exited early, the cursor remains on the last key returned.
+func (c *Cursor) Backward() iter.Seq2[[]byte, []byte] {
+	return func(yield func([]byte, []byte) bool) {
+		for k, v := c.Last(); k != nil; k, v = c.Prev() {
+			if !yield(k, v) {
+				return
+			}
+		}
+	}
+}
+
+// All returns an iterator over the remaining keys and values of the range,
+// like a loop of Next.
+func (it *RangeIterator) All() iter.Seq2[[]byte, []byte] {
+	return func(yield func([]byte, []byte) bool) {
+		for k, v := it.Next(); k != nil; k, v = it.Next() {
+			if !yield(k, v) {
+				return
+			}
+		}
+	}
+}
+
+// All returns an iterator over the keys and values of the bucket, in
+// ascending order. Like with a Cursor, the value of a nested bucket is nil.
+// Each loop uses a new cursor.
+func (b *Bucket) All() iter.Seq2[[]byte, []byte] {
+	return func(yield func([]byte, []byte) bool) {
+		b.Cursor().All()(yield)
+	}
+}
+
+// Backward returns an iterator over the keys and values of the bucket, in
+// descending order.
+func (b *Bucket) Backward() iter.Seq2[[]byte, []byte] {
+	return func(yield func([]byte, []byte) bool) {
+		b.Cursor().Backward()(yield)
+	}
+}
+
+// Prefix returns an iterator over the keys with a prefix and their values, in
+// ascending order. Use Range with RangeOptions.Prefix for other bounds and
+// directions.
+func (b *Bucket) Prefix(prefix []byte) iter.Seq2[[]byte, []byte] {
+	return func(yield func([]byte, []byte) bool) {
+		b.Range(nil, nil, &RangeOptions{Prefix: prefix}).All()(yield)
+	}
+}
+
+// Buckets returns an iterator over the buckets of the transaction, including
+// the nested ones, depth-first in key order. It yields the path of each
+// bucket, the names of its parents followed by its name, and the bucket,
+// which is nil if its comparator isn't registered. Like with Tx.Walk, the
+// buckets under a bucket whose comparator isn't registered are skipped.
+func (tx *Tx) Buckets() iter.Seq2[[][]byte, *Bucket] {
+	return func(yield func([][]byte, *Bucket) bool) {
+		walkBuckets(&tx.root, nil, yield)
+	}
+}
+
+// walkBuckets yields the buckets nested in b, whose path is given, and the
+// buckets under them. It returns false once yield returns false.
+func walkBuckets(b *Bucket, path [][]byte, yield func([][]byte, *Bucket) bool) bool {
+	c := b.Cursor()
+	for k, _, flags := c.first(); k != nil; k, _, flags = c.next() {
+		if (flags&common.BucketLeafFlag) == 0 || b.reservedBucket(k) {
+			continue
+		}
+		// The keys of a bucket whose comparator isn't registered can't be
+		// read in order.
+		child := b.nestedBucket(k)
+		if !child.comparatorKnown() {
+			child = nil
+		}
+		childPath := append(path[:len(path):len(path)], k)
+		if !yield(childPath, child) {
+			return false
+		}
+		if child != nil && !walkBuckets(child, childPath, yield) {
+			return false
+		}
+	}
+	return true
+}
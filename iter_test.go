@@ -0,0 +1,171 @@
+//go:build go1.23
+
+package bbolt_test
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	bolt "go.etcd.io/bbolt"
+	"go.etcd.io/bbolt/internal/btesting"
+)
+
+// Ensure that the iterators of buckets and cursors return the same keys as
+// the cursor loops, and stop cleanly on break.
+func TestBucket_Iterators(t *testing.T) {
+	db := btesting.MustCreateDB(t)
+	var keys []string
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		require.NoError(t, err)
+		for i := 0; i < 3000; i++ {
+			k := fmt.Sprintf("%c%04d", 'a'+i%3, i)
+			require.NoError(t, b.Put([]byte(k), []byte("v"+k)))
+		}
+		_, err = b.CreateBucket([]byte("nested"))
+		require.NoError(t, err)
+		keys, _ = cursorKeys(b)
+		return nil
+	}))
+
+	require.NoError(t, db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("widgets"))
+		var forward, backward, prefixed, ranged []string
+		for k, v := range b.All() {
+			if string(k) == "nested" {
+				require.Nil(t, v)
+			} else {
+				require.Equal(t, "v"+string(k), string(v))
+			}
+			forward = append(forward, string(k))
+		}
+		for k := range b.Backward() {
+			backward = append(backward, string(k))
+		}
+		for k := range b.Prefix([]byte("b")) {
+			prefixed = append(prefixed, string(k))
+		}
+		for k := range b.Range([]byte("b"), []byte("c"), &bolt.RangeOptions{Reverse: true}).All() {
+			ranged = append(ranged, string(k))
+		}
+		require.Equal(t, keys, forward)
+		require.Len(t, backward, len(keys))
+		require.Equal(t, keys[len(keys)-1], backward[0])
+		require.Len(t, prefixed, 1000)
+		require.True(t, strings.HasPrefix(prefixed[999], "b"))
+		require.Len(t, ranged, 1000)
+		require.Equal(t, prefixed[999], ranged[0])
+
+		// A cursor remains on the last key returned before a break.
+		c := b.Cursor()
+		var n int
+		for k := range c.All() {
+			if n++; n == 1500 {
+				require.Equal(t, keys[1499], string(k))
+				break
+			}
+		}
+		k, _ := c.Next()
+		require.Equal(t, keys[1500], string(k))
+		for k := range c.Backward() {
+			require.Equal(t, keys[len(keys)-1], string(k))
+			break
+		}
+		k, _ = c.Prev()
+		require.Equal(t, keys[len(keys)-2], string(k))
+		return nil
+	}))
+}
+
+// Ensure that Tx.Buckets walks the nested buckets depth-first, and stops on
+// break.
+func TestTx_Buckets(t *testing.T) {
+	db := btesting.MustCreateDB(t)
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		for _, path := range [][]string{{"a", "x", "1"}, {"a", "y"}, {"b"}, {"a", "x", "2"}} {
+			b, err := tx.CreateBucketIfNotExists([]byte(path[0]))
+			require.NoError(t, err)
+			for _, name := range path[1:] {
+				b, err = b.CreateBucketIfNotExists([]byte(name))
+				require.NoError(t, err)
+			}
+			require.NoError(t, b.Put([]byte("key"), []byte("value")))
+		}
+		return nil
+	}))
+
+	require.NoError(t, db.View(func(tx *bolt.Tx) error {
+		var paths []string
+		for path, b := range tx.Buckets() {
+			require.NotNil(t, b)
+			var names []string
+			for _, name := range path {
+				names = append(names, string(name))
+			}
+			paths = append(paths, strings.Join(names, "/"))
+		}
+		require.Equal(t, []string{"a", "a/x", "a/x/1", "a/x/2", "a/y", "b"}, paths)
+
+		paths = nil
+		for path := range tx.Buckets() {
+			if len(path) == 3 {
+				break
+			}
+			paths = append(paths, string(path[len(path)-1]))
+		}
+		require.Equal(t, []string{"a", "x"}, paths)
+		return nil
+	}))
+}
+
+func ExampleBucket_All() {
+	// Open the database.
+	db, err := bolt.Open(tempfile(), 0600, nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.Remove(db.Path())
+
+	// Insert data into a bucket.
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("animals"))
+		if err != nil {
+			return err
+		}
+		for _, animal := range []string{"dog", "cat", "liger", "cow"} {
+			if err := b.Put([]byte(animal), []byte("yes")); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		log.Fatal(err)
+	}
+
+	// Iterate over the keys until the first one starting with "d".
+	if err := db.View(func(tx *bolt.Tx) error {
+		for k := range tx.Bucket([]byte("animals")).All() {
+			if k[0] == 'd' {
+				break
+			}
+			fmt.Printf("%s\n", k)
+		}
+		return nil
+	}); err != nil {
+		log.Fatal(err)
+	}
+
+	// Close database to release the file lock.
+	if err := db.Close(); err != nil {
+		log.Fatal(err)
+	}
+
+	// Output:
+	// cat
+	// cow
+}
@@ -2,6 +2,7 @@ package bbolt
 
 import (
 	"bytes"
+	"encoding/binary"
 	"fmt"
 	"sort"
 
@@ -44,13 +45,16 @@ func (n *node) size() int {
 		item := &n.inodes[i]
 		sz += elsz + uintptr(len(item.Key())) + uintptr(len(item.Value()))
 	}
-	return int(sz)
+	return int(sz) - n.keyPrefixSavedBytes()
 }
 
 // sizeLessThan returns true if the node is less than a given size.
 // This is an optimization to avoid calculating a large node when we only need
 // to know if it fits inside a certain page size.
 func (n *node) sizeLessThan(v uintptr) bool {
+	if n.prefixCompression() {
+		return uintptr(n.size()) < v
+	}
 	sz, elsz := common.PageHeaderSize, n.pageElementSize()
 	for i := 0; i < len(n.inodes); i++ {
 		item := &n.inodes[i]
@@ -62,6 +66,24 @@ func (n *node) sizeLessThan(v uintptr) bool {
 	return true
 }
 
+// prefixCompression returns true if the node is written as a
+// prefix-compressed leaf page. The root of a new bucket has no bucket yet.
+func (n *node) prefixCompression() bool {
+	return n.isLeaf && n.bucket != nil && n.bucket.prefixCompression()
+}
+
+// keyPrefixSavedBytes returns the number of bytes saved by writing the node as
+// a prefix-compressed leaf page.
+func (n *node) keyPrefixSavedBytes() int {
+	if !n.prefixCompression() {
+		return 0
+	}
+	if plen := common.LeafKeyPrefixSize(n.inodes); plen > 0 {
+		return common.KeyPrefixSavedBytes(len(n.inodes), plen)
+	}
+	return 0
+}
+
 // pageElementSize returns the size of each page element based on the type of node.
 func (n *node) pageElementSize() uintptr {
 	if n.isLeaf {
@@ -80,7 +102,7 @@ func (n *node) childAt(index int) *node {
 
 // childIndex returns the index of a given child node.
 func (n *node) childIndex(child *node) int {
-	index := sort.Search(len(n.inodes), func(i int) bool { return bytes.Compare(n.inodes[i].Key(), child.key) != -1 })
+	index := sort.Search(len(n.inodes), func(i int) bool { return n.bucket.compare(n.inodes[i].Key(), child.key) != -1 })
 	return index
 }
 
@@ -124,13 +146,16 @@ func (n *node) put(oldKey, newKey, value []byte, pgId common.Pgid, flags uint32)
 	}
 
 	// Find insertion index.
-	index := sort.Search(len(n.inodes), func(i int) bool { return bytes.Compare(n.inodes[i].Key(), oldKey) != -1 })
+	index := sort.Search(len(n.inodes), func(i int) bool { return n.bucket.compare(n.inodes[i].Key(), oldKey) != -1 })
 
 	// Add capacity and shift nodes if we don't have an exact match and need to insert.
 	exact := len(n.inodes) > 0 && index < len(n.inodes) && bytes.Equal(n.inodes[index].Key(), oldKey)
 	if !exact {
 		n.inodes = append(n.inodes, common.Inode{})
 		copy(n.inodes[index+1:], n.inodes[index:])
+		if n.isLeaf {
+			n.bucket.addKeyCount(1)
+		}
 	}
 
 	inode := &n.inodes[index]
@@ -144,7 +169,7 @@ func (n *node) put(oldKey, newKey, value []byte, pgId common.Pgid, flags uint32)
 // del removes a key from the node.
 func (n *node) del(key []byte) {
 	// Find index of key.
-	index := sort.Search(len(n.inodes), func(i int) bool { return bytes.Compare(n.inodes[i].Key(), key) != -1 })
+	index := sort.Search(len(n.inodes), func(i int) bool { return n.bucket.compare(n.inodes[i].Key(), key) != -1 })
 
 	// Exit if the key isn't found.
 	if index >= len(n.inodes) || !bytes.Equal(n.inodes[index].Key(), key) {
@@ -153,6 +178,9 @@ func (n *node) del(key []byte) {
 
 	// Delete inode from the node.
 	n.inodes = append(n.inodes[:index], n.inodes[index+1:]...)
+	if n.isLeaf {
+		n.bucket.addKeyCount(-1)
+	}
 
 	// Mark the node as needing rebalancing.
 	n.unbalanced = true
@@ -164,6 +192,14 @@ func (n *node) read(p *common.Page) {
 	n.isLeaf = p.IsLeafPage()
 	n.inodes = common.ReadInodeFromPage(p)
 
+	// The branch elements of counted buckets hold the number of keys under
+	// them as their value.
+	if !n.isLeaf && n.bucket.ext.IsCounted() {
+		for i := range n.inodes {
+			n.inodes[i].SetValue(p.BranchPageElement(uint16(i)).SubtreeKeyCount())
+		}
+	}
+
 	// Save first key, so we can find the node in the parent when we spill.
 	if len(n.inodes) > 0 {
 		n.key = n.inodes[0].Key()
@@ -196,7 +232,11 @@ func (n *node) write(p *common.Page) {
 		return
 	}
 
-	common.WriteInodeToPage(n.inodes, p)
+	if n.prefixCompression() {
+		common.WritePrefixedInodeToPage(n.inodes, p)
+	} else {
+		common.WriteInodeToPage(n.inodes, p)
+	}
 
 	// DEBUG ONLY: n.dump()
 }
@@ -277,9 +317,19 @@ func (n *node) splitIndex(threshold int) (index, sz uintptr) {
 		inode := n.inodes[i]
 		elsize := n.pageElementSize() + uintptr(len(inode.Key())) + uintptr(len(inode.Value()))
 
+		// The keys of a prefix-compressed page share the prefix of its first
+		// and last key.
+		var saved uintptr
+		if n.prefixCompression() {
+			plen := common.CommonPrefixSize(n.inodes[0].Key(), inode.Key())
+			if s := common.KeyPrefixSavedBytes(i+1, plen); s > 0 {
+				saved = uintptr(s)
+			}
+		}
+
 		// If we have at least the minimum number of keys and adding another
 		// node would put us over the threshold then exit and return.
-		if index >= common.MinKeysPerPage && sz+elsize > uintptr(threshold) {
+		if index >= common.MinKeysPerPage && sz+elsize-saved > uintptr(threshold) {
 			break
 		}
 
@@ -312,7 +362,8 @@ func (n *node) spill() error {
 	n.children = nil
 
 	// Split nodes into appropriate sizes. The first node will always be n.
-	var nodes = n.split(uintptr(tx.db.pageSize))
+	// The page trailer isn't available to the node's data.
+	var nodes = n.split(uintptr(tx.db.pageSize - tx.db.pageTrailerSize()))
 	for _, node := range nodes {
 		// Add node's page to the freelist if it's not new.
 		if node.pgid > 0 {
@@ -321,7 +372,7 @@ func (n *node) spill() error {
 		}
 
 		// Allocate contiguous space for the node.
-		p, err := tx.allocate((node.size() + tx.db.pageSize - 1) / tx.db.pageSize)
+		p, err := tx.allocate((node.size() + tx.db.pageTrailerSize() + tx.db.pageSize - 1) / tx.db.pageSize)
 		if err != nil {
 			return err
 		}
@@ -341,7 +392,7 @@ func (n *node) spill() error {
 				key = node.inodes[0].Key()
 			}
 
-			node.parent.put(key, node.inodes[0].Key(), nil, node.pgid, 0)
+			node.parent.put(key, node.inodes[0].Key(), node.subtreeKeyCount(), node.pgid, 0)
 			node.key = node.inodes[0].Key()
 			common.Assert(len(node.key) > 0, "spill: zero-length node key")
 		}
@@ -360,6 +411,27 @@ func (n *node) spill() error {
 	return nil
 }
 
+// subtreeKeyCount returns the value of the parent branch element of the node,
+// which is the number of keys under it in a counted bucket, or nil.
+func (n *node) subtreeKeyCount() []byte {
+	if !n.bucket.ext.IsCounted() {
+		return nil
+	}
+	return binary.BigEndian.AppendUint64(make([]byte, 0, common.SubtreeKeyCountSize), n.keyCount())
+}
+
+// keyCount returns the number of keys under the node of a counted bucket.
+func (n *node) keyCount() uint64 {
+	if n.isLeaf {
+		return uint64(len(n.inodes))
+	}
+	var count uint64
+	for i := range n.inodes {
+		count += n.bucket.subtreeKeyCount(n.inodes[i].Pgid(), n.inodes[i].Value())
+	}
+	return count
+}
+
 // rebalance attempts to combine the node with sibling nodes if the node fill
 // size is below a threshold or if there are not enough keys.
 func (n *node) rebalance() {
@@ -534,5 +606,5 @@ type nodes []*node
 func (s nodes) Len() int      { return len(s) }
 func (s nodes) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
 func (s nodes) Less(i, j int) bool {
-	return bytes.Compare(s[i].inodes[0].Key(), s[j].inodes[0].Key()) == -1
+	return s[i].bucket.compare(s[i].inodes[0].Key(), s[j].inodes[0].Key()) == -1
 }
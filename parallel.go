@@ -0,0 +1,119 @@
+package bbolt
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	berrors "go.etcd.io/bbolt/errors"
+)
+
+// ParallelScan scans the keys of the bucket with n goroutines, which call fn
+// concurrently with the keys and values of n ranges of the bucket, split at
+// the keys of its branch pages. The ranges hold about as many pages, and fewer
+// than n ranges are scanned if the bucket doesn't have enough pages. Each
+// worker scans its range in ascending order with its own cursor, and passes
+// its index to fn, from 0 for the first range to the last one, so the results
+// of the workers can be merged in key order. Like with a Cursor, the value of
+// a nested bucket is nil, and the expired keys are skipped.
+//
+// The workers read the same snapshot of the transaction, which must not be
+// modified until ParallelScan returns, and fn must not use the transaction
+// for anything but reading. The keys and values are only valid for the life
+// of the transaction.
+//
+// If fn returns an error, the other workers stop, and the errors returned by
+// fn are returned joined. If ctx is done, the workers stop before their next
+// call of fn, and the cause of ctx is returned.
+func (b *Bucket) ParallelScan(ctx context.Context, n int, fn func(worker int, k, v []byte) error) error {
+	if b.tx.db == nil {
+		return berrors.ErrTxClosed
+	}
+	n = max(n, 1)
+	bounds := append([][]byte{nil}, b.splitKeys(n)...)
+	bounds = append(bounds, nil)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		wg        sync.WaitGroup
+		mu        sync.Mutex
+		errs      []error
+		cancelled bool
+	)
+	for i := 0; i < len(bounds)-1; i++ {
+		wg.Add(1)
+		go func(worker int, lower, upper []byte) {
+			defer wg.Done()
+			it := b.Range(lower, upper, nil)
+			for k, v := it.Next(); k != nil; k, v = it.Next() {
+				// Don't call fn once ctx is done, nor once another worker failed.
+				if ctx.Err() != nil {
+					mu.Lock()
+					cancelled = true
+					mu.Unlock()
+					return
+				}
+				if err := fn(worker, k, v); err != nil {
+					mu.Lock()
+					errs = append(errs, err)
+					mu.Unlock()
+					cancel()
+					return
+				}
+			}
+		}(i, bounds[i], bounds[i+1])
+	}
+	wg.Wait()
+
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	} else if cancelled {
+		return context.Cause(ctx)
+	}
+	return nil
+}
+
+// splitKeys returns up to n-1 increasing keys splitting the bucket into
+// ranges holding about as many pages. They're picked among the keys of the
+// highest level of branch elements with at least n elements, or of the
+// level above the leaves.
+func (b *Bucket) splitKeys(n int) [][]byte {
+	p, node := b.pageNode(b.RootPage())
+	level := []elemRef{{page: p, node: node}}
+	var keys [][]byte
+	for !level[0].isLeaf() {
+		keys = keys[:0]
+		for _, ref := range level {
+			for i := 0; i < ref.count(); i++ {
+				keys = append(keys, ref.branchKey(i))
+			}
+		}
+		if len(keys) >= n {
+			break
+		}
+
+		var children []elemRef
+		for _, ref := range level {
+			for i := 0; i < ref.count(); i++ {
+				p, node := b.pageNode(ref.branchPgid(i))
+				children = append(children, elemRef{page: p, node: node})
+			}
+		}
+		level = children
+	}
+
+	// The first key of the level doesn't split the bucket.
+	if len(keys) <= 1 {
+		return nil
+	}
+	if len(keys) <= n {
+		return keys[1:]
+	}
+	split := make([][]byte, 0, n-1)
+	for i := 1; i < n; i++ {
+		split = append(split, keys[i*len(keys)/n])
+	}
+	return split
+}
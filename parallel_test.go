@@ -0,0 +1,147 @@
+package bbolt_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	bolt "go.etcd.io/bbolt"
+	berrors "go.etcd.io/bbolt/errors"
+	"go.etcd.io/bbolt/internal/btesting"
+)
+
+// parallelScan scans a bucket with n workers, and returns the keys scanned by
+// each worker.
+func parallelScan(t *testing.T, b *bolt.Bucket, n int) [][]string {
+	var mu sync.Mutex
+	var ranges [][]string
+	err := b.ParallelScan(context.Background(), n, func(worker int, k, v []byte) error {
+		mu.Lock()
+		defer mu.Unlock()
+		for len(ranges) <= worker {
+			ranges = append(ranges, nil)
+		}
+		ranges[worker] = append(ranges[worker], string(k))
+		return nil
+	})
+	require.NoError(t, err)
+	return ranges
+}
+
+// Ensure that the workers of ParallelScan scan every key once, in ordered
+// ranges.
+func TestBucket_ParallelScan(t *testing.T) {
+	db := btesting.MustCreateDB(t)
+	var keys []string
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		require.NoError(t, err)
+		for i := 0; i < 20000; i++ {
+			require.NoError(t, b.Put([]byte(fmt.Sprintf("%06d", i)), []byte("value")))
+		}
+		_, err = b.CreateBucket([]byte("nested"))
+		require.NoError(t, err)
+		keys, _ = cursorKeys(b)
+
+		inline, err := tx.CreateBucket([]byte("inline"))
+		require.NoError(t, err)
+		require.NoError(t, inline.Put([]byte("foo"), []byte("bar")))
+		return nil
+	}))
+
+	require.NoError(t, db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("widgets"))
+		for _, n := range []int{0, 1, 2, 7, 64, 100000} {
+			ranges := parallelScan(t, b, n)
+			require.LessOrEqual(t, len(ranges), max(n, 1))
+			if n > 1 {
+				require.Greater(t, len(ranges), 1, "n=%d", n)
+			}
+			var all []string
+			for _, r := range ranges {
+				require.NotEmpty(t, r)
+				require.True(t, sort.StringsAreSorted(r))
+				all = append(all, r...)
+			}
+			require.Equal(t, keys, all, "n=%d", n)
+		}
+
+		require.NoError(t, b.ParallelScan(context.Background(), 4, func(worker int, k, v []byte) error {
+			if string(k) == "nested" {
+				require.Nil(t, v)
+			} else {
+				require.Equal(t, "value", string(v))
+			}
+			return nil
+		}))
+
+		ranges := parallelScan(t, tx.Bucket([]byte("inline")), 4)
+		require.Equal(t, [][]string{{"foo"}}, ranges)
+		return nil
+	}))
+}
+
+// Ensure that ParallelScan stops on the error of a worker or of the context, and
+// fails in a closed transaction.
+func TestBucket_ParallelScan_Error(t *testing.T) {
+	db := btesting.MustCreateDB(t)
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		require.NoError(t, err)
+		for i := 0; i < 20000; i++ {
+			require.NoError(t, b.Put([]byte(fmt.Sprintf("%06d", i)), []byte("value")))
+		}
+		return nil
+	}))
+
+	require.NoError(t, db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("widgets"))
+		errStop := errors.New("stop")
+		var mu sync.Mutex
+		var scanned int
+		err := b.ParallelScan(context.Background(), 4, func(worker int, k, v []byte) error {
+			mu.Lock()
+			defer mu.Unlock()
+			if scanned++; string(k) == "000100" {
+				return errStop
+			}
+			return nil
+		})
+		require.ErrorIs(t, err, errStop)
+		require.Less(t, scanned, 20000)
+
+		// fn isn't called once the context is done.
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		err = b.ParallelScan(ctx, 4, func(worker int, k, v []byte) error {
+			require.Fail(t, "fn called with a done context")
+			return nil
+		})
+		require.ErrorIs(t, err, context.Canceled)
+
+		ctx, cancel = context.WithCancel(context.Background())
+		scanned = 0
+		err = b.ParallelScan(ctx, 1, func(worker int, k, v []byte) error {
+			scanned++
+			cancel()
+			return nil
+		})
+		require.ErrorIs(t, err, context.Canceled)
+		require.Equal(t, 1, scanned)
+		return nil
+	}))
+
+	tx, err := db.Begin(false)
+	require.NoError(t, err)
+	b := tx.Bucket([]byte("widgets"))
+	require.NoError(t, tx.Rollback())
+	err = b.ParallelScan(context.Background(), 4, func(worker int, k, v []byte) error {
+		return nil
+	})
+	require.ErrorIs(t, err, berrors.ErrTxClosed)
+}
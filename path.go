@@ -0,0 +1,162 @@
+package bbolt
+
+import (
+	"errors"
+
+	berrors "go.etcd.io/bbolt/errors"
+	"go.etcd.io/bbolt/internal/common"
+)
+
+// BucketAt retrieves a nested bucket by its path, the names of the buckets
+// from the top level one down to it. Returns nil if the path is empty, or if a
+// bucket of the path does not exist.
+// The bucket instance is only valid for the lifetime of the transaction.
+func (tx *Tx) BucketAt(path ...[]byte) *Bucket {
+	if len(path) == 0 {
+		return nil
+	}
+	return tx.bucketAt(path)
+}
+
+// CreateBucketPath creates the buckets of a path which don't exist yet, from
+// the top level one down, and returns the last one.
+// Returns an error if the path is empty, if a bucket name is blank or too
+// long, or if a key of the path represents a non-bucket value.
+// The bucket instance is only valid for the lifetime of the transaction.
+func (tx *Tx) CreateBucketPath(path ...[]byte) (*Bucket, error) {
+	if len(path) == 0 {
+		return nil, berrors.ErrBucketNameRequired
+	}
+	b := &tx.root
+	for _, name := range path {
+		var err error
+		if b, err = b.CreateBucketIfNotExists(name); err != nil {
+			return nil, err
+		}
+	}
+	return b, nil
+}
+
+// DeleteBucketPath deletes the last bucket of a path, and the buckets nested
+// in it.
+// Returns an error if the path is empty, if a bucket of the path does not
+// exist, or if the key represents a non-bucket value.
+func (tx *Tx) DeleteBucketPath(path ...[]byte) error {
+	if len(path) == 0 {
+		return berrors.ErrBucketNameRequired
+	}
+	parent := tx.bucketAt(path[:len(path)-1])
+	if parent == nil {
+		return berrors.ErrBucketNotFound
+	}
+	return parent.DeleteBucket(path[len(path)-1])
+}
+
+// SkipBucket is returned by a WalkFunc to skip the keys of a bucket. It's
+// never returned by Tx.Walk.
+var SkipBucket = errors.New("skip this bucket")
+
+// WalkFunc is called by Tx.Walk for each key. path holds the names of the
+// buckets holding k, from the top level one down, and is empty for the top
+// level buckets. v is nil if k is a bucket. seq is the sequence of k if it's a
+// bucket, and of the bucket holding it otherwise.
+//
+// If it returns SkipBucket for a bucket, the keys of the bucket are skipped;
+// for another key, the remaining keys of the bucket holding it are skipped.
+// Other errors stop the walk.
+type WalkFunc func(path [][]byte, k, v []byte, seq uint64) error
+
+// WalkOptions filters the keys walked by Tx.WalkWithOptions by their depth,
+// the number of buckets holding them plus one: the top level buckets have a
+// depth of 1, and their keys a depth of 2.
+type WalkOptions struct {
+	// MinDepth is the depth of the first keys passed to the WalkFunc. The
+	// buckets above it are walked through, but not passed.
+	MinDepth int
+
+	// MaxDepth is the depth of the last keys walked, or 0 to walk every key.
+	MaxDepth int
+}
+
+// Walk calls fn for each key of the transaction, depth-first in key order:
+// for each top level bucket, then for each of its keys, followed by the keys
+// of each nested bucket, and so on. Like with a Cursor, the expired keys are
+// skipped. Like with Tx.Buckets, fn is called for a bucket whose comparator
+// isn't registered, but its keys are skipped. The path, keys and values are
+// only valid for the life of the transaction, and must not be modified.
+// Returns the error returned by fn.
+func (tx *Tx) Walk(fn WalkFunc) error {
+	return tx.WalkWithOptions(fn, nil)
+}
+
+// WalkWithOptions walks the keys of the transaction like Walk, filtered by
+// their depth.
+func (tx *Tx) WalkWithOptions(fn WalkFunc, opts *WalkOptions) error {
+	if tx.db == nil {
+		return berrors.ErrTxClosed
+	}
+	var o WalkOptions
+	if opts != nil {
+		o = *opts
+	}
+	return tx.walk(func(path [][]byte, b *Bucket, k, v []byte, flags uint32) error {
+		if (flags & common.BucketLeafFlag) != 0 {
+			return fn(path, k, nil, b.nestedBucket(k).Sequence())
+		}
+		return fn(path, k, b.value(v, flags), b.Sequence())
+	}, o)
+}
+
+// leafWalkFunc is called by Tx.walk for each key, like a WalkFunc, with the
+// bucket b holding it, and its leaf value v and flags as stored.
+type leafWalkFunc func(path [][]byte, b *Bucket, k, v []byte, flags uint32) error
+
+// walk walks the keys of the transaction like WalkWithOptions, passing the
+// leaf values to fn as stored, so that compaction can copy them.
+func (tx *Tx) walk(fn leafWalkFunc, opts WalkOptions) error {
+	return walkBucket(&tx.root, nil, fn, opts)
+}
+
+// walkBucket calls fn for the keys of b, whose path is given, and for the
+// keys of the buckets nested in it.
+func walkBucket(b *Bucket, path [][]byte, fn leafWalkFunc, opts WalkOptions) error {
+	depth := len(path) + 1
+	c := b.Cursor()
+	for k, v, flags := c.first(); k != nil; k, v, flags = c.next() {
+		// Stop rather than walk past the keys of a page which failed to be read.
+		if err := b.tx.Err(); err != nil {
+			return err
+		}
+		if b.hidden(k, v, flags) {
+			continue
+		}
+
+		// The keys of a bucket whose comparator isn't registered can't be
+		// read in order.
+		var child *Bucket
+		isBucket := (flags & common.BucketLeafFlag) != 0
+		if isBucket {
+			if child = b.nestedBucket(k); !child.comparatorKnown() {
+				child = nil
+			}
+		}
+
+		if depth >= opts.MinDepth {
+			if err := fn(path, b, k, v, flags); errors.Is(err, SkipBucket) {
+				if !isBucket {
+					return nil
+				}
+				continue
+			} else if err != nil {
+				return err
+			}
+		}
+
+		if child != nil && (opts.MaxDepth == 0 || depth < opts.MaxDepth) {
+			if err := walkBucket(child, append(path[:len(path):len(path)], k), fn, opts); err != nil {
+				return err
+			}
+		}
+	}
+	return b.tx.Err()
+}
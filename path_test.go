@@ -0,0 +1,142 @@
+package bbolt_test
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	bolt "go.etcd.io/bbolt"
+	berrors "go.etcd.io/bbolt/errors"
+	"go.etcd.io/bbolt/internal/btesting"
+)
+
+// Ensure that nested buckets can be created, retrieved and deleted by path.
+func TestTx_BucketPath(t *testing.T) {
+	db := btesting.MustCreateDB(t)
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketPath([]byte("a"), []byte("b"), []byte("c"))
+		require.NoError(t, err)
+		require.NoError(t, b.Put([]byte("foo"), []byte("bar")))
+
+		// Existing buckets are kept.
+		b, err = tx.CreateBucketPath([]byte("a"), []byte("b"))
+		require.NoError(t, err)
+		require.NotNil(t, b.Bucket([]byte("c")))
+		require.NoError(t, b.Put([]byte("key"), []byte("value")))
+
+		_, err = tx.CreateBucketPath()
+		require.ErrorIs(t, err, berrors.ErrBucketNameRequired)
+		_, err = tx.CreateBucketPath([]byte("a"), nil)
+		require.ErrorIs(t, err, berrors.ErrBucketNameRequired)
+		_, err = tx.CreateBucketPath([]byte("a"), []byte("b"), []byte("key"), []byte("d"))
+		require.ErrorIs(t, err, berrors.ErrIncompatibleValue)
+		return nil
+	}))
+
+	require.NoError(t, db.View(func(tx *bolt.Tx) error {
+		require.Equal(t, []byte("bar"), tx.BucketAt([]byte("a"), []byte("b"), []byte("c")).Get([]byte("foo")))
+		require.NotNil(t, tx.BucketAt([]byte("a")))
+		require.Nil(t, tx.BucketAt())
+		require.Nil(t, tx.BucketAt([]byte("a"), []byte("x")))
+		require.Nil(t, tx.BucketAt([]byte("a"), []byte("b"), []byte("key")))
+		return nil
+	}))
+
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		require.ErrorIs(t, tx.DeleteBucketPath(), berrors.ErrBucketNameRequired)
+		require.ErrorIs(t, tx.DeleteBucketPath([]byte("x"), []byte("b")), berrors.ErrBucketNotFound)
+		require.ErrorIs(t, tx.DeleteBucketPath([]byte("a"), []byte("x")), berrors.ErrBucketNotFound)
+		require.ErrorIs(t, tx.DeleteBucketPath([]byte("a"), []byte("b"), []byte("key")), berrors.ErrIncompatibleValue)
+		require.NoError(t, tx.DeleteBucketPath([]byte("a"), []byte("b")))
+		require.Nil(t, tx.BucketAt([]byte("a"), []byte("b")))
+		require.NotNil(t, tx.BucketAt([]byte("a")))
+		return nil
+	}))
+}
+
+// walkEntries returns the keys walked by Tx.WalkWithOptions as paths, with
+// their values and sequences, skipping the buckets named "skip" and the keys
+// after the ones named "last".
+func walkEntries(t *testing.T, tx *bolt.Tx, opts *bolt.WalkOptions) []string {
+	var entries []string
+	require.NoError(t, tx.WalkWithOptions(func(path [][]byte, k, v []byte, seq uint64) error {
+		var names []string
+		for _, name := range path {
+			names = append(names, string(name))
+		}
+		names = append(names, string(k))
+		entry := strings.Join(names, "/")
+		if v != nil {
+			entry += "=" + string(v)
+		}
+		entries = append(entries, fmt.Sprintf("%s %d", entry, seq))
+		if string(k) == "skip" || string(k) == "last" {
+			return bolt.SkipBucket
+		}
+		return nil
+	}, opts))
+	return entries
+}
+
+// Ensure that Tx.Walk walks every key depth-first, skips buckets, and filters
+// keys by depth.
+func TestTx_Walk(t *testing.T) {
+	db := btesting.MustCreateDB(t)
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		for _, path := range [][]string{{"a", "x"}, {"a", "skip", "y"}, {"b"}} {
+			var bpath [][]byte
+			for _, name := range path {
+				bpath = append(bpath, []byte(name))
+			}
+			b, err := tx.CreateBucketPath(bpath...)
+			require.NoError(t, err)
+			seq, err := b.NextSequence()
+			require.NoError(t, err)
+			require.NoError(t, b.Put([]byte("key"), []byte(fmt.Sprint(seq*10+uint64(len(path))))))
+		}
+		b := tx.BucketAt([]byte("b"))
+		require.NoError(t, b.Put([]byte("last"), nil))
+		require.NoError(t, b.Put([]byte("zzz"), []byte("hidden")))
+		return nil
+	}))
+
+	require.NoError(t, db.View(func(tx *bolt.Tx) error {
+		require.Equal(t, []string{
+			"a 0",
+			"a/skip 0",
+			"a/x 1",
+			"a/x/key=12 1",
+			"b 1",
+			"b/key=11 1",
+			"b/last= 1",
+		}, walkEntries(t, tx, nil))
+
+		require.Equal(t, []string{"a 0", "b 1"}, walkEntries(t, tx, &bolt.WalkOptions{MaxDepth: 1}))
+		require.Equal(t, []string{
+			"a/skip 0",
+			"a/x 1",
+			"b/key=11 1",
+			"b/last= 1",
+		}, walkEntries(t, tx, &bolt.WalkOptions{MinDepth: 2, MaxDepth: 2}))
+		require.Equal(t, []string{
+			"a/skip/y 1",
+			"a/skip/y/key=13 1",
+			"a/x/key=12 1",
+		}, walkEntries(t, tx, &bolt.WalkOptions{MinDepth: 3}))
+
+		errStop := errors.New("stop")
+		var n int
+		err := tx.Walk(func(path [][]byte, k, v []byte, seq uint64) error {
+			if n++; n == 3 {
+				return errStop
+			}
+			return nil
+		})
+		require.ErrorIs(t, err, errStop)
+		require.Equal(t, 3, n)
+		return nil
+	}))
+}
@@ -0,0 +1,164 @@
+package bbolt_test
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	bolt "go.etcd.io/bbolt"
+	berrors "go.etcd.io/bbolt/errors"
+	"go.etcd.io/bbolt/internal/btesting"
+	"go.etcd.io/bbolt/internal/common"
+	"go.etcd.io/bbolt/internal/guts_cli"
+)
+
+// fileMeta returns the latest meta page of the file of a closed database.
+func fileMeta(t *testing.T, path string) *common.Meta {
+	var latest *common.Meta
+	for id := uint64(0); id < 2; id++ {
+		_, buf, err := guts_cli.ReadPage(path, id)
+		require.NoError(t, err)
+		if m := common.LoadPageMeta(buf); latest == nil || m.Txid() > latest.Txid() {
+			latest = m
+		}
+	}
+	return latest
+}
+
+// fillPrefixDB puts keys sharing long prefixes into a bucket, and into nested
+// buckets of which some are inline.
+func fillPrefixDB(t *testing.T, db *btesting.DB) {
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte("objects"))
+		if err != nil {
+			return err
+		}
+		for i := 0; i < 2000; i++ {
+			k := fmt.Sprintf("tenant/%04d/object/%08d", i/500, i)
+			if err := b.Put([]byte(k), []byte(fmt.Sprintf("value-%d", i))); err != nil {
+				return err
+			}
+		}
+		for i := 0; i < 3; i++ {
+			nb, err := b.CreateBucketIfNotExists([]byte(fmt.Sprintf("tenant/%04d/nested", i)))
+			if err != nil {
+				return err
+			}
+			for j := 0; j < 10*(i+1)*(i+1); j++ {
+				if err := nb.Put([]byte(fmt.Sprintf("nested/key/%06d", j)), []byte("v")); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}))
+}
+
+// Ensure that prefix compression stores the same data in fewer leaf pages,
+// and that the pages remain readable without it.
+func TestDB_PrefixCompression(t *testing.T) {
+	plain := btesting.MustCreateDB(t)
+	fillPrefixDB(t, plain)
+	expected := dumpDB(t, plain.DB)
+
+	db := btesting.MustCreateDBWithOption(t, &bolt.Options{PrefixCompression: true})
+	fillPrefixDB(t, db)
+	require.Equal(t, expected, dumpDB(t, db.DB))
+	db.MustCheck()
+
+	var plainStats, stats bolt.BucketStats
+	require.NoError(t, plain.View(func(tx *bolt.Tx) error {
+		plainStats = tx.Bucket([]byte("objects")).Stats()
+		return nil
+	}))
+	require.NoError(t, db.View(func(tx *bolt.Tx) error {
+		stats = tx.Bucket([]byte("objects")).Stats()
+
+		// Seek into a prefix-compressed leaf.
+		k, v := tx.Bucket([]byte("objects")).Cursor().Seek([]byte("tenant/0001/object/00000600"))
+		require.Equal(t, "tenant/0001/object/00000600", string(k))
+		require.Equal(t, "value-600", string(v))
+		return nil
+	}))
+	require.Zero(t, plainStats.LeafPrefixSaved)
+	require.Greater(t, stats.LeafPrefixSaved, 0)
+	require.Less(t, stats.LeafPageN, plainStats.LeafPageN)
+	require.Less(t, stats.InlineBucketInuse, plainStats.InlineBucketInuse)
+
+	// Pages written with and without prefix compression are mixed.
+	db.MustClose()
+	db.SetOptions(&bolt.Options{})
+	db.MustReopen()
+	require.Equal(t, expected, dumpDB(t, db.DB))
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("objects"))
+		for i := 0; i < 2000; i += 7 {
+			if err := b.Delete([]byte(fmt.Sprintf("tenant/%04d/object/%08d", i/500, i))); err != nil {
+				return err
+			}
+		}
+		return nil
+	}))
+	require.NoError(t, plain.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("objects"))
+		for i := 0; i < 2000; i += 7 {
+			if err := b.Delete([]byte(fmt.Sprintf("tenant/%04d/object/%08d", i/500, i))); err != nil {
+				return err
+			}
+		}
+		return nil
+	}))
+	require.Equal(t, dumpDB(t, plain.DB), dumpDB(t, db.DB))
+	db.MustCheck()
+}
+
+// Ensure that prefix compression upgrades the format of the file, keeping its
+// other features, and that files with unknown features are refused.
+func TestDB_PrefixCompression_Format(t *testing.T) {
+	db := btesting.MustCreateDBWithOption(t, &bolt.Options{PageChecksums: true})
+	fillPrefixDB(t, db)
+	meta := func() *common.Meta {
+		return fileMeta(t, db.Path())
+	}
+	db.MustClose()
+	require.Equal(t, common.PageChecksumVersion, meta().Version())
+
+	db.SetOptions(&bolt.Options{PrefixCompression: true})
+	db.MustReopen()
+	fillPrefixDB(t, db)
+	expected := dumpDB(t, db.DB)
+	db.MustClose()
+	require.Equal(t, common.FeatureVersion, meta().Version())
+	require.True(t, meta().HasFeature(common.FeaturePageChecksums))
+	require.True(t, meta().HasFeature(common.FeaturePrefixCompression))
+	require.False(t, meta().HasFeature(common.FeatureEncryption))
+
+	// The feature remains when prefix compression is turned off.
+	db.SetOptions(&bolt.Options{ChecksumVerification: bolt.ChecksumVerifyAlways})
+	db.MustReopen()
+	fillPrefixDB(t, db)
+	require.Equal(t, expected, dumpDB(t, db.DB))
+	db.MustCheck()
+	db.MustClose()
+	require.Equal(t, common.FeatureVersion, meta().Version())
+
+	// Flag an unknown feature in a copy of the file.
+	data, err := os.ReadFile(db.Path())
+	require.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "unknown")
+	require.NoError(t, os.WriteFile(path, data, 0600))
+	for id := uint64(0); id < 2; id++ {
+		_, buf, err := guts_cli.ReadPage(path, id)
+		require.NoError(t, err)
+		m := common.LoadPageMeta(buf)
+		m.SetFlags(m.Flags() | 1<<31)
+		m.SetChecksum(m.Sum64())
+		require.NoError(t, guts_cli.WritePage(path, buf))
+	}
+	_, err = bolt.Open(path, 0600, nil)
+	require.ErrorIs(t, err, berrors.ErrVersionMismatch)
+	db.MustReopen()
+}
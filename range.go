@@ -0,0 +1,237 @@
+package bbolt
+
+import (
+	"bytes"
+
+	"go.etcd.io/bbolt/internal/common"
+)
+
+// RangeOptions represents the options of a bounded iteration with Range. By
+// default, the range includes its lower bound and excludes its upper bound,
+// like DeleteRange, and is iterated in ascending order.
+type RangeOptions struct {
+	// LowerExclusive excludes the lower bound from the range.
+	LowerExclusive bool
+
+	// UpperInclusive includes the upper bound in the range.
+	UpperInclusive bool
+
+	// Prefix restricts the range to the keys with the prefix. In buckets
+	// with a Comparator, whose keys with a prefix may not be contiguous, the
+	// iteration stops at the first key of the range without the prefix.
+	Prefix []byte
+
+	// Reverse iterates from the upper bound to the lower bound.
+	Reverse bool
+
+	// Limit is the maximum number of keys returned, if it's not zero.
+	Limit int
+}
+
+// RangeIterator iterates over the keys of a bucket between two bounds. It's
+// returned by Bucket.Range.
+type RangeIterator struct {
+	c            *Cursor
+	lower, upper []byte
+	opts         RangeOptions
+	anchor       []byte // key after which an iterator resumed from a token starts
+	last         []byte // last key returned
+	n            int
+	started      bool
+	done         bool
+}
+
+// Range returns an iterator over the keys of the bucket between lower and
+// upper, with the given options, which may be nil. A nil bound leaves the
+// range open on that side. Like the keys returned by a Cursor, the value of a
+// nested bucket is nil, and the expired keys are skipped.
+//
+// The iterator doesn't move past the bounds: it stops without reading the
+// next leaf page once the branch pages show that its keys are out of the
+// range.
+func (b *Bucket) Range(lower, upper []byte, opts *RangeOptions) *RangeIterator {
+	it := &RangeIterator{c: b.Cursor(), lower: lower, upper: upper}
+	if opts != nil {
+		it.opts = *opts
+	}
+
+	// Narrow the bounds to the keys with the prefix, which are contiguous in
+	// bytes.Compare order.
+	if prefix := it.opts.Prefix; prefix != nil && b.ext.Comparator() == 0 {
+		if it.lower == nil || bytes.Compare(prefix, it.lower) > 0 {
+			it.lower, it.opts.LowerExclusive = prefix, false
+		}
+		if end := prefixEnd(prefix); end != nil {
+			if cmp := bytes.Compare(end, it.upper); it.upper == nil || cmp < 0 || (cmp == 0 && it.opts.UpperInclusive) {
+				it.upper, it.opts.UpperInclusive = end, false
+			}
+		}
+	}
+	return it
+}
+
+// Next moves the iterator to the next key of the range, in the order of the
+// iteration, and returns its key and value. A nil key is returned once the
+// range is exhausted.
+// The returned key and value are only valid for the life of the transaction.
+func (it *RangeIterator) Next() (key []byte, value []byte) {
+	c := it.c
+	common.Assert(c.bucket.tx.db != nil, "tx closed")
+	if it.done || (it.opts.Limit > 0 && it.n >= it.opts.Limit) {
+		it.done = true
+		return nil, nil
+	}
+
+	var k, v []byte
+	var flags uint32
+	if !it.started {
+		k, v, flags = it.start()
+		it.started = true
+	} else {
+		k, v, flags = it.step()
+	}
+	for k != nil && c.bucket.hidden(k, v, flags) && it.inRange(k) {
+		k, v, flags = it.step()
+	}
+
+	if k == nil || !it.inRange(k) {
+		it.done = true
+		return nil, nil
+	}
+	it.n++
+	it.last = k
+	return c.userKeyValue(k, v, flags)
+}
+
+// start moves the cursor to the first element of the iteration.
+func (it *RangeIterator) start() ([]byte, []byte, uint32) {
+	c := it.c
+
+	// Resume after the anchor, whether or not it still exists.
+	if it.anchor != nil {
+		k, v, flags := c.seekGE(it.anchor)
+		if !it.opts.Reverse {
+			if k != nil && bytes.Equal(k, it.anchor) {
+				return c.next()
+			}
+			return k, v, flags
+		}
+		if k == nil {
+			return c.lastElement()
+		}
+		return c.prev()
+	}
+
+	if !it.opts.Reverse {
+		if it.lower == nil {
+			return c.first()
+		}
+		k, v, flags := c.seekGE(it.lower)
+		if k != nil && it.opts.LowerExclusive && bytes.Equal(k, it.lower) {
+			return c.next()
+		}
+		return k, v, flags
+	}
+
+	if it.upper == nil {
+		return c.lastElement()
+	}
+	k, v, flags := c.seekGE(it.upper)
+	if k == nil {
+		return c.lastElement()
+	} else if !it.opts.UpperInclusive || !bytes.Equal(k, it.upper) {
+		return c.prev()
+	}
+	return k, v, flags
+}
+
+// step moves the cursor to the next element of the iteration, unless the
+// branch pages show that the next leaf is out of the range.
+func (it *RangeIterator) step() ([]byte, []byte, uint32) {
+	c := it.c
+	if len(c.stack) == 0 {
+		return nil, nil, 0
+	}
+	top := &c.stack[len(c.stack)-1]
+	if !it.opts.Reverse {
+		// The keys of the next subtree are equal to or greater than the key
+		// of its branch element.
+		if it.upper != nil && top.index >= top.count()-1 {
+			if bk := it.nextBranchKey(); bk != nil && !it.belowUpper(bk) {
+				return nil, nil, 0
+			}
+		}
+		return c.next()
+	}
+
+	// The keys of the previous subtree are less than the key of the branch
+	// element of the current one.
+	if it.lower != nil && top.index <= 0 {
+		if bk := it.prevBranchKey(); bk != nil && c.bucket.compare(bk, it.lower) <= 0 {
+			return nil, nil, 0
+		}
+	}
+	return c.prev()
+}
+
+// nextBranchKey returns the key of the branch element of the subtree after
+// the leaf the cursor is on, or nil if it's the last leaf.
+func (it *RangeIterator) nextBranchKey() []byte {
+	stack := it.c.stack
+	for i := len(stack) - 2; i >= 0; i-- {
+		if ref := &stack[i]; ref.index < ref.count()-1 {
+			return ref.branchKey(ref.index + 1)
+		}
+	}
+	return nil
+}
+
+// prevBranchKey returns the key of the branch element of the innermost
+// subtree holding the leaf the cursor is on which isn't the first one of its
+// branch, or nil if it's the first leaf.
+func (it *RangeIterator) prevBranchKey() []byte {
+	stack := it.c.stack
+	for i := len(stack) - 2; i >= 0; i-- {
+		if ref := &stack[i]; ref.index > 0 {
+			return ref.branchKey(ref.index)
+		}
+	}
+	return nil
+}
+
+// inRange returns whether a key is between the bounds and has the prefix.
+func (it *RangeIterator) inRange(k []byte) bool {
+	if it.opts.Prefix != nil && !bytes.HasPrefix(k, it.opts.Prefix) {
+		return false
+	}
+	return it.aboveLower(k) && it.belowUpper(k)
+}
+
+func (it *RangeIterator) aboveLower(k []byte) bool {
+	if it.lower == nil {
+		return true
+	}
+	cmp := it.c.bucket.compare(k, it.lower)
+	return cmp > 0 || (cmp == 0 && !it.opts.LowerExclusive)
+}
+
+func (it *RangeIterator) belowUpper(k []byte) bool {
+	if it.upper == nil {
+		return true
+	}
+	cmp := it.c.bucket.compare(k, it.upper)
+	return cmp < 0 || (cmp == 0 && it.opts.UpperInclusive)
+}
+
+// prefixEnd returns the first key greater than the keys with a prefix, in
+// bytes.Compare order, or nil if there's none.
+func prefixEnd(prefix []byte) []byte {
+	for i := len(prefix) - 1; i >= 0; i-- {
+		if prefix[i] != 0xff {
+			end := append([]byte{}, prefix[:i+1]...)
+			end[i]++
+			return end
+		}
+	}
+	return nil
+}
@@ -0,0 +1,228 @@
+package bbolt_test
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"math/rand"
+	"os"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	bolt "go.etcd.io/bbolt"
+	"go.etcd.io/bbolt/internal/btesting"
+)
+
+// createRangeBucket creates a bucket with every other key between 0 and n,
+// with an expired key between them, and returns the sorted keys.
+func createRangeBucket(t *testing.T, db *btesting.DB, n int) []string {
+	var keys []string
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		require.NoError(t, err)
+		for i := 0; i < n; i += 2 {
+			k := fmt.Sprintf("%05d", i)
+			require.NoError(t, b.Put([]byte(k), []byte("v"+k)))
+			keys = append(keys, k)
+		}
+		require.NoError(t, b.PutWithTTL([]byte("00101"), []byte("expired"), time.Nanosecond))
+		return nil
+	}))
+	time.Sleep(time.Millisecond)
+	return keys
+}
+
+// Ensure that SeekGT, SeekLE and SeekLT find the keys around every key, at
+// the boundaries of the pages, and skip the expired keys.
+func TestCursor_SeekBounds(t *testing.T) {
+	db := btesting.MustCreateDBWithOption(t, &bolt.Options{HideExpired: true})
+	const n = 5000
+	keys := createRangeBucket(t, db, n)
+
+	require.NoError(t, db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket([]byte("widgets")).Cursor()
+		for i := -1; i <= n; i++ {
+			seek := fmt.Sprintf("%05d", i)
+			if i < 0 {
+				seek = "0"
+			}
+			// The index of the first key equal to or greater than seek.
+			j := sort.SearchStrings(keys, seek)
+			exact := j < len(keys) && keys[j] == seek
+
+			gt, le, lt := j, j-1, j-1
+			if exact {
+				gt, le = j+1, j
+			}
+			for _, tc := range []struct {
+				seek     func([]byte) ([]byte, []byte)
+				expected int
+			}{{c.SeekGT, gt}, {c.SeekLE, le}, {c.SeekLT, lt}} {
+				k, v := tc.seek([]byte(seek))
+				if tc.expected < 0 || tc.expected >= len(keys) {
+					require.Nil(t, k, "seek %s", seek)
+					continue
+				}
+				require.Equal(t, keys[tc.expected], string(k), "seek %s", seek)
+				require.Equal(t, "v"+keys[tc.expected], string(v))
+			}
+		}
+
+		// The cursor can move on from the keys it seeks.
+		k, _ := c.SeekLE([]byte("zzz"))
+		require.Equal(t, keys[len(keys)-1], string(k))
+		k, _ = c.Prev()
+		require.Equal(t, keys[len(keys)-2], string(k))
+		k, _ = c.SeekLT([]byte("00102"))
+		require.Equal(t, "00100", string(k))
+		k, _ = c.Next()
+		require.Equal(t, "00102", string(k))
+		return nil
+	}))
+}
+
+// Ensure that Range returns the keys between its bounds, in both directions,
+// with inclusive and exclusive bounds, prefixes and limits.
+func TestBucket_Range(t *testing.T) {
+	db := btesting.MustCreateDBWithOption(t, &bolt.Options{HideExpired: true})
+	const n = 5000
+	keys := createRangeBucket(t, db, n)
+
+	r := rand.New(rand.NewSource(42))
+	bound := func() []byte {
+		if r.Intn(5) == 0 {
+			return nil
+		}
+		return []byte(fmt.Sprintf("%05d", r.Intn(n+2)-1))
+	}
+	require.NoError(t, db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("widgets"))
+		for i := 0; i < 2000; i++ {
+			lower, upper := bound(), bound()
+			opts := bolt.RangeOptions{
+				LowerExclusive: r.Intn(2) == 0,
+				UpperInclusive: r.Intn(2) == 0,
+				Reverse:        r.Intn(2) == 0,
+			}
+			if r.Intn(3) == 0 {
+				opts.Limit = r.Intn(20)
+			}
+			if r.Intn(3) == 0 {
+				opts.Prefix = []byte(fmt.Sprintf("%05d", r.Intn(n))[:r.Intn(5)])
+			}
+
+			var expected []string
+			for _, k := range keys {
+				if (lower == nil || k > string(lower) || (k == string(lower) && !opts.LowerExclusive)) &&
+					(upper == nil || k < string(upper) || (k == string(upper) && opts.UpperInclusive)) &&
+					bytes.HasPrefix([]byte(k), opts.Prefix) {
+					expected = append(expected, k)
+				}
+			}
+			if opts.Reverse {
+				for i, j := 0, len(expected)-1; i < j; i, j = i+1, j-1 {
+					expected[i], expected[j] = expected[j], expected[i]
+				}
+			}
+			if opts.Limit > 0 && len(expected) > opts.Limit {
+				expected = expected[:opts.Limit]
+			}
+
+			var actual []string
+			it := b.Range(lower, upper, &opts)
+			for k, v := it.Next(); k != nil; k, v = it.Next() {
+				require.Equal(t, "v"+string(k), string(v))
+				actual = append(actual, string(k))
+			}
+			require.Equal(t, expected, actual, "range %q %q %+v", lower, upper, opts)
+			k, _ := it.Next()
+			require.Nil(t, k)
+		}
+		return nil
+	}))
+}
+
+// Ensure that Range follows the order of the comparator of the bucket.
+func TestBucket_Range_Comparator(t *testing.T) {
+	db := btesting.MustCreateDB(t)
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketWithOptions([]byte("widgets"), &bolt.BucketOptions{Comparator: bolt.ReverseComparator})
+		require.NoError(t, err)
+		for _, k := range []string{"a1", "a2", "b1", "b2", "c1"} {
+			require.NoError(t, b.Put([]byte(k), nil))
+		}
+		_, err = b.CreateBucket([]byte("b3"))
+		return err
+	}))
+
+	require.NoError(t, db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("widgets"))
+		keys := func(it *bolt.RangeIterator) []string {
+			var keys []string
+			for k, _ := it.Next(); k != nil; k, _ = it.Next() {
+				keys = append(keys, string(k))
+			}
+			return keys
+		}
+		require.Equal(t, []string{"b3", "b2", "b1"}, keys(b.Range([]byte("b3"), []byte("a2"), nil)))
+		require.Equal(t, []string{"a2", "b1", "b2"}, keys(b.Range([]byte("b2"), []byte("a2"), &bolt.RangeOptions{Reverse: true, UpperInclusive: true, Limit: 3})))
+		require.Equal(t, []string{"b3", "b2", "b1"}, keys(b.Range([]byte("b9"), nil, &bolt.RangeOptions{Prefix: []byte("b")})))
+
+		k, v := b.Cursor().SeekLE([]byte("b"))
+		require.Equal(t, []byte("b1"), k)
+		require.Empty(t, v)
+		return nil
+	}))
+}
+
+func ExampleBucket_Range() {
+	// Open the database.
+	db, err := bolt.Open(tempfile(), 0600, nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.Remove(db.Path())
+
+	// Store events by date.
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("events"))
+		if err != nil {
+			return err
+		}
+		for _, date := range []string{"2024-12-31", "2025-01-15", "2025-02-01", "2025-02-14", "2025-03-01"} {
+			if err := b.Put([]byte(date), []byte("event")); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		log.Fatal(err)
+	}
+
+	// Print the two latest events of 2025 until February included.
+	if err := db.View(func(tx *bolt.Tx) error {
+		it := tx.Bucket([]byte("events")).Range([]byte("2025"), []byte("2025-02-28"), &bolt.RangeOptions{
+			UpperInclusive: true,
+			Reverse:        true,
+			Limit:          2,
+		})
+		for k, _ := it.Next(); k != nil; k, _ = it.Next() {
+			fmt.Println(string(k))
+		}
+		return nil
+	}); err != nil {
+		log.Fatal(err)
+	}
+
+	// Close database to release the file lock.
+	if err := db.Close(); err != nil {
+		log.Fatal(err)
+	}
+
+	// Output:
+	// 2025-02-14
+	// 2025-02-01
+}
@@ -0,0 +1,52 @@
+package bbolt
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Ensure that Range doesn't move to the leaves which the branch pages show
+// to be out of the range.
+func TestBucket_Range_StopsAtBranch(t *testing.T) {
+	db, err := Open(filepath.Join(t.TempDir(), "db"), 0600, nil)
+	require.NoError(t, err)
+	defer db.Close()
+
+	require.NoError(t, db.Update(func(tx *Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		require.NoError(t, err)
+		for i := 0; i < 1000; i++ {
+			require.NoError(t, b.Put([]byte(fmt.Sprintf("%05d", i)), make([]byte, 100)))
+		}
+		return nil
+	}))
+
+	require.NoError(t, db.View(func(tx *Tx) error {
+		b := tx.Bucket([]byte("widgets"))
+		root := tx.page(b.RootPage())
+		require.True(t, root.IsBranchPage())
+		second := root.BranchPageElement(1).Key()
+
+		// The iteration ends on the first leaf, rather than on the first key
+		// of the second one.
+		it := b.Range(nil, second, nil)
+		var n int
+		for k, _ := it.Next(); k != nil; k, _ = it.Next() {
+			n++
+		}
+		require.Equal(t, int(tx.page(root.BranchPageElement(0).Pgid()).Count()), n)
+		require.Equal(t, 0, it.c.stack[0].index)
+
+		// The reverse iteration ends on the second leaf.
+		it = b.Range(second, nil, &RangeOptions{LowerExclusive: true, Reverse: true})
+		for k, _ := it.Next(); k != nil; k, _ = it.Next() {
+			n++
+		}
+		require.Equal(t, 999, n)
+		require.Equal(t, 1, it.c.stack[0].index)
+		return nil
+	}))
+}
@@ -0,0 +1,195 @@
+package bbolt
+
+import (
+	"encoding/binary"
+
+	"go.etcd.io/bbolt/internal/common"
+)
+
+// KeyCount returns the number of keys in the bucket, including the nested
+// buckets but not their keys, and the expired keys which weren't reaped yet.
+// It's read from the header of counted buckets, and the keys of other buckets
+// are iterated over.
+func (b *Bucket) KeyCount() int {
+	if b.ext.IsCounted() {
+		return int(b.ext.KeyCount())
+	}
+	var n int
+	c := b.Cursor()
+	for k, _, _ := c.first(); k != nil; k, _, _ = c.next() {
+		n++
+	}
+	return n
+}
+
+// CountRange returns the number of keys in the bucket which are equal to or
+// greater than start, and less than end. A nil start counts from the first
+// key, and a nil end up to the last key. In counted buckets, it only reads
+// the pages on the paths to start and end.
+func (b *Bucket) CountRange(start, end []byte) int {
+	if !b.ext.IsCounted() {
+		var n int
+		c := b.Cursor()
+		k, _, _ := c.seek(start)
+		if ref := &c.stack[len(c.stack)-1]; ref.index >= ref.count() {
+			k, _, _ = c.next()
+		}
+		for ; k != nil && (end == nil || b.compare(k, end) < 0); k, _, _ = c.next() {
+			n++
+		}
+		return n
+	}
+
+	var from, to uint64
+	if start != nil {
+		from = b.keysBefore(start)
+	}
+	if to = b.ext.KeyCount(); end != nil {
+		to = b.keysBefore(end)
+	}
+	if to < from {
+		return 0
+	}
+	return int(to - from)
+}
+
+// keysBefore returns the number of keys less than key in a counted bucket.
+func (b *Bucket) keysBefore(key []byte) uint64 {
+	c := b.Cursor()
+	c.seek(key)
+	return c.position()
+}
+
+// addKeyCount adds delta to the number of keys of a counted bucket.
+func (b *Bucket) addKeyCount(delta int) {
+	if b.ext.IsCounted() {
+		b.ext.SetKeyCount(uint64(int64(b.ext.KeyCount()) + int64(delta)))
+	}
+}
+
+// subtreeKeyCount returns the number of keys under a branch element of a
+// counted bucket, given its page id and its value. The stored count of a page
+// loaded as a node may be stale until the node is spilled, so it's counted
+// from the node.
+func (b *Bucket) subtreeKeyCount(pgId common.Pgid, v []byte) uint64 {
+	if n := b.nodes[pgId]; n != nil {
+		return n.keyCount()
+	}
+	return binary.BigEndian.Uint64(v)
+}
+
+// SeekIndex moves the cursor to the key at the given position in the bucket,
+// counting from 0, and returns its key and value. Expired keys which weren't
+// reaped yet are counted, and returned even if DB.HideExpired is set.
+// In counted buckets, it only reads the pages on the path to the key, and it
+// iterates over the keys before it in other buckets.
+// If the position is out of range then a nil key and value are returned.
+// The returned key and value are only valid for the life of the transaction.
+func (c *Cursor) SeekIndex(i int) (key []byte, value []byte) {
+	common.Assert(c.bucket.tx.db != nil, "tx closed")
+	if i < 0 {
+		return nil, nil
+	}
+	k, v, flags := c.seekIndex(uint64(i))
+	if (flags & uint32(common.BucketLeafFlag)) != 0 {
+		return k, nil
+	}
+	return k, c.bucket.value(v, flags)
+}
+
+func (c *Cursor) seekIndex(i uint64) (key []byte, value []byte, flags uint32) {
+	if !c.bucket.ext.IsCounted() {
+		k, v, flags := c.first()
+		for ; k != nil && i > 0; i-- {
+			k, v, flags = c.next()
+		}
+		return k, v, flags
+	}
+
+	// Descend into the element holding the key, skipping the keys of the
+	// elements before it.
+	c.stack = c.stack[:0]
+	pgId := c.bucket.RootPage()
+	for {
+		p, n := c.bucket.pageNode(pgId)
+		ref := elemRef{page: p, node: n}
+		if ref.isLeaf() {
+			if i >= uint64(ref.count()) {
+				ref.index = ref.count()
+				c.stack = append(c.stack, ref)
+				return nil, nil, 0
+			}
+			ref.index = int(i)
+			c.stack = append(c.stack, ref)
+			return c.keyValue()
+		}
+
+		for ref.index = 0; ref.index < ref.count()-1; ref.index++ {
+			count := c.childKeyCount(&ref, ref.index)
+			if i < count {
+				break
+			}
+			i -= count
+		}
+		c.stack = append(c.stack, ref)
+		if ref.node != nil {
+			pgId = ref.node.inodes[ref.index].Pgid()
+		} else {
+			pgId = ref.page.BranchPageElement(uint16(ref.index)).Pgid()
+		}
+	}
+}
+
+// Rank returns the position in the bucket of the key the cursor is on,
+// counting from 0, or the number of keys if it's past the last key.
+// Expired keys which weren't reaped yet are counted. In counted buckets, it
+// only reads the pages the cursor is on, and it iterates over the keys before
+// the key in other buckets. Returns -1 if the cursor isn't positioned.
+func (c *Cursor) Rank() int {
+	common.Assert(c.bucket.tx.db != nil, "tx closed")
+	if len(c.stack) == 0 {
+		return -1
+	}
+	if c.bucket.ext.IsCounted() {
+		return int(c.position())
+	}
+
+	key, _, _ := c.keyValue()
+	if key == nil {
+		return c.bucket.KeyCount()
+	}
+	var n int
+	rc := c.bucket.Cursor()
+	for k, _, _ := rc.first(); k != nil && c.bucket.compare(k, key) < 0; k, _, _ = rc.next() {
+		n++
+	}
+	return n
+}
+
+// position returns the number of keys before the element the cursor is on in
+// a counted bucket.
+func (c *Cursor) position() uint64 {
+	var pos uint64
+	for i := range c.stack {
+		ref := &c.stack[i]
+		if ref.isLeaf() {
+			pos += uint64(ref.index)
+			break
+		}
+		for j := 0; j < ref.index; j++ {
+			pos += c.childKeyCount(ref, j)
+		}
+	}
+	return pos
+}
+
+// childKeyCount returns the number of keys under the i-th element of a branch
+// page or node of a counted bucket.
+func (c *Cursor) childKeyCount(ref *elemRef, i int) uint64 {
+	if ref.node != nil {
+		inode := &ref.node.inodes[i]
+		return c.bucket.subtreeKeyCount(inode.Pgid(), inode.Value())
+	}
+	elem := ref.page.BranchPageElement(uint16(i))
+	return c.bucket.subtreeKeyCount(elem.Pgid(), elem.SubtreeKeyCount())
+}
@@ -0,0 +1,252 @@
+package bbolt_test
+
+import (
+	"fmt"
+	"log"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	bolt "go.etcd.io/bbolt"
+	"go.etcd.io/bbolt/internal/btesting"
+	"go.etcd.io/bbolt/internal/common"
+)
+
+// requireRanks verifies the key count, the ranks and the ranges of a bucket
+// holding the given sorted keys.
+func requireRanks(t *testing.T, b *bolt.Bucket, keys []string) {
+	require.Equal(t, len(keys), b.KeyCount())
+
+	c := b.Cursor()
+	require.Equal(t, -1, c.Rank())
+	for i := 0; i < len(keys); i += 1 + len(keys)/50 {
+		k, _ := c.SeekIndex(i)
+		require.Equal(t, keys[i], string(k))
+		require.Equal(t, i, c.Rank())
+
+		k, _ = c.Seek([]byte(keys[i]))
+		require.Equal(t, keys[i], string(k))
+		require.Equal(t, i, c.Rank())
+
+		end := (i + 7*len(keys)/10) % (len(keys) + 1)
+		expected := end - i
+		if end < i {
+			expected = 0
+		}
+		var endKey []byte
+		if end < len(keys) {
+			endKey = []byte(keys[end])
+		}
+		require.Equal(t, expected, b.CountRange([]byte(keys[i]), endKey))
+	}
+
+	k, _ := c.SeekIndex(len(keys))
+	require.Nil(t, k)
+	k, _ = c.SeekIndex(-1)
+	require.Nil(t, k)
+	c.Seek([]byte("\xff"))
+	require.Equal(t, len(keys), c.Rank())
+	require.Equal(t, len(keys), b.CountRange(nil, nil))
+	require.Zero(t, b.CountRange([]byte("\xff"), nil))
+}
+
+// Ensure that counted buckets keep the number of keys under each branch
+// element up to date, and that other buckets return the same results.
+func TestBucket_Counted(t *testing.T) {
+	db := btesting.MustCreateDB(t)
+	const n = 5000
+	r := rand.New(rand.NewSource(42))
+	keys := make(map[string]bool)
+	for _, name := range []string{"counted", "plain"} {
+		require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+			_, err := tx.CreateBucketWithOptions([]byte(name), &bolt.BucketOptions{Counted: name == "counted"})
+			return err
+		}))
+	}
+
+	sortedKeys := func() []string {
+		var sorted []string
+		for k := range keys {
+			sorted = append(sorted, k)
+		}
+		sort.Strings(sorted)
+		return sorted
+	}
+
+	for round := 0; round < 4; round++ {
+		require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+			var changes []func(b *bolt.Bucket) error
+			for i := 0; i < n/2; i++ {
+				k := fmt.Sprintf("%08d", r.Intn(n))
+				if keys[k] && r.Intn(3) == 0 {
+					delete(keys, k)
+					changes = append(changes, func(b *bolt.Bucket) error { return b.Delete([]byte(k)) })
+				} else {
+					keys[k] = true
+					changes = append(changes, func(b *bolt.Bucket) error { return b.Put([]byte(k), []byte("value")) })
+				}
+			}
+			if round == 0 {
+				keys["nested"] = true
+				changes = append(changes, func(b *bolt.Bucket) error {
+					_, err := b.CreateBucket([]byte("nested"))
+					return err
+				})
+			}
+
+			for _, name := range []string{"counted", "plain"} {
+				b := tx.Bucket([]byte(name))
+				for _, change := range changes {
+					require.NoError(t, change(b))
+				}
+				// The counts of the dirty nodes are up to date.
+				requireRanks(t, b, sortedKeys())
+			}
+			return nil
+		}))
+		db.MustCheck()
+	}
+
+	db.MustClose()
+	db.MustReopen()
+	require.NoError(t, db.View(func(tx *bolt.Tx) error {
+		sorted := sortedKeys()
+		for _, name := range []string{"counted", "plain"} {
+			requireRanks(t, tx.Bucket([]byte(name)), sorted)
+		}
+		require.True(t, tx.Bucket([]byte("counted")).Options().Counted)
+		return nil
+	}))
+
+	// Deleting most keys merges the pages.
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("counted"))
+		for i, k := range sortedKeys() {
+			if i%10 != 0 && k != "nested" {
+				delete(keys, k)
+				require.NoError(t, b.Delete([]byte(k)))
+			}
+		}
+		return nil
+	}))
+	db.MustCheck()
+	require.NoError(t, db.View(func(tx *bolt.Tx) error {
+		requireRanks(t, tx.Bucket([]byte("counted")), sortedKeys())
+		return nil
+	}))
+}
+
+// Ensure that the meta page flags the counted buckets once one is created, so
+// that the versions of bbolt which don't maintain their counts refuse the
+// file, even with a bucket of other options.
+func TestBucket_Counted_Format(t *testing.T) {
+	db := btesting.MustCreateDB(t)
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketWithOptions([]byte("compressed"), &bolt.BucketOptions{Codec: bolt.FlateCodec})
+		return err
+	}))
+	db.MustClose()
+	require.False(t, fileMeta(t, db.Path()).HasFeature(common.FeatureCountedBuckets))
+
+	db.MustReopen()
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketWithOptions([]byte("counted"), &bolt.BucketOptions{Counted: true})
+		return err
+	}))
+	db.MustClose()
+	require.True(t, fileMeta(t, db.Path()).HasFeature(common.FeatureCountedBuckets))
+	db.MustReopen()
+}
+
+// Ensure that rolling back to a savepoint restores the number of keys, and
+// that Compact keeps buckets counted.
+func TestBucket_Counted_SavepointCompact(t *testing.T) {
+	db := btesting.MustCreateDB(t)
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		parent, err := tx.CreateBucket([]byte("parent"))
+		require.NoError(t, err)
+		b, err := parent.CreateBucketWithOptions([]byte("widgets"), &bolt.BucketOptions{Counted: true})
+		require.NoError(t, err)
+		for i := 0; i < 1000; i++ {
+			require.NoError(t, b.Put([]byte(fmt.Sprintf("%04d", i)), []byte("value")))
+		}
+
+		sp, err := tx.Savepoint()
+		require.NoError(t, err)
+		for i := 1000; i < 2000; i++ {
+			require.NoError(t, b.Put([]byte(fmt.Sprintf("%04d", i)), []byte("value")))
+		}
+		require.Equal(t, 2000, b.KeyCount())
+		require.NoError(t, sp.RollbackTo())
+		require.Equal(t, 1000, b.KeyCount())
+		return nil
+	}))
+	db.MustCheck()
+
+	dst, err := bolt.Open(filepath.Join(t.TempDir(), "dst"), 0600, nil)
+	require.NoError(t, err)
+	defer dst.Close()
+	require.NoError(t, bolt.Compact(dst, db.DB, 0))
+	require.NoError(t, dst.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("parent")).Bucket([]byte("widgets"))
+		require.True(t, b.Options().Counted)
+		require.Equal(t, 1000, b.KeyCount())
+		k, _ := b.Cursor().SeekIndex(500)
+		require.Equal(t, []byte("0500"), k)
+		return nil
+	}))
+}
+
+func ExampleCursor_SeekIndex() {
+	// Open the database.
+	db, err := bolt.Open(tempfile(), 0600, nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.Remove(db.Path())
+
+	// Create a counted bucket.
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketWithOptions([]byte("events"), &bolt.BucketOptions{Counted: true})
+		if err != nil {
+			return err
+		}
+		for i := 0; i < 100; i++ {
+			if err := b.Put([]byte(fmt.Sprintf("event-%03d", i)), []byte("data")); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		log.Fatal(err)
+	}
+
+	// Read the third page of ten events.
+	if err := db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("events"))
+		c := b.Cursor()
+		k, _ := c.SeekIndex(20)
+		fmt.Printf("%d events, page 3 starts at %s\n", b.KeyCount(), k)
+
+		k, _ = c.Seek([]byte("event-042"))
+		fmt.Printf("%s is event #%d\n", k, c.Rank())
+		fmt.Printf("%d events from 050 to 075\n", b.CountRange([]byte("event-050"), []byte("event-075")))
+		return nil
+	}); err != nil {
+		log.Fatal(err)
+	}
+
+	// Close database to release the file lock.
+	if err := db.Close(); err != nil {
+		log.Fatal(err)
+	}
+
+	// Output:
+	// 100 events, page 3 starts at event-020
+	// event-042 is event #42
+	// 25 events from 050 to 075
+}
@@ -0,0 +1,193 @@
+package bbolt
+
+import (
+	berrors "go.etcd.io/bbolt/errors"
+	"go.etcd.io/bbolt/internal/common"
+)
+
+// Savepoint marks a state of a writable transaction, which the transaction
+// can be rolled back to without rolling back the whole transaction.
+type Savepoint struct {
+	tx         *Tx
+	meta       common.Meta
+	pages      map[common.Pgid]*common.Page
+	eagerPages int // number of pages written before commit by the transaction
+	freed      int // number of pages freed by the transaction
+	changes    int // number of changes recorded for the watchers
+	buckets    []bucketState
+}
+
+// bucketState holds the state of a cached bucket at a savepoint.
+type bucketState struct {
+	b        *Bucket
+	bucket   common.InBucket
+	ext      common.InBucketExt
+	page     *common.Page
+	rootNode *node
+	nodes    map[common.Pgid]*node
+	buckets  map[string]*Bucket
+}
+
+// Savepoint returns a savepoint at the current state of the transaction.
+// The changes made after it can be undone with RollbackTo, or kept with
+// Release. Savepoints can be nested.
+//
+// The dirty nodes are copied when the savepoint is created, and again when
+// rolling back to it.
+func (tx *Tx) Savepoint() (*Savepoint, error) {
+	if tx.db == nil {
+		return nil, berrors.ErrTxClosed
+	} else if !tx.writable {
+		return nil, berrors.ErrTxNotWritable
+	}
+
+	sp := &Savepoint{
+		tx:         tx,
+		pages:      make(map[common.Pgid]*common.Page, len(tx.pages)),
+		eagerPages: len(tx.eagerPages),
+		changes:    len(tx.changes),
+	}
+	tx.meta.Copy(&sp.meta)
+	for id, p := range tx.pages {
+		sp.pages[id] = p
+	}
+	if txp := tx.db.freelist.pending[tx.meta.Txid()]; txp != nil {
+		sp.freed = len(txp.ids)
+	}
+	sp.saveBucket(&tx.root)
+
+	tx.savepoints = append(tx.savepoints, sp)
+	return sp, nil
+}
+
+// saveBucket saves the state of b and of its cached nested buckets.
+func (sp *Savepoint) saveBucket(b *Bucket) {
+	st := bucketState{
+		b:       b,
+		bucket:  *b.InBucket,
+		ext:     b.ext,
+		page:    b.page,
+		buckets: make(map[string]*Bucket, len(b.buckets)),
+	}
+	st.rootNode, st.nodes = cloneNodes(b.rootNode, b.nodes)
+	for name, child := range b.buckets {
+		st.buckets[name] = child
+		sp.saveBucket(child)
+	}
+	sp.buckets = append(sp.buckets, st)
+}
+
+// RollbackTo undoes the changes made to the transaction after the savepoint.
+// The dirty nodes, the roots and sequences of the buckets, and the pages
+// allocated and freed are restored to their state at the savepoint. The
+// savepoint remains valid, and the savepoints created after it are released.
+//
+// Buckets obtained before the savepoint remain valid. Buckets obtained after
+// it, and cursors, must not be used after RollbackTo.
+func (sp *Savepoint) RollbackTo() error {
+	i, err := sp.index()
+	if err != nil {
+		return err
+	}
+	tx := sp.tx
+
+	tx.db.freelist.rollbackTo(tx.meta.Txid(), sp.freed, tx.eagerPages[sp.eagerPages:], sp.meta.Pgid())
+	tx.eagerPages = tx.eagerPages[:sp.eagerPages]
+	tx.changes = tx.changes[:sp.changes]
+	sp.meta.Copy(tx.meta)
+	tx.pages = make(map[common.Pgid]*common.Page, len(sp.pages))
+	for id, p := range sp.pages {
+		tx.pages[id] = p
+	}
+
+	for _, st := range sp.buckets {
+		b := st.b
+		*b.InBucket = st.bucket
+		b.ext = st.ext
+		b.page = st.page
+		b.rootNode, b.nodes = cloneNodes(st.rootNode, st.nodes)
+		b.buckets = make(map[string]*Bucket, len(st.buckets))
+		for name, child := range st.buckets {
+			b.buckets[name] = child
+		}
+	}
+
+	tx.savepoints = tx.savepoints[:i+1]
+	return nil
+}
+
+// Release discards the savepoint and the savepoints created after it. The
+// changes made after them are kept.
+func (sp *Savepoint) Release() error {
+	i, err := sp.index()
+	if err != nil {
+		return err
+	}
+	sp.tx.savepoints = sp.tx.savepoints[:i]
+	return nil
+}
+
+// index returns the position of the savepoint in the savepoints of its
+// transaction, or an error if it was released or the transaction is closed.
+func (sp *Savepoint) index() (int, error) {
+	if sp.tx.db == nil {
+		return 0, berrors.ErrTxClosed
+	}
+	for i, s := range sp.tx.savepoints {
+		if s == sp {
+			return i, nil
+		}
+	}
+	return 0, berrors.ErrSavepointReleased
+}
+
+// dereference removes the references of the saved nodes and inline pages to
+// the mmap, as Bucket.dereference does, before it's remapped.
+func (sp *Savepoint) dereference() {
+	for i := range sp.buckets {
+		st := &sp.buckets[i]
+		if st.rootNode != nil {
+			st.rootNode.root().dereference()
+		}
+		if st.page != nil {
+			st.page = cloneInlinePage(st.page)
+		}
+	}
+}
+
+// cloneNodes returns a copy of the node tree of a bucket, given by its root
+// node and its node cache, which later changes to the nodes don't affect.
+// The keys and values of the inodes are shared.
+func cloneNodes(root *node, nodes map[common.Pgid]*node) (*node, map[common.Pgid]*node) {
+	clones := make(map[*node]*node, len(nodes))
+	var clone func(n *node) *node
+	clone = func(n *node) *node {
+		if n == nil {
+			return nil
+		}
+		if c := clones[n]; c != nil {
+			return c
+		}
+		c := &node{
+			bucket:     n.bucket,
+			isLeaf:     n.isLeaf,
+			unbalanced: n.unbalanced,
+			spilled:    n.spilled,
+			key:        n.key,
+			pgid:       n.pgid,
+			inodes:     append(common.Inodes(nil), n.inodes...),
+		}
+		clones[n] = c
+		c.parent = clone(n.parent)
+		for _, child := range n.children {
+			c.children = append(c.children, clone(child))
+		}
+		return c
+	}
+
+	m := make(map[common.Pgid]*node, len(nodes))
+	for id, n := range nodes {
+		m[id] = clone(n)
+	}
+	return clone(root), m
+}
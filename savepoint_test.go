@@ -0,0 +1,253 @@
+package bbolt_test
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	bolt "go.etcd.io/bbolt"
+	berrors "go.etcd.io/bbolt/errors"
+	"go.etcd.io/bbolt/internal/btesting"
+)
+
+// fillSavepointDB creates the bucket "widgets" with keys, a sequence, a
+// nested bucket and an inline nested bucket.
+func fillSavepointDB(t *testing.T, db *btesting.DB) {
+	require.NoError(t, db.Fill([]byte("widgets"), 1, 1000, keyGen, valueGen("widgets")))
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("widgets"))
+		if err := b.SetSequence(42); err != nil {
+			return err
+		}
+		nested, err := b.CreateBucket([]byte("nested"))
+		if err != nil {
+			return err
+		}
+		for i := 0; i < 1000; i++ {
+			if err := nested.Put(keyGen(0, i), valueGen("nested")(0, i)); err != nil {
+				return err
+			}
+		}
+		inline, err := b.CreateBucket([]byte("inline"))
+		if err != nil {
+			return err
+		}
+		return inline.Put([]byte("foo"), []byte("bar"))
+	}))
+}
+
+// Ensure that rolling back to a savepoint undoes the changes made after it,
+// and keeps the changes made before it.
+func TestTx_Savepoint_RollbackTo(t *testing.T) {
+	db := btesting.MustCreateDB(t)
+	fillSavepointDB(t, db)
+
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("widgets"))
+		require.NoError(t, b.Put([]byte("before"), []byte("savepoint")))
+		require.NoError(t, b.Delete(keyGen(0, 1)))
+		expected := dumpTx(t, tx)
+
+		sp, err := tx.Savepoint()
+		require.NoError(t, err)
+		for i := 0; i < 1000; i += 3 {
+			require.NoError(t, b.Put(keyGen(0, i), []byte("changed")))
+			require.NoError(t, b.Bucket([]byte("nested")).Delete(keyGen(0, i)))
+		}
+		require.NoError(t, b.Bucket([]byte("inline")).Put([]byte("baz"), []byte("bat")))
+		_, err = b.NextSequence()
+		require.NoError(t, err)
+		require.NoError(t, b.DeleteBucket([]byte("nested")))
+		_, err = tx.CreateBucket([]byte("new"))
+		require.NoError(t, err)
+
+		require.NoError(t, sp.RollbackTo())
+		require.Equal(t, expected, dumpTx(t, tx))
+		require.Equal(t, uint64(42), b.Sequence())
+
+		// The savepoint can be rolled back to again.
+		require.NoError(t, b.Delete([]byte("before")))
+		require.NoError(t, sp.RollbackTo())
+		require.Equal(t, expected, dumpTx(t, tx))
+
+		// Changes after the rollback are committed.
+		require.NoError(t, b.Bucket([]byte("nested")).Put([]byte("after"), []byte("rollback")))
+		expected["widgets/nested/after"] = "rollback"
+		require.NoError(t, sp.Release())
+		return nil
+	}))
+
+	expected := dumpDB(t, db.DB)
+	require.Equal(t, "savepoint", expected["widgets/before"])
+	require.Equal(t, "rollback", expected["widgets/nested/after"])
+	require.NotContains(t, expected, "new/")
+	db.MustCheck()
+
+	db.MustClose()
+	db.MustReopen()
+	require.Equal(t, expected, dumpDB(t, db.DB))
+}
+
+// Ensure that nested savepoints can be rolled back to and released in order.
+func TestTx_Savepoint_Nested(t *testing.T) {
+	db := btesting.MustCreateDB(t)
+	fillSavepointDB(t, db)
+
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("widgets"))
+		sp1, err := tx.Savepoint()
+		require.NoError(t, err)
+		require.NoError(t, b.Put([]byte("a"), []byte("1")))
+
+		sp2, err := tx.Savepoint()
+		require.NoError(t, err)
+		require.NoError(t, b.Put([]byte("b"), []byte("2")))
+
+		sp3, err := tx.Savepoint()
+		require.NoError(t, err)
+		require.NoError(t, b.Put([]byte("c"), []byte("3")))
+
+		// Rolling back to sp2 releases sp3.
+		require.NoError(t, sp2.RollbackTo())
+		require.Equal(t, []byte("1"), b.Get([]byte("a")))
+		require.Nil(t, b.Get([]byte("b")))
+		require.Nil(t, b.Get([]byte("c")))
+		require.ErrorIs(t, sp3.RollbackTo(), berrors.ErrSavepointReleased)
+
+		// Releasing sp2 keeps its changes, which sp1 can still undo.
+		require.NoError(t, b.Put([]byte("d"), []byte("4")))
+		require.NoError(t, sp2.Release())
+		require.ErrorIs(t, sp2.Release(), berrors.ErrSavepointReleased)
+		require.Equal(t, []byte("4"), b.Get([]byte("d")))
+		require.NoError(t, sp1.RollbackTo())
+		require.Nil(t, b.Get([]byte("a")))
+		require.Nil(t, b.Get([]byte("d")))
+		return nil
+	}))
+	db.MustCheck()
+}
+
+// Ensure that the pages allocated and freed by blobs after a savepoint are
+// restored by rolling back to it.
+func TestTx_Savepoint_Blobs(t *testing.T) {
+	for name, o := range map[string]*bolt.Options{
+		"default":    nil,
+		"wal":        {WALMode: true},
+		"encryption": {Encryption: testEncryption(testEncryptionKey)},
+	} {
+		t.Run(name, func(t *testing.T) {
+			db := btesting.MustCreateDBWithOption(t, o)
+			fillSavepointDB(t, db)
+			putBlobs(t, db, blobSizes...)
+			expected := dumpDB(t, db.DB)
+			size := dbSize(t, db.DB)
+
+			require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+				inline := tx.Bucket([]byte("widgets")).Bucket([]byte("inline"))
+				sp, err := tx.Savepoint()
+				require.NoError(t, err)
+
+				// Grow the file, so that it's remapped.
+				b := tx.Bucket([]byte("blobs"))
+				require.NoError(t, b.PutReader(blobKey(len(blobSizes)), bytes.NewReader(make([]byte, 32<<20))))
+				require.NoError(t, b.Delete(blobKey(len(blobSizes)-1)))
+				require.NoError(t, b.PutReader(blobKey(1), bytes.NewReader(blobData(300*1024))))
+
+				require.NoError(t, sp.RollbackTo())
+				require.Equal(t, expected, dumpTx(t, tx))
+				require.Equal(t, []byte("bar"), inline.Get([]byte("foo")))
+				return nil
+			}))
+			requireBlobs(t, db.DB, blobSizes...)
+			db.MustCheck()
+
+			// The pages allocated after the savepoint were released.
+			require.Equal(t, size, dbSize(t, db.DB))
+		})
+	}
+}
+
+// dbSize returns the size of the database seen by a read transaction.
+func dbSize(t *testing.T, db *bolt.DB) int64 {
+	var size int64
+	require.NoError(t, db.View(func(tx *bolt.Tx) error {
+		size = tx.Size()
+		return nil
+	}))
+	return size
+}
+
+// Ensure that savepoints require an open writable transaction.
+func TestTx_Savepoint_Errors(t *testing.T) {
+	db := btesting.MustCreateDB(t)
+	require.NoError(t, db.View(func(tx *bolt.Tx) error {
+		_, err := tx.Savepoint()
+		require.ErrorIs(t, err, berrors.ErrTxNotWritable)
+		return nil
+	}))
+
+	tx, err := db.Begin(true)
+	require.NoError(t, err)
+	sp, err := tx.Savepoint()
+	require.NoError(t, err)
+	require.NoError(t, tx.Rollback())
+	require.ErrorIs(t, sp.RollbackTo(), berrors.ErrTxClosed)
+	require.ErrorIs(t, sp.Release(), berrors.ErrTxClosed)
+	_, err = tx.Savepoint()
+	require.ErrorIs(t, err, berrors.ErrTxClosed)
+}
+
+func ExampleTx_Savepoint() {
+	// Open the database.
+	db, err := bolt.Open(tempfile(), 0600, nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.Remove(db.Path())
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			return err
+		}
+		if err := b.Put([]byte("foo"), []byte("bar")); err != nil {
+			return err
+		}
+
+		// Try a sub-operation, and undo its changes if it fails.
+		sp, err := tx.Savepoint()
+		if err != nil {
+			return err
+		}
+		err = b.Put([]byte("baz"), []byte("bat"))
+		if err == nil {
+			err = b.Put([]byte{}, []byte("bat"))
+		}
+		if err != nil {
+			fmt.Printf("sub-operation failed: %v\n", err)
+			if err := sp.RollbackTo(); err != nil {
+				return err
+			}
+		} else if err := sp.Release(); err != nil {
+			return err
+		}
+
+		fmt.Printf("foo=%s, baz=%s\n", b.Get([]byte("foo")), b.Get([]byte("baz")))
+		return nil
+	}); err != nil {
+		log.Fatal(err)
+	}
+
+	// Close database to release the file lock.
+	if err := db.Close(); err != nil {
+		log.Fatal(err)
+	}
+
+	// Output:
+	// sub-operation failed: key required
+	// foo=bar, baz=
+}
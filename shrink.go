@@ -0,0 +1,242 @@
+package bbolt
+
+import (
+	"bytes"
+	"context"
+	"sort"
+
+	"go.etcd.io/bbolt/errors"
+	"go.etcd.io/bbolt/internal/common"
+)
+
+// shrinkBatchSize is the maximum number of pages moved by a transaction of
+// DB.Shrink, which bounds the memory held by the dirty nodes.
+const shrinkBatchSize = 4096
+
+// Shrink reclaims the free pages at the end of the database file, and
+// truncates the file. It moves the pages in use at the end of the file into
+// free pages below them, in a series of write transactions, then removes the
+// free pages at the end from the freelist.
+//
+// Pages which are still used by open read transactions can't be reused, so
+// they are left in place. Shrink returns when no more pages can be moved, or
+// when ctx is done. On Windows the pages are reclaimed, but the file keeps its
+// size while it's open.
+func (db *DB) Shrink(ctx context.Context) error {
+	last := common.Pgid(0)
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		var top common.Pgid
+		var moved int
+		err := db.Update(func(tx *Tx) error {
+			tx.allocLowest = true
+			var pages map[common.Pgid]struct{}
+			top, pages = tx.tailPages(shrinkBatchSize)
+			moved = len(pages)
+			if moved > 0 {
+				return tx.root.relocate(pages)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		// Stop when no page can be moved, or when the last batch didn't
+		// lower the highest page in use.
+		if moved == 0 || (last != 0 && top >= last) {
+			break
+		}
+		last = top
+	}
+
+	return db.Update(func(tx *Tx) error {
+		tx.allocLowest = true
+		tx.meta.SetPgid(tx.db.freelist.trimTail(tx.meta.Pgid()))
+		return nil
+	})
+}
+
+// tailPages returns the highest page in use, and up to max of the highest
+// pages in use which fit into free pages below them.
+func (tx *Tx) tailPages(max int) (common.Pgid, map[common.Pgid]struct{}) {
+	type pageSpan struct {
+		id common.Pgid
+		n  common.Pgid
+	}
+
+	var inUse []pageSpan
+	tx.forEachCommittedPage(tx.root.RootPage(), func(p *common.Page) {
+		inUse = append(inUse, pageSpan{p.Id(), common.Pgid(p.Overflow()) + 1})
+	})
+	sort.Slice(inUse, func(i, j int) bool { return inUse[i].id > inUse[j].id })
+
+	var free []pageSpan
+	for _, id := range tx.db.freelist.getFreePageIDs() {
+		if l := len(free); l > 0 && free[l-1].id+free[l-1].n == id {
+			free[l-1].n++
+		} else {
+			free = append(free, pageSpan{id, 1})
+		}
+	}
+
+	var top common.Pgid
+	if len(inUse) > 0 {
+		top = inUse[0].id + inUse[0].n - 1
+	}
+
+	// Move the highest pages first, each into the lowest free span which
+	// is large enough, as the allocator does.
+	pages := make(map[common.Pgid]struct{})
+	for _, p := range inUse {
+		if len(pages) >= max {
+			break
+		}
+		i := sort.Search(len(free), func(i int) bool { return free[i].id >= p.id })
+		j := 0
+		for ; j < i && free[j].n < p.n; j++ {
+		}
+		if j == i {
+			break
+		}
+		free[j].id += p.n
+		free[j].n -= p.n
+		pages[p.id] = struct{}{}
+	}
+	return top, pages
+}
+
+// relocate loads the nodes stored in the given pages, and their parents, so
+// that they are written to newly allocated pages when the transaction
+// commits, and moves the blob pages in the given pages. It descends into the
+// nested buckets.
+func (b *Bucket) relocate(pages map[common.Pgid]struct{}) error {
+	var blobs [][]byte
+	if b.RootPage() == 0 {
+		// Inline buckets are stored in their parent's page, but the chunks
+		// of their blobs aren't.
+		if b.page != nil {
+			blobs = b.tx.relocatedBlobs(b.page, pages)
+		}
+	} else {
+		var children [][]byte
+		var walk func(id common.Pgid, path []int)
+		walk = func(id common.Pgid, path []int) {
+			if _, ok := pages[id]; ok {
+				n := b.node(b.RootPage(), nil)
+				for _, index := range path {
+					n = n.childAt(index)
+				}
+			}
+
+			p := b.tx.page(id)
+			switch {
+			case p.IsBranchPage():
+				for i := range p.BranchPageElements() {
+					walk(p.BranchPageElement(uint16(i)).Pgid(), append(path, i))
+				}
+			case p.IsLeafPage():
+				for i := range p.LeafPageElements() {
+					if elem := p.LeafPageElement(uint16(i)); elem.IsBucketEntry() {
+						children = append(children, cloneBytes(elem.Key()))
+					}
+				}
+				blobs = append(blobs, b.tx.relocatedBlobs(p, pages)...)
+			}
+		}
+		walk(b.RootPage(), nil)
+
+		for _, name := range children {
+			// The blobs of a bucket can't be found without its comparator.
+			child := b.Bucket(name)
+			if child == nil {
+				return errors.ErrUnknownComparator
+			}
+			if err := child.relocate(pages); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, key := range blobs {
+		c := b.Cursor()
+		k, v, flags := c.seek(key)
+		common.Assert(bytes.Equal(k, key), "blob %q not found", key)
+		nv, err := b.tx.relocateBlob(v, pages)
+		if err != nil {
+			return err
+		}
+		c.node().put(key, key, nv, 0, flags)
+	}
+	return nil
+}
+
+// relocatedBlobs returns the keys of the blobs of a leaf page which have their
+// index or a chunk in the given pages.
+func (tx *Tx) relocatedBlobs(p *common.Page, pages map[common.Pgid]struct{}) [][]byte {
+	var keys [][]byte
+	for i := range p.LeafPageElements() {
+		elem := p.LeafPageElement(uint16(i))
+		if !elem.IsBlobEntry() {
+			continue
+		}
+		id := common.LoadBlobRef(elem.Value()).Index()
+		if id == 0 {
+			continue
+		}
+		ids := append([]common.Pgid{id}, tx.page(id).BlobChunkIds()...)
+		for _, id := range ids {
+			if _, ok := pages[id]; ok {
+				keys = append(keys, cloneBytes(elem.Key()))
+				break
+			}
+		}
+	}
+	return keys
+}
+
+// relocateBlob copies the chunks of the blob referenced by the leaf value v
+// which are in the given pages to newly allocated pages, and writes a new
+// index. The old pages are freed. It returns the leaf value referencing the
+// new index.
+func (tx *Tx) relocateBlob(v []byte, pages map[common.Pgid]struct{}) ([]byte, error) {
+	ref := *common.LoadBlobRef(v)
+	x := tx.page(ref.Index())
+	ids := append([]common.Pgid{}, x.BlobChunkIds()...)
+	chunkSize := x.BlobIndex().ChunkSize()
+
+	for i, id := range ids {
+		if _, ok := pages[id]; !ok {
+			continue
+		}
+		size := tx.page(id).BlobChunk().Size()
+		p, err := tx.allocateEagerPage(common.BlobPageFlag, int(common.PageHeaderSize)+common.BlobChunkHeaderSize+int(size))
+		if err != nil {
+			return nil, err
+		}
+		ids[i] = p.Id()
+
+		// Allocating may have remapped the file, so look up the chunk again.
+		old := tx.page(id)
+		p.BlobChunk().SetSize(size)
+		copy(p.BlobData(), old.BlobData())
+		tx.db.freelist.free(tx.meta.Txid(), old)
+		delete(tx.pages, id)
+		if err := tx.writeEagerPage(p); err != nil {
+			return nil, err
+		}
+	}
+
+	nx, err := tx.writeBlobIndex(ids, chunkSize)
+	if err != nil {
+		return nil, err
+	}
+	tx.db.freelist.free(tx.meta.Txid(), tx.page(ref.Index()))
+	delete(tx.pages, ref.Index())
+
+	nref := common.NewBlobRef(ref.Size(), nx.Id())
+	return cloneBytes(nref.Bytes()), nil
+}
@@ -0,0 +1,114 @@
+package bbolt_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	bolt "go.etcd.io/bbolt"
+	"go.etcd.io/bbolt/internal/btesting"
+)
+
+// fillShrinkDB fills the bucket "low" and then the bucket "high", which has a
+// nested bucket, and deletes "low" so that the free pages are at the start of
+// the file.
+func fillShrinkDB(t *testing.T, db *btesting.DB) {
+	require.NoError(t, db.Fill([]byte("low"), 10, 1000, keyGen, valueGen("low")))
+	require.NoError(t, db.Fill([]byte("high"), 10, 1000, keyGen, valueGen("high")))
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.Bucket([]byte("high")).CreateBucket([]byte("nested"))
+		if err != nil {
+			return err
+		}
+		for i := 0; i < 1000; i++ {
+			if err := b.Put(keyGen(0, i), valueGen("nested")(0, i)); err != nil {
+				return err
+			}
+		}
+		return tx.DeleteBucket([]byte("low"))
+	}))
+}
+
+// Ensure that Shrink moves the pages at the end of the file into free pages,
+// and truncates the file.
+func TestDB_Shrink(t *testing.T) {
+	for _, preferLowest := range []bool{false, true} {
+		t.Run(fmt.Sprintf("preferLowest=%t", preferLowest), func(t *testing.T) {
+			db := btesting.MustCreateDBWithOption(t, &bolt.Options{FreelistPreferLowest: preferLowest})
+			fillShrinkDB(t, db)
+			expected := dumpDB(t, db.DB)
+			before := fileSize(db.Path())
+
+			require.NoError(t, db.Shrink(context.Background()))
+			require.Less(t, fileSize(db.Path()), before*2/3)
+			require.Equal(t, expected, dumpDB(t, db.DB))
+			db.MustCheck()
+
+			// The file can still grow.
+			require.NoError(t, db.Fill([]byte("high"), 2, 1000, keyGen, valueGen("again")))
+			db.MustCheck()
+
+			db.MustClose()
+			db.MustReopen()
+			require.Len(t, dumpDB(t, db.DB), len(expected))
+		})
+	}
+}
+
+// Ensure that Shrink works in WAL mode, and with encrypted pages.
+func TestDB_Shrink_Options(t *testing.T) {
+	for name, o := range map[string]*bolt.Options{
+		"wal":            {WALMode: true},
+		"encryption":     {Encryption: testEncryption(testEncryptionKey)},
+		"noFreelistSync": {NoFreelistSync: true},
+	} {
+		t.Run(name, func(t *testing.T) {
+			db := btesting.MustCreateDBWithOption(t, o)
+			fillShrinkDB(t, db)
+			require.NoError(t, db.Checkpoint())
+			expected := dumpDB(t, db.DB)
+			before := fileSize(db.Path())
+
+			require.NoError(t, db.Shrink(context.Background()))
+			require.Less(t, fileSize(db.Path()), before*2/3)
+			require.Equal(t, expected, dumpDB(t, db.DB))
+			db.MustCheck()
+
+			db.MustClose()
+			db.MustReopen()
+			require.Equal(t, expected, dumpDB(t, db.DB))
+		})
+	}
+}
+
+// Ensure that Shrink leaves the pages used by an open read transaction.
+func TestDB_Shrink_OpenReadTx(t *testing.T) {
+	db := btesting.MustCreateDB(t)
+	require.NoError(t, db.Fill([]byte("high"), 10, 1000, keyGen, valueGen("high")))
+
+	// The pages of the deleted bucket are still used by the reader.
+	rtx, err := db.Begin(false)
+	require.NoError(t, err)
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		return tx.DeleteBucket([]byte("high"))
+	}))
+	before := fileSize(db.Path())
+	require.NoError(t, db.Shrink(context.Background()))
+	require.Equal(t, before, fileSize(db.Path()))
+	require.Equal(t, 10000, rtx.Bucket([]byte("high")).Stats().KeyN)
+	require.NoError(t, rtx.Rollback())
+
+	require.NoError(t, db.Shrink(context.Background()))
+	require.Less(t, fileSize(db.Path()), before/2)
+	db.MustCheck()
+}
+
+// Ensure that Shrink stops when its context is done.
+func TestDB_Shrink_Canceled(t *testing.T) {
+	db := btesting.MustCreateDB(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	require.ErrorIs(t, db.Shrink(ctx), context.Canceled)
+}
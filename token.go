@@ -0,0 +1,199 @@
+package bbolt
+
+import (
+	"bytes"
+	"encoding/binary"
+
+	"go.etcd.io/bbolt/errors"
+)
+
+// tokenVersion is the version of the format of the tokens.
+const tokenVersion = 2
+
+// The directions of the iteration recorded in a token.
+const (
+	tokenAscending  = 0
+	tokenDescending = 1
+)
+
+// The flags of a token.
+const (
+	tokenLowerExclusive = 1 << iota
+	tokenUpperInclusive
+	tokenHasKey
+	tokenHasLower
+	tokenHasUpper
+	tokenHasPrefix
+
+	tokenFlags = 1<<iota - 1
+)
+
+// Token returns an opaque token of the position of the cursor, which resumes
+// the iteration after the current key, in the direction of the last move of
+// the cursor, when it's passed to Bucket.CursorFromToken in another
+// transaction: descending after Last, Prev, SeekLE and SeekLT, and ascending
+// otherwise. If the cursor isn't on a key, the iteration resumes from the
+// first key in that direction.
+func (c *Cursor) Token() []byte {
+	var key []byte
+	if len(c.stack) > 0 {
+		key, _, _ = c.keyValue()
+	}
+	return encodeToken(c.bucket.path(), key, nil, nil, RangeOptions{Reverse: c.reverse})
+}
+
+// Token returns an opaque token of the position of the iterator, which
+// resumes the iteration after the last key returned, in the same direction,
+// between the same bounds and with the same limit, when it's passed to
+// Bucket.CursorFromToken in another transaction. If no key was returned, the
+// iteration resumes from the start of the range.
+func (it *RangeIterator) Token() []byte {
+	last := it.last
+	if last == nil {
+		last = it.anchor
+	}
+	return encodeToken(it.c.bucket.path(), last, it.lower, it.upper, it.opts)
+}
+
+// CursorFromToken returns an iterator resuming the iteration recorded in a
+// token returned by Cursor.Token or RangeIterator.Token, in a bucket at the
+// same path. The iteration resumes in the direction recorded in the token,
+// after its key, even if it was deleted since: the keys added before it
+// aren't returned, and the keys added after it are. Returns ErrInvalidToken
+// if the token is malformed, has an unknown direction or flags, or was
+// returned for a bucket at another path.
+func (b *Bucket) CursorFromToken(tok []byte) (*RangeIterator, error) {
+	path, key, lower, upper, opts, err := decodeToken(tok)
+	if err != nil {
+		return nil, err
+	}
+	bpath := b.path()
+	if len(path) != len(bpath) {
+		return nil, errors.ErrInvalidToken
+	}
+	for i := range path {
+		if !bytes.Equal(path[i], bpath[i]) {
+			return nil, errors.ErrInvalidToken
+		}
+	}
+	return &RangeIterator{c: b.Cursor(), lower: lower, upper: upper, opts: opts, anchor: key}, nil
+}
+
+// encodeToken returns a token of the position of an iteration.
+func encodeToken(path [][]byte, key, lower, upper []byte, opts RangeOptions) []byte {
+	var flags byte
+	for _, f := range []struct {
+		set  bool
+		flag byte
+	}{
+		{opts.LowerExclusive, tokenLowerExclusive},
+		{opts.UpperInclusive, tokenUpperInclusive},
+		{key != nil, tokenHasKey},
+		{lower != nil, tokenHasLower},
+		{upper != nil, tokenHasUpper},
+		{opts.Prefix != nil, tokenHasPrefix},
+	} {
+		if f.set {
+			flags |= f.flag
+		}
+	}
+
+	direction := byte(tokenAscending)
+	if opts.Reverse {
+		direction = tokenDescending
+	}
+	buf := []byte{tokenVersion, direction, flags}
+	buf = binary.AppendUvarint(buf, uint64(opts.Limit))
+	buf = binary.AppendUvarint(buf, uint64(len(path)))
+	for _, name := range path {
+		buf = appendTokenBytes(buf, name)
+	}
+	for _, b := range [][]byte{key, lower, upper, opts.Prefix} {
+		if b != nil {
+			buf = appendTokenBytes(buf, b)
+		}
+	}
+	return buf
+}
+
+// decodeToken returns the position of an iteration recorded in a token.
+func decodeToken(tok []byte) (path [][]byte, key, lower, upper []byte, opts RangeOptions, err error) {
+	if len(tok) < 3 || tok[0] != tokenVersion {
+		return nil, nil, nil, nil, opts, errors.ErrInvalidToken
+	}
+	switch tok[1] {
+	case tokenAscending:
+	case tokenDescending:
+		opts.Reverse = true
+	default:
+		return nil, nil, nil, nil, opts, errors.ErrInvalidToken
+	}
+	flags, r := tok[2], tokenReader{buf: tok[3:]}
+	if flags&^tokenFlags != 0 {
+		return nil, nil, nil, nil, opts, errors.ErrInvalidToken
+	}
+	opts.LowerExclusive = flags&tokenLowerExclusive != 0
+	opts.UpperInclusive = flags&tokenUpperInclusive != 0
+	opts.Limit = int(r.uvarint())
+
+	n := r.uvarint()
+	for i := uint64(0); i < n && r.err == nil; i++ {
+		path = append(path, r.bytes())
+	}
+	for _, f := range []struct {
+		flag byte
+		b    *[]byte
+	}{
+		{tokenHasKey, &key},
+		{tokenHasLower, &lower},
+		{tokenHasUpper, &upper},
+		{tokenHasPrefix, &opts.Prefix},
+	} {
+		if flags&f.flag != 0 {
+			*f.b = r.bytes()
+		}
+	}
+	if r.err != nil || len(r.buf) > 0 || opts.Limit < 0 {
+		return nil, nil, nil, nil, opts, errors.ErrInvalidToken
+	}
+	return path, key, lower, upper, opts, nil
+}
+
+// appendTokenBytes appends a byte slice prefixed with its length to a token.
+func appendTokenBytes(buf, b []byte) []byte {
+	buf = binary.AppendUvarint(buf, uint64(len(b)))
+	return append(buf, b...)
+}
+
+// tokenReader reads the fields of a token, and records the first error.
+type tokenReader struct {
+	buf []byte
+	err error
+}
+
+func (r *tokenReader) uvarint() uint64 {
+	if r.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(r.buf)
+	if n <= 0 {
+		r.err = errors.ErrInvalidToken
+		return 0
+	}
+	r.buf = r.buf[n:]
+	return v
+}
+
+func (r *tokenReader) bytes() []byte {
+	n := r.uvarint()
+	if r.err != nil {
+		return nil
+	}
+	if n > uint64(len(r.buf)) {
+		r.err = errors.ErrInvalidToken
+		return nil
+	}
+	b := append([]byte{}, r.buf[:n]...)
+	r.buf = r.buf[n:]
+	return b
+}
@@ -0,0 +1,190 @@
+package bbolt_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	bolt "go.etcd.io/bbolt"
+	berrors "go.etcd.io/bbolt/errors"
+	"go.etcd.io/bbolt/internal/btesting"
+)
+
+// rangeKeys returns the keys returned by an iterator.
+func rangeKeys(it *bolt.RangeIterator) []string {
+	var keys []string
+	for k, _ := it.Next(); k != nil; k, _ = it.Next() {
+		keys = append(keys, string(k))
+	}
+	return keys
+}
+
+// Ensure that tokens page through a range across transactions, in both
+// directions, when the keys around the anchors change between the pages.
+func TestBucket_CursorFromToken(t *testing.T) {
+	for _, reverse := range []bool{false, true} {
+		t.Run(fmt.Sprintf("reverse %v", reverse), func(t *testing.T) {
+			db := btesting.MustCreateDB(t)
+			require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+				parent, err := tx.CreateBucket([]byte("parent"))
+				require.NoError(t, err)
+				b, err := parent.CreateBucket([]byte("widgets"))
+				require.NoError(t, err)
+				for i := 0; i < 1000; i++ {
+					require.NoError(t, b.Put([]byte(fmt.Sprintf("%04d", i)), []byte("value")))
+				}
+				return nil
+			}))
+			bucket := func(tx *bolt.Tx) *bolt.Bucket {
+				return tx.Bucket([]byte("parent")).Bucket([]byte("widgets"))
+			}
+
+			// The keys from 0100 to 0899 included, by pages of 100 keys.
+			opts := &bolt.RangeOptions{UpperInclusive: true, Reverse: reverse, Limit: 100}
+			var tok []byte
+			var pages [][]string
+			for {
+				require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+					b := bucket(tx)
+					var it *bolt.RangeIterator
+					if tok == nil {
+						it = b.Range([]byte("0100"), []byte("0899"), opts)
+					} else {
+						var err error
+						it, err = b.CursorFromToken(tok)
+						require.NoError(t, err)
+					}
+					page := rangeKeys(it)
+					tok = it.Token()
+					if len(page) > 0 {
+						pages = append(pages, page)
+
+						// Delete the anchor, and add keys on both sides of it.
+						last := page[len(page)-1]
+						require.NoError(t, b.Delete([]byte(last)))
+						require.NoError(t, b.Put([]byte(last+"-"), nil))
+						require.NoError(t, b.Put([]byte(last[:3]), nil))
+					}
+					return nil
+				}))
+				if len(pages) == 0 || len(pages[len(pages)-1]) < 100 {
+					break
+				}
+			}
+
+			var all []string
+			for _, page := range pages {
+				all = append(all, page...)
+			}
+			// The keys added after the anchors of the 8 full pages are
+			// returned, apart from the one added below the range.
+			require.Len(t, pages, 9)
+			if !reverse {
+				require.Len(t, pages[8], 8)
+				require.Equal(t, "0100", all[0])
+				require.Equal(t, "0199", all[99])
+				require.Equal(t, "0199-", all[100])
+				require.Equal(t, "0200", all[101])
+				require.Equal(t, "0899", all[len(all)-1])
+			} else {
+				require.Len(t, pages[8], 7)
+				require.Equal(t, "0899", all[0])
+				require.Equal(t, "0800", all[99])
+				require.Equal(t, "080", all[100])
+				require.Equal(t, "0799", all[101])
+				require.Equal(t, "0100", all[len(all)-1])
+			}
+		})
+	}
+}
+
+// Ensure that a cursor token resumes after the key of the cursor, in the
+// direction of its last move.
+func TestCursor_Token(t *testing.T) {
+	db := btesting.MustCreateDB(t)
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		require.NoError(t, err)
+		for _, k := range []string{"a", "b", "c", "d"} {
+			require.NoError(t, b.Put([]byte(k), nil))
+		}
+		return nil
+	}))
+
+	var tok, startTok, prevTok, lastTok []byte
+	require.NoError(t, db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket([]byte("widgets")).Cursor()
+		startTok = c.Token()
+		c.Seek([]byte("b"))
+		tok = c.Token()
+		c.Last()
+		c.Prev()
+		prevTok = c.Token()
+		c.SeekLE([]byte("z"))
+		lastTok = c.Token()
+		return nil
+	}))
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte("widgets")).Delete([]byte("b"))
+	}))
+	require.NoError(t, db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("widgets"))
+		it, err := b.CursorFromToken(tok)
+		require.NoError(t, err)
+		require.Equal(t, []string{"c", "d"}, rangeKeys(it))
+
+		it, err = b.CursorFromToken(startTok)
+		require.NoError(t, err)
+		require.Equal(t, []string{"a", "c", "d"}, rangeKeys(it))
+
+		it, err = b.CursorFromToken(prevTok)
+		require.NoError(t, err)
+		require.Equal(t, []string{"a"}, rangeKeys(it))
+
+		it, err = b.CursorFromToken(lastTok)
+		require.NoError(t, err)
+		require.Equal(t, []string{"c", "a"}, rangeKeys(it))
+		return nil
+	}))
+}
+
+// Ensure that malformed tokens, tokens with an unknown direction or flags, and
+// tokens of other buckets, are rejected.
+func TestBucket_CursorFromToken_Invalid(t *testing.T) {
+	db := btesting.MustCreateDB(t)
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		require.NoError(t, err)
+		require.NoError(t, b.Put([]byte("foo"), nil))
+		other, err := tx.CreateBucket([]byte("other"))
+		require.NoError(t, err)
+
+		it := b.Range([]byte("a"), []byte("z"), &bolt.RangeOptions{Prefix: []byte("f"), Limit: 10})
+		it.Next()
+		tok := it.Token()
+
+		_, err = other.CursorFromToken(tok)
+		require.ErrorIs(t, err, berrors.ErrInvalidToken)
+		for i := 0; i < len(tok); i++ {
+			_, err = b.CursorFromToken(tok[:i])
+			require.ErrorIs(t, err, berrors.ErrInvalidToken)
+		}
+		_, err = b.CursorFromToken(append(tok, 0))
+		require.ErrorIs(t, err, berrors.ErrInvalidToken)
+		for _, corrupt := range []func(tok []byte){
+			func(tok []byte) { tok[1] = 2 },
+			func(tok []byte) { tok[2] |= 0x80 },
+		} {
+			tok := append([]byte{}, tok...)
+			corrupt(tok)
+			_, err = b.CursorFromToken(tok)
+			require.ErrorIs(t, err, berrors.ErrInvalidToken)
+		}
+
+		it, err = b.CursorFromToken(tok)
+		require.NoError(t, err)
+		require.Empty(t, rangeKeys(it))
+		return nil
+	}))
+}
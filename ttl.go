@@ -0,0 +1,322 @@
+package bbolt
+
+import (
+	"bytes"
+	"encoding/binary"
+	"sync"
+	"time"
+
+	"go.etcd.io/bbolt/internal/common"
+)
+
+// expiryBucket is the name of the top level bucket holding the expiry times
+// of the keys set by Bucket.PutWithTTL, sorted by time. It's maintained by
+// Put, Delete, MoveBucket and DB.ReapExpired, and hidden from the public API.
+const expiryBucket = "\x00bbolt.expiry"
+
+// DefaultReapBatchSize is the number of expired keys deleted by each
+// transaction of DB.ReapExpired.
+const DefaultReapBatchSize = 1000
+
+// expirySize is the size of the expiry time prepended to expiring values.
+const expirySize = 8
+
+// PutWithTTL sets the value for a key in the bucket, like Put, which expires
+// after ttl. Expired keys are deleted by DB.ReapExpired, and hidden until then
+// if DB.HideExpired is set. Setting the key again with Put makes it permanent.
+//
+// The expiry times are stored in a hidden top level bucket, under the path
+// of the bucket, which MoveBucket rewrites.
+func (b *Bucket) PutWithTTL(key []byte, value []byte, ttl time.Duration) error {
+	expiry := time.Now().Add(ttl).UnixNano()
+	if expiry <= 0 {
+		// Zero is the expiry time of the keys which don't expire.
+		expiry = 1
+	}
+	return b.put(b.Cursor(), key, value, expiry)
+}
+
+// ReapExpired deletes the keys whose expiry time has passed, and returns the
+// number of keys deleted. The keys are deleted with Bucket.Delete, in a
+// series of write transactions of DefaultReapBatchSize keys, so other writers
+// are only blocked for short periods.
+func (db *DB) ReapExpired() (int, error) {
+	var reaped int
+	for {
+		n, more, err := db.reapBatch()
+		if err != nil {
+			return reaped, err
+		}
+		reaped += n
+		if !more {
+			return reaped, nil
+		}
+	}
+}
+
+// reapBatch deletes up to DefaultReapBatchSize expired keys in a write
+// transaction, and returns whether more keys may have expired.
+func (db *DB) reapBatch() (reaped int, more bool, err error) {
+	// Only start a write transaction if a key expired.
+	now := time.Now().UnixNano()
+	var expired bool
+	if err := db.View(func(tx *Tx) error {
+		if eb := tx.expiryBucket(); eb != nil {
+			k, _ := eb.Cursor().First()
+			expired = k != nil && loadExpiry(k) <= now
+		}
+		return nil
+	}); err != nil || !expired {
+		return 0, false, err
+	}
+
+	err = db.Update(func(tx *Tx) error {
+		reaped, more = 0, false
+		eb := tx.expiryBucket()
+		if eb == nil {
+			return nil
+		}
+
+		// Collect the entries first, as deleting the keys deletes them.
+		var entries [][]byte
+		c := eb.Cursor()
+		for k, _ := c.First(); k != nil && loadExpiry(k) <= now; k, _ = c.Next() {
+			if len(entries) == DefaultReapBatchSize {
+				more = true
+				break
+			}
+			entries = append(entries, cloneBytes(k))
+		}
+
+		for _, e := range entries {
+			if b, key := tx.expiringKey(e); b != nil {
+				if err := b.Delete(key); err != nil {
+					return err
+				}
+				reaped++
+				continue
+			}
+
+			// The key was deleted with its bucket.
+			if err := eb.Delete(e); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return reaped, more, err
+}
+
+// expiringKey returns the key of an expiry entry and its bucket, or a nil
+// bucket if the key doesn't exist or has another expiry time.
+func (tx *Tx) expiringKey(entry []byte) (*Bucket, []byte) {
+	expiry, path, key, ok := decodeExpiryEntry(entry)
+	if !ok {
+		return nil, nil
+	}
+	b := tx.bucketAt(path)
+	if b == nil {
+		return nil, nil
+	}
+	k, v, flags := b.Cursor().seek(key)
+	if !bytes.Equal(k, key) || (flags&common.ExpiringLeafFlag) == 0 || loadExpiry(v) != expiry {
+		return nil, nil
+	}
+	return b, key
+}
+
+// expiryBucket returns the expiry bucket, or nil if it doesn't exist.
+func (tx *Tx) expiryBucket() *Bucket {
+	return tx.root.nestedBucket([]byte(expiryBucket))
+}
+
+// putExpiryEntry adds an entry to the expiry bucket, creating it if needed.
+func (tx *Tx) putExpiryEntry(entry []byte) error {
+	// Make the versions of bbolt which can't read expiring values, nor hide
+	// the expiry bucket, refuse the file.
+	tx.meta.AddFeature(common.FeatureExpiringKeys)
+
+	eb := tx.expiryBucket()
+	if eb == nil {
+		// Create it like CreateBucket, which refuses its name.
+		name := []byte(expiryBucket)
+		c := tx.root.Cursor()
+		c.seek(name)
+		empty := Bucket{InBucket: &common.InBucket{}, rootNode: &node{isLeaf: true}}
+		c.node().put(name, name, empty.write(), 0, common.BucketLeafFlag)
+		tx.root.page = nil
+		eb = tx.expiryBucket()
+	}
+	return eb.Put(entry, []byte{})
+}
+
+// moveExpiryEntries rewrites the expiry entries of the expiring keys of b,
+// and of the buckets nested in it, from the bucket path from to the path to.
+func (tx *Tx) moveExpiryEntries(b *Bucket, from, to [][]byte) error {
+	eb := tx.expiryBucket()
+	if eb == nil {
+		return nil
+	}
+	c := b.Cursor()
+	for k, v, flags := c.first(); k != nil; k, v, flags = c.next() {
+		switch {
+		case (flags & common.BucketLeafFlag) != 0:
+			child := b.nestedBucket(k)
+			if err := tx.moveExpiryEntries(child, append(from[:len(from):len(from)], k), append(to[:len(to):len(to)], k)); err != nil {
+				return err
+			}
+		case (flags & common.ExpiringLeafFlag) != 0:
+			expiry := loadExpiry(v)
+			if err := eb.Delete(expiryEntry(expiry, from, k)); err != nil {
+				return err
+			}
+			if err := eb.Put(expiryEntry(expiry, to, k), []byte{}); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// deleteExpiry deletes the expiry entry of key, whose previous leaf value v
+// has the given flags, if it was expiring.
+func (b *Bucket) deleteExpiry(key []byte, v []byte, flags uint32) error {
+	if v == nil || (flags&common.ExpiringLeafFlag) == 0 {
+		return nil
+	}
+	eb := b.tx.expiryBucket()
+	if eb == nil {
+		return nil
+	}
+	return eb.Delete(expiryEntry(loadExpiry(v), b.path(), key))
+}
+
+// expired returns whether a leaf value with the given flags has expired, and
+// is hidden by DB.HideExpired.
+func (b *Bucket) expired(v []byte, flags uint32) bool {
+	return (flags&common.ExpiringLeafFlag) != 0 && b.tx.db.HideExpired && loadExpiry(v) <= time.Now().UnixNano()
+}
+
+// hidden returns whether the cursors skip the key k, whose leaf value v has
+// the given flags: the expired keys, and the reserved buckets.
+func (b *Bucket) hidden(k, v []byte, flags uint32) bool {
+	return b.expired(v, flags) || ((flags&common.BucketLeafFlag) != 0 && b.reservedBucket(k))
+}
+
+// reservedBucket returns whether name is the name of a bucket maintained by
+// bbolt in b, which is hidden from the public API: the expiry bucket, at the
+// top level.
+func (b *Bucket) reservedBucket(name []byte) bool {
+	return b == &b.tx.root && string(name) == expiryBucket
+}
+
+// leafExpiry returns the expiry time of a leaf value with the given flags, or
+// 0 if it doesn't expire.
+func leafExpiry(v []byte, flags uint32) int64 {
+	if (flags & common.ExpiringLeafFlag) == 0 {
+		return 0
+	}
+	return loadExpiry(v)
+}
+
+// loadExpiry returns the expiry time stored at the start of buf.
+func loadExpiry(buf []byte) int64 {
+	return int64(binary.BigEndian.Uint64(buf))
+}
+
+// appendExpiry appends the expiry time and the value v to buf.
+func appendExpiry(buf []byte, expiry int64, v []byte) []byte {
+	buf = binary.BigEndian.AppendUint64(buf, uint64(expiry))
+	return append(buf, v...)
+}
+
+// expiryEntry returns the key of the expiry entry of a key, which is its
+// expiry time, followed by the length prefixed names of its bucket path, a
+// zero length and the key. The entries are sorted by expiry time.
+func expiryEntry(expiry int64, path [][]byte, key []byte) []byte {
+	buf := make([]byte, 0, expirySize+len(key)+1+len(path)*(binary.MaxVarintLen32+16))
+	buf = appendExpiry(buf, expiry, nil)
+	for _, name := range path {
+		buf = binary.AppendUvarint(buf, uint64(len(name)))
+		buf = append(buf, name...)
+	}
+	buf = binary.AppendUvarint(buf, 0)
+	return append(buf, key...)
+}
+
+// decodeExpiryEntry returns the expiry time, the bucket path and the key of
+// an expiry entry, or false if it's invalid.
+func decodeExpiryEntry(e []byte) (expiry int64, path [][]byte, key []byte, ok bool) {
+	if len(e) < expirySize {
+		return 0, nil, nil, false
+	}
+	expiry, e = loadExpiry(e), e[expirySize:]
+	for {
+		n, sz := binary.Uvarint(e)
+		if sz <= 0 || uint64(len(e)-sz) < n {
+			return 0, nil, nil, false
+		}
+		e = e[sz:]
+		if n == 0 {
+			return expiry, path, e, len(e) > 0
+		}
+		path = append(path, e[:n])
+		e = e[n:]
+	}
+}
+
+// reaper deletes the expired keys periodically.
+type reaper struct {
+	db       *DB
+	interval time.Duration
+	stopping chan struct{}
+	stopped  chan struct{}
+	stopOnce sync.Once
+}
+
+// newReaper starts a reaper of the database.
+func newReaper(db *DB, interval time.Duration) *reaper {
+	r := &reaper{
+		db:       db,
+		interval: interval,
+		stopping: make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+	go r.run()
+	return r
+}
+
+// run reaps the expired keys every interval, checking for a stop between the
+// batches.
+func (r *reaper) run() {
+	defer close(r.stopped)
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.stopping:
+			return
+		case <-ticker.C:
+		}
+		for more := true; more; {
+			select {
+			case <-r.stopping:
+				return
+			default:
+			}
+			var err error
+			if _, more, err = r.db.reapBatch(); err != nil {
+				r.db.Logger().Errorf("reaping expired keys failed: %v", err)
+				break
+			}
+		}
+	}
+}
+
+// stop stops the reaper and waits for it to exit.
+func (r *reaper) stop() {
+	r.stopOnce.Do(func() {
+		close(r.stopping)
+	})
+	<-r.stopped
+}
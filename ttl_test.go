@@ -0,0 +1,353 @@
+package bbolt_test
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	bolt "go.etcd.io/bbolt"
+	berrors "go.etcd.io/bbolt/errors"
+	"go.etcd.io/bbolt/internal/btesting"
+	"go.etcd.io/bbolt/internal/common"
+)
+
+// expiryEntries returns the number of entries in the expiry bucket.
+func expiryEntries(t *testing.T, db *bolt.DB) int {
+	var n int
+	require.NoError(t, db.View(func(tx *bolt.Tx) error {
+		n = bolt.ExpiryEntryN(tx)
+		return nil
+	}))
+	return n
+}
+
+// cursorKeys returns the keys of a bucket, iterated forwards and backwards.
+func cursorKeys(b *bolt.Bucket) (forward, backward []string) {
+	c := b.Cursor()
+	for k, _ := c.First(); k != nil; k, _ = c.Next() {
+		forward = append(forward, string(k))
+	}
+	for k, _ := c.Last(); k != nil; k, _ = c.Prev() {
+		backward = append(backward, string(k))
+	}
+	return forward, backward
+}
+
+// Ensure that expired keys are only hidden from Get and the cursors if
+// DB.HideExpired is set.
+func TestBucket_PutWithTTL(t *testing.T) {
+	db := btesting.MustCreateDB(t)
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		require.NoError(t, err)
+		require.NoError(t, b.PutWithTTL([]byte("a"), []byte("expired"), -time.Second))
+		require.NoError(t, b.PutWithTTL([]byte("b"), []byte("live"), time.Hour))
+		require.NoError(t, b.Put([]byte("c"), []byte("permanent")))
+		require.NoError(t, b.PutWithTTL([]byte("d"), []byte("expired"), -time.Second))
+
+		coded, err := tx.CreateBucketWithOptions([]byte("coded"), &bolt.BucketOptions{Codec: bolt.FlateCodec})
+		require.NoError(t, err)
+		return coded.PutWithTTL([]byte("foo"), []byte("bar"), time.Hour)
+	}))
+	require.Equal(t, 4, expiryEntries(t, db.DB))
+	db.MustCheck()
+
+	require.NoError(t, db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("widgets"))
+		require.Equal(t, []byte("expired"), b.Get([]byte("a")))
+		forward, _ := cursorKeys(b)
+		require.Equal(t, []string{"a", "b", "c", "d"}, forward)
+		require.Equal(t, []byte("bar"), tx.Bucket([]byte("coded")).Get([]byte("foo")))
+		return nil
+	}))
+
+	db.HideExpired = true
+	require.NoError(t, db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("widgets"))
+		require.Nil(t, b.Get([]byte("a")))
+		require.Equal(t, []byte("live"), b.Get([]byte("b")))
+		forward, backward := cursorKeys(b)
+		require.Equal(t, []string{"b", "c"}, forward)
+		require.Equal(t, []string{"c", "b"}, backward)
+
+		k, v := b.Cursor().Seek([]byte("a"))
+		require.Equal(t, []byte("b"), k)
+		require.Equal(t, []byte("live"), v)
+		k, _ = b.Cursor().Seek([]byte("d"))
+		require.Nil(t, k)
+		return nil
+	}))
+}
+
+// Ensure that ReapExpired deletes the expired keys in batches, and that
+// overwriting or deleting a key removes its expiry time.
+func TestDB_ReapExpired(t *testing.T) {
+	const n = 2*bolt.DefaultReapBatchSize + 10
+	db := btesting.MustCreateDBWithOption(t, &bolt.Options{WatchBufferSize: n})
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		parent, err := tx.CreateBucket([]byte("parent"))
+		require.NoError(t, err)
+		b, err := parent.CreateBucket([]byte("sessions"))
+		require.NoError(t, err)
+		for i := 0; i < n; i++ {
+			require.NoError(t, b.PutWithTTL([]byte(fmt.Sprintf("%05d", i)), []byte("expired"), -time.Minute))
+		}
+		require.NoError(t, b.PutWithTTL([]byte("live"), []byte("value"), time.Hour))
+
+		// Overwritten and deleted keys don't expire.
+		require.NoError(t, b.Put([]byte("00000"), []byte("permanent")))
+		require.NoError(t, b.Delete([]byte("00001")))
+		c := b.Cursor()
+		c.Seek([]byte("00002"))
+		require.NoError(t, c.Delete())
+
+		// The keys of deleted buckets leave stale entries.
+		deleted, err := tx.CreateBucket([]byte("deleted"))
+		require.NoError(t, err)
+		require.NoError(t, deleted.PutWithTTL([]byte("foo"), []byte("bar"), -time.Minute))
+		return tx.DeleteBucket([]byte("deleted"))
+	}))
+	require.Equal(t, n-3+2, expiryEntries(t, db.DB))
+
+	ch, err := db.Watch(context.Background(), bucketPath("parent", "sessions"), nil)
+	require.NoError(t, err)
+	reaped, err := db.ReapExpired()
+	require.NoError(t, err)
+	require.Equal(t, n-3, reaped)
+	require.Len(t, receiveEvents(ch), n-3)
+	require.Equal(t, 1, expiryEntries(t, db.DB))
+	db.MustCheck()
+
+	require.NoError(t, db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("parent")).Bucket([]byte("sessions"))
+		require.Equal(t, 2, b.Stats().KeyN)
+		require.Equal(t, []byte("permanent"), b.Get([]byte("00000")))
+		require.Equal(t, []byte("value"), b.Get([]byte("live")))
+		return nil
+	}))
+
+	reaped, err = db.ReapExpired()
+	require.NoError(t, err)
+	require.Zero(t, reaped)
+}
+
+// Ensure that MoveBucket rewrites the expiry entries of the keys of the moved
+// bucket and of its nested buckets, so that they're still reaped.
+func TestDB_ReapExpired_MoveBucket(t *testing.T) {
+	db := btesting.MustCreateDB(t)
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		src, err := tx.CreateBucket([]byte("src"))
+		require.NoError(t, err)
+		b, err := src.CreateBucket([]byte("sessions"))
+		require.NoError(t, err)
+		require.NoError(t, b.PutWithTTL([]byte("foo"), []byte("expired"), -time.Minute))
+		require.NoError(t, b.PutWithTTL([]byte("bar"), []byte("live"), time.Hour))
+		nested, err := b.CreateBucket([]byte("nested"))
+		require.NoError(t, err)
+		require.NoError(t, nested.PutWithTTL([]byte("baz"), []byte("expired"), -time.Minute))
+		_, err = tx.CreateBucket([]byte("dst"))
+		return err
+	}))
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte("src")).MoveBucket([]byte("sessions"), tx.Bucket([]byte("dst")))
+	}))
+	require.Equal(t, 3, expiryEntries(t, db.DB))
+
+	reaped, err := db.ReapExpired()
+	require.NoError(t, err)
+	require.Equal(t, 2, reaped)
+	require.Equal(t, 1, expiryEntries(t, db.DB))
+	db.MustCheck()
+
+	require.NoError(t, db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("dst")).Bucket([]byte("sessions"))
+		require.Nil(t, b.Get([]byte("foo")))
+		require.Equal(t, []byte("live"), b.Get([]byte("bar")))
+		require.Nil(t, b.Bucket([]byte("nested")).Get([]byte("baz")))
+		return nil
+	}))
+}
+
+// Ensure that the expiry bucket is hidden from the top level buckets, and
+// can't be created, deleted or moved.
+func TestTx_ExpiryBucket_Hidden(t *testing.T) {
+	db := btesting.MustCreateDB(t)
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		require.NoError(t, err)
+		return b.PutWithTTL([]byte("foo"), []byte("bar"), time.Hour)
+	}))
+	require.Equal(t, 1, expiryEntries(t, db.DB))
+
+	name := []byte("\x00bbolt.expiry")
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		var names []string
+		require.NoError(t, tx.ForEach(func(k []byte, _ *bolt.Bucket) error {
+			names = append(names, string(k))
+			return nil
+		}))
+		require.Equal(t, []string{"widgets"}, names)
+
+		var walked []string
+		require.NoError(t, tx.Walk(func(path [][]byte, k, v []byte, seq uint64) error {
+			walked = append(walked, string(k))
+			return nil
+		}))
+		require.Equal(t, []string{"widgets", "foo"}, walked)
+
+		k, _ := tx.Cursor().First()
+		require.Equal(t, []byte("widgets"), k)
+		require.Nil(t, tx.Bucket(name))
+
+		_, err := tx.CreateBucket(name)
+		require.ErrorIs(t, err, berrors.ErrBucketNameReserved)
+		_, err = tx.CreateBucketIfNotExists(name)
+		require.ErrorIs(t, err, berrors.ErrBucketNameReserved)
+		require.ErrorIs(t, tx.DeleteBucket(name), berrors.ErrBucketNotFound)
+		require.ErrorIs(t, tx.MoveBucket(name, nil, tx.Bucket([]byte("widgets"))), berrors.ErrBucketNotFound)
+		_, err = tx.Cursor().Bucket().DeleteRange(nil, nil)
+		require.ErrorIs(t, err, berrors.ErrBucketNameReserved)
+		return nil
+	}))
+	require.Equal(t, 1, expiryEntries(t, db.DB))
+	db.MustCheck()
+}
+
+// Ensure that the meta page flags the expiring keys once one is set, so that
+// the versions of bbolt which can't read them refuse the file.
+func TestBucket_PutWithTTL_Format(t *testing.T) {
+	db := btesting.MustCreateDB(t)
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		require.NoError(t, err)
+		return b.Put([]byte("foo"), []byte("bar"))
+	}))
+	db.MustClose()
+	require.False(t, fileMeta(t, db.Path()).HasFeature(common.FeatureExpiringKeys))
+
+	db.MustReopen()
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte("widgets")).PutWithTTL([]byte("baz"), []byte("bat"), time.Hour)
+	}))
+	db.MustClose()
+	require.True(t, fileMeta(t, db.Path()).HasFeature(common.FeatureExpiringKeys))
+	db.MustReopen()
+}
+
+// Ensure that the expired keys are reaped in the background.
+func TestDB_ReapInterval(t *testing.T) {
+	db := btesting.MustCreateDBWithOption(t, &bolt.Options{ReapInterval: 10 * time.Millisecond})
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		require.NoError(t, err)
+		require.NoError(t, b.PutWithTTL([]byte("foo"), []byte("bar"), 50*time.Millisecond))
+		return b.PutWithTTL([]byte("baz"), []byte("bat"), time.Hour)
+	}))
+
+	require.Eventually(t, func() bool {
+		return expiryEntries(t, db.DB) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+	require.NoError(t, db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("widgets"))
+		require.Nil(t, b.Get([]byte("foo")))
+		require.Equal(t, []byte("bat"), b.Get([]byte("baz")))
+		return nil
+	}))
+}
+
+// Ensure that Compact keeps the expiry times of the keys.
+func TestCompact_TTL(t *testing.T) {
+	src := btesting.MustCreateDB(t)
+	require.NoError(t, src.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		require.NoError(t, err)
+		require.NoError(t, b.PutWithTTL([]byte("foo"), []byte("bar"), -time.Minute))
+		return b.PutWithTTL([]byte("baz"), []byte("bat"), time.Hour)
+	}))
+
+	dst, err := bolt.Open(filepath.Join(t.TempDir(), "dst"), 0600, nil)
+	require.NoError(t, err)
+	defer dst.Close()
+	require.NoError(t, bolt.Compact(dst, src.DB, 0))
+	require.Equal(t, dumpDB(t, src.DB), dumpDB(t, dst))
+
+	reaped, err := dst.ReapExpired()
+	require.NoError(t, err)
+	require.Equal(t, 1, reaped)
+	require.Equal(t, 1, expiryEntries(t, dst))
+}
+
+// Ensure that Compact skips the expired keys when the source hides them.
+func TestCompact_TTL_HideExpired(t *testing.T) {
+	for _, txMaxSize := range []int64{0, 4096} {
+		src := btesting.MustCreateDB(t)
+		require.NoError(t, src.Update(func(tx *bolt.Tx) error {
+			b, err := tx.CreateBucket([]byte("widgets"))
+			require.NoError(t, err)
+			require.NoError(t, b.PutWithTTL([]byte("foo"), []byte("bar"), -time.Minute))
+			return b.PutWithTTL([]byte("baz"), []byte("bat"), time.Hour)
+		}))
+		src.HideExpired = true
+
+		dst, err := bolt.Open(filepath.Join(t.TempDir(), "dst"), 0600, nil)
+		require.NoError(t, err)
+		require.NoError(t, bolt.Compact(dst, src.DB, txMaxSize))
+		require.Equal(t, dumpDB(t, src.DB), dumpDB(t, dst))
+		require.Equal(t, 1, expiryEntries(t, dst))
+		require.NoError(t, dst.Close())
+	}
+}
+
+func ExampleBucket_PutWithTTL() {
+	// Open the database, hiding the expired keys.
+	db, err := bolt.Open(tempfile(), 0600, &bolt.Options{HideExpired: true})
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.Remove(db.Path())
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("sessions"))
+		if err != nil {
+			return err
+		}
+		if err := b.PutWithTTL([]byte("alice"), []byte("token1"), time.Hour); err != nil {
+			return err
+		}
+		return b.PutWithTTL([]byte("bob"), []byte("token2"), -time.Second)
+	}); err != nil {
+		log.Fatal(err)
+	}
+
+	// Read the sessions which didn't expire.
+	if err := db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte("sessions")).ForEach(func(k, v []byte) error {
+			fmt.Printf("%s: %s\n", k, v)
+			return nil
+		})
+	}); err != nil {
+		log.Fatal(err)
+	}
+
+	// Delete the expired sessions.
+	reaped, err := db.ReapExpired()
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("reaped %d\n", reaped)
+
+	// Close database to release the file lock.
+	if err := db.Close(); err != nil {
+		log.Fatal(err)
+	}
+
+	// Output:
+	// alice: token1
+	// reaped 1
+}
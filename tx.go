@@ -34,6 +34,29 @@ type Tx struct {
 	stats          TxStats
 	commitHandlers []func()
 
+	// pageErr holds the first page checksum or decryption failure detected
+	// by the transaction. Pages may be read concurrently by Bucket.ParallelScan.
+	pageErr atomic.Pointer[error]
+
+	// allocLowest makes the transaction allocate the lowest free pages,
+	// whatever the freelist preference is. It's set by DB.Shrink.
+	allocLowest bool
+
+	// eagerPages holds the headers of the pages written before commit, by blobs
+	// and bulk loads.
+	// A rollback reloads the freelist if there are any, and rolling back to
+	// a savepoint returns the ones allocated after it.
+	eagerPages []*common.Page
+
+	// savepoints holds the savepoints which weren't released, in the order
+	// they were created.
+	savepoints []*Savepoint
+
+	// watching is set if the database had watchers when the transaction
+	// started, and changes holds the changes recorded for them.
+	watching bool
+	changes  []ChangeEvent
+
 	// WriteFlag specifies the flag for write-related methods like WriteTo().
 	// Tx opens the database file with the specified flag to copy the data.
 	//
@@ -72,6 +95,17 @@ func (tx *Tx) ID() int {
 	return int(tx.meta.Txid())
 }
 
+// Err returns the error wrapping ErrPageChecksum or ErrPageDecrypt of the first
+// page read by the transaction which failed checksum verification or
+// decryption, or nil. Such a page is read as if it were empty, so the keys it
+// holds are missing from the results of the transaction.
+func (tx *Tx) Err() error {
+	if err := tx.pageErr.Load(); err != nil {
+		return *err
+	}
+	return nil
+}
+
 // DB returns a reference to the database that created the transaction.
 func (tx *Tx) DB() *DB {
 	return tx.db
@@ -119,6 +153,13 @@ func (tx *Tx) CreateBucket(name []byte) (*Bucket, error) {
 	return tx.root.CreateBucket(name)
 }
 
+// CreateBucketWithOptions creates a new bucket with the given persisted options.
+// Returns an error if the bucket already exists, if the bucket name is blank, or if the bucket name is too long.
+// The bucket instance is only valid for the lifetime of the transaction.
+func (tx *Tx) CreateBucketWithOptions(name []byte, opts *BucketOptions) (*Bucket, error) {
+	return tx.root.CreateBucketWithOptions(name, opts)
+}
+
 // CreateBucketIfNotExists creates a new bucket if it doesn't already exist.
 // Returns an error if the bucket name is blank, or if the bucket name is too long.
 // The bucket instance is only valid for the lifetime of the transaction.
@@ -151,6 +192,7 @@ func (tx *Tx) MoveBucket(child []byte, src *Bucket, dst *Bucket) error {
 }
 
 // ForEach executes a function for each bucket in the root.
+// The bucket is nil if its comparator isn't registered.
 // If the provided function returns an error then the iteration is stopped and
 // the error is returned to the caller.
 func (tx *Tx) ForEach(fn func(name []byte, b *Bucket) error) error {
@@ -184,6 +226,9 @@ func (tx *Tx) Commit() (err error) {
 		return berrors.ErrTxClosed
 	} else if !tx.writable {
 		return berrors.ErrTxNotWritable
+	} else if err = tx.Err(); err != nil {
+		tx.rollback()
+		return err
 	}
 
 	// TODO(benbjohnson): Use vectorized I/O to write out dirty pages.
@@ -196,6 +241,8 @@ func (tx *Tx) Commit() (err error) {
 	}
 
 	opgid := tx.meta.Pgid()
+	// The high water mark of the last commit, which DB.Shrink may lower.
+	cpgid := tx.db.meta().Pgid()
 
 	// spill data onto dirty pages.
 	startTime = time.Now()
@@ -203,12 +250,22 @@ func (tx *Tx) Commit() (err error) {
 		lg.Errorf("spilling data onto dirty pages failed: %v", err)
 		tx.rollback()
 		return err
+	} else if err = tx.Err(); err != nil {
+		// Spilling reads the pages of the nodes it frees.
+		tx.rollback()
+		return err
 	}
 	tx.stats.IncSpillTime(time.Since(startTime))
 
 	// Free the old root bucket.
 	tx.meta.RootBucket().SetRootPage(tx.root.RootPage())
 
+	// Make the versions of bbolt which can't read prefix-compressed pages
+	// refuse the file.
+	if tx.db.PrefixCompression {
+		tx.meta.AddFeature(common.FeaturePrefixCompression)
+	}
+
 	// Free the old freelist because commit writes out a fresh freelist.
 	if tx.meta.Freelist() != common.PgidNoFreelist {
 		tx.db.freelist.free(tx.meta.Txid(), tx.db.page(tx.meta.Freelist()))
@@ -237,9 +294,15 @@ func (tx *Tx) Commit() (err error) {
 		}
 	}
 
-	// Write dirty pages to disk.
+	// Write dirty pages to disk, or append them to the write-ahead log
+	// along with the meta.
 	startTime = time.Now()
-	if err = tx.write(); err != nil {
+	if tx.db.wal != nil {
+		err = tx.db.wal.commit(tx)
+	} else {
+		err = tx.write()
+	}
+	if err != nil {
 		lg.Errorf("writing data failed: %v", err)
 		tx.rollback()
 		return err
@@ -262,13 +325,27 @@ func (tx *Tx) Commit() (err error) {
 	}
 
 	// Write meta to disk.
-	if err = tx.writeMeta(); err != nil {
-		lg.Errorf("writeMeta failed: %v", err)
-		tx.rollback()
-		return err
+	if tx.db.wal == nil {
+		if err = tx.writeMeta(); err != nil {
+			lg.Errorf("writeMeta failed: %v", err)
+			tx.rollback()
+			return err
+		}
 	}
 	tx.stats.IncWriteTime(time.Since(startTime))
 
+	// If the high water mark has moved down then shrink the file. The commit
+	// is durable already, so a failure only leaves the file larger.
+	if tx.meta.Pgid() < cpgid {
+		if shrinkErr := tx.db.shrinkFile(tx.meta.Pgid()); shrinkErr != nil {
+			lg.Warningf("shrinking db file failed, pgid: %d, pagesize: %d, error: %v", tx.meta.Pgid(), tx.db.pageSize, shrinkErr)
+		}
+	}
+
+	// Publish the changes before the writer lock is released, so that they
+	// are published in commit order.
+	tx.db.publishChanges(tx.ID(), tx.changes)
+
 	// Finalize the transaction.
 	tx.close()
 
@@ -283,7 +360,7 @@ func (tx *Tx) Commit() (err error) {
 func (tx *Tx) commitFreelist() error {
 	// Allocate new pages for the new free list. This will overestimate
 	// the size of the freelist but not underestimate the size (which would be bad).
-	p, err := tx.allocate((tx.db.freelist.size() / tx.db.pageSize) + 1)
+	p, err := tx.allocate(((tx.db.freelist.size() + tx.db.pageTrailerSize()) / tx.db.pageSize) + 1)
 	if err != nil {
 		tx.rollback()
 		return err
@@ -299,13 +376,14 @@ func (tx *Tx) commitFreelist() error {
 
 // Rollback closes the transaction and ignores all previous updates. Read-only
 // transactions must be rolled back and not committed.
+// Returns the error of Err if a page failed verification or decryption.
 func (tx *Tx) Rollback() error {
 	common.Assert(!tx.managed, "managed tx rollback not allowed")
 	if tx.db == nil {
 		return berrors.ErrTxClosed
 	}
 	tx.nonPhysicalRollback()
-	return nil
+	return tx.Err()
 }
 
 // nonPhysicalRollback is called when user calls Rollback directly, in this case we do not need to reload the free pages from disk.
@@ -313,6 +391,11 @@ func (tx *Tx) nonPhysicalRollback() {
 	if tx.db == nil {
 		return
 	}
+	// The pages written before commit are only returned by reloading.
+	if len(tx.eagerPages) > 0 {
+		tx.rollback()
+		return
+	}
 	if tx.writable {
 		tx.db.freelist.rollback(tx.meta.Txid())
 	}
@@ -373,6 +456,8 @@ func (tx *Tx) close() {
 	tx.meta = nil
 	tx.root = Bucket{tx: tx}
 	tx.pages = nil
+	tx.savepoints = nil
+	tx.changes = nil
 }
 
 // Copy writes the entire database to a writer.
@@ -423,6 +508,13 @@ func (tx *Tx) WriteTo(w io.Writer) (n int64, err error) {
 		return n, fmt.Errorf("meta 1 copy: %s", err)
 	}
 
+	// Pages logged since the last checkpoint aren't in the file yet.
+	if tx.db.wal != nil {
+		wn, err := tx.db.wal.writeDataPages(w, tx.meta.Pgid())
+		n += wn
+		return n, err
+	}
+
 	// Move past the meta pages in the file.
 	if _, err := f.Seek(int64(tx.db.pageSize*2), io.SeekStart); err != nil {
 		return n, fmt.Errorf("seek: %s", err)
@@ -485,9 +577,15 @@ func (tx *Tx) write() error {
 	// Clear out page cache early.
 	tx.pages = make(map[common.Pgid]*common.Page)
 	sort.Sort(pages)
+	tx.db.setPageChecksums(pages)
+	sealed, err := tx.db.sealPages(pages)
+	if err != nil {
+		lg.Errorf("encrypting pages failed: %v", err)
+		return err
+	}
 
 	// Write pages to disk in order.
-	for _, p := range pages {
+	for _, p := range sealed {
 		rem := (uint64(p.Overflow()) + 1) * uint64(tx.db.pageSize)
 		offset := int64(p.Id()) * int64(tx.db.pageSize)
 		var written uintptr
@@ -519,6 +617,7 @@ func (tx *Tx) write() error {
 			written += uintptr(sz)
 		}
 	}
+	tx.db.recordPageWrites(pages, tx.meta.Txid())
 
 	// Ignore file sync if flag is set on DB.
 	if !tx.db.NoSync || common.IgnoreNoSync {
@@ -588,7 +687,22 @@ func (tx *Tx) page(id common.Pgid) *common.Page {
 	}
 
 	// Otherwise return directly from the mmap.
-	p := tx.db.page(id)
+	return tx.storedPage(id)
+}
+
+// storedPage returns a reference to the page with a given id as it is stored.
+// A page which fails checksum verification or decryption is returned empty,
+// and its error is returned by Err.
+func (tx *Tx) storedPage(id common.Pgid) *common.Page {
+	p, err := tx.db.readPage(id)
+	if err == nil {
+		err = tx.db.verifyPageChecksum(p, tx.meta.Pgid())
+	}
+	if err != nil {
+		// Read the page as an empty leaf, and fail the transaction.
+		tx.pageErr.CompareAndSwap(nil, &err)
+		return common.NewPage(id, common.LeafPageFlag, 0, 0)
+	}
 	p.FastCheck(id)
 	return p
 }
@@ -629,7 +743,10 @@ func (tx *Tx) Page(id int) (*common.PageInfo, error) {
 	}
 
 	// Build the page info.
-	p := tx.db.page(common.Pgid(id))
+	p, err := tx.db.readPage(common.Pgid(id))
+	if err != nil {
+		return nil, err
+	}
 	info := &common.PageInfo{
 		ID:            id,
 		Count:         int(p.Count()),
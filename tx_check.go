@@ -1,6 +1,7 @@
 package bbolt
 
 import (
+	"encoding/binary"
 	"encoding/hex"
 	"fmt"
 
@@ -50,6 +51,12 @@ func (tx *Tx) check(cfg checkConfig, ch chan error) {
 		freed[id] = true
 	}
 
+	// Verify the page checksums, or decrypt the pages, first, as reading a
+	// corrupted page below could panic.
+	if (tx.db.pageChecksums || tx.db.aead != nil) && !tx.checkPageIntegrity(ch) {
+		return
+	}
+
 	// Track every reachable page.
 	reachable := make(map[common.Pgid]*common.Page)
 	reachable[0] = tx.page(0) // meta0
@@ -72,6 +79,10 @@ func (tx *Tx) check(cfg checkConfig, ch chan error) {
 				ch <- fmt.Errorf("page %d: unreachable unfreed", int(i))
 			}
 		}
+
+		if cfg.indexes {
+			tx.checkIndexes(cfg.kvStringer, ch)
+		}
 	} else {
 		// Check the db file starting from a specified pageId.
 		if cfg.pageId < 2 || cfg.pageId >= uint64(tx.meta.Pgid()) {
@@ -83,9 +94,85 @@ func (tx *Tx) check(cfg checkConfig, ch chan error) {
 	}
 }
 
+// checkPageIntegrity verifies the checksum of the freelist page and of every
+// page reachable from the root bucket, regardless of the ChecksumVerification
+// policy, or decrypts them if the database is encrypted. It returns false if
+// any failure was reported.
+func (tx *Tx) checkPageIntegrity(ch chan error) bool {
+	ok := true
+	hwm := tx.meta.Pgid()
+	check := func(id common.Pgid) *common.Page {
+		// Out of range page ids are reported by the structural checks.
+		if id < 2 || id >= hwm {
+			return nil
+		}
+		if tx.db.aead != nil {
+			p, err := tx.db.decryptedPage(id)
+			if err != nil {
+				ch <- err
+				ok = false
+				return nil
+			}
+			return p
+		}
+		p := tx.db.page(id)
+		if err := tx.db.checkPageChecksum(p, hwm); err != nil {
+			ch <- err
+			ok = false
+			return nil
+		}
+		return p
+	}
+
+	if tx.meta.Freelist() != common.PgidNoFreelist {
+		check(tx.meta.Freelist())
+	}
+
+	var walk func(id common.Pgid)
+	var walkLeaf func(p *common.Page)
+	walk = func(id common.Pgid) {
+		p := check(id)
+		switch {
+		case p == nil:
+		case p.IsBranchPage():
+			for i := range p.BranchPageElements() {
+				walk(p.BranchPageElement(uint16(i)).Pgid())
+			}
+		case p.IsLeafPage():
+			walkLeaf(p)
+		}
+	}
+	walkLeaf = func(p *common.Page) {
+		for i := range p.LeafPageElements() {
+			elem := p.LeafPageElement(uint16(i))
+			switch {
+			case elem.IsBlobEntry():
+				x := check(common.LoadBlobRef(elem.Value()).Index())
+				if x == nil || !x.IsBlobIndexPage() {
+					break
+				}
+				for _, id := range x.BlobChunkIds() {
+					check(id)
+				}
+			case elem.IsBucketEntry():
+				// Inline buckets are stored in the page itself, but the
+				// chunks of their blobs aren't.
+				v := elem.Value()
+				if root := common.LoadBucket(v).RootPage(); root != 0 {
+					walk(root)
+				} else {
+					walkLeaf(common.LoadBucket(v).InlinePage(v, elem.Flags()))
+				}
+			}
+		}
+	}
+	walk(tx.root.RootPage())
+	return ok
+}
+
 func (tx *Tx) recursivelyCheckPage(pageId common.Pgid, reachable map[common.Pgid]*common.Page, freed map[common.Pgid]bool,
 	kvStringer KVStringer, ch chan error) {
-	tx.checkInvariantProperties(pageId, reachable, freed, kvStringer, ch)
+	tx.checkInvariantProperties(pageId, compareKeys, reachable, freed, kvStringer, ch)
 	tx.recursivelyCheckBucketInPage(pageId, reachable, freed, kvStringer, ch)
 }
 
@@ -110,7 +197,7 @@ func (tx *Tx) recursivelyCheckBucketInPage(pageId common.Pgid, reachable map[com
 					FillPercent: DefaultFillPercent,
 					tx:          tx,
 				}
-				if child := tmpBucket.Bucket(elem.Key()); child != nil {
+				if child := tmpBucket.nestedBucket(elem.Key()); child != nil {
 					tx.recursivelyCheckBucket(child, reachable, freed, kvStringer, ch)
 				}
 			}
@@ -122,59 +209,181 @@ func (tx *Tx) recursivelyCheckBucketInPage(pageId common.Pgid, reachable map[com
 
 func (tx *Tx) recursivelyCheckBucket(b *Bucket, reachable map[common.Pgid]*common.Page, freed map[common.Pgid]bool,
 	kvStringer KVStringer, ch chan error) {
-	// Ignore inline buckets.
+	// Inline buckets are stored in their parent's page, only the chunks of
+	// their blobs are checked.
 	if b.RootPage() == 0 {
+		if b.page != nil {
+			tx.verifyBlobsReachable(b.page, nil, reachable, freed, kvStringer, ch)
+			if b.ext.IsCounted() && uint64(b.page.Count()) != b.ext.KeyCount() {
+				ch <- fmt.Errorf("inline bucket: %d keys, but the header counts %d", b.page.Count(), b.ext.KeyCount())
+			}
+		}
 		return
 	}
 
-	tx.checkInvariantProperties(b.RootPage(), reachable, freed, kvStringer, ch)
+	// The key order of a bucket whose comparator isn't registered can't be
+	// verified.
+	compare := b.compare
+	if !b.comparatorKnown() {
+		ch <- fmt.Errorf("bucket with root page %d: unknown comparator %016x", int(b.RootPage()), b.ext.Comparator())
+		compare = nil
+	}
+	tx.checkInvariantProperties(b.RootPage(), compare, reachable, freed, kvStringer, ch)
+	if b.ext.IsCounted() {
+		tx.checkKeyCounts(b, ch)
+	}
 
-	// Check each bucket within this bucket.
-	_ = b.ForEachBucket(func(k []byte) error {
-		if child := b.Bucket(k); child != nil {
+	// Check each bucket within this bucket, including the expiry bucket hidden
+	// by ForEachBucket.
+	c := b.Cursor()
+	for k, _, flags := c.first(); k != nil; k, _, flags = c.next() {
+		if flags&common.BucketLeafFlag == 0 {
+			continue
+		}
+		if child := b.nestedBucket(k); child != nil {
 			tx.recursivelyCheckBucket(child, reachable, freed, kvStringer, ch)
 		}
-		return nil
-	})
+	}
+}
+
+// checkKeyCounts verifies that the numbers of keys stored in the branch
+// elements and in the header of a counted bucket match its leaf pages.
+func (tx *Tx) checkKeyCounts(b *Bucket, ch chan error) {
+	var count func(id common.Pgid) uint64
+	count = func(id common.Pgid) uint64 {
+		p := tx.page(id)
+		if !p.IsBranchPage() {
+			return uint64(p.Count())
+		}
+		var n uint64
+		for i := range p.BranchPageElements() {
+			elem := p.BranchPageElement(uint16(i))
+			c := count(elem.Pgid())
+			if stored := binary.BigEndian.Uint64(elem.SubtreeKeyCount()); stored != c {
+				ch <- fmt.Errorf("page %d: element %d has %d keys, but counts %d", int(id), i, c, stored)
+			}
+			n += c
+		}
+		return n
+	}
+	if n := count(b.RootPage()); n != b.ext.KeyCount() {
+		ch <- fmt.Errorf("bucket with root page %d: %d keys, but the header counts %d", int(b.RootPage()), n, b.ext.KeyCount())
+	}
 }
 
-func (tx *Tx) checkInvariantProperties(pageId common.Pgid, reachable map[common.Pgid]*common.Page, freed map[common.Pgid]bool,
+func (tx *Tx) checkInvariantProperties(pageId common.Pgid, compare func(a, b []byte) int, reachable map[common.Pgid]*common.Page, freed map[common.Pgid]bool,
 	kvStringer KVStringer, ch chan error) {
 	tx.forEachPage(pageId, func(p *common.Page, _ int, stack []common.Pgid) {
 		verifyPageReachable(p, tx.meta.Pgid(), stack, reachable, freed, ch)
+		if p.IsLeafPage() {
+			tx.verifyBlobsReachable(p, stack, reachable, freed, kvStringer, ch)
+		}
 	})
 
-	tx.recursivelyCheckPageKeyOrder(pageId, kvStringer.KeyToString, ch)
+	if compare != nil {
+		tx.recursivelyCheckPageKeyOrder(pageId, compare, kvStringer.KeyToString, ch)
+	}
 }
 
 func verifyPageReachable(p *common.Page, hwm common.Pgid, stack []common.Pgid, reachable map[common.Pgid]*common.Page, freed map[common.Pgid]bool, ch chan error) {
+	markPageReachable(p, hwm, stack, reachable, freed, ch)
+
+	// We should only encounter un-freed leaf and branch pages.
+	if !freed[p.Id()] && !p.IsBranchPage() && !p.IsLeafPage() {
+		ch <- fmt.Errorf("page %d: invalid type: %s (stack: %v)", int(p.Id()), p.Typ(), stack)
+	}
+}
+
+// markPageReachable marks a page and its overflow pages as reachable. It
+// returns false if the page was already reachable, or is freed.
+func markPageReachable(p *common.Page, hwm common.Pgid, stack []common.Pgid, reachable map[common.Pgid]*common.Page, freed map[common.Pgid]bool, ch chan error) bool {
 	if p.Id() > hwm {
 		ch <- fmt.Errorf("page %d: out of bounds: %d (stack: %v)", int(p.Id()), int(hwm), stack)
 	}
 
 	// Ensure each page is only referenced once.
+	ok := true
 	for i := common.Pgid(0); i <= common.Pgid(p.Overflow()); i++ {
 		var id = p.Id() + i
-		if _, ok := reachable[id]; ok {
+		if _, dup := reachable[id]; dup {
 			ch <- fmt.Errorf("page %d: multiple references (stack: %v)", int(id), stack)
+			ok = false
 		}
 		reachable[id] = p
 	}
 
-	// We should only encounter un-freed leaf and branch pages.
 	if freed[p.Id()] {
 		ch <- fmt.Errorf("page %d: reachable freed", int(p.Id()))
-	} else if !p.IsBranchPage() && !p.IsLeafPage() {
-		ch <- fmt.Errorf("page %d: invalid type: %s (stack: %v)", int(p.Id()), p.Typ(), stack)
+		return false
 	}
+	return ok
+}
+
+// verifyBlobsReachable marks the index and chunk pages of the blobs of a leaf
+// page as reachable, and verifies that the chunks add up to the size of their
+// blob.
+func (tx *Tx) verifyBlobsReachable(p *common.Page, stack []common.Pgid, reachable map[common.Pgid]*common.Page, freed map[common.Pgid]bool,
+	kvStringer KVStringer, ch chan error) {
+	hwm := tx.meta.Pgid()
+	for i := range p.LeafPageElements() {
+		elem := p.LeafPageElement(uint16(i))
+		if !elem.IsBlobEntry() {
+			continue
+		}
+
+		ref := common.LoadBlobRef(elem.Value())
+		key := kvStringer.KeyToString(elem.Key())
+		if ref.Index() == 0 {
+			if ref.Size() != 0 {
+				ch <- fmt.Errorf("blob %s on page %d: no index, expected %d bytes", key, int(p.Id()), ref.Size())
+			}
+			continue
+		}
+
+		x := tx.blobPage(ref.Index(), common.BlobIndexPageFlag, hwm, key, stack, ch)
+		if x == nil || !markPageReachable(x, hwm, stack, reachable, freed, ch) {
+			continue
+		}
+		var size uint64
+		ids := x.BlobChunkIds()
+		for i, id := range ids {
+			c := tx.blobPage(id, common.BlobPageFlag, hwm, key, stack, ch)
+			if c == nil || !markPageReachable(c, hwm, stack, reachable, freed, ch) {
+				size = ref.Size()
+				break
+			}
+			if i < len(ids)-1 && c.BlobChunk().Size() != x.BlobIndex().ChunkSize() {
+				ch <- fmt.Errorf("page %d: chunk holds %d bytes, expected %d (blob %s, stack: %v)", int(id), c.BlobChunk().Size(), x.BlobIndex().ChunkSize(), key, stack)
+			}
+			size += uint64(c.BlobChunk().Size())
+		}
+		if size != ref.Size() {
+			ch <- fmt.Errorf("blob %s on page %d: chunks hold %d bytes, expected %d", key, int(p.Id()), size, ref.Size())
+		}
+	}
+}
+
+// blobPage returns the blob page of the given id and type, or reports an
+// error and returns nil if it's out of bounds or of another type.
+func (tx *Tx) blobPage(id common.Pgid, flag uint16, hwm common.Pgid, key string, stack []common.Pgid, ch chan error) *common.Page {
+	if id < 2 || id >= hwm {
+		ch <- fmt.Errorf("page %d: out of bounds: %d (blob %s, stack: %v)", int(id), int(hwm), key, stack)
+		return nil
+	}
+	p := tx.page(id)
+	if p.Flags() != flag {
+		ch <- fmt.Errorf("page %d: invalid type: %s (blob %s, stack: %v)", int(id), p.Typ(), key, stack)
+		return nil
+	}
+	return p
 }
 
 // recursivelyCheckPageKeyOrder verifies database consistency with respect to b-tree
-// key order constraints:
+// key order constraints, in the order of compare:
 //   - keys on pages must be sorted
 //   - keys on children pages are between 2 consecutive keys on the parent's branch page).
-func (tx *Tx) recursivelyCheckPageKeyOrder(pgId common.Pgid, keyToString func([]byte) string, ch chan error) {
-	tx.recursivelyCheckPageKeyOrderInternal(pgId, nil, nil, nil, keyToString, ch)
+func (tx *Tx) recursivelyCheckPageKeyOrder(pgId common.Pgid, compare func(a, b []byte) int, keyToString func([]byte) string, ch chan error) {
+	tx.recursivelyCheckPageKeyOrderInternal(pgId, nil, nil, nil, compare, keyToString, ch)
 }
 
 // recursivelyCheckPageKeyOrderInternal verifies that all keys in the subtree rooted at `pgid` are:
@@ -184,7 +393,7 @@ func (tx *Tx) recursivelyCheckPageKeyOrder(pgId common.Pgid, keyToString func([]
 //     `pagesStack` is expected to contain IDs of pages from the tree root to `pgid` for the clean debugging message.
 func (tx *Tx) recursivelyCheckPageKeyOrderInternal(
 	pgId common.Pgid, minKeyClosed, maxKeyOpen []byte, pagesStack []common.Pgid,
-	keyToString func([]byte) string, ch chan error) (maxKeyInSubtree []byte) {
+	compare func(a, b []byte) int, keyToString func([]byte) string, ch chan error) (maxKeyInSubtree []byte) {
 
 	p := tx.page(pgId)
 	pagesStack = append(pagesStack, pgId)
@@ -194,13 +403,13 @@ func (tx *Tx) recursivelyCheckPageKeyOrderInternal(
 		runningMin := minKeyClosed
 		for i := range p.BranchPageElements() {
 			elem := p.BranchPageElement(uint16(i))
-			verifyKeyOrder(elem.Pgid(), "branch", i, elem.Key(), runningMin, maxKeyOpen, ch, keyToString, pagesStack)
+			verifyKeyOrder(elem.Pgid(), "branch", i, elem.Key(), runningMin, maxKeyOpen, compare, ch, keyToString, pagesStack)
 
 			maxKey := maxKeyOpen
 			if i < len(p.BranchPageElements())-1 {
 				maxKey = p.BranchPageElement(uint16(i + 1)).Key()
 			}
-			maxKeyInSubtree = tx.recursivelyCheckPageKeyOrderInternal(elem.Pgid(), elem.Key(), maxKey, pagesStack, keyToString, ch)
+			maxKeyInSubtree = tx.recursivelyCheckPageKeyOrderInternal(elem.Pgid(), elem.Key(), maxKey, pagesStack, compare, keyToString, ch)
 			runningMin = maxKeyInSubtree
 		}
 		return maxKeyInSubtree
@@ -208,7 +417,7 @@ func (tx *Tx) recursivelyCheckPageKeyOrderInternal(
 		runningMin := minKeyClosed
 		for i := range p.LeafPageElements() {
 			elem := p.LeafPageElement(uint16(i))
-			verifyKeyOrder(pgId, "leaf", i, elem.Key(), runningMin, maxKeyOpen, ch, keyToString, pagesStack)
+			verifyKeyOrder(pgId, "leaf", i, elem.Key(), runningMin, maxKeyOpen, compare, ch, keyToString, pagesStack)
 			runningMin = elem.Key()
 		}
 		if p.Count() > 0 {
@@ -224,13 +433,13 @@ func (tx *Tx) recursivelyCheckPageKeyOrderInternal(
  * verifyKeyOrder checks whether an entry with given #index on pgId (pageType: "branch|leaf") that has given "key",
  * is within range determined by (previousKey..maxKeyOpen) and reports found violations to the channel (ch).
  */
-func verifyKeyOrder(pgId common.Pgid, pageType string, index int, key []byte, previousKey []byte, maxKeyOpen []byte, ch chan error, keyToString func([]byte) string, pagesStack []common.Pgid) {
-	if index == 0 && previousKey != nil && compareKeys(previousKey, key) > 0 {
+func verifyKeyOrder(pgId common.Pgid, pageType string, index int, key []byte, previousKey []byte, maxKeyOpen []byte, compare func(a, b []byte) int, ch chan error, keyToString func([]byte) string, pagesStack []common.Pgid) {
+	if index == 0 && previousKey != nil && compare(previousKey, key) > 0 {
 		ch <- fmt.Errorf("the first key[%d]=(hex)%s on %s page(%d) needs to be >= the key in the ancestor (%s). Stack: %v",
 			index, keyToString(key), pageType, pgId, keyToString(previousKey), pagesStack)
 	}
 	if index > 0 {
-		cmpRet := compareKeys(previousKey, key)
+		cmpRet := compare(previousKey, key)
 		if cmpRet > 0 {
 			ch <- fmt.Errorf("key[%d]=(hex)%s on %s page(%d) needs to be > (found <) than previous element (hex)%s. Stack: %v",
 				index, keyToString(key), pageType, pgId, keyToString(previousKey), pagesStack)
@@ -240,7 +449,7 @@ func verifyKeyOrder(pgId common.Pgid, pageType string, index int, key []byte, pr
 				index, keyToString(key), pageType, pgId, keyToString(previousKey), pagesStack)
 		}
 	}
-	if maxKeyOpen != nil && compareKeys(key, maxKeyOpen) >= 0 {
+	if maxKeyOpen != nil && compare(key, maxKeyOpen) >= 0 {
 		ch <- fmt.Errorf("key[%d]=(hex)%s on %s page(%d) needs to be < than key of the next element in ancestor (hex)%s. Pages stack: %v",
 			index, keyToString(key), pageType, pgId, keyToString(previousKey), pagesStack)
 	}
@@ -251,6 +460,7 @@ func verifyKeyOrder(pgId common.Pgid, pageType string, index int, key []byte, pr
 type checkConfig struct {
 	kvStringer KVStringer
 	pageId     uint64
+	indexes    bool
 }
 
 type CheckOption func(options *checkConfig)
@@ -268,6 +478,14 @@ func WithPageId(pageId uint64) CheckOption {
 	}
 }
 
+// WithIndexes makes the check also verify that the registered indexes are
+// consistent with their indexed buckets.
+func WithIndexes() CheckOption {
+	return func(c *checkConfig) {
+		c.indexes = true
+	}
+}
+
 // KVStringer allows to prepare human-readable diagnostic messages.
 type KVStringer interface {
 	KeyToString([]byte) string
@@ -0,0 +1,454 @@
+package bbolt
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"unsafe"
+
+	berrors "go.etcd.io/bbolt/errors"
+	"go.etcd.io/bbolt/internal/common"
+)
+
+// walSuffix is appended to the database path to name its write-ahead log.
+const walSuffix = "-wal"
+
+// walMagic marks the start of every record in the write-ahead log.
+const walMagic uint32 = 0xB0174A1E
+
+// walRecordHeader precedes the pages written by a single commit. The pages
+// are stored back to back, with their own page headers, and the last one is
+// always the meta page.
+type walRecordHeader struct {
+	Magic    uint32
+	_        uint32
+	Txid     uint64
+	Size     uint64 // number of bytes following the header
+	Checksum uint64 // fnv64a of the bytes following the header
+}
+
+// wal is the write-ahead log of a database opened with Options.WALMode.
+//
+// Commits append their dirty pages to the log and keep them in memory until
+// a checkpoint copies them into the database file. Because pages are only
+// reused once no transaction can reach them, the latest logged version of
+// each page is all that readers need, just like the database file itself.
+type wal struct {
+	db   *DB
+	file *os.File
+	size int64 // size of the valid records in the log
+
+	mu    sync.RWMutex                 // Protects pages.
+	pages map[common.Pgid]*common.Page // Latest version of every logged page.
+	maxOv uint32                       // Largest overflow of the logged pages.
+
+	// meta is the latest committed meta. It is protected by db.metalock.
+	meta *common.Meta
+
+	checkpointSize int64
+	notify         chan struct{}
+	stopping       chan struct{}
+	stopped        chan struct{}
+	stopOnce       sync.Once
+}
+
+// openWAL replays the write-ahead log of the database, if there is one, and
+// applies it to the database file. If Options.WALMode is set, the log is kept
+// open for subsequent commits.
+func (db *DB) openWAL(mode os.FileMode, options *Options) error {
+	path := db.path + walSuffix
+	if _, err := os.Stat(path); os.IsNotExist(err) && (!options.WALMode || db.readOnly) {
+		return nil
+	}
+
+	flag := os.O_RDWR | os.O_CREATE
+	if db.readOnly {
+		flag = os.O_RDONLY
+	}
+	f, err := db.openFile(path, flag, mode)
+	if err != nil {
+		return err
+	}
+	w := &wal{
+		db:             db,
+		file:           f,
+		pages:          make(map[common.Pgid]*common.Page),
+		checkpointSize: int64(options.WALCheckpointSize),
+	}
+	if w.checkpointSize <= 0 {
+		w.checkpointSize = common.DefaultWALCheckpointSize
+	}
+
+	if err := w.replay(); err != nil {
+		_ = f.Close()
+		return err
+	}
+
+	// Make sure the pages of the replayed commits are mapped.
+	if w.meta != nil {
+		if sz := int(w.meta.Pgid()+1) * db.pageSize; sz > db.datasz {
+			if err := db.mmap(sz); err != nil {
+				_ = f.Close()
+				return err
+			}
+		}
+	}
+
+	// A read-only database serves the replayed pages from memory.
+	if db.readOnly {
+		db.wal = w
+		return nil
+	}
+
+	if err := w.checkpoint(); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if !options.WALMode {
+		if err := f.Close(); err != nil {
+			return err
+		}
+		return os.Remove(path)
+	}
+
+	w.notify = make(chan struct{}, 1)
+	w.stopping = make(chan struct{})
+	w.stopped = make(chan struct{})
+	go w.runCheckpointer()
+
+	db.wal = w
+	return nil
+}
+
+// closeWAL checkpoints the write-ahead log and removes it.
+func (db *DB) closeWAL() error {
+	w := db.wal
+	db.wal = nil
+	if db.readOnly {
+		return w.file.Close()
+	}
+
+	// Keep the log around if its pages can't be applied.
+	if err := w.checkpoint(); err != nil {
+		_ = w.file.Close()
+		return fmt.Errorf("checkpoint: %w", err)
+	}
+	if err := w.file.Close(); err != nil {
+		return err
+	}
+	return os.Remove(w.file.Name())
+}
+
+// Checkpoint copies the commits in the write-ahead log into the database file
+// and truncates the log. It does nothing unless Options.WALMode is set.
+func (db *DB) Checkpoint() error {
+	if db.readOnly {
+		return berrors.ErrDatabaseReadOnly
+	}
+
+	db.rwlock.Lock()
+	defer db.rwlock.Unlock()
+
+	if !db.opened {
+		return berrors.ErrDatabaseNotOpen
+	} else if db.wal == nil {
+		return nil
+	}
+	return db.wal.checkpoint()
+}
+
+// runCheckpointer checkpoints the log whenever it outgrows checkpointSize.
+func (w *wal) runCheckpointer() {
+	defer close(w.stopped)
+	for {
+		select {
+		case <-w.stopping:
+			return
+		case <-w.notify:
+			w.db.rwlock.Lock()
+			if err := w.checkpoint(); err != nil {
+				w.db.Logger().Errorf("checkpointing write-ahead log failed: %v", err)
+			}
+			w.db.rwlock.Unlock()
+		}
+	}
+}
+
+// stop stops the checkpointer and waits for it to exit.
+func (w *wal) stop() {
+	if w.stopping == nil {
+		return
+	}
+	w.stopOnce.Do(func() {
+		close(w.stopping)
+	})
+	<-w.stopped
+}
+
+// page returns the logged version of the page, or nil if it isn't logged.
+func (w *wal) page(id common.Pgid) *common.Page {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	return w.pages[id]
+}
+
+// add makes p the latest version of its page. Logged pages which overlap p
+// must have been freed before p was allocated, so they are dropped. This keeps
+// the logged pages disjoint, so they can be written to the file in any order.
+func (w *wal) add(p *common.Page) {
+	id, ov := p.Id(), common.Pgid(p.Overflow())
+	for i := id + 1; i <= id+ov; i++ {
+		delete(w.pages, i)
+	}
+	for i := common.Pgid(1); i <= common.Pgid(w.maxOv) && i <= id; i++ {
+		if prev, ok := w.pages[id-i]; ok && common.Pgid(prev.Overflow()) >= i {
+			delete(w.pages, id-i)
+		}
+	}
+
+	w.pages[id] = p
+	if p.Overflow() > w.maxOv {
+		w.maxOv = p.Overflow()
+	}
+}
+
+// commit appends the dirty pages and the meta of tx to the log, syncs it, and
+// makes them visible to new transactions.
+func (w *wal) commit(tx *Tx) error {
+	db := tx.db
+	lg := db.Logger()
+
+	pages := make(common.Pages, 0, len(tx.pages))
+	for _, p := range tx.pages {
+		pages = append(pages, p)
+	}
+	tx.pages = make(map[common.Pgid]*common.Page)
+	sort.Sort(pages)
+	db.setPageChecksums(pages)
+	pages, err := db.sealPages(pages)
+	if err != nil {
+		lg.Errorf("encrypting pages failed: %v", err)
+		return err
+	}
+
+	metaBuf := make([]byte, db.pageSize)
+	metaPage := db.pageInBuffer(metaBuf, 0)
+	tx.meta.Write(metaPage)
+
+	// Build the record, so it is written with a single call.
+	size := db.pageSize
+	for _, p := range pages {
+		size += (int(p.Overflow()) + 1) * db.pageSize
+	}
+	hdr := walRecordHeader{Magic: walMagic, Txid: uint64(tx.meta.Txid()), Size: uint64(size)}
+	buf := make([]byte, binary.Size(&hdr), binary.Size(&hdr)+size)
+	for _, p := range pages {
+		buf = append(buf, common.UnsafeByteSlice(unsafe.Pointer(p), 0, 0, (int(p.Overflow())+1)*db.pageSize)...)
+	}
+	buf = append(buf, metaBuf...)
+
+	h := fnv.New64a()
+	_, _ = h.Write(buf[binary.Size(&hdr):])
+	hdr.Checksum = h.Sum64()
+	encodeWALRecordHeader(buf, &hdr)
+
+	if _, err := w.file.WriteAt(buf, w.size); err != nil {
+		lg.Errorf("appending to write-ahead log failed, offset: %d, error: %v", w.size, err)
+		return err
+	}
+	if !db.NoSync || common.IgnoreNoSync {
+		if err := w.file.Sync(); err != nil {
+			lg.Errorf("syncing write-ahead log failed: %v", err)
+			_ = w.file.Truncate(w.size)
+			return err
+		}
+	}
+	w.size += int64(len(buf))
+	tx.stats.IncWrite(1)
+	db.recordPageWrites(pages, tx.meta.Txid())
+
+	w.mu.Lock()
+	for _, p := range pages {
+		w.add(p)
+	}
+	w.mu.Unlock()
+
+	db.metalock.Lock()
+	w.meta = metaPage.Meta()
+	db.metalock.Unlock()
+
+	if w.size >= w.checkpointSize && w.notify != nil {
+		select {
+		case w.notify <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+// replay reads the valid records of the log, and loads the pages of every
+// commit newer than the database file. A torn record at the end of the log,
+// left by a crash while appending, is discarded.
+func (w *wal) replay() error {
+	db := w.db
+	info, err := w.file.Stat()
+	if err != nil {
+		return err
+	}
+	txid := db.meta().Txid()
+
+	hdrSize := int64(binary.Size(&walRecordHeader{}))
+	r := bufio.NewReader(io.NewSectionReader(w.file, 0, info.Size()))
+	var off int64
+	for {
+		var hdr walRecordHeader
+		if err := binary.Read(r, binary.LittleEndian, &hdr); err != nil {
+			break
+		}
+		if hdr.Magic != walMagic || int64(hdr.Size) > info.Size()-off-hdrSize || int(hdr.Size)%db.pageSize != 0 {
+			break
+		}
+		body := make([]byte, hdr.Size)
+		if _, err := io.ReadFull(r, body); err != nil {
+			break
+		}
+		h := fnv.New64a()
+		_, _ = h.Write(body)
+		if h.Sum64() != hdr.Checksum {
+			break
+		}
+
+		var pages []*common.Page
+		for pos := 0; pos < len(body); {
+			p := common.LoadPage(body[pos:])
+			pos += (int(p.Overflow()) + 1) * db.pageSize
+			if pos > len(body) {
+				return fmt.Errorf("write-ahead log record of txid %d: page %d overflows the record", hdr.Txid, p.Id())
+			}
+			pages = append(pages, p)
+		}
+		meta := pages[len(pages)-1]
+		if !meta.IsMetaPage() || meta.Meta().Validate() != nil || uint64(meta.Meta().Txid()) != hdr.Txid {
+			return fmt.Errorf("write-ahead log record of txid %d: invalid meta page", hdr.Txid)
+		}
+		off += hdrSize + int64(hdr.Size)
+
+		// Skip the commits which were already checkpointed.
+		if meta.Meta().Txid() <= txid {
+			continue
+		}
+		for _, p := range pages[:len(pages)-1] {
+			w.add(p)
+		}
+		w.meta = meta.Meta()
+	}
+
+	w.size = off
+	if off < info.Size() && !db.readOnly {
+		db.Logger().Warningf("discarding %d bytes at the end of write-ahead log", info.Size()-off)
+		return w.file.Truncate(off)
+	}
+	return nil
+}
+
+// checkpoint writes the logged pages and the latest meta into the database
+// file, then truncates the log. The caller must hold the writer lock.
+func (w *wal) checkpoint() error {
+	db := w.db
+	if w.size == 0 {
+		return nil
+	}
+
+	if w.meta != nil {
+		if err := db.grow(int(w.meta.Pgid()+1) * db.pageSize); err != nil {
+			return err
+		}
+
+		w.mu.RLock()
+		pages := make(common.Pages, 0, len(w.pages))
+		for _, p := range w.pages {
+			pages = append(pages, p)
+		}
+		w.mu.RUnlock()
+		sort.Sort(pages)
+
+		for _, p := range pages {
+			// Pages above the high water mark were trimmed by DB.Shrink.
+			if p.Id() >= w.meta.Pgid() {
+				continue
+			}
+			buf := common.UnsafeByteSlice(unsafe.Pointer(p), 0, 0, (int(p.Overflow())+1)*db.pageSize)
+			if _, err := db.ops.writeAt(buf, int64(p.Id())*int64(db.pageSize)); err != nil {
+				return err
+			}
+		}
+		if !db.NoSync || common.IgnoreNoSync {
+			if err := fdatasync(db); err != nil {
+				return err
+			}
+		}
+
+		buf := make([]byte, db.pageSize)
+		p := db.pageInBuffer(buf, 0)
+		p.SetId(common.Pgid(w.meta.Txid() % 2))
+		p.SetFlags(common.MetaPageFlag)
+		w.meta.Copy(p.Meta())
+		if _, err := db.ops.writeAt(buf, int64(p.Id())*int64(db.pageSize)); err != nil {
+			return err
+		}
+		if !db.NoSync || common.IgnoreNoSync {
+			if err := fdatasync(db); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := w.file.Truncate(0); err != nil {
+		return err
+	}
+	if err := w.file.Sync(); err != nil {
+		return err
+	}
+	w.size = 0
+
+	w.mu.Lock()
+	w.pages = make(map[common.Pgid]*common.Page)
+	w.maxOv = 0
+	w.mu.Unlock()
+	return nil
+}
+
+// writeDataPages writes the data pages below the high water mark to w, taking
+// logged pages from memory and the rest from the database file.
+func (w *wal) writeDataPages(dst io.Writer, pgid common.Pgid) (n int64, err error) {
+	db := w.db
+	for id := common.Pgid(2); id < pgid; {
+		count := common.Pgid(1)
+		p := w.page(id)
+		if p != nil {
+			count += common.Pgid(p.Overflow())
+		} else {
+			p = (*common.Page)(unsafe.Pointer(&db.data[id*common.Pgid(db.pageSize)]))
+		}
+
+		nn, err := dst.Write(common.UnsafeByteSlice(unsafe.Pointer(p), 0, 0, int(count)*db.pageSize))
+		n += int64(nn)
+		if err != nil {
+			return n, err
+		}
+		id += count
+	}
+	return n, nil
+}
+
+func encodeWALRecordHeader(buf []byte, hdr *walRecordHeader) {
+	binary.LittleEndian.PutUint32(buf[0:], hdr.Magic)
+	binary.LittleEndian.PutUint64(buf[8:], hdr.Txid)
+	binary.LittleEndian.PutUint64(buf[16:], hdr.Size)
+	binary.LittleEndian.PutUint64(buf[24:], hdr.Checksum)
+}
@@ -0,0 +1,201 @@
+package bbolt_test
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	bolt "go.etcd.io/bbolt"
+	"go.etcd.io/bbolt/internal/btesting"
+	"go.etcd.io/bbolt/internal/common"
+)
+
+// Ensure that commits in WAL mode are appended to the log and survive a reopen.
+func TestDB_WALMode(t *testing.T) {
+	db := btesting.MustCreateDBWithOption(t, &bolt.Options{WALMode: true})
+	walPath := db.Path() + "-wal"
+
+	require.NoError(t, db.Fill([]byte("widgets"), 10, 100, keyGen, valueGen("v")))
+
+	fi, err := os.Stat(walPath)
+	require.NoError(t, err)
+	require.NotZero(t, fi.Size())
+
+	expected := dumpDB(t, db.DB)
+	require.Len(t, expected, 1001)
+	db.MustCheck()
+
+	// Closing the database checkpoints the log and removes it.
+	db.MustClose()
+	_, err = os.Stat(walPath)
+	require.True(t, os.IsNotExist(err))
+
+	db.SetOptions(&bolt.Options{})
+	db.MustReopen()
+	require.Equal(t, expected, dumpDB(t, db.DB))
+}
+
+// Ensure that Checkpoint copies the logged pages into the data file.
+func TestDB_Checkpoint(t *testing.T) {
+	db := btesting.MustCreateDBWithOption(t, &bolt.Options{WALMode: true})
+	walPath := db.Path() + "-wal"
+
+	require.NoError(t, db.Fill([]byte("widgets"), 5, 100, keyGen, valueGen("v")))
+	require.NoError(t, db.Checkpoint())
+
+	fi, err := os.Stat(walPath)
+	require.NoError(t, err)
+	require.Zero(t, fi.Size())
+
+	// The data file alone holds every commit now.
+	path := filepath.Join(t.TempDir(), "copy")
+	require.NoError(t, common.CopyFile(db.Path(), path))
+	copied, err := bolt.Open(path, 0600, nil)
+	require.NoError(t, err)
+	defer copied.Close()
+	require.Equal(t, dumpDB(t, db.DB), dumpDB(t, copied))
+
+	// Checkpoint is a no-op without WAL mode.
+	require.NoError(t, copied.Checkpoint())
+}
+
+// Ensure that the checkpointer runs once the log outgrows WALCheckpointSize.
+func TestDB_WALMode_CheckpointSize(t *testing.T) {
+	db := btesting.MustCreateDBWithOption(t, &bolt.Options{WALMode: true, WALCheckpointSize: 64 * 1024})
+	walPath := db.Path() + "-wal"
+
+	require.NoError(t, db.Fill([]byte("widgets"), 100, 10, keyGen, valueGen("v")))
+
+	require.Eventually(t, func() bool {
+		fi, err := os.Stat(walPath)
+		require.NoError(t, err)
+		return fi.Size() < 64*1024
+	}, time.Second*5, time.Millisecond*10)
+	db.MustCheck()
+}
+
+// Ensure that the log is replayed when a database is opened after a crash.
+func TestDB_WALMode_Recover(t *testing.T) {
+	for _, walMode := range []bool{false, true} {
+		t.Run(fmt.Sprintf("walMode=%t", walMode), func(t *testing.T) {
+			db := btesting.MustCreateDBWithOption(t, &bolt.Options{WALMode: true})
+			require.NoError(t, db.Fill([]byte("widgets"), 2, 100, keyGen, valueGen("base")))
+			require.NoError(t, db.Checkpoint())
+			require.NoError(t, db.Fill([]byte("widgets"), 5, 100, keyGen, valueGen("logged")))
+			expected := dumpDB(t, db.DB)
+
+			// Copy both files while the database is open, as if it crashed.
+			dir := t.TempDir()
+			path := filepath.Join(dir, "db")
+			require.NoError(t, common.CopyFile(db.Path(), path))
+			require.NoError(t, common.CopyFile(db.Path()+"-wal", path+"-wal"))
+
+			// Append a torn record, as if it crashed while appending.
+			f, err := os.OpenFile(path+"-wal", os.O_APPEND|os.O_WRONLY, 0600)
+			require.NoError(t, err)
+			_, err = f.Write([]byte{0x1e, 0x4a, 0x17, 0xb0, 0, 0, 0, 0, 1, 2, 3})
+			require.NoError(t, err)
+			require.NoError(t, f.Close())
+
+			// A read-only database serves the logged commits from memory.
+			ro, err := bolt.Open(path, 0600, &bolt.Options{ReadOnly: true})
+			require.NoError(t, err)
+			require.Equal(t, expected, dumpDB(t, ro))
+			require.NoError(t, ro.Close())
+
+			recovered, err := bolt.Open(path, 0600, &bolt.Options{WALMode: walMode})
+			require.NoError(t, err)
+			require.Equal(t, expected, dumpDB(t, recovered))
+			require.NoError(t, recovered.View(func(tx *bolt.Tx) error {
+				for err := range tx.Check() {
+					return err
+				}
+				return nil
+			}))
+			require.NoError(t, recovered.Close())
+
+			_, err = os.Stat(path + "-wal")
+			require.True(t, os.IsNotExist(err))
+		})
+	}
+}
+
+// Ensure that WriteTo includes the pages which haven't been checkpointed.
+func TestDB_WALMode_WriteTo(t *testing.T) {
+	db := btesting.MustCreateDBWithOption(t, &bolt.Options{WALMode: true})
+	require.NoError(t, db.Fill([]byte("widgets"), 5, 100, keyGen, valueGen("v")))
+
+	path := filepath.Join(t.TempDir(), "copy")
+	require.NoError(t, db.View(func(tx *bolt.Tx) error {
+		return tx.CopyFile(path, 0600)
+	}))
+
+	copied, err := bolt.Open(path, 0600, nil)
+	require.NoError(t, err)
+	defer copied.Close()
+	require.Equal(t, dumpDB(t, db.DB), dumpDB(t, copied))
+}
+
+// Ensure that readers see consistent data while commits are checkpointed.
+func TestDB_WALMode_ConcurrentReaders(t *testing.T) {
+	db := btesting.MustCreateDBWithOption(t, &bolt.Options{WALMode: true, WALCheckpointSize: 32 * 1024})
+
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				err := db.View(func(tx *bolt.Tx) error {
+					b := tx.Bucket([]byte("widgets"))
+					if b == nil {
+						return nil
+					}
+					// Every transaction writes a full set of keys with the same value.
+					var first []byte
+					return b.ForEach(func(k, v []byte) error {
+						if first == nil {
+							first = v[:len(v)-10]
+						} else if string(first) != string(v[:len(v)-10]) {
+							return fmt.Errorf("key %s: inconsistent value %s, expected prefix %s", k, v, first)
+						}
+						return nil
+					})
+				})
+				if err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+
+	for i := 0; i < 50; i++ {
+		require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+			b, err := tx.CreateBucketIfNotExists([]byte("widgets"))
+			if err != nil {
+				return err
+			}
+			for j := 0; j < 100; j++ {
+				if err := b.Put([]byte(fmt.Sprintf("%04d", j)), []byte(fmt.Sprintf("%04d-%09d", i, j))); err != nil {
+					return err
+				}
+			}
+			return nil
+		}))
+	}
+	close(stop)
+	wg.Wait()
+	db.MustCheck()
+}
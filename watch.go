@@ -0,0 +1,238 @@
+package bbolt
+
+import (
+	"bytes"
+	"context"
+
+	berrors "go.etcd.io/bbolt/errors"
+	"go.etcd.io/bbolt/internal/common"
+)
+
+// ChangeType is the type of a ChangeEvent.
+type ChangeType int
+
+const (
+	// ChangePut is a key set by Bucket.Put or Bucket.PutReader.
+	ChangePut ChangeType = iota + 1
+	// ChangeDelete is a key deleted by Bucket.Delete or Cursor.Delete.
+	ChangeDelete
+	// ChangeCreateBucket is a nested bucket created in a bucket.
+	ChangeCreateBucket
+	// ChangeDeleteBucket is a nested bucket deleted from a bucket. The
+	// buckets nested in it are reported deleted first, but not its keys.
+	ChangeDeleteBucket
+	// ChangeMoveBucket is a nested bucket moved from a bucket to DstBucket.
+	ChangeMoveBucket
+	// ChangeOverflow is sent when the buffer of a watcher is full. It's the
+	// last event of the watcher, whose channel is closed after it.
+	ChangeOverflow
+)
+
+func (t ChangeType) String() string {
+	switch t {
+	case ChangePut:
+		return "put"
+	case ChangeDelete:
+		return "delete"
+	case ChangeCreateBucket:
+		return "createBucket"
+	case ChangeDeleteBucket:
+		return "deleteBucket"
+	case ChangeMoveBucket:
+		return "moveBucket"
+	case ChangeOverflow:
+		return "overflow"
+	}
+	return "unknown"
+}
+
+// ChangeEvent describes a change committed by a write transaction.
+type ChangeEvent struct {
+	Type ChangeType
+
+	// TxID is the id of the transaction which committed the change.
+	TxID int
+
+	// Bucket is the path of the bucket holding the key, empty for the
+	// top level buckets, and Key the key or the name of the nested bucket.
+	Bucket [][]byte
+	Key    []byte
+
+	// OldValue is the value of a key before it was set or deleted, nil if
+	// it didn't exist, and NewValue the value it was set to. The values of
+	// blobs are nil.
+	OldValue []byte
+	NewValue []byte
+
+	// DstBucket is the path of the bucket a nested bucket was moved to.
+	DstBucket [][]byte
+}
+
+// watcher is a subscription of DB.Watch.
+type watcher struct {
+	path   [][]byte
+	prefix []byte
+	ch     chan ChangeEvent
+	size   int           // number of events buffered before an overflow
+	done   chan struct{} // closed when the watcher is removed
+}
+
+// Watch returns a channel of the changes committed to the bucket at the given
+// path and to the buckets nested in it, whose key starts with prefix. For the
+// nested buckets, the prefix applies to the name of the bucket nested in the
+// watched one. An empty path watches every bucket.
+//
+// The events of a transaction are sent in order after it commits, and the
+// transactions in commit order. Only the transactions started after Watch
+// returns are watched.
+//
+// Events are buffered up to Options.WatchBufferSize. If a consumer falls
+// behind and the buffer is full, a ChangeOverflow event is sent and the
+// channel is closed. The channel is also closed when ctx is done or the
+// database is closed.
+func (db *DB) Watch(ctx context.Context, bucketPath [][]byte, prefix []byte) (<-chan ChangeEvent, error) {
+	if db.readOnly {
+		return nil, berrors.ErrDatabaseReadOnly
+	}
+
+	w := &watcher{
+		prefix: cloneBytes(prefix),
+		ch:     make(chan ChangeEvent, db.watchBufferSize+1),
+		size:   db.watchBufferSize,
+		done:   make(chan struct{}),
+	}
+	for _, name := range bucketPath {
+		w.path = append(w.path, cloneBytes(name))
+	}
+
+	db.watchMu.Lock()
+	if !db.opened {
+		db.watchMu.Unlock()
+		return nil, berrors.ErrDatabaseNotOpen
+	}
+	db.watchers = append(db.watchers, w)
+	db.watchMu.Unlock()
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			db.watchMu.Lock()
+			db.removeWatcher(w)
+			db.watchMu.Unlock()
+		case <-w.done:
+		}
+	}()
+	return w.ch, nil
+}
+
+// hasWatchers returns whether any watcher is registered.
+func (db *DB) hasWatchers() bool {
+	db.watchMu.Lock()
+	defer db.watchMu.Unlock()
+	return len(db.watchers) > 0
+}
+
+// publishChanges sends the changes committed by a transaction to the
+// watchers. It's called with the writer lock held, so that the transactions
+// are published in commit order.
+func (db *DB) publishChanges(txid int, changes []ChangeEvent) {
+	if len(changes) == 0 {
+		return
+	}
+
+	db.watchMu.Lock()
+	defer db.watchMu.Unlock()
+	for _, w := range append([]*watcher(nil), db.watchers...) {
+		for _, e := range changes {
+			if !w.match(e) {
+				continue
+			}
+			if len(w.ch) >= w.size {
+				w.ch <- ChangeEvent{Type: ChangeOverflow, TxID: txid}
+				db.removeWatcher(w)
+				break
+			}
+			w.ch <- e
+		}
+	}
+}
+
+// removeWatcher removes a watcher and closes its channel, if it wasn't
+// removed already. watchMu must be held.
+func (db *DB) removeWatcher(w *watcher) {
+	for i, x := range db.watchers {
+		if x == w {
+			db.watchers = append(db.watchers[:i], db.watchers[i+1:]...)
+			close(w.ch)
+			close(w.done)
+			return
+		}
+	}
+}
+
+// closeWatchers removes all the watchers when the database is closed.
+func (db *DB) closeWatchers() {
+	db.watchMu.Lock()
+	defer db.watchMu.Unlock()
+	for len(db.watchers) > 0 {
+		db.removeWatcher(db.watchers[0])
+	}
+}
+
+// match returns whether the change is watched by w. A moved bucket is
+// watched at either of its locations.
+func (w *watcher) match(e ChangeEvent) bool {
+	return w.matchKey(e.Bucket, e.Key) || (e.Type == ChangeMoveBucket && w.matchKey(e.DstBucket, e.Key))
+}
+
+// matchKey returns whether the key in the bucket at the given path is watched
+// by w.
+func (w *watcher) matchKey(path [][]byte, key []byte) bool {
+	if len(path) < len(w.path) {
+		return false
+	}
+	for i, name := range w.path {
+		if !bytes.Equal(name, path[i]) {
+			return false
+		}
+	}
+	if len(path) > len(w.path) {
+		key = path[len(w.path)]
+	}
+	return bytes.HasPrefix(key, w.prefix)
+}
+
+// recordChange records a change of key in the bucket for the watchers, if
+// the transaction is watched. v and flags are the previous leaf value of the
+// key, v is nil if it didn't exist.
+func (b *Bucket) recordChange(typ ChangeType, key []byte, v []byte, flags uint32, value []byte) {
+	if !b.tx.watching {
+		return
+	}
+	path := b.path()
+	if len(path) > 0 && string(path[0]) == expiryBucket {
+		// The changes of the expiry bucket are internal.
+		return
+	}
+	e := ChangeEvent{
+		Type:   typ,
+		TxID:   b.tx.ID(),
+		Bucket: path,
+		Key:    cloneBytes(key),
+	}
+	if typ == ChangePut && value != nil {
+		e.NewValue = cloneBytes(value)
+	}
+	if v != nil && (flags&(common.BucketLeafFlag|common.BlobLeafFlag)) == 0 {
+		e.OldValue = cloneBytes(b.value(v, flags))
+	}
+	b.tx.changes = append(b.tx.changes, e)
+}
+
+// path returns the names of the buckets from the root bucket to b.
+func (b *Bucket) path() [][]byte {
+	if b.parent == nil {
+		return nil
+	}
+	return append(b.parent.path(), b.name)
+}
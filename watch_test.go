@@ -0,0 +1,260 @@
+package bbolt_test
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	bolt "go.etcd.io/bbolt"
+	berrors "go.etcd.io/bbolt/errors"
+	"go.etcd.io/bbolt/internal/btesting"
+)
+
+// bucketPath returns the path of the bucket names.
+func bucketPath(names ...string) [][]byte {
+	var path [][]byte
+	for _, name := range names {
+		path = append(path, []byte(name))
+	}
+	return path
+}
+
+// receiveEvents returns the events buffered in ch. The events of a
+// transaction are sent before its commit returns.
+func receiveEvents(ch <-chan bolt.ChangeEvent) []bolt.ChangeEvent {
+	var events []bolt.ChangeEvent
+	for {
+		select {
+		case e, ok := <-ch:
+			if !ok {
+				return events
+			}
+			events = append(events, e)
+		default:
+			return events
+		}
+	}
+}
+
+// Ensure that every type of change is sent to a watcher, in commit order.
+func TestDB_Watch(t *testing.T) {
+	db := btesting.MustCreateDB(t)
+	ch, err := db.Watch(context.Background(), nil, nil)
+	require.NoError(t, err)
+
+	var txids []int
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		txids = append(txids, tx.ID())
+		b, err := tx.CreateBucket([]byte("widgets"))
+		require.NoError(t, err)
+		require.NoError(t, b.Put([]byte("foo"), []byte("bar")))
+		_, err = b.CreateBucketIfNotExists([]byte("nested"))
+		require.NoError(t, err)
+		_, err = tx.CreateBucketIfNotExists([]byte("widgets"))
+		return err
+	}))
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		txids = append(txids, tx.ID())
+		b := tx.Bucket([]byte("widgets"))
+		require.NoError(t, b.Put([]byte("foo"), []byte("baz")))
+		require.NoError(t, b.Bucket([]byte("nested")).Put([]byte("a"), []byte("1")))
+		require.NoError(t, b.Delete([]byte("missing")))
+		c := b.Cursor()
+		c.Seek([]byte("foo"))
+		require.NoError(t, c.Delete())
+
+		dst, err := tx.CreateBucket([]byte("dst"))
+		require.NoError(t, err)
+		require.NoError(t, tx.MoveBucket([]byte("nested"), b, dst))
+		return tx.DeleteBucket([]byte("dst"))
+	}))
+
+	require.Equal(t, []bolt.ChangeEvent{
+		{Type: bolt.ChangeCreateBucket, TxID: txids[0], Key: []byte("widgets")},
+		{Type: bolt.ChangePut, TxID: txids[0], Bucket: bucketPath("widgets"), Key: []byte("foo"), NewValue: []byte("bar")},
+		{Type: bolt.ChangeCreateBucket, TxID: txids[0], Bucket: bucketPath("widgets"), Key: []byte("nested")},
+		{Type: bolt.ChangePut, TxID: txids[1], Bucket: bucketPath("widgets"), Key: []byte("foo"), OldValue: []byte("bar"), NewValue: []byte("baz")},
+		{Type: bolt.ChangePut, TxID: txids[1], Bucket: bucketPath("widgets", "nested"), Key: []byte("a"), NewValue: []byte("1")},
+		{Type: bolt.ChangeDelete, TxID: txids[1], Bucket: bucketPath("widgets"), Key: []byte("foo"), OldValue: []byte("baz")},
+		{Type: bolt.ChangeCreateBucket, TxID: txids[1], Key: []byte("dst")},
+		{Type: bolt.ChangeMoveBucket, TxID: txids[1], Bucket: bucketPath("widgets"), Key: []byte("nested"), DstBucket: bucketPath("dst")},
+		{Type: bolt.ChangeDeleteBucket, TxID: txids[1], Bucket: bucketPath("dst"), Key: []byte("nested")},
+		{Type: bolt.ChangeDeleteBucket, TxID: txids[1], Key: []byte("dst")},
+	}, receiveEvents(ch))
+}
+
+// Ensure that a watcher only receives the changes of its bucket and of the
+// nested buckets, with the key prefix.
+func TestDB_Watch_Filter(t *testing.T) {
+	db := btesting.MustCreateDB(t)
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucket([]byte("widgets"))
+		return err
+	}))
+	ch, err := db.Watch(context.Background(), bucketPath("widgets"), []byte("a"))
+	require.NoError(t, err)
+
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("widgets"))
+		require.NoError(t, b.Put([]byte("a1"), []byte("1")))
+		require.NoError(t, b.Put([]byte("b1"), []byte("2")))
+		for _, name := range []string{"a-nested", "b-nested"} {
+			nested, err := b.CreateBucket([]byte(name))
+			require.NoError(t, err)
+			require.NoError(t, nested.Put([]byte("x"), []byte("3")))
+		}
+		other, err := tx.CreateBucket([]byte("other"))
+		require.NoError(t, err)
+		require.NoError(t, other.Put([]byte("a2"), []byte("4")))
+
+		// A bucket moved into the watched bucket is watched.
+		moved, err := other.CreateBucket([]byte("a-moved"))
+		require.NoError(t, err)
+		require.NoError(t, moved.Put([]byte("y"), []byte("5")))
+		return tx.MoveBucket([]byte("a-moved"), other, b)
+	}))
+
+	var got []string
+	for _, e := range receiveEvents(ch) {
+		got = append(got, fmt.Sprintf("%s %q %s", e.Type, e.Bucket, e.Key))
+	}
+	require.Equal(t, []string{
+		`put ["widgets"] a1`,
+		`createBucket ["widgets"] a-nested`,
+		`put ["widgets" "a-nested"] x`,
+		`moveBucket ["other"] a-moved`,
+	}, got)
+}
+
+// Ensure that the changes of transactions rolled back, and the changes rolled
+// back to a savepoint, aren't sent.
+func TestDB_Watch_Rollback(t *testing.T) {
+	db := btesting.MustCreateDB(t)
+	ch, err := db.Watch(context.Background(), nil, nil)
+	require.NoError(t, err)
+
+	tx, err := db.Begin(true)
+	require.NoError(t, err)
+	_, err = tx.CreateBucket([]byte("rolledback"))
+	require.NoError(t, err)
+	require.NoError(t, tx.Rollback())
+
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		require.NoError(t, err)
+		sp, err := tx.Savepoint()
+		require.NoError(t, err)
+		require.NoError(t, b.Put([]byte("foo"), []byte("bar")))
+		return sp.RollbackTo()
+	}))
+
+	events := receiveEvents(ch)
+	require.Len(t, events, 1)
+	require.Equal(t, bolt.ChangeCreateBucket, events[0].Type)
+	require.Equal(t, []byte("widgets"), events[0].Key)
+}
+
+// Ensure that a slow watcher receives an overflow event, and that its channel
+// is closed, without blocking the writers.
+func TestDB_Watch_Overflow(t *testing.T) {
+	db := btesting.MustCreateDBWithOption(t, &bolt.Options{WatchBufferSize: 3})
+	ch, err := db.Watch(context.Background(), nil, nil)
+	require.NoError(t, err)
+
+	var txid int
+	for i := 0; i < 2; i++ {
+		require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+			txid = tx.ID()
+			b, err := tx.CreateBucketIfNotExists([]byte("widgets"))
+			require.NoError(t, err)
+			for j := 0; j < 2; j++ {
+				require.NoError(t, b.Put([]byte(fmt.Sprintf("%d-%d", i, j)), []byte("bar")))
+			}
+			return nil
+		}))
+	}
+
+	events := receiveEvents(ch)
+	require.Len(t, events, 4)
+	require.Equal(t, bolt.ChangeOverflow, events[3].Type)
+	require.Equal(t, txid, events[3].TxID)
+	_, ok := <-ch
+	require.False(t, ok)
+}
+
+// Ensure that the channel of a watcher is closed when its context is done,
+// and when the database is closed.
+func TestDB_Watch_Close(t *testing.T) {
+	db := btesting.MustCreateDB(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	ch1, err := db.Watch(ctx, nil, nil)
+	require.NoError(t, err)
+	ch2, err := db.Watch(context.Background(), nil, nil)
+	require.NoError(t, err)
+
+	cancel()
+	select {
+	case _, ok := <-ch1:
+		require.False(t, ok)
+	case <-time.After(5 * time.Second):
+		t.Fatal("watcher not closed")
+	}
+
+	closed := db.DB
+	db.MustClose()
+	_, ok := <-ch2
+	require.False(t, ok)
+
+	_, err = closed.Watch(context.Background(), nil, nil)
+	require.ErrorIs(t, err, berrors.ErrDatabaseNotOpen)
+}
+
+func ExampleDB_Watch() {
+	// Open the database.
+	db, err := bolt.Open(tempfile(), 0600, nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.Remove(db.Path())
+
+	// Watch the keys of the bucket "widgets".
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch, err := db.Watch(ctx, [][]byte{[]byte("widgets")}, nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			return err
+		}
+		if err := b.Put([]byte("foo"), []byte("bar")); err != nil {
+			return err
+		}
+		return b.Put([]byte("foo"), []byte("baz"))
+	}); err != nil {
+		log.Fatal(err)
+	}
+
+	// Receive the changes.
+	for i := 0; i < 2; i++ {
+		e := <-ch
+		fmt.Printf("%s %s: %q -> %q\n", e.Type, e.Key, e.OldValue, e.NewValue)
+	}
+
+	// Close database to release the file lock.
+	if err := db.Close(); err != nil {
+		log.Fatal(err)
+	}
+
+	// Output:
+	// put foo: "" -> "bar"
+	// put foo: "bar" -> "baz"
+}